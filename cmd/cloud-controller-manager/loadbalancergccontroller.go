@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	cloudprovider "k8s.io/cloud-provider"
+	lbgccontrolleroptions "k8s.io/cloud-provider-gcp/cmd/cloud-controller-manager/options"
+	"k8s.io/cloud-provider-gcp/pkg/controller/loadbalancergc"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+	"k8s.io/klog/v2"
+)
+
+type loadBalancerGCController struct {
+	options *lbgccontrolleroptions.LoadBalancerGCControllerOptions
+}
+
+func newLoadBalancerGCController() *loadBalancerGCController {
+	return &loadBalancerGCController{options: lbgccontrolleroptions.NewLoadBalancerGCControllerOptions()}
+}
+
+func (lbGCController *loadBalancerGCController) startLoadBalancerGCControllerWrapper(initContext app.ControllerInitContext, completedConfig *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	if errs := lbGCController.options.Validate(); len(errs) > 0 {
+		klog.Fatalf("Load balancer GC controller values are not properly set: %v", errs)
+	}
+
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return startLoadBalancerGCController(completedConfig, lbGCController.options, controllerCtx, cloud)
+	}
+}
+
+func startLoadBalancerGCController(ccmConfig *cloudcontrollerconfig.CompletedConfig, opts *lbgccontrolleroptions.LoadBalancerGCControllerOptions, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("LoadBalancerGCController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+
+	lbGCController := loadbalancergc.NewController(
+		ccmConfig.SharedInformers.Core().V1().Services(),
+		gceCloud,
+		opts.Period,
+		opts.GracePeriod,
+		opts.DryRun,
+	)
+
+	go lbGCController.Run(controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
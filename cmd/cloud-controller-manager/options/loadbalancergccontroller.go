@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// LoadBalancerGCControllerOptions holds the load balancer garbage collector options.
+type LoadBalancerGCControllerOptions struct {
+	Period      time.Duration
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+// NewLoadBalancerGCControllerOptions returns the default load balancer garbage collector options.
+func NewLoadBalancerGCControllerOptions() *LoadBalancerGCControllerOptions {
+	return &LoadBalancerGCControllerOptions{
+		Period:      10 * time.Minute,
+		GracePeriod: time.Hour,
+	}
+}
+
+// AddFlags adds flags related to the load balancer garbage collector to the specified FlagSet.
+func (o *LoadBalancerGCControllerOptions) AddFlags(fs *pflag.FlagSet) {
+	if o == nil {
+		return
+	}
+	fs.DurationVar(&o.Period, "loadbalancer-gc-period", o.Period, "The period for looking up load balancer resources whose service no longer exists. Requires the loadbalancer-gc controller to be enabled.")
+	fs.DurationVar(&o.GracePeriod, "loadbalancer-gc-grace-period", o.GracePeriod, "How long the load balancer resources of a service must be orphaned before they are deleted.")
+	fs.BoolVar(&o.DryRun, "loadbalancer-gc-dry-run", o.DryRun, "Only log the orphaned load balancer resources that would be deleted.")
+}
+
+// Validate checks validation of LoadBalancerGCControllerOptions.
+func (o *LoadBalancerGCControllerOptions) Validate() []error {
+	if o == nil {
+		return nil
+	}
+	errs := make([]error, 0)
+
+	if o.Period <= 0 {
+		errs = append(errs, fmt.Errorf("--loadbalancer-gc-period must be positive"))
+	}
+	if o.GracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("--loadbalancer-gc-grace-period can not be negative"))
+	}
+
+	return errs
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancergc
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ControllerName is the name of the load balancer garbage collector.
+	ControllerName = "loadbalancer-gc"
+
+	resultSuccess = "success"
+	resultError   = "error"
+	resultDryRun  = "dry_run"
+)
+
+// resourceKinds are the kinds of load balancer resources reported by the metrics.
+var resourceKinds = []gce.LoadBalancerResourceKind{
+	gce.LoadBalancerResourceForwardingRule,
+	gce.LoadBalancerResourceTargetPool,
+	gce.LoadBalancerResourceBackendService,
+	gce.LoadBalancerResourceNetworkEndpointGroup,
+	gce.LoadBalancerResourceHealthCheck,
+	gce.LoadBalancerResourceRegionHealthCheck,
+	gce.LoadBalancerResourceHTTPHealthCheck,
+	gce.LoadBalancerResourceFirewall,
+	gce.LoadBalancerResourceFirewallPolicyRule,
+	gce.LoadBalancerResourceAddress,
+}
+
+// loadBalancerResources lists and deletes the GCE resources of the load balancers of the cluster.
+// It is implemented by gce.Cloud.
+type loadBalancerResources interface {
+	ListLoadBalancerResources() ([]gce.LoadBalancerResource, error)
+	DeleteLoadBalancerResource(r gce.LoadBalancerResource) error
+}
+
+// Controller deletes the GCE resources of load balancers whose service no longer exists, like
+// services deleted while the cloud-controller-manager was down or whose finalizer was removed
+// by hand. Resources are deleted once orphaned for longer than the grace period.
+//
+// The controller is disabled by default. The load balancer dry run mode of the cloud config relies
+// on it: the resources of the services deleted during the dry run are left in place, and only
+// collected by this controller once the dry run is over.
+type Controller struct {
+	cloud               loadBalancerResources
+	serviceLister       corelisters.ServiceLister
+	serviceListerSynced cache.InformerSynced
+
+	period      time.Duration
+	gracePeriod time.Duration
+	dryRun      bool
+	now         func() time.Time
+
+	// orphanedSince records when each orphaned resource was first found orphaned.
+	orphanedSince map[gce.LoadBalancerResource]time.Time
+}
+
+// NewController returns a new load balancer garbage collector, running every period. In dry
+// run mode, it only logs the resources it would delete.
+func NewController(serviceInformer coreinformers.ServiceInformer, cloud loadBalancerResources, period, gracePeriod time.Duration, dryRun bool) *Controller {
+	registerLoadBalancerGCMetrics()
+
+	return &Controller{
+		cloud:               cloud,
+		serviceLister:       serviceInformer.Lister(),
+		serviceListerSynced: serviceInformer.Informer().HasSynced,
+		period:              period,
+		gracePeriod:         gracePeriod,
+		dryRun:              dryRun,
+		now:                 time.Now,
+		orphanedSince:       map[gce.LoadBalancerResource]time.Time{},
+	}
+}
+
+// Run periodically deletes the orphaned load balancer resources until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	klog.Infof("Starting %s controller (dry run: %v)", ControllerName, c.dryRun)
+	defer klog.Infof("Shutting down %s controller", ControllerName)
+	controllerManagerMetrics.ControllerStarted(ControllerName)
+	defer controllerManagerMetrics.ControllerStopped(ControllerName)
+
+	if !cache.WaitForNamedCacheSync(ControllerName, stopCh, c.serviceListerSynced) {
+		return
+	}
+
+	wait.Until(func() {
+		if err := c.sync(); err != nil {
+			klog.Errorf("Failed to garbage collect orphaned load balancer resources: %v", err)
+		}
+	}, c.period, stopCh)
+}
+
+// sync deletes the resources orphaned for longer than the grace period.
+func (c *Controller) sync() error {
+	resources, err := c.cloud.ListLoadBalancerResources()
+	if err != nil {
+		return err
+	}
+
+	legacyNames, err := c.legacyLoadBalancerNames()
+	if err != nil {
+		return err
+	}
+
+	now := c.now()
+	orphanedSince := map[gce.LoadBalancerResource]time.Time{}
+	orphanedCount := map[gce.LoadBalancerResourceKind]int{}
+	var errs []error
+	for _, r := range resources {
+		// Legacy load balancers belong to the service of the UID they are named after, whatever
+		// the service named in their description.
+		inUse := legacyNames.Has(r.LoadBalancerName)
+		if !r.Legacy {
+			inUse, err = c.serviceUsesLoadBalancer(r.Service)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if inUse {
+			continue
+		}
+
+		since, ok := c.orphanedSince[r]
+		if !ok {
+			since = now
+			klog.V(2).Infof("Found %s %s of load balancer %s of deleted service %s", r.Kind, r.Name, r.LoadBalancerName, r.Service)
+		}
+		orphanedSince[r] = since
+		orphanedCount[r.Kind]++
+		if now.Sub(since) < c.gracePeriod {
+			continue
+		}
+
+		if c.dryRun {
+			klog.Infof("Dry run: would delete %s %s of deleted service %s, orphaned since %v", r.Kind, r.Name, r.Service, since)
+			deletedResources.WithLabelValues(string(r.Kind), resultDryRun).Inc()
+			continue
+		}
+		klog.Infof("Deleting %s %s of deleted service %s, orphaned since %v", r.Kind, r.Name, r.Service, since)
+		if err := c.cloud.DeleteLoadBalancerResource(r); err != nil {
+			// Resources still referenced by another orphaned resource are deleted by a later sync.
+			klog.Warningf("Failed to delete %s %s of deleted service %s: %v", r.Kind, r.Name, r.Service, err)
+			deletedResources.WithLabelValues(string(r.Kind), resultError).Inc()
+			errs = append(errs, err)
+			continue
+		}
+		deletedResources.WithLabelValues(string(r.Kind), resultSuccess).Inc()
+		delete(orphanedSince, r)
+		orphanedCount[r.Kind]--
+	}
+	c.orphanedSince = orphanedSince
+
+	for _, kind := range resourceKinds {
+		orphanedResources.WithLabelValues(string(kind)).Set(float64(orphanedCount[kind]))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// serviceUsesLoadBalancer returns whether the service exists and uses a load balancer, or has a
+// load balancer pending cleanup by the service controller.
+func (c *Controller) serviceUsesLoadBalancer(nm types.NamespacedName) (bool, error) {
+	svc, err := c.serviceLister.Services(nm.Namespace).Get(nm.Name)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return usesLoadBalancer(svc), nil
+}
+
+// legacyLoadBalancerNames returns the legacy load balancer names of the services that use a load
+// balancer, named after their UID, see cloudprovider.DefaultLoadBalancerName.
+func (c *Controller) legacyLoadBalancerNames() (sets.String, error) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	names := sets.NewString()
+	for _, svc := range services {
+		if usesLoadBalancer(svc) {
+			names.Insert(cloudprovider.DefaultLoadBalancerName(svc))
+		}
+	}
+	return names, nil
+}
+
+// usesLoadBalancer returns whether the service uses a load balancer, or has a load balancer
+// pending cleanup by the service controller.
+func usesLoadBalancer(svc *v1.Service) bool {
+	return svc.Spec.Type == v1.ServiceTypeLoadBalancer || servicehelpers.HasLBFinalizer(svc)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancergc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+)
+
+const testGracePeriod = time.Hour
+
+type fakeLoadBalancerResources struct {
+	resources []gce.LoadBalancerResource
+	deleted   []gce.LoadBalancerResource
+}
+
+func (f *fakeLoadBalancerResources) ListLoadBalancerResources() ([]gce.LoadBalancerResource, error) {
+	return f.resources, nil
+}
+
+func (f *fakeLoadBalancerResources) DeleteLoadBalancerResource(r gce.LoadBalancerResource) error {
+	f.deleted = append(f.deleted, r)
+	var remaining []gce.LoadBalancerResource
+	for _, existing := range f.resources {
+		if existing != r {
+			remaining = append(remaining, existing)
+		}
+	}
+	f.resources = remaining
+	return nil
+}
+
+func service(name string, svcType v1.ServiceType, finalizers ...string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Finalizers: finalizers},
+		Spec:       v1.ServiceSpec{Type: svcType},
+	}
+}
+
+func withUID(svc *v1.Service, uid types.UID) *v1.Service {
+	svc.UID = uid
+	return svc
+}
+
+func resources(svcName string) []gce.LoadBalancerResource {
+	nm := types.NamespacedName{Namespace: "default", Name: svcName}
+	return []gce.LoadBalancerResource{
+		{Kind: gce.LoadBalancerResourceForwardingRule, Name: "ccm2-" + svcName, LoadBalancerName: "ccm2-" + svcName, Service: nm},
+		{Kind: gce.LoadBalancerResourceFirewall, Name: "k8s-fw-ccm2-" + svcName, LoadBalancerName: "ccm2-" + svcName, Service: nm},
+	}
+}
+
+func legacyResources(svcName string, uid types.UID) []gce.LoadBalancerResource {
+	nm := types.NamespacedName{Namespace: "default", Name: svcName}
+	name := cloudprovider.DefaultLoadBalancerName(&v1.Service{ObjectMeta: metav1.ObjectMeta{UID: uid}})
+	return []gce.LoadBalancerResource{
+		{Kind: gce.LoadBalancerResourceForwardingRule, Name: name, LoadBalancerName: name, Service: nm, Legacy: true},
+		{Kind: gce.LoadBalancerResourceFirewall, Name: "k8s-fw-" + name, LoadBalancerName: name, Service: nm, Legacy: true},
+	}
+}
+
+func setupController(t *testing.T, cloud *fakeLoadBalancerResources, dryRun bool, services ...*v1.Service) (*Controller, *time.Time, cache.Store) {
+	t.Helper()
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	serviceInformer := informerFactory.Core().V1().Services()
+	for _, svc := range services {
+		if err := serviceInformer.Informer().GetStore().Add(svc); err != nil {
+			t.Fatalf("Failed to add service %s: %v", svc.Name, err)
+		}
+	}
+	c := NewController(serviceInformer, cloud, time.Minute, testGracePeriod, dryRun)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now, serviceInformer.Informer().GetStore()
+}
+
+func TestSyncDeletesOrphanedResourcesAfterGracePeriod(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		services    []*v1.Service
+		wantDeleted []gce.LoadBalancerResource
+	}{
+		{
+			desc:        "deleted service",
+			wantDeleted: resources("deleted"),
+		},
+		{
+			desc:     "load balancer service",
+			services: []*v1.Service{service("deleted", v1.ServiceTypeLoadBalancer)},
+		},
+		{
+			desc:        "service no longer of type LoadBalancer",
+			services:    []*v1.Service{service("deleted", v1.ServiceTypeClusterIP)},
+			wantDeleted: resources("deleted"),
+		},
+		{
+			desc:     "service pending load balancer cleanup",
+			services: []*v1.Service{service("deleted", v1.ServiceTypeClusterIP, servicehelpers.LoadBalancerCleanupFinalizer)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cloud := &fakeLoadBalancerResources{resources: resources("deleted")}
+			c, now, _ := setupController(t, cloud, false, tc.services...)
+
+			if err := c.sync(); err != nil {
+				t.Fatalf("sync() = %v", err)
+			}
+			if len(cloud.deleted) != 0 {
+				t.Errorf("sync() deleted %v within the grace period", cloud.deleted)
+			}
+
+			*now = now.Add(testGracePeriod)
+			if err := c.sync(); err != nil {
+				t.Fatalf("sync() = %v", err)
+			}
+			if diff := cmp.Diff(tc.wantDeleted, cloud.deleted); diff != "" {
+				t.Errorf("sync() deleted unexpected resources (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSyncMatchesLegacyResourcesByUID(t *testing.T) {
+	const uid = "0123abcd-4567-89ef-0123-456789abcdef"
+	testCases := []struct {
+		desc        string
+		services    []*v1.Service
+		wantDeleted []gce.LoadBalancerResource
+	}{
+		{
+			desc:        "deleted service",
+			wantDeleted: legacyResources("legacy", uid),
+		},
+		{
+			desc:     "load balancer service",
+			services: []*v1.Service{withUID(service("legacy", v1.ServiceTypeLoadBalancer), uid)},
+		},
+		{
+			desc:     "load balancer service renamed in the description",
+			services: []*v1.Service{withUID(service("other", v1.ServiceTypeLoadBalancer), uid)},
+		},
+		{
+			desc:        "service re-created with the same name",
+			services:    []*v1.Service{withUID(service("legacy", v1.ServiceTypeLoadBalancer), "89abcdef-0123-4567-89ab-cdef01234567")},
+			wantDeleted: legacyResources("legacy", uid),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cloud := &fakeLoadBalancerResources{resources: legacyResources("legacy", uid)}
+			c, now, _ := setupController(t, cloud, false, tc.services...)
+
+			for i := 0; i < 2; i++ {
+				if err := c.sync(); err != nil {
+					t.Fatalf("sync() = %v", err)
+				}
+				*now = now.Add(testGracePeriod)
+			}
+			if diff := cmp.Diff(tc.wantDeleted, cloud.deleted); diff != "" {
+				t.Errorf("sync() deleted unexpected resources (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSyncResetsGracePeriodOfReusedResources(t *testing.T) {
+	cloud := &fakeLoadBalancerResources{resources: resources("recreated")}
+	c, now, store := setupController(t, cloud, false)
+
+	if err := c.sync(); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	// The service is recreated, then deleted again.
+	svc := service("recreated", v1.ServiceTypeLoadBalancer)
+	if err := store.Add(svc); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(testGracePeriod / 2)
+	if err := c.sync(); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	if err := store.Delete(svc); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(testGracePeriod / 2)
+	if err := c.sync(); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	if len(cloud.deleted) != 0 {
+		t.Errorf("sync() deleted %v within the grace period", cloud.deleted)
+	}
+	if len(c.orphanedSince) != 2 {
+		t.Errorf("sync() tracks %d orphaned resources, want 2", len(c.orphanedSince))
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	cloud := &fakeLoadBalancerResources{resources: resources("deleted")}
+	c, now, _ := setupController(t, cloud, true)
+
+	for i := 0; i < 2; i++ {
+		if err := c.sync(); err != nil {
+			t.Fatalf("sync() = %v", err)
+		}
+		*now = now.Add(testGracePeriod)
+	}
+	if len(cloud.deleted) != 0 {
+		t.Errorf("sync() deleted %v in dry run mode", cloud.deleted)
+	}
+	if len(c.orphanedSince) != 2 {
+		t.Errorf("sync() tracks %d orphaned resources, want 2", len(c.orphanedSince))
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancergc
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// LoadBalancerGCSubsystem - subsystem name used for the load balancer garbage collector
+const LoadBalancerGCSubsystem = "loadbalancer_gc_controller"
+
+var (
+	orphanedResources = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      LoadBalancerGCSubsystem,
+			Name:           "orphaned_resources",
+			Help:           "Gauge measuring number of load balancer resources whose service no longer exists.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind"},
+	)
+	deletedResources = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      LoadBalancerGCSubsystem,
+			Name:           "deleted_resources_total",
+			Help:           "Number of orphaned load balancer resources deleted, or that would be deleted in dry run mode.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind", "result"},
+	)
+)
+
+var registerGCMetrics sync.Once
+
+// registerLoadBalancerGCMetrics registers the load balancer garbage collector metrics.
+func registerLoadBalancerGCMetrics() {
+	registerGCMetrics.Do(func() {
+		legacyregistry.MustRegister(orphanedResources)
+		legacyregistry.MustRegister(deletedResources)
+	})
+}
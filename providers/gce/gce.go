@@ -23,6 +23,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"regexp"
 	"runtime"
 	"slices"
 	"strconv"
@@ -49,10 +50,13 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/pkg/version"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
 )
@@ -67,6 +71,12 @@ const (
 	gceAffinityTypeNone = "NONE"
 	// AffinityTypeClientIP - affinity based on Client IP.
 	gceAffinityTypeClientIP = "CLIENT_IP"
+	// AffinityTypeClientIPProto - affinity based on Client IP and protocol.
+	gceAffinityTypeClientIPProto = "CLIENT_IP_PROTO"
+	// AffinityTypeClientIPPortProto - affinity based on Client IP, port and protocol.
+	gceAffinityTypeClientIPPortProto = "CLIENT_IP_PORT_PROTO"
+	// AffinityTypeClientIPNoDestination - affinity based on Client IP, whatever the destination.
+	gceAffinityTypeClientIPNoDestination = "CLIENT_IP_NO_DESTINATION"
 
 	operationPollInterval           = time.Second
 	maxTargetPoolCreateInstances    = 200
@@ -171,11 +181,20 @@ type Cloud struct {
 	// it is updated by the nodeInformer
 	nodeZones          map[string]sets.String
 	nodeInformerSynced cache.InformerSynced
-	// sharedResourceLock is used to serialize GCE operations that may mutate shared state to
-	// prevent inconsistencies. For example, load balancers manipulation methods will take the
-	// lock to prevent shared resources from being prematurely deleted while the operation is
-	// in progress.
-	sharedResourceLock sync.Mutex
+	// The listers of the endpoint slices, services and nodes are set by SetInformers. The
+	// services whose internal load balancer is resynced when their endpoints change are
+	// queued in internalNEGEndpointsQueue.
+	endpointSliceLister       discoverylisters.EndpointSliceLister
+	endpointSlicesSynced      cache.InformerSynced
+	serviceLister             corelisters.ServiceLister
+	servicesSynced            cache.InformerSynced
+	nodeLister                corelisters.NodeLister
+	internalNEGEndpointsQueue workqueue.RateLimitingInterface
+	// sharedResourceLocks serialize the GCE operations that may mutate the same shared resources,
+	// keyed by resource, to prevent inconsistencies. For example, load balancers manipulation
+	// methods lock the shared resources they use to prevent them from being prematurely deleted
+	// while the operation is in progress.
+	sharedResourceLocks keyedMutex
 	// AlphaFeatureGate gates gce alpha features in Cloud instance.
 	// Related wrapper functions that interacts with gce alpha api should examine whether
 	// the corresponding api is enabled.
@@ -195,6 +214,29 @@ type Cloud struct {
 	// stackType indicates whether the cluster is a single stack IPv4, single
 	// stack IPv6 or a dual stack cluster
 	stackType StackType
+	// loadBalancerNamingScheme is the naming scheme of the load balancers
+	// of new services.
+	loadBalancerNamingScheme LoadBalancerNamingScheme
+	// loadBalancerDryRun records the changes of the load balancer operations
+	// instead of making them. If nil, the changes are made.
+	loadBalancerDryRun *dryRunRecorder
+	// firewallPolicy writes the firewalls of the load balancers into a network
+	// firewall policy. If nil, they are VPC firewall rules.
+	firewallPolicy *firewallPolicy
+	// firewallConfig holds the options of the firewalls of the load balancers.
+	firewallConfig FirewallConfig
+	// nodeTagsConfig configures the discovery of the network tags of the nodes
+	// when nodeTags is empty. nodeTagsRegexp is its compiled regexp.
+	nodeTagsConfig NodeTagsConfig
+	nodeTagsRegexp *regexp.Regexp
+	// loadBalancerUpdateConcurrency is the maximum number of zonal instance
+	// groups updated at once, see updateConcurrency.
+	loadBalancerUpdateConcurrency int
+	// instanceLabels is the allowlist of the instance labels InstanceMetadata
+	// returns, see instanceAdditionalLabels.
+	instanceLabels sets.String
+	// instanceCache caches the instances of the nodes, see getCachedInstance.
+	instanceCache instanceCache
 }
 
 // ConfigGlobal is the in memory representation of the gce.conf config data
@@ -232,6 +274,72 @@ type ConfigGlobal struct {
 	// Default to none.
 	// For example: MyFeatureFlag
 	AlphaFeatures []string `gcfg:"alpha-features"`
+	// LoadBalancerNamingScheme is the naming scheme of the load balancers of new
+	// services, either v1 (default) or v2. Existing load balancers keep their names.
+	LoadBalancerNamingScheme string `gcfg:"load-balancer-naming-scheme"`
+	// LoadBalancerDryRun makes the load balancer implementation report the changes it
+	// would make to the GCE resources and to the services as Service events and logs,
+	// without making them. The load balancers of services deleted in dry run mode are
+	// leaked: the services are deleted without waiting for them, and their resources are
+	// only deleted by the loadbalancer-gc controller, which is disabled by default, once
+	// dry run mode is disabled.
+	LoadBalancerDryRun bool `gcfg:"load-balancer-dry-run"`
+	// FirewallPolicy is the name of a network firewall policy associated with the network
+	// of the cluster. If set, the firewalls of the load balancers are written as rules of
+	// the policy, one per firewall, instead of VPC firewall rules. It requires
+	// FirewallTargetServiceAccounts, as the rules can't target the network tags of the nodes.
+	FirewallPolicy string `gcfg:"firewall-policy"`
+	// FirewallPolicyRegion is the region of FirewallPolicy if it is a regional policy.
+	FirewallPolicyRegion string `gcfg:"firewall-policy-region"`
+	// FirewallPolicyMinPriority and FirewallPolicyMaxPriority bound the priorities of the
+	// rules of the load balancers in FirewallPolicy, 1000 to 9999 by default.
+	FirewallPolicyMinPriority int64 `gcfg:"firewall-policy-min-priority"`
+	FirewallPolicyMaxPriority int64 `gcfg:"firewall-policy-max-priority"`
+	// FirewallLogging enables the logging of the connections allowed by the firewalls of the
+	// load balancers. Services can override it with an annotation.
+	FirewallLogging bool `gcfg:"firewall-logging"`
+	// FirewallLoggingMetadata is INCLUDE_ALL_METADATA (the default) or EXCLUDE_ALL_METADATA.
+	FirewallLoggingMetadata string `gcfg:"firewall-logging-metadata"`
+	// FirewallPriority is the priority of the firewalls of the load balancers, from 1 to 65535.
+	FirewallPriority int64 `gcfg:"firewall-priority"`
+	// FirewallTargetServiceAccounts are the service accounts of the nodes of the cluster. When set,
+	// the firewalls of the load balancers target them instead of the network tags of the nodes.
+	FirewallTargetServiceAccounts []string `gcfg:"firewall-target-service-account"`
+	// NodeTagsStrategy is how the network tags of the nodes are discovered when NodeTags is
+	// empty: longest-prefix (default), instance-label, instance-metadata or regexp.
+	NodeTagsStrategy string `gcfg:"node-tags-strategy"`
+	// NodeTagsLabel is the instance label naming the network tag of the instance-label strategy.
+	NodeTagsLabel string `gcfg:"node-tags-label"`
+	// NodeTagsMetadataKey is the instance metadata key listing the network tags of the
+	// instance-metadata strategy.
+	NodeTagsMetadataKey string `gcfg:"node-tags-metadata-key"`
+	// NodeTagsRegexp matches the network tags of the regexp strategy.
+	NodeTagsRegexp string `gcfg:"node-tags-regexp"`
+	// LoadBalancerUpdateConcurrency is the maximum number of zonal instance groups of the load
+	// balancers updated at once. Defaults to 4.
+	LoadBalancerUpdateConcurrency int `gcfg:"load-balancer-update-concurrency"`
+	// RateLimitQPS and RateLimitBurst bound the rate of the GCE API calls without a rate-limit
+	// of their own. The calls are not rate limited by default, and the burst defaults to 1.
+	RateLimitQPS   float64 `gcfg:"rate-limit-qps"`
+	RateLimitBurst int     `gcfg:"rate-limit-burst"`
+	// RateLimits bound the rate of the GCE API calls of a service or of an operation of a service,
+	// formatted as <service>[.<operation>],<qps>,<burst>.
+	// For example: Instances.List,5,10
+	RateLimits []string `gcfg:"rate-limit"`
+	// InstanceLabels are the labels describing the instances of the nodes, prefixed with
+	// InstanceLabelPrefix, added to the nodes, e.g. machine-family or gpu-type.
+	// No label is added by default.
+	InstanceLabels []string `gcfg:"instance-label"`
+	// InstanceCacheRefreshPeriod is the period of the aggregated lists of the instances refreshing
+	// the instance cache, e.g. 5m. The instances are not listed by default.
+	InstanceCacheRefreshPeriod string `gcfg:"instance-cache-refresh-period"`
+	// InstanceCacheMaxAge is the maximum age of the cached instances read by the instance
+	// cache consumers, e.g. 1m. The instances are not cached by default.
+	InstanceCacheMaxAge string `gcfg:"instance-cache-max-age"`
+	// InstanceCacheConsumerMaxAges override InstanceCacheMaxAge for some consumers, formatted as
+	// <consumer>,<max age>.
+	// For example: InstanceShutdown,0s
+	InstanceCacheConsumerMaxAges []string `gcfg:"instance-cache-consumer-max-age"`
 }
 
 // ConfigFile is the struct used to parse the /etc/gce.conf configuration file.
@@ -266,6 +374,25 @@ type CloudConfig struct {
 	UseMetadataServer  bool
 	AlphaFeatureGate   *AlphaFeatureGate
 	StackType          string
+	// LoadBalancerNamingScheme is the naming scheme of the load balancers of new services.
+	LoadBalancerNamingScheme LoadBalancerNamingScheme
+	// LoadBalancerDryRun makes the load balancer implementation only report the changes it would make.
+	// The load balancers of the services deleted in dry run mode are leaked.
+	LoadBalancerDryRun bool
+	// FirewallPolicy is the network firewall policy the firewalls of the load balancers are written into.
+	FirewallPolicy *FirewallPolicyConfig
+	// Firewall holds the options of the firewalls of the load balancers.
+	Firewall FirewallConfig
+	// NodeTagsConfig configures the discovery of the network tags of the nodes when NodeTags is empty.
+	NodeTagsConfig NodeTagsConfig
+	// LoadBalancerUpdateConcurrency is the maximum number of zonal instance groups updated at once.
+	LoadBalancerUpdateConcurrency int
+	// RateLimit configures the rate limiting of the GCE API calls.
+	RateLimit RateLimitConfig
+	// InstanceLabels is the allowlist of the instance labels added to the nodes.
+	InstanceLabels sets.String
+	// InstanceCache configures the cache of the instances of the nodes.
+	InstanceCache InstanceCacheConfig
 }
 
 func init() {
@@ -354,8 +481,43 @@ func generateCloudConfig(configFile *ConfigFile) (cloudConfig *CloudConfig, err
 		}
 
 		cloudConfig.NodeTags = configFile.Global.NodeTags
+		cloudConfig.NodeTagsConfig, err = parseNodeTagsConfig(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
 		cloudConfig.NodeInstancePrefix = configFile.Global.NodeInstancePrefix
 		cloudConfig.AlphaFeatureGate = NewAlphaFeatureGate(configFile.Global.AlphaFeatures)
+		switch scheme := LoadBalancerNamingScheme(configFile.Global.LoadBalancerNamingScheme); scheme {
+		case "", LoadBalancerNamingSchemeV1, LoadBalancerNamingSchemeV2:
+			cloudConfig.LoadBalancerNamingScheme = scheme
+		default:
+			return nil, fmt.Errorf("unsupported load-balancer-naming-scheme %q", scheme)
+		}
+		cloudConfig.LoadBalancerDryRun = configFile.Global.LoadBalancerDryRun
+		cloudConfig.FirewallPolicy, err = parseFirewallPolicyConfig(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
+		cloudConfig.Firewall, err = parseFirewallConfig(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
+		if configFile.Global.LoadBalancerUpdateConcurrency < 0 {
+			return nil, fmt.Errorf("invalid load-balancer-update-concurrency %d: must not be negative", configFile.Global.LoadBalancerUpdateConcurrency)
+		}
+		cloudConfig.LoadBalancerUpdateConcurrency = configFile.Global.LoadBalancerUpdateConcurrency
+		cloudConfig.RateLimit, err = parseRateLimitConfig(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
+		cloudConfig.InstanceLabels, err = parseInstanceLabels(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
+		cloudConfig.InstanceCache, err = parseInstanceCacheConfig(&configFile.Global)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// retrieve projectID and zone
@@ -559,6 +721,9 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 		metricsCollector:         newLoadBalancerMetrics(),
 		projectsBasePath:         getProjectsBasePath(service.BasePath),
 		stackType:                StackType(config.StackType),
+		loadBalancerNamingScheme: config.LoadBalancerNamingScheme,
+		firewallConfig:           config.Firewall,
+		nodeTagsConfig:           config.NodeTagsConfig,
 	}
 
 	gce.manager = &gceServiceManager{gce}
@@ -567,9 +732,22 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 		Alpha:         serviceAlpha,
 		Beta:          serviceBeta,
 		ProjectRouter: &gceProjectRouter{gce},
-		RateLimiter:   &gceRateLimiter{gce},
+		RateLimiter:   newGCERateLimiter(config.RateLimit, operationPollRateLimiter),
 	}
 	gce.c = cloud.NewGCE(gce.s)
+	gce.firewallPolicy = gce.newFirewallPolicy(config.FirewallPolicy)
+	if config.LoadBalancerDryRun {
+		gce.enableLoadBalancerDryRun()
+	}
+	gce.loadBalancerUpdateConcurrency = config.LoadBalancerUpdateConcurrency
+	gce.internalNEGEndpointsQueue = newInternalNEGEndpointsQueue()
+	gce.instanceLabels = config.InstanceLabels
+	gce.instanceCache.config = config.InstanceCache
+	if config.NodeTagsConfig.Strategy == NodeTagsStrategyRegexp {
+		if gce.nodeTagsRegexp, err = regexp.Compile(config.NodeTagsConfig.Regexp); err != nil {
+			return nil, fmt.Errorf("invalid node tags regexp %q: %v", config.NodeTagsConfig.Regexp, err)
+		}
+	}
 
 	return gce, nil
 }
@@ -685,6 +863,8 @@ func (g *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder,
 
 	go g.watchClusterID(stop)
 	go g.metricsCollector.Run(stop)
+	go g.runInstanceCacheRefresh(stop)
+	go g.runInternalNEGEndpointsSync(stop)
 }
 
 // LoadBalancer returns an implementation of LoadBalancer for Google Compute Engine.
@@ -807,6 +987,7 @@ func (g *Cloud) SetInformers(informerFactory informers.SharedInformerFactory) {
 		},
 	})
 	g.nodeInformerSynced = nodeInformer.HasSynced
+	g.setEndpointSliceInformers(informerFactory)
 }
 
 func (g *Cloud) updateNodeZones(prevNode, newNode *v1.Node) {
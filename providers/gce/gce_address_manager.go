@@ -29,6 +29,20 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// IPVersion represents the IP version of a GCE address or forwarding rule.
+type IPVersion string
+
+const (
+	// IPVersionIPv4 is the IP version of IPv4 addresses and forwarding rules.
+	IPVersionIPv4 IPVersion = "IPV4"
+	// IPVersionIPv6 is the IP version of IPv6 addresses and forwarding rules.
+	IPVersionIPv6 IPVersion = "IPV6"
+)
+
+// addressPurposeSharedLoadBalancerVIP is the purpose of internal addresses used by
+// several forwarding rules, such as the per protocol rules of a mixed-protocol load balancer.
+const addressPurposeSharedLoadBalancerVIP = "SHARED_LOADBALANCER_VIP"
+
 type addressManager struct {
 	logPrefix   string
 	svc         CloudAddressService
@@ -36,12 +50,15 @@ type addressManager struct {
 	serviceName string
 	targetIP    string
 	addressType cloud.LbScheme
+	ipVersion   IPVersion
+	purpose     string
+	wasShared   bool
 	region      string
 	subnetURL   string
 	tryRelease  bool
 }
 
-func newAddressManager(svc CloudAddressService, serviceName, region, subnetURL, name, targetIP string, addressType cloud.LbScheme) *addressManager {
+func newAddressManager(svc CloudAddressService, serviceName, region, subnetURL, name, targetIP string, addressType cloud.LbScheme, ipVersion IPVersion) *addressManager {
 	return &addressManager{
 		svc:         svc,
 		logPrefix:   fmt.Sprintf("AddressManager(%q)", name),
@@ -50,11 +67,26 @@ func newAddressManager(svc CloudAddressService, serviceName, region, subnetURL,
 		name:        name,
 		targetIP:    targetIP,
 		addressType: addressType,
+		ipVersion:   ipVersion,
 		tryRelease:  true,
 		subnetURL:   subnetURL,
 	}
 }
 
+// ShareAddress makes the internal address usable by the forwarding rules of every protocol of a
+// mixed-protocol load balancer. The address is reserved with the SHARED_LOADBALANCER_VIP purpose
+// and kept after the forwarding rules are created, since they all reference it.
+func (am *addressManager) ShareAddress() {
+	am.purpose = addressPurposeSharedLoadBalancerVIP
+	am.tryRelease = false
+}
+
+// WasShared returns whether HoldAddress found the address reserved for the forwarding rules
+// of a mixed-protocol load balancer.
+func (am *addressManager) WasShared() bool {
+	return am.wasShared
+}
+
 // HoldAddress will ensure that the IP is reserved with an address - either owned by the controller
 // or by a user. If the address is not the addressManager.name, then it's assumed to be a user's address.
 // The string returned is the reserved IP address.
@@ -73,6 +105,7 @@ func (am *addressManager) HoldAddress() (string, error) {
 	}
 
 	if addr != nil {
+		am.wasShared = addr.Purpose == addressPurposeSharedLoadBalancerVIP
 		// If address exists, check if the address had the expected attributes.
 		validationError := am.validateAddress(addr)
 		if validationError == nil {
@@ -128,6 +161,12 @@ func (am *addressManager) ensureAddressReservation() (string, error) {
 		Address:     am.targetIP,
 		AddressType: string(am.addressType),
 		Subnetwork:  am.subnetURL,
+		Purpose:     am.purpose,
+	}
+	// IPv4 addresses are reserved without an explicit IP version to keep the
+	// requests identical to the ones issued before IPv6 support was added.
+	if am.ipVersion == IPVersionIPv6 {
+		newAddr.IpVersion = string(IPVersionIPv6)
 	}
 
 	reserveErr := am.svc.ReserveRegionAddress(newAddr, am.region)
@@ -189,6 +228,12 @@ func (am *addressManager) validateAddress(addr *compute.Address) error {
 	if addr.AddressType != string(am.addressType) {
 		return fmt.Errorf("address %q does not have the expected address type %q, actual: %q", addr.Name, am.addressType, addr.AddressType)
 	}
+	if (am.ipVersion == IPVersionIPv6) != (addr.IpVersion == string(IPVersionIPv6)) {
+		return fmt.Errorf("address %q does not have the expected IP version %q, actual: %q", addr.Name, am.ipVersion, addr.IpVersion)
+	}
+	if am.purpose != "" && am.isManagedAddress(addr) && addr.Purpose != am.purpose {
+		return fmt.Errorf("address %q does not have the expected purpose %q, actual: %q", addr.Name, am.purpose, addr.Purpose)
+	}
 
 	return nil
 }
@@ -108,6 +108,16 @@ func (g *Cloud) GetRegionAddress(name, region string) (*compute.Address, error)
 	return v, mc.Observe(err)
 }
 
+// ListRegionAddresses lists all addresses in the region.
+func (g *Cloud) ListRegionAddresses(region string) ([]*compute.Address, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newAddressMetricContext("list", region)
+	v, err := g.c.Addresses().List(ctx, region, filter.None)
+	return v, mc.Observe(err)
+}
+
 // GetBetaRegionAddress returns the beta region address by name
 func (g *Cloud) GetBetaRegionAddress(name, region string) (*computebeta.Address, error) {
 	ctx, cancel := cloud.ContextWithCallTimeout()
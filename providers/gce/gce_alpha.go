@@ -21,12 +21,18 @@ package gce
 
 const (
 	// AlphaFeatureILBSubsets allows InternalLoadBalancer services to include a subset
-	// of cluster nodes as backends instead of all nodes.
+	// of cluster nodes as backends instead of all nodes. New services are provisioned
+	// with subsetting, see ILBSubsettingAnnotationKey.
 	AlphaFeatureILBSubsets = "ILBSubsets"
 
 	// AlphaFeatureSkipIGsManagement enabled L4 Regional Backend Services and
 	// disables instance group management in service controller
 	AlphaFeatureSkipIGsManagement = "SkipIGsManagement"
+
+	// AlphaFeatureNetLBRBS makes service controller implement L4 Regional Backend Service
+	// based external load balancers for services annotated with RBSAnnotationKey, instead
+	// of leaving them to other controllers.
+	AlphaFeatureNetLBRBS = "NetLBRBS"
 )
 
 // AlphaFeatureGate contains a mapping of alpha features to whether they are enabled
@@ -21,16 +21,22 @@ package gce
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"k8s.io/klog/v2"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // LoadBalancerType defines a specific type for holding load balancer types (eg. Internal)
 type LoadBalancerType string
 
+// ILBBackendType defines a specific type for holding the backend types of internal load balancers.
+type ILBBackendType string
+
 const (
 	// ServiceAnnotationLoadBalancerType is annotated on a service with type LoadBalancer
 	// dictates what specific kind of GCP LB should be assembled.
@@ -64,6 +70,12 @@ const (
 	// cluster is created in.
 	ServiceAnnotationILBSubnet = "networking.gke.io/internal-load-balancer-subnet"
 
+	// ServiceAnnotationLoadBalancerIPAddresses is annotated on a service with a comma-separated list
+	// of the names of static addresses reserved in the region of the cluster: at most one IPv4 and one
+	// IPv6 address. The load balancer uses their IP addresses instead of spec.loadBalancerIP, and
+	// never releases them.
+	ServiceAnnotationLoadBalancerIPAddresses = "networking.gke.io/load-balancer-ip-addresses"
+
 	// NetworkTierAnnotationKey is annotated on a Service object to indicate which
 	// network tier a GCP LB should use. The valid values are "Standard" and
 	// "Premium" (default).
@@ -81,6 +93,75 @@ const (
 
 	// RBSEnabled is an annotation to indicate the Service is opt-in for RBS
 	RBSEnabled = "enabled"
+
+	// LoadBalancerNameAnnotationKey is set by the controller on a Service object to record
+	// the name of its load balancer resources. It is only honored if its value is the legacy
+	// or the v2 name of the Service, see GetLoadBalancerName.
+	LoadBalancerNameAnnotationKey = "cloud.google.com/load-balancer-name"
+
+	// ServiceAnnotationILBBackendType is annotated on an internal load balancer service to select
+	// the backends of its backend services. By default they are the instance groups of the cluster,
+	// which contain all the nodes. With GCE_VM_IP_NEG, they are zonal GCE_VM_IP network endpoint
+	// groups of the load balancer. The members are updated when the load balancer is synced.
+	// Without the annotation, the backends of load balancers provisioned with subsetting or selecting
+	// their nodes are network endpoint groups, which is reported by an event. Changing the
+	// annotation of an existing load balancer replaces the backends of its backend services in place.
+	ServiceAnnotationILBBackendType = "networking.gke.io/internal-load-balancer-backend-type"
+
+	// ILBSubsettingAnnotationKey is set to "true" by the controller on the internal load balancer
+	// services it provisions while AlphaFeatureILBSubsets is enabled. Unless the backend type is
+	// annotated, their backends are GCE_VM_IP network endpoint groups which contain, if
+	// externalTrafficPolicy=Cluster, a stable subset of the nodes of each zone, and all the nodes
+	// if externalTrafficPolicy=Local.
+	ILBSubsettingAnnotationKey = "cloud.google.com/l4-ilb-subsetting"
+
+	// ILBBackendTypeInstanceGroup is the annotation value for instance group backends.
+	ILBBackendTypeInstanceGroup ILBBackendType = "InstanceGroup"
+
+	// ILBBackendTypeNEG is the annotation value for GCE_VM_IP network endpoint group backends.
+	ILBBackendTypeNEG ILBBackendType = "GCE_VM_IP_NEG"
+
+	// ServiceAnnotationILBSessionAffinity is annotated on an internal load balancer service with the
+	// ClientIP session affinity to select how the connections of a client are kept on the same
+	// backend: CLIENT_IP_PROTO, CLIENT_IP_PORT_PROTO or CLIENT_IP_NO_DESTINATION. By default, it is
+	// CLIENT_IP. Backend services of services setting it are never shared.
+	ServiceAnnotationILBSessionAffinity = "networking.gke.io/internal-load-balancer-session-affinity"
+
+	// ServiceAnnotationHealthCheckInterval is annotated on a service to set the number of seconds
+	// between two health checks of its load balancer, from 1 to 300. Shared health checks ignore it.
+	ServiceAnnotationHealthCheckInterval = "networking.gke.io/health-check-interval"
+
+	// ServiceAnnotationHealthCheckTimeout is annotated on a service to set the number of seconds
+	// to wait for a health check response, from 1 to 300 and no more than the interval.
+	ServiceAnnotationHealthCheckTimeout = "networking.gke.io/health-check-timeout"
+
+	// ServiceAnnotationHealthCheckHealthyThreshold is annotated on a service to set the number of
+	// consecutive successful health checks marking a backend healthy, from 1 to 10.
+	ServiceAnnotationHealthCheckHealthyThreshold = "networking.gke.io/health-check-healthy-threshold"
+
+	// ServiceAnnotationHealthCheckUnhealthyThreshold is annotated on a service to set the number of
+	// consecutive failed health checks marking a backend unhealthy, from 1 to 10.
+	ServiceAnnotationHealthCheckUnhealthyThreshold = "networking.gke.io/health-check-unhealthy-threshold"
+
+	// ServiceAnnotationFirewallLogging is annotated on a service with "true" or "false" to enable
+	// or disable the logging of the connections allowed by the traffic firewall of its load balancer.
+	ServiceAnnotationFirewallLogging = "networking.gke.io/firewall-logging"
+
+	// ServiceAnnotationFirewallLoggingMetadata is annotated on a service to select the metadata of
+	// the logs of its traffic firewall: INCLUDE_ALL_METADATA or EXCLUDE_ALL_METADATA.
+	ServiceAnnotationFirewallLoggingMetadata = "networking.gke.io/firewall-logging-metadata"
+
+	// ServiceAnnotationFirewallPriority is annotated on a service to set the priority of the traffic
+	// firewall of its load balancer, from 1 to 65535. Network firewall policies ignore it.
+	ServiceAnnotationFirewallPriority = "networking.gke.io/firewall-priority"
+
+	// ServiceAnnotationLoadBalancerNodeSelector is annotated on a service with a node label selector,
+	// e.g. "pool=ingress,!batch", to only send the traffic of its load balancer to the matching nodes.
+	// Its health check, health check firewall and traffic firewall then only target these nodes.
+	// The instance groups of the cluster contain all the nodes, so internal load balancers of services
+	// setting it must set ServiceAnnotationILBBackendType to network endpoint groups, and external ones
+	// are backed by target pools.
+	ServiceAnnotationLoadBalancerNodeSelector = "networking.gke.io/load-balancer-node-selector"
 )
 
 // GetLoadBalancerAnnotationType returns the type of GCP load balancer which should be assembled.
@@ -140,6 +221,52 @@ func GetServiceNetworkTier(service *v1.Service) (cloud.NetworkTier, error) {
 	}
 }
 
+// GetLoadBalancerAnnotationILBBackendType returns the backend type of the internal load balancer of
+// the service, and an error if the specified type is not supported.
+func GetLoadBalancerAnnotationILBBackendType(service *v1.Service) (ILBBackendType, error) {
+	l, ok := service.Annotations[ServiceAnnotationILBBackendType]
+	if !ok {
+		return ILBBackendTypeInstanceGroup, nil
+	}
+	switch v := ILBBackendType(l); v {
+	case ILBBackendTypeInstanceGroup, ILBBackendTypeNEG:
+		return v, nil
+	default:
+		return ILBBackendTypeInstanceGroup, fmt.Errorf("unsupported internal load balancer backend type: %q", v)
+	}
+}
+
+// GetLoadBalancerAnnotationILBSessionAffinity returns the GCE session affinity of the internal load
+// balancer of the service, or "" if it is not annotated, and an error if the specified affinity is
+// not supported or the service does not have the ClientIP session affinity.
+func GetLoadBalancerAnnotationILBSessionAffinity(service *v1.Service) (string, error) {
+	l, ok := service.Annotations[ServiceAnnotationILBSessionAffinity]
+	if !ok {
+		return "", nil
+	}
+	switch l {
+	case gceAffinityTypeClientIPProto, gceAffinityTypeClientIPPortProto, gceAffinityTypeClientIPNoDestination:
+	default:
+		return "", fmt.Errorf("unsupported internal load balancer session affinity: %q", l)
+	}
+	if service.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		return "", fmt.Errorf("annotation %s requires the %s session affinity", ServiceAnnotationILBSessionAffinity, v1.ServiceAffinityClientIP)
+	}
+	return l, nil
+}
+
+// GetLoadBalancerAnnotationIPAddressNames returns the names of the static addresses annotated on
+// the service.
+func GetLoadBalancerAnnotationIPAddressNames(service *v1.Service) []string {
+	var names []string
+	for _, name := range strings.Split(service.Annotations[ServiceAnnotationLoadBalancerIPAddresses], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // ILBOptions represents the extra options specified when creating a
 // load balancer.
 type ILBOptions struct {
@@ -162,3 +289,89 @@ func GetLoadBalancerAnnotationSubnet(service *v1.Service) string {
 	}
 	return ""
 }
+
+// HealthCheckParams represents the tuning of the health check of a load balancer.
+type HealthCheckParams struct {
+	CheckIntervalSec   int64
+	TimeoutSec         int64
+	HealthyThreshold   int64
+	UnhealthyThreshold int64
+}
+
+// defaultHealthCheckParams returns the tuning of the health checks not tuned by annotations.
+func defaultHealthCheckParams() HealthCheckParams {
+	return HealthCheckParams{
+		CheckIntervalSec:   gceHcCheckIntervalSeconds,
+		TimeoutSec:         gceHcTimeoutSeconds,
+		HealthyThreshold:   gceHcHealthyThreshold,
+		UnhealthyThreshold: gceHcUnhealthyThreshold,
+	}
+}
+
+// healthCheckParamsOrDefault returns params, or the default tuning if params is nil.
+func healthCheckParamsOrDefault(params *HealthCheckParams) HealthCheckParams {
+	if params == nil {
+		return defaultHealthCheckParams()
+	}
+	return *params
+}
+
+// GetLoadBalancerAnnotationHealthCheckParams returns the health check tuning set by the annotations
+// of the service, or nil if none is set. Values that are not annotated keep their default, and an
+// error is returned if a value is not supported.
+func GetLoadBalancerAnnotationHealthCheckParams(service *v1.Service) (*HealthCheckParams, error) {
+	params := defaultHealthCheckParams()
+	annotated := false
+	for _, a := range []struct {
+		key      string
+		value    *int64
+		min, max int64
+	}{
+		{ServiceAnnotationHealthCheckInterval, &params.CheckIntervalSec, 1, 300},
+		{ServiceAnnotationHealthCheckTimeout, &params.TimeoutSec, 1, 300},
+		{ServiceAnnotationHealthCheckHealthyThreshold, &params.HealthyThreshold, 1, 10},
+		{ServiceAnnotationHealthCheckUnhealthyThreshold, &params.UnhealthyThreshold, 1, 10},
+	} {
+		l, ok := service.Annotations[a.key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || v < a.min || v > a.max {
+			return nil, fmt.Errorf("invalid value %q for annotation %s: must be an integer from %d to %d", l, a.key, a.min, a.max)
+		}
+		*a.value = v
+		annotated = true
+	}
+	if !annotated {
+		return nil, nil
+	}
+	if params.TimeoutSec > params.CheckIntervalSec {
+		return nil, fmt.Errorf("health check timeout %ds is longer than the health check interval %ds", params.TimeoutSec, params.CheckIntervalSec)
+	}
+	return &params, nil
+}
+
+// GetLoadBalancerAnnotationNodeSelector returns the selector of the nodes of the load balancer of
+// the service, which selects all the nodes if the service is not annotated.
+func GetLoadBalancerAnnotationNodeSelector(service *v1.Service) (labels.Selector, error) {
+	l, ok := service.Annotations[ServiceAnnotationLoadBalancerNodeSelector]
+	if !ok {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(l)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for annotation %s: %v", l, ServiceAnnotationLoadBalancerNodeSelector, err)
+	}
+	return selector, nil
+}
+
+// serviceHealthCheckParams returns the health check tuning set by the annotations of the service,
+// or nil if none is set or the health check is shared. Shared health checks keep the default tuning.
+func serviceHealthCheckParams(service *v1.Service, shared bool) (*HealthCheckParams, error) {
+	params, err := GetLoadBalancerAnnotationHealthCheckParams(service)
+	if err != nil || shared {
+		return nil, err
+	}
+	return params, nil
+}
@@ -72,3 +72,77 @@ func TestServiceNetworkTierAnnotationKey(t *testing.T) {
 		})
 	}
 }
+
+func TestGetLoadBalancerAnnotationHealthCheckParams(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations    map[string]string
+		expectedParams *HealthCheckParams
+		expectErr      bool
+	}{
+		"No annotation": {},
+		"All values": {
+			annotations: map[string]string{
+				ServiceAnnotationHealthCheckInterval:           "2",
+				ServiceAnnotationHealthCheckTimeout:            "2",
+				ServiceAnnotationHealthCheckHealthyThreshold:   "4",
+				ServiceAnnotationHealthCheckUnhealthyThreshold: "5",
+			},
+			expectedParams: &HealthCheckParams{CheckIntervalSec: 2, TimeoutSec: 2, HealthyThreshold: 4, UnhealthyThreshold: 5},
+		},
+		"Values not annotated keep their default": {
+			annotations:    map[string]string{ServiceAnnotationHealthCheckInterval: "30"},
+			expectedParams: &HealthCheckParams{CheckIntervalSec: 30, TimeoutSec: gceHcTimeoutSeconds, HealthyThreshold: gceHcHealthyThreshold, UnhealthyThreshold: gceHcUnhealthyThreshold},
+		},
+		"Report an error on a non integer value": {
+			annotations: map[string]string{ServiceAnnotationHealthCheckTimeout: "1s"},
+			expectErr:   true,
+		},
+		"Report an error on an out of range value": {
+			annotations: map[string]string{ServiceAnnotationHealthCheckUnhealthyThreshold: "11"},
+			expectErr:   true,
+		},
+		"Report an error on a timeout longer than the interval": {
+			annotations: map[string]string{ServiceAnnotationHealthCheckTimeout: "10"},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Annotations: testCase.annotations}}
+			params, err := GetLoadBalancerAnnotationHealthCheckParams(svc)
+			assert.Equal(t, testCase.expectedParams, params)
+			assert.Equal(t, testCase.expectErr, err != nil)
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationILBBackendType(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations  map[string]string
+		expectedType ILBBackendType
+		expectErr    bool
+	}{
+		"No annotation": {
+			expectedType: ILBBackendTypeInstanceGroup,
+		},
+		"Instance groups": {
+			annotations:  map[string]string{ServiceAnnotationILBBackendType: "InstanceGroup"},
+			expectedType: ILBBackendTypeInstanceGroup,
+		},
+		"Network endpoint groups": {
+			annotations:  map[string]string{ServiceAnnotationILBBackendType: "GCE_VM_IP_NEG"},
+			expectedType: ILBBackendTypeNEG,
+		},
+		"Report an error on an unknown type": {
+			annotations:  map[string]string{ServiceAnnotationILBBackendType: "GCE_VM_IP_PORT"},
+			expectedType: ILBBackendTypeInstanceGroup,
+			expectErr:    true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Annotations: testCase.annotations}}
+			backendType, err := GetLoadBalancerAnnotationILBBackendType(svc)
+			assert.Equal(t, testCase.expectedType, backendType)
+			assert.Equal(t, testCase.expectErr, err != nil)
+		})
+	}
+}
@@ -208,6 +208,7 @@ func (manager *gceServiceManager) AttachDiskOnCloudProvider(
 
 	ctx, cancel := cloud.ContextWithCallTimeout()
 	defer cancel()
+	defer manager.gce.instanceCache.invalidate(instanceZone, instanceName)
 	return manager.gce.c.Instances().AttachDisk(ctx, meta.ZonalKey(instanceName, instanceZone), attachedDiskV1)
 }
 
@@ -217,6 +218,7 @@ func (manager *gceServiceManager) DetachDiskOnCloudProvider(
 	devicePath string) error {
 	ctx, cancel := cloud.ContextWithCallTimeout()
 	defer cancel()
+	defer manager.gce.instanceCache.invalidate(instanceZone, instanceName)
 	return manager.gce.c.Instances().DetachDisk(ctx, meta.ZonalKey(instanceName, instanceZone), devicePath)
 }
 
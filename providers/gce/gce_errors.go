@@ -0,0 +1,134 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider/api"
+	"k8s.io/klog/v2"
+)
+
+// gceErrorClass is the class of an error returned by GCE. It is the class label of the classified
+// error metrics, and the reason of the events raised on the objects affected by the error.
+type gceErrorClass string
+
+const (
+	gceErrorQuotaExceeded     gceErrorClass = "QuotaExceeded"
+	gceErrorRateLimitExceeded gceErrorClass = "RateLimitExceeded"
+	gceErrorResourceInUse     gceErrorClass = "ResourceInUse"
+	gceErrorIPSpaceExhausted  gceErrorClass = "IPSpaceExhausted"
+	gceErrorPermissionDenied  gceErrorClass = "PermissionDenied"
+	// gceErrorOther is the class of the errors not in any other class, which are neither reported
+	// by events nor retried after a delay.
+	gceErrorOther gceErrorClass = "Other"
+)
+
+// gceErrorClasses describe the classes of errors, and the delay after which the operations they
+// failed are retried.
+var gceErrorClasses = map[gceErrorClass]struct {
+	description string
+	retryAfter  time.Duration
+}{
+	gceErrorQuotaExceeded:     {"a quota of the project is exceeded", 5 * time.Minute},
+	gceErrorRateLimitExceeded: {"a rate limit of the project is exceeded", 30 * time.Second},
+	gceErrorResourceInUse:     {"a resource is in use by another resource", time.Minute},
+	gceErrorIPSpaceExhausted:  {"the IP space of the subnetwork is exhausted", 5 * time.Minute},
+	gceErrorPermissionDenied:  {"the permission is denied", 5 * time.Minute},
+}
+
+// classifyGCEError returns the class of the error. The errors of failed operations are
+// googleapi errors whose message starts with the code of the operation error.
+func classifyGCEError(err error) gceErrorClass {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return gceErrorOther
+	}
+	switch {
+	case isRateLimitExceeded(apiErr):
+		return gceErrorRateLimitExceeded
+	case isGCEError(apiErr, "quotaExceeded") || strings.HasPrefix(apiErr.Message, "QUOTA_EXCEEDED"):
+		return gceErrorQuotaExceeded
+	case isGCEError(apiErr, "resourceInUseByAnotherResource") || strings.HasPrefix(apiErr.Message, "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE") || isInUsedByError(apiErr):
+		return gceErrorResourceInUse
+	case strings.Contains(apiErr.Message, "IP_SPACE_EXHAUSTED"):
+		return gceErrorIPSpaceExhausted
+	case apiErr.Code == http.StatusForbidden:
+		return gceErrorPermissionDenied
+	}
+	return gceErrorOther
+}
+
+// gceError is an error returned by GCE, of a class other than gceErrorOther. It converts to an
+// api.RetryError with errors.As, so that the controllers retry the operation after the delay of
+// the class instead of hot-looping.
+type gceError struct {
+	class gceErrorClass
+	err   error
+}
+
+func (e *gceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *gceError) Unwrap() error {
+	return e.err
+}
+
+// As converts the error to an api.RetryError.
+func (e *gceError) As(target interface{}) bool {
+	retryErr, ok := target.(**api.RetryError)
+	if ok {
+		*retryErr = api.NewRetryError(e.err.Error(), gceErrorClasses[e.class].retryAfter)
+	}
+	return ok
+}
+
+// classifyError raises an event of the class of the error on the object affected by the error,
+// and returns the error with the retry hint of its class. Errors of gceErrorOther are returned
+// unchanged.
+func (g *Cloud) classifyError(obj runtime.Object, err error) error {
+	class := classifyGCEError(err)
+	if class == gceErrorOther {
+		return err
+	}
+	var gceErr *gceError
+	if errors.As(err, &gceErr) {
+		// The error was already classified and reported.
+		return err
+	}
+	klog.V(2).Infof("classifyError: %s error: %v", class, err)
+	if g.eventRecorder != nil {
+		g.eventRecorder.Eventf(obj, v1.EventTypeWarning, string(class), "GCE call failed because %s: %v", gceErrorClasses[class].description, err)
+	}
+	return &gceError{class: class, err: err}
+}
+
+// nodeReference returns the reference of the node to raise events on.
+func nodeReference(nodeName types.NodeName) *v1.ObjectReference {
+	return &v1.ObjectReference{Kind: "Node", Name: string(nodeName), UID: types.UID(nodeName)}
+}
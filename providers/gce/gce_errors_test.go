@@ -0,0 +1,152 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/api"
+)
+
+func TestClassifyGCEError(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want gceErrorClass
+	}{
+		{desc: "not an API error", err: errors.New("boom"), want: gceErrorOther},
+		{desc: "not found", err: &googleapi.Error{Code: http.StatusNotFound}, want: gceErrorOther},
+		{desc: "quota reason", err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, want: gceErrorQuotaExceeded},
+		{desc: "quota operation error", err: &googleapi.Error{Code: http.StatusForbidden, Message: "QUOTA_EXCEEDED - Quota 'FORWARDING_RULES' exceeded."}, want: gceErrorQuotaExceeded},
+		{desc: "too many requests", err: &googleapi.Error{Code: http.StatusTooManyRequests}, want: gceErrorRateLimitExceeded},
+		{desc: "rate limit reason", err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, want: gceErrorRateLimitExceeded},
+		{desc: "in use reason", err: &googleapi.Error{Code: http.StatusBadRequest, Errors: []googleapi.ErrorItem{{Reason: "resourceInUseByAnotherResource"}}}, want: gceErrorResourceInUse},
+		{desc: "in use message", err: &googleapi.Error{Code: http.StatusBadRequest, Message: "The health_check resource is already being used by backend service"}, want: gceErrorResourceInUse},
+		{desc: "IP space exhausted", err: &googleapi.Error{Code: http.StatusBadRequest, Message: "IP_SPACE_EXHAUSTED - IP space of subnetwork is exhausted."}, want: gceErrorIPSpaceExhausted},
+		{desc: "permission denied", err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, want: gceErrorPermissionDenied},
+		{desc: "wrapped API error", err: fmt.Errorf("failed: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), want: gceErrorRateLimitExceeded},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.want, classifyGCEError(tc.err))
+		})
+	}
+}
+
+func TestEnsureLoadBalancerClassifiesErrors(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	c := gce.c.(*cloud.MockGCE)
+	c.MockForwardingRules.InsertHook = insertForwardingRulesQuotaExceededHook
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+
+	// The controllers retry after the delay of the class, and still see the API error.
+	var retryErr *api.RetryError
+	require.True(t, errors.As(fmt.Errorf("failed to ensure load balancer: %w", err), &retryErr))
+	assert.Equal(t, 5*time.Minute, retryErr.RetryAfter())
+	var apiErr *googleapi.Error
+	assert.True(t, errors.As(err, &apiErr))
+
+	found := false
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.HasPrefix(event, "Warning "+string(gceErrorQuotaExceeded)+" ") {
+			found = true
+		}
+	}
+	assert.True(t, found, "no %s event", gceErrorQuotaExceeded)
+}
+
+func TestLoadBalancerClassifiesErrorsBeforeEnsuring(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.loadBalancerNamingScheme = LoadBalancerNamingSchemeV2
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The load balancer name is chosen by looking up the forwarding rules of the load balancer.
+	c := gce.c.(*cloud.MockGCE)
+	c.MockForwardingRules.GetHook = func(ctx context.Context, key *meta.Key, m *cloud.MockForwardingRules, options ...cloud.Option) (bool, *compute.ForwardingRule, error) {
+		return true, nil, &googleapi.Error{Code: http.StatusTooManyRequests}
+	}
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	var retryErr *api.RetryError
+	require.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, 30*time.Second, retryErr.RetryAfter())
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning "+string(gceErrorRateLimitExceeded)+" ")
+}
+
+func TestCreateRouteClassifiesErrors(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	_, err = createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	c := gce.c.(*cloud.MockGCE)
+	c.MockRoutes.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.Route, m *cloud.MockRoutes, options ...cloud.Option) (bool, error) {
+		return true, &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}
+	}
+	err = gce.CreateRoute(context.TODO(), vals.ClusterName, "route", &cloudprovider.Route{TargetNode: "test-node-1", DestinationCIDR: "10.0.0.0/24"})
+	var retryErr *api.RetryError
+	require.True(t, errors.As(err, &retryErr))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning "+string(gceErrorPermissionDenied)+" GCE call failed because the permission is denied")
+}
@@ -90,6 +90,7 @@ func NewFakeGCECloud(vals TestClusterValues) *Cloud {
 		unsafeSubnetworkURL: vals.SubnetworkURL,
 		stackType:           vals.StackType,
 	}
+	gce.internalNEGEndpointsQueue = newInternalNEGEndpointsQueue()
 	c := cloud.NewMockGCE(&gceProjectRouter{gce})
 	gce.c = c
 	return gce
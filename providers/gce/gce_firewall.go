@@ -21,8 +21,10 @@ package gce
 
 import (
 	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 )
 
@@ -49,6 +51,16 @@ func (g *Cloud) CreateFirewall(f *compute.Firewall) error {
 	return mc.Observe(g.c.Firewalls().Insert(ctx, meta.GlobalKey(f.Name), f))
 }
 
+// ListFirewalls lists all Firewalls in the project.
+func (g *Cloud) ListFirewalls() ([]*compute.Firewall, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallMetricContext("list")
+	v, err := g.c.Firewalls().List(ctx, filter.None)
+	return v, mc.Observe(err)
+}
+
 // DeleteFirewall deletes the given firewall rule.
 func (g *Cloud) DeleteFirewall(name string) error {
 	ctx, cancel := cloud.ContextWithCallTimeout()
@@ -75,3 +87,48 @@ func (g *Cloud) PatchFirewall(f *compute.Firewall) error {
 	mc := newFirewallMetricContext("Patch")
 	return mc.Observe(g.c.Firewalls().Patch(ctx, meta.GlobalKey(f.Name), f))
 }
+
+// The firewalls of the load balancers are VPC firewall rules, or rules of the network firewall
+// policy of the cluster if one is configured, see firewallPolicy.
+
+func (g *Cloud) getLoadBalancerFirewall(name string) (*compute.Firewall, error) {
+	if g.firewallPolicy != nil {
+		return g.getFirewallPolicyRule(name)
+	}
+	return g.GetFirewall(name)
+}
+
+func (g *Cloud) createLoadBalancerFirewall(f *compute.Firewall) error {
+	if g.firewallPolicy != nil {
+		return g.createFirewallPolicyRule(f)
+	}
+	return g.CreateFirewall(f)
+}
+
+func (g *Cloud) patchLoadBalancerFirewall(f *compute.Firewall) error {
+	if g.firewallPolicy != nil {
+		return g.patchFirewallPolicyRule(f)
+	}
+	return g.PatchFirewall(firewallPatch(f))
+}
+
+func (g *Cloud) deleteLoadBalancerFirewall(name string) error {
+	if g.firewallPolicy != nil {
+		return g.deleteFirewallPolicyRule(name)
+	}
+	return g.DeleteFirewall(name)
+}
+
+// loadBalancerFirewallTargetTags returns the network tags of the nodes targeted by the firewalls of
+// the load balancers. Rules of network firewall policies can't target network tags, and firewalls
+// targeting the service accounts of the nodes don't.
+func (g *Cloud) loadBalancerFirewallTargetTags(svc *v1.Service, nodes []*v1.Node) ([]string, error) {
+	if g.firewallPolicy != nil || g.firewallConfig.targetsServiceAccounts() {
+		return nil, nil
+	}
+	tags, err := g.GetNodeTags(nodeNames(nodes))
+	if err != nil {
+		g.raiseNodeTagsDiscoveryFailedEvent(svc, err)
+	}
+	return tags, err
+}
@@ -0,0 +1,179 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"strconv"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// FirewallLoggingIncludeAllMetadata and FirewallLoggingExcludeAllMetadata are the metadata
+	// modes of the logs of the firewalls.
+	FirewallLoggingIncludeAllMetadata = "INCLUDE_ALL_METADATA"
+	FirewallLoggingExcludeAllMetadata = "EXCLUDE_ALL_METADATA"
+
+	// defaultFirewallPriority is the priority GCE gives to firewalls created without one.
+	defaultFirewallPriority = 1000
+	// maxFirewallPriority is the lowest priority of a firewall.
+	maxFirewallPriority = 65535
+)
+
+// FirewallConfig holds the options of the firewalls of the load balancers.
+type FirewallConfig struct {
+	// Logging enables the logging of the connections allowed by the firewalls.
+	Logging bool
+	// LoggingMetadata is FirewallLoggingIncludeAllMetadata (the default) or FirewallLoggingExcludeAllMetadata.
+	LoggingMetadata string
+	// Priority of the firewalls, from 1 to 65535. 0 keeps the default priority of GCE.
+	Priority int64
+	// TargetServiceAccounts are the service accounts of the nodes. When set, the firewalls target
+	// them instead of the network tags of the nodes.
+	TargetServiceAccounts []string
+}
+
+// parseFirewallConfig returns the options of the firewalls of the load balancers set in the config.
+func parseFirewallConfig(global *ConfigGlobal) (FirewallConfig, error) {
+	config := FirewallConfig{
+		Logging:               global.FirewallLogging,
+		LoggingMetadata:       global.FirewallLoggingMetadata,
+		Priority:              global.FirewallPriority,
+		TargetServiceAccounts: global.FirewallTargetServiceAccounts,
+	}
+	if err := validateFirewallLoggingMetadata(config.LoggingMetadata); err != nil {
+		return config, err
+	}
+	if config.Priority < 0 || config.Priority > maxFirewallPriority {
+		return config, fmt.Errorf("invalid firewall-priority %d: must be from 1 to %d", config.Priority, maxFirewallPriority)
+	}
+	return config, nil
+}
+
+func validateFirewallLoggingMetadata(metadata string) error {
+	switch metadata {
+	case "", FirewallLoggingIncludeAllMetadata, FirewallLoggingExcludeAllMetadata:
+		return nil
+	default:
+		return fmt.Errorf("unsupported firewall logging metadata %q: must be %s or %s", metadata, FirewallLoggingIncludeAllMetadata, FirewallLoggingExcludeAllMetadata)
+	}
+}
+
+// GetLoadBalancerAnnotationFirewallConfig returns the options of the firewalls of the load balancer
+// of the service: the given defaults, overridden by the annotations of the service.
+func GetLoadBalancerAnnotationFirewallConfig(service *v1.Service, defaults FirewallConfig) (FirewallConfig, error) {
+	config := defaults
+	if l, ok := service.Annotations[ServiceAnnotationFirewallLogging]; ok {
+		logging, err := strconv.ParseBool(l)
+		if err != nil {
+			return config, fmt.Errorf("invalid value %q for annotation %s: must be true or false", l, ServiceAnnotationFirewallLogging)
+		}
+		config.Logging = logging
+	}
+	if l, ok := service.Annotations[ServiceAnnotationFirewallLoggingMetadata]; ok {
+		if err := validateFirewallLoggingMetadata(l); err != nil {
+			return config, fmt.Errorf("invalid annotation %s: %v", ServiceAnnotationFirewallLoggingMetadata, err)
+		}
+		config.LoggingMetadata = l
+	}
+	if l, ok := service.Annotations[ServiceAnnotationFirewallPriority]; ok {
+		priority, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || priority < 1 || priority > maxFirewallPriority {
+			return config, fmt.Errorf("invalid value %q for annotation %s: must be an integer from 1 to %d", l, ServiceAnnotationFirewallPriority, maxFirewallPriority)
+		}
+		config.Priority = priority
+	}
+	return config, nil
+}
+
+// loadBalancerFirewallConfig returns the options of the traffic firewalls of the load balancer of
+// the service, or of the health check firewalls, shared by the services, if svc is nil. Rules of
+// network firewall policies keep the priority allocated by the policy.
+func (g *Cloud) loadBalancerFirewallConfig(svc *v1.Service) (FirewallConfig, error) {
+	config := g.firewallConfig
+	if svc != nil {
+		var err error
+		if config, err = GetLoadBalancerAnnotationFirewallConfig(svc, config); err != nil {
+			return config, err
+		}
+	}
+	if g.firewallPolicy != nil {
+		config.Priority = 0
+	}
+	return config, nil
+}
+
+// targetsServiceAccounts returns whether the firewalls target the service accounts of the nodes
+// instead of their network tags.
+func (c FirewallConfig) targetsServiceAccounts() bool {
+	return len(c.TargetServiceAccounts) > 0
+}
+
+// apply sets the options to the firewall.
+func (c FirewallConfig) apply(fw *compute.Firewall) {
+	fw.Priority = c.Priority
+	fw.LogConfig = nil
+	if c.Logging {
+		fw.LogConfig = &compute.FirewallLogConfig{Enable: true, Metadata: c.LoggingMetadata}
+	}
+	if c.targetsServiceAccounts() {
+		fw.TargetTags = nil
+		fw.TargetServiceAccounts = c.TargetServiceAccounts
+	}
+}
+
+// firewallConfigEqual returns whether both firewalls have the same options. The logging metadata
+// mode is only compared when set on both, as GCE defaults it and network firewall policies ignore it.
+func firewallConfigEqual(a, b *compute.Firewall) bool {
+	if firewallPriority(a) != firewallPriority(b) || !equalStringSets(a.TargetServiceAccounts, b.TargetServiceAccounts) {
+		return false
+	}
+	aLogging, bLogging := a.LogConfig != nil && a.LogConfig.Enable, b.LogConfig != nil && b.LogConfig.Enable
+	if aLogging != bLogging {
+		return false
+	}
+	return !aLogging || a.LogConfig.Metadata == "" || b.LogConfig.Metadata == "" || a.LogConfig.Metadata == b.LogConfig.Metadata
+}
+
+func firewallPriority(fw *compute.Firewall) int64 {
+	if fw.Priority == 0 {
+		return defaultFirewallPriority
+	}
+	return fw.Priority
+}
+
+// firewallPatch returns the firewall to patch the existing firewall with, explicitly disabling
+// logging and clearing the targets that are not set, which patches otherwise leave unchanged.
+func firewallPatch(fw *compute.Firewall) *compute.Firewall {
+	patch := *fw
+	if patch.LogConfig == nil {
+		patch.LogConfig = &compute.FirewallLogConfig{Enable: false, ForceSendFields: []string{"Enable"}}
+	}
+	patch.NullFields = append([]string{}, fw.NullFields...)
+	if len(patch.TargetTags) == 0 {
+		patch.NullFields = append(patch.NullFields, "TargetTags")
+	}
+	if len(patch.TargetServiceAccounts) == 0 {
+		patch.NullFields = append(patch.NullFields, "TargetServiceAccounts")
+	}
+	return &patch
+}
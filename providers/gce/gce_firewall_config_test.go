@@ -0,0 +1,189 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testNodeServiceAccount = "nodes@project.iam.gserviceaccount.com"
+
+func TestGetLoadBalancerAnnotationFirewallConfig(t *testing.T) {
+	t.Parallel()
+
+	defaults := FirewallConfig{Logging: true, Priority: 900, TargetServiceAccounts: []string{testNodeServiceAccount}}
+	for _, tc := range []struct {
+		desc        string
+		annotations map[string]string
+		want        FirewallConfig
+		wantErr     bool
+	}{
+		{
+			desc: "no annotation",
+			want: defaults,
+		},
+		{
+			desc: "annotations override the defaults",
+			annotations: map[string]string{
+				ServiceAnnotationFirewallLogging:         "false",
+				ServiceAnnotationFirewallLoggingMetadata: FirewallLoggingExcludeAllMetadata,
+				ServiceAnnotationFirewallPriority:        "500",
+			},
+			want: FirewallConfig{LoggingMetadata: FirewallLoggingExcludeAllMetadata, Priority: 500, TargetServiceAccounts: []string{testNodeServiceAccount}},
+		},
+		{
+			desc:        "invalid logging",
+			annotations: map[string]string{ServiceAnnotationFirewallLogging: "yes please"},
+			wantErr:     true,
+		},
+		{
+			desc:        "invalid logging metadata",
+			annotations: map[string]string{ServiceAnnotationFirewallLoggingMetadata: "CUSTOM_METADATA"},
+			wantErr:     true,
+		},
+		{
+			desc:        "priority out of range",
+			annotations: map[string]string{ServiceAnnotationFirewallPriority: "65536"},
+			wantErr:     true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService("")
+			for k, v := range tc.annotations {
+				svc.Annotations[k] = v
+			}
+			config, err := GetLoadBalancerAnnotationFirewallConfig(svc, defaults)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+		})
+	}
+}
+
+func TestFirewallConfigEqual(t *testing.T) {
+	t.Parallel()
+
+	newFirewall := func(config FirewallConfig) *compute.Firewall {
+		fw := &compute.Firewall{TargetTags: []string{"node-tag"}}
+		config.apply(fw)
+		return fw
+	}
+	assert.True(t, firewallConfigEqual(newFirewall(FirewallConfig{}), &compute.Firewall{Priority: 1000}), "0 is the default priority")
+	assert.True(t, firewallConfigEqual(newFirewall(FirewallConfig{}), &compute.Firewall{LogConfig: &compute.FirewallLogConfig{}}))
+	assert.False(t, firewallConfigEqual(newFirewall(FirewallConfig{Priority: 900}), &compute.Firewall{Priority: 1000}))
+	assert.False(t, firewallConfigEqual(newFirewall(FirewallConfig{Logging: true}), &compute.Firewall{}))
+	assert.True(t, firewallConfigEqual(newFirewall(FirewallConfig{Logging: true}), &compute.Firewall{LogConfig: &compute.FirewallLogConfig{Enable: true, Metadata: FirewallLoggingIncludeAllMetadata}}))
+	assert.False(t, firewallConfigEqual(newFirewall(FirewallConfig{Logging: true, LoggingMetadata: FirewallLoggingExcludeAllMetadata}), &compute.Firewall{LogConfig: &compute.FirewallLogConfig{Enable: true, Metadata: FirewallLoggingIncludeAllMetadata}}))
+	assert.False(t, firewallConfigEqual(newFirewall(FirewallConfig{TargetServiceAccounts: []string{testNodeServiceAccount}}), &compute.Firewall{}))
+}
+
+func TestEnsureInternalLoadBalancerWithFirewallConfig(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.firewallConfig = FirewallConfig{Logging: true, TargetServiceAccounts: []string{testNodeServiceAccount}}
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationFirewallPriority] = "900"
+	svc.Annotations[ServiceAnnotationFirewallLoggingMetadata] = FirewallLoggingExcludeAllMetadata
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Empty(t, fw.TargetTags)
+	assert.Equal(t, []string{testNodeServiceAccount}, fw.TargetServiceAccounts)
+	assert.Equal(t, int64(900), fw.Priority)
+	assert.Equal(t, &compute.FirewallLogConfig{Enable: true, Metadata: FirewallLoggingExcludeAllMetadata}, fw.LogConfig)
+
+	// Health check firewalls only follow the cloud config.
+	hcFw, err := gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, true))
+	require.NoError(t, err)
+	assert.Empty(t, hcFw.TargetTags)
+	assert.Equal(t, []string{testNodeServiceAccount}, hcFw.TargetServiceAccounts)
+	assert.Zero(t, hcFw.Priority)
+	assert.Equal(t, &compute.FirewallLogConfig{Enable: true}, hcFw.LogConfig)
+
+	// Logging can be turned off by the service.
+	svc.Annotations[ServiceAnnotationFirewallLogging] = "false"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fw, err = gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.False(t, fw.LogConfig.Enable)
+	assert.Equal(t, int64(900), fw.Priority)
+
+	svc.Annotations[ServiceAnnotationFirewallPriority] = "0"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+}
+
+func TestEnsureExternalLoadBalancerWithFirewallConfig(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.firewallConfig = FirewallConfig{Priority: 900, TargetServiceAccounts: []string{testNodeServiceAccount}}
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	svc.Annotations[ServiceAnnotationFirewallLogging] = "true"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Empty(t, fw.TargetTags)
+	assert.Equal(t, []string{testNodeServiceAccount}, fw.TargetServiceAccounts)
+	assert.Equal(t, int64(900), fw.Priority)
+	assert.Equal(t, &compute.FirewallLogConfig{Enable: true}, fw.LogConfig)
+	hcFw, err := gce.GetFirewall(MakeHealthCheckFirewallName(vals.ClusterID, lbName, false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{testNodeServiceAccount}, hcFw.TargetServiceAccounts)
+	assert.Nil(t, hcFw.LogConfig)
+
+	// Changing the priority updates the firewall.
+	svc.Annotations[ServiceAnnotationFirewallPriority] = "800"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fw, err = gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, int64(800), fw.Priority)
+}
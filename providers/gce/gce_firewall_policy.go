@@ -0,0 +1,572 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultFirewallPolicyMinPriority and defaultFirewallPolicyMaxPriority bound the priorities
+	// of the rules written into a network firewall policy unless configured otherwise.
+	defaultFirewallPolicyMinPriority = 1000
+	defaultFirewallPolicyMaxPriority = 9999
+	// maxFirewallPolicyPriority is the lowest priority of a rule of a network firewall policy.
+	maxFirewallPolicyPriority = 2147483647
+
+	firewallPolicyRuleDirectionIngress = "INGRESS"
+	firewallPolicyRuleActionAllow      = "allow"
+)
+
+// FirewallPolicyConfig configures the network firewall policy the firewalls of the load
+// balancers are written into, instead of VPC firewall rules.
+type FirewallPolicyConfig struct {
+	// Name is the name of the network firewall policy. It must be associated with the network of
+	// the cluster.
+	Name string
+	// Region is the region of a regional network firewall policy, empty for a global one.
+	Region string
+	// MinPriority and MaxPriority bound the priorities of the rules of the load balancers.
+	MinPriority int64
+	MaxPriority int64
+}
+
+// parseFirewallPolicyConfig returns the network firewall policy configured in gce.conf, or nil if
+// none is. Rules of network firewall policies can't target the network tags of the nodes, so the
+// firewalls must target their service accounts.
+func parseFirewallPolicyConfig(global *ConfigGlobal) (*FirewallPolicyConfig, error) {
+	if global.FirewallPolicy == "" {
+		return nil, nil
+	}
+	if len(global.FirewallTargetServiceAccounts) == 0 {
+		return nil, fmt.Errorf("firewall-policy %q requires firewall-target-service-account to be set", global.FirewallPolicy)
+	}
+	config := &FirewallPolicyConfig{
+		Name:        global.FirewallPolicy,
+		Region:      global.FirewallPolicyRegion,
+		MinPriority: global.FirewallPolicyMinPriority,
+		MaxPriority: global.FirewallPolicyMaxPriority,
+	}
+	if config.MinPriority == 0 {
+		config.MinPriority = defaultFirewallPolicyMinPriority
+	}
+	if config.MaxPriority == 0 {
+		config.MaxPriority = defaultFirewallPolicyMaxPriority
+	}
+	if config.MinPriority < 0 || config.MaxPriority > maxFirewallPolicyPriority || config.MinPriority > config.MaxPriority {
+		return nil, fmt.Errorf("invalid firewall policy priorities %d to %d", config.MinPriority, config.MaxPriority)
+	}
+	return config, nil
+}
+
+func newFirewallPolicyMetricContext(request, region string) *metricContext {
+	if region == "" {
+		region = unusedMetricLabel
+	}
+	return newGenericMetricContext("firewallpolicy", request, region, unusedMetricLabel, computeV1Version)
+}
+
+// firewallPolicyRules reads and writes the rules of a network firewall policy. The rules of a
+// policy are identified by their priority.
+type firewallPolicyRules interface {
+	ListRules(ctx context.Context) ([]*compute.FirewallPolicyRule, error)
+	GetRule(ctx context.Context, priority int64) (*compute.FirewallPolicyRule, error)
+	AddRule(ctx context.Context, rule *compute.FirewallPolicyRule) error
+	PatchRule(ctx context.Context, rule *compute.FirewallPolicyRule) error
+	RemoveRule(ctx context.Context, priority int64) error
+}
+
+// firewallPolicy writes the firewalls of the load balancers as rules of a network firewall policy.
+// The rules written by the cluster are named after the firewalls and carry the cluster ID in their
+// description, so that the rules of other clusters and of users sharing the policy are left alone.
+type firewallPolicy struct {
+	config FirewallPolicyConfig
+	rules  firewallPolicyRules
+	// lock serializes the allocation of the priorities of new rules.
+	lock sync.Mutex
+
+	// prioritiesLock guards priorities.
+	prioritiesLock sync.Mutex
+	// priorities are the priorities of the rules written by the cluster, by name, so that a rule is
+	// read with a single call instead of listing the whole policy. They are loaded by the first
+	// listing of the rules, nil until then, and kept up to date by the rules the cluster adds and
+	// removes: the controller is the only writer of the rules of the cluster.
+	priorities map[string]int64
+}
+
+// firewallPolicyRuleDescription is the description of the rules written by the cluster.
+type firewallPolicyRuleDescription struct {
+	ClusterID   string `json:"kubernetes.io/cluster-id"`
+	Description string `json:"description,omitempty"`
+}
+
+// gceFirewallPolicyRules implements firewallPolicyRules with the compute API. The generated cloud
+// interfaces can't address a rule of a policy by priority, so the calls are made directly.
+type gceFirewallPolicyRules struct {
+	s         *cloud.Service
+	projectID string
+	region    string
+	name      string
+}
+
+// callKey returns the key the rate limiter accepts and observes the calls of the operation with.
+func (r *gceFirewallPolicyRules) callKey(operation string) *cloud.CallContextKey {
+	service := "NetworkFirewallPolicies"
+	if r.region != "" {
+		service = "RegionNetworkFirewallPolicies"
+	}
+	return &cloud.CallContextKey{
+		ProjectID: r.projectID,
+		Operation: operation,
+		Version:   meta.VersionGA,
+		Service:   service,
+	}
+}
+
+func (r *gceFirewallPolicyRules) ListRules(ctx context.Context) ([]*compute.FirewallPolicyRule, error) {
+	ck := r.callKey("Get")
+	if err := r.s.RateLimiter.Accept(ctx, ck); err != nil {
+		return nil, err
+	}
+	var policy *compute.FirewallPolicy
+	var err error
+	if r.region != "" {
+		policy, err = r.s.GA.RegionNetworkFirewallPolicies.Get(r.projectID, r.region, r.name).Context(ctx).Do()
+	} else {
+		policy, err = r.s.GA.NetworkFirewallPolicies.Get(r.projectID, r.name).Context(ctx).Do()
+	}
+	r.s.RateLimiter.Observe(ctx, err, ck)
+	if err != nil {
+		return nil, err
+	}
+	return policy.Rules, nil
+}
+
+func (r *gceFirewallPolicyRules) GetRule(ctx context.Context, priority int64) (*compute.FirewallPolicyRule, error) {
+	ck := r.callKey("GetRule")
+	if err := r.s.RateLimiter.Accept(ctx, ck); err != nil {
+		return nil, err
+	}
+	var rule *compute.FirewallPolicyRule
+	var err error
+	if r.region != "" {
+		rule, err = r.s.GA.RegionNetworkFirewallPolicies.GetRule(r.projectID, r.region, r.name).Priority(priority).Context(ctx).Do()
+	} else {
+		rule, err = r.s.GA.NetworkFirewallPolicies.GetRule(r.projectID, r.name).Priority(priority).Context(ctx).Do()
+	}
+	r.s.RateLimiter.Observe(ctx, err, ck)
+	return rule, err
+}
+
+func (r *gceFirewallPolicyRules) AddRule(ctx context.Context, rule *compute.FirewallPolicyRule) error {
+	ck := r.callKey("AddRule")
+	if err := r.s.RateLimiter.Accept(ctx, ck); err != nil {
+		return err
+	}
+	var op *compute.Operation
+	var err error
+	if r.region != "" {
+		op, err = r.s.GA.RegionNetworkFirewallPolicies.AddRule(r.projectID, r.region, r.name, rule).Context(ctx).Do()
+	} else {
+		op, err = r.s.GA.NetworkFirewallPolicies.AddRule(r.projectID, r.name, rule).Context(ctx).Do()
+	}
+	r.s.RateLimiter.Observe(ctx, err, ck)
+	if err != nil {
+		return err
+	}
+	return r.s.WaitForCompletion(ctx, op)
+}
+
+func (r *gceFirewallPolicyRules) PatchRule(ctx context.Context, rule *compute.FirewallPolicyRule) error {
+	ck := r.callKey("PatchRule")
+	if err := r.s.RateLimiter.Accept(ctx, ck); err != nil {
+		return err
+	}
+	var op *compute.Operation
+	var err error
+	if r.region != "" {
+		op, err = r.s.GA.RegionNetworkFirewallPolicies.PatchRule(r.projectID, r.region, r.name, rule).Priority(rule.Priority).Context(ctx).Do()
+	} else {
+		op, err = r.s.GA.NetworkFirewallPolicies.PatchRule(r.projectID, r.name, rule).Priority(rule.Priority).Context(ctx).Do()
+	}
+	r.s.RateLimiter.Observe(ctx, err, ck)
+	if err != nil {
+		return err
+	}
+	return r.s.WaitForCompletion(ctx, op)
+}
+
+func (r *gceFirewallPolicyRules) RemoveRule(ctx context.Context, priority int64) error {
+	ck := r.callKey("RemoveRule")
+	if err := r.s.RateLimiter.Accept(ctx, ck); err != nil {
+		return err
+	}
+	var op *compute.Operation
+	var err error
+	if r.region != "" {
+		op, err = r.s.GA.RegionNetworkFirewallPolicies.RemoveRule(r.projectID, r.region, r.name).Priority(priority).Context(ctx).Do()
+	} else {
+		op, err = r.s.GA.NetworkFirewallPolicies.RemoveRule(r.projectID, r.name).Priority(priority).Context(ctx).Do()
+	}
+	r.s.RateLimiter.Observe(ctx, err, ck)
+	if err != nil {
+		return err
+	}
+	return r.s.WaitForCompletion(ctx, op)
+}
+
+// newFirewallPolicy returns the firewallPolicy writing into the configured policy, or nil if none
+// is configured.
+func (g *Cloud) newFirewallPolicy(config *FirewallPolicyConfig) *firewallPolicy {
+	if config == nil || config.Name == "" {
+		return nil
+	}
+	return &firewallPolicy{
+		config: *config,
+		rules: &gceFirewallPolicyRules{
+			s:         g.s,
+			projectID: g.NetworkProjectID(),
+			region:    config.Region,
+			name:      config.Name,
+		},
+	}
+}
+
+// firewallPolicyOwnedRules lists the rules of the policy written by the cluster, by name, and
+// reloads the priorities of the policy.
+func (g *Cloud) firewallPolicyOwnedRules(ctx context.Context) (map[string]*compute.FirewallPolicyRule, error) {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := g.firewallPolicy.rules.ListRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	owned := map[string]*compute.FirewallPolicyRule{}
+	priorities := map[string]int64{}
+	for _, rule := range rules {
+		if ownsFirewallPolicyRule(rule, clusterID) {
+			owned[rule.RuleName] = rule
+			priorities[rule.RuleName] = rule.Priority
+		}
+	}
+	g.firewallPolicy.prioritiesLock.Lock()
+	g.firewallPolicy.priorities = priorities
+	g.firewallPolicy.prioritiesLock.Unlock()
+	return owned, nil
+}
+
+// ownsFirewallPolicyRule returns whether the rule was written by the cluster.
+func ownsFirewallPolicyRule(rule *compute.FirewallPolicyRule, clusterID string) bool {
+	d := &firewallPolicyRuleDescription{}
+	return rule.RuleName != "" && json.Unmarshal([]byte(rule.Description), d) == nil && d.ClusterID == clusterID
+}
+
+// firewallPolicyOwnedRule returns the rule of the policy written by the cluster for the firewall.
+// The rule is read by its known priority, the policy is only listed when the priorities are not
+// loaded yet, or when the rule at the known priority is not the expected one anymore.
+func (g *Cloud) firewallPolicyOwnedRule(ctx context.Context, name string) (*compute.FirewallPolicyRule, error) {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	priority, known, loaded := g.firewallPolicy.priority(name)
+	if known {
+		rule, err := g.firewallPolicy.rules.GetRule(ctx, priority)
+		if err == nil && rule.RuleName == name && ownsFirewallPolicyRule(rule, clusterID) {
+			return rule, nil
+		}
+		// The rule was removed or replaced by someone else, or could not be read: the rules are
+		// listed again, which reports the errors that persist.
+		klog.V(4).Infof("firewallPolicyOwnedRule(%v): rule not found at priority %d of firewall policy %v, listing the rules: %v", name, priority, g.firewallPolicy.config.Name, err)
+	} else if loaded {
+		return nil, firewallPolicyRuleNotFoundError(g.firewallPolicy.config.Name, name)
+	}
+	owned, err := g.firewallPolicyOwnedRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rule, ok := owned[name]
+	if !ok {
+		return nil, firewallPolicyRuleNotFoundError(g.firewallPolicy.config.Name, name)
+	}
+	return rule, nil
+}
+
+// getFirewallPolicyRule returns the rule of the policy written by the cluster for the firewall, as a
+// firewall.
+func (g *Cloud) getFirewallPolicyRule(name string) (*compute.Firewall, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallPolicyMetricContext("get", g.firewallPolicy.config.Region)
+	rule, err := g.firewallPolicyOwnedRule(ctx, name)
+	if err != nil {
+		return nil, mc.Observe(err)
+	}
+	return g.firewallFromPolicyRule(rule), mc.Observe(nil)
+}
+
+// listFirewallPolicyRules returns the rules of the policy written by the cluster, as firewalls.
+func (g *Cloud) listFirewallPolicyRules() ([]*compute.Firewall, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallPolicyMetricContext("list", g.firewallPolicy.config.Region)
+	owned, err := g.firewallPolicyOwnedRules(ctx)
+	if err != nil {
+		return nil, mc.Observe(err)
+	}
+	firewalls := make([]*compute.Firewall, 0, len(owned))
+	for _, rule := range owned {
+		firewalls = append(firewalls, g.firewallFromPolicyRule(rule))
+	}
+	return firewalls, mc.Observe(nil)
+}
+
+// createFirewallPolicyRule adds the rule of the firewall to the policy, at the first free priority
+// starting from the one derived from the name of the firewall.
+func (g *Cloud) createFirewallPolicyRule(fw *compute.Firewall) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	g.firewallPolicy.lock.Lock()
+	defer g.firewallPolicy.lock.Unlock()
+
+	mc := newFirewallPolicyMetricContext("create", g.firewallPolicy.config.Region)
+	if _, err := g.firewallPolicyOwnedRule(ctx, fw.Name); err == nil {
+		return mc.Observe(&googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("rule %q already exists in firewall policy %q", fw.Name, g.firewallPolicy.config.Name)})
+	} else if !isNotFound(err) {
+		return mc.Observe(err)
+	}
+	priority, err := g.firewallPolicy.allocatePriority(fw.Name, func(priority int64) (bool, error) {
+		return g.firewallPolicyPriorityUsed(ctx, priority)
+	})
+	if err != nil {
+		return mc.Observe(err)
+	}
+	rule, err := g.firewallPolicyRule(fw, priority)
+	if err != nil {
+		return mc.Observe(err)
+	}
+	if g.loadBalancerDryRun.record(planActionCreate, planResourceFirewallPolicyRule, fw.Name, "priority %d", priority) {
+		return nil
+	}
+	klog.V(2).Infof("createFirewallPolicyRule(%v): adding rule to firewall policy %v with priority %d", fw.Name, g.firewallPolicy.config.Name, priority)
+	if err := g.firewallPolicy.rules.AddRule(ctx, rule); err != nil {
+		return mc.Observe(err)
+	}
+	g.firewallPolicy.setPriority(fw.Name, priority)
+	return mc.Observe(nil)
+}
+
+// firewallPolicyPriorityUsed returns whether a rule of the policy has the priority. The priorities
+// of the rules of the cluster are known, the others are read.
+func (g *Cloud) firewallPolicyPriorityUsed(ctx context.Context, priority int64) (bool, error) {
+	if g.firewallPolicy.ownsPriority(priority) {
+		return true, nil
+	}
+	_, err := g.firewallPolicy.rules.GetRule(ctx, priority)
+	switch {
+	case err == nil:
+		return true, nil
+	// Missing priorities are reported as invalid arguments.
+	case isNotFound(err) || isHTTPErrorCode(err, http.StatusBadRequest):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// patchFirewallPolicyRule updates the rule of the firewall in the policy, keeping its priority.
+func (g *Cloud) patchFirewallPolicyRule(fw *compute.Firewall) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallPolicyMetricContext("patch", g.firewallPolicy.config.Region)
+	existing, err := g.firewallPolicyOwnedRule(ctx, fw.Name)
+	if err != nil {
+		return mc.Observe(err)
+	}
+	rule, err := g.firewallPolicyRule(fw, existing.Priority)
+	if err != nil {
+		return mc.Observe(err)
+	}
+	if g.loadBalancerDryRun.record(planActionUpdate, planResourceFirewallPolicyRule, fw.Name, "priority %d", existing.Priority) {
+		return nil
+	}
+	klog.V(2).Infof("patchFirewallPolicyRule(%v): patching rule of firewall policy %v with priority %d", fw.Name, g.firewallPolicy.config.Name, existing.Priority)
+	return mc.Observe(g.firewallPolicy.rules.PatchRule(ctx, rule))
+}
+
+// deleteFirewallPolicyRule removes the rule of the firewall from the policy. Rules not written by
+// the cluster are never removed.
+func (g *Cloud) deleteFirewallPolicyRule(name string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallPolicyMetricContext("delete", g.firewallPolicy.config.Region)
+	rule, err := g.firewallPolicyOwnedRule(ctx, name)
+	if err != nil {
+		return mc.Observe(err)
+	}
+	if g.loadBalancerDryRun.record(planActionDelete, planResourceFirewallPolicyRule, name, "priority %d", rule.Priority) {
+		return nil
+	}
+	klog.V(2).Infof("deleteFirewallPolicyRule(%v): removing rule of firewall policy %v with priority %d", name, g.firewallPolicy.config.Name, rule.Priority)
+	if err := g.firewallPolicy.rules.RemoveRule(ctx, rule.Priority); err != nil {
+		return mc.Observe(err)
+	}
+	g.firewallPolicy.deletePriority(name)
+	return mc.Observe(nil)
+}
+
+// priority returns the priority of the rule of the cluster, whether it is known, and whether the
+// priorities are loaded.
+func (p *firewallPolicy) priority(name string) (int64, bool, bool) {
+	p.prioritiesLock.Lock()
+	defer p.prioritiesLock.Unlock()
+	priority, ok := p.priorities[name]
+	return priority, ok, p.priorities != nil
+}
+
+// ownsPriority returns whether a rule of the cluster is known to have the priority.
+func (p *firewallPolicy) ownsPriority(priority int64) bool {
+	p.prioritiesLock.Lock()
+	defer p.prioritiesLock.Unlock()
+	for _, owned := range p.priorities {
+		if owned == priority {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *firewallPolicy) setPriority(name string, priority int64) {
+	p.prioritiesLock.Lock()
+	defer p.prioritiesLock.Unlock()
+	if p.priorities != nil {
+		p.priorities[name] = priority
+	}
+}
+
+func (p *firewallPolicy) deletePriority(name string) {
+	p.prioritiesLock.Lock()
+	defer p.prioritiesLock.Unlock()
+	delete(p.priorities, name)
+}
+
+// allocatePriority returns the priority of a new rule: the priority derived from the hash of its
+// name, or the next free one within the range of the policy.
+func (p *firewallPolicy) allocatePriority(name string, used func(priority int64) (bool, error)) (int64, error) {
+	size := p.config.MaxPriority - p.config.MinPriority + 1
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	offset := int64(h.Sum32()) % size
+	for i := int64(0); i < size; i++ {
+		priority := p.config.MinPriority + (offset+i)%size
+		inUse, err := used(priority)
+		if err != nil {
+			return 0, err
+		}
+		if !inUse {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("no free priority between %d and %d in firewall policy %q", p.config.MinPriority, p.config.MaxPriority, p.config.Name)
+}
+
+// firewallPolicyRule returns the rule of the policy allowing the traffic of the firewall. Firewalls
+// without target service account are rejected, as their rule would apply to all the instances of
+// the network.
+func (g *Cloud) firewallPolicyRule(fw *compute.Firewall, priority int64) (*compute.FirewallPolicyRule, error) {
+	if len(fw.TargetServiceAccounts) == 0 {
+		return nil, fmt.Errorf("firewall %q has no target service account, which rules of firewall policy %q require", fw.Name, g.firewallPolicy.config.Name)
+	}
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	desc, err := json.Marshal(&firewallPolicyRuleDescription{ClusterID: clusterID, Description: fw.Description})
+	if err != nil {
+		return nil, err
+	}
+	match := &compute.FirewallPolicyRuleMatcher{
+		SrcIpRanges:  fw.SourceRanges,
+		DestIpRanges: fw.DestinationRanges,
+	}
+	for _, allowed := range fw.Allowed {
+		match.Layer4Configs = append(match.Layer4Configs, &compute.FirewallPolicyRuleMatcherLayer4Config{
+			IpProtocol: allowed.IPProtocol,
+			Ports:      allowed.Ports,
+		})
+	}
+	return &compute.FirewallPolicyRule{
+		RuleName:              fw.Name,
+		Description:           string(desc),
+		Direction:             firewallPolicyRuleDirectionIngress,
+		Action:                firewallPolicyRuleActionAllow,
+		Priority:              priority,
+		Match:                 match,
+		EnableLogging:         fw.LogConfig != nil && fw.LogConfig.Enable,
+		TargetServiceAccounts: fw.TargetServiceAccounts,
+	}, nil
+}
+
+// firewallFromPolicyRule returns the firewall whose traffic the rule of the policy allows.
+func (g *Cloud) firewallFromPolicyRule(rule *compute.FirewallPolicyRule) *compute.Firewall {
+	d := &firewallPolicyRuleDescription{}
+	// The description was checked by ownsFirewallPolicyRule.
+	_ = json.Unmarshal([]byte(rule.Description), d)
+	// The priority of the rule is allocated by the policy, see loadBalancerFirewallConfig.
+	fw := &compute.Firewall{
+		Name:                  rule.RuleName,
+		Description:           d.Description,
+		Network:               g.networkURL,
+		TargetServiceAccounts: rule.TargetServiceAccounts,
+	}
+	if rule.EnableLogging {
+		fw.LogConfig = &compute.FirewallLogConfig{Enable: true}
+	}
+	if rule.Match != nil {
+		fw.SourceRanges = rule.Match.SrcIpRanges
+		fw.DestinationRanges = rule.Match.DestIpRanges
+		for _, l4 := range rule.Match.Layer4Configs {
+			fw.Allowed = append(fw.Allowed, &compute.FirewallAllowed{IPProtocol: l4.IpProtocol, Ports: l4.Ports})
+		}
+	}
+	return fw
+}
+
+func firewallPolicyRuleNotFoundError(policy, name string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("rule %q not found in firewall policy %q", name, policy)}
+}
@@ -0,0 +1,351 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeFirewallPolicyRules keeps the rules of a network firewall policy by priority.
+type fakeFirewallPolicyRules struct {
+	lock  sync.Mutex
+	rules map[int64]*compute.FirewallPolicyRule
+	// lists counts the listings of the rules.
+	lists int
+}
+
+func (f *fakeFirewallPolicyRules) ListRules(ctx context.Context) ([]*compute.FirewallPolicyRule, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lists++
+	var rules []*compute.FirewallPolicyRule
+	for _, rule := range f.rules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (f *fakeFirewallPolicyRules) GetRule(ctx context.Context, priority int64) (*compute.FirewallPolicyRule, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	rule, ok := f.rules[priority]
+	if !ok {
+		// GCE reports missing priorities as invalid arguments.
+		return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for field 'priority': '%d'", priority)}
+	}
+	return rule, nil
+}
+
+// listCount returns the number of listings of the rules.
+func (f *fakeFirewallPolicyRules) listCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.lists
+}
+
+func (f *fakeFirewallPolicyRules) AddRule(ctx context.Context, rule *compute.FirewallPolicyRule) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.rules[rule.Priority]; ok {
+		return fmt.Errorf("a rule with priority %d already exists", rule.Priority)
+	}
+	f.rules[rule.Priority] = rule
+	return nil
+}
+
+func (f *fakeFirewallPolicyRules) PatchRule(ctx context.Context, rule *compute.FirewallPolicyRule) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.rules[rule.Priority]; !ok {
+		return firewallPolicyRuleNotFoundError("fake", rule.RuleName)
+	}
+	f.rules[rule.Priority] = rule
+	return nil
+}
+
+func (f *fakeFirewallPolicyRules) RemoveRule(ctx context.Context, priority int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.rules, priority)
+	return nil
+}
+
+// assertRulesHaveTarget asserts that all the rules of the policy target service accounts.
+func (f *fakeFirewallPolicyRules) assertRulesHaveTarget(t *testing.T) {
+	t.Helper()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, rule := range f.rules {
+		assert.NotEmpty(t, rule.TargetServiceAccounts, "rule %s has no target", rule.RuleName)
+	}
+}
+
+// byName returns the rules of the policy by name.
+func (f *fakeFirewallPolicyRules) byName() map[string]*compute.FirewallPolicyRule {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	rules := map[string]*compute.FirewallPolicyRule{}
+	for _, rule := range f.rules {
+		rules[rule.RuleName] = rule
+	}
+	return rules
+}
+
+const fakeNodeServiceAccount = "nodes@test-project.iam.gserviceaccount.com"
+
+func newFakeFirewallPolicy(gce *Cloud) *fakeFirewallPolicyRules {
+	rules := &fakeFirewallPolicyRules{rules: map[int64]*compute.FirewallPolicyRule{}}
+	gce.firewallConfig.TargetServiceAccounts = []string{fakeNodeServiceAccount}
+	gce.firewallPolicy = &firewallPolicy{
+		config: FirewallPolicyConfig{
+			Name:        "lb-policy",
+			MinPriority: defaultFirewallPolicyMinPriority,
+			MaxPriority: defaultFirewallPolicyMaxPriority,
+		},
+		rules: rules,
+	}
+	return rules
+}
+
+func TestParseFirewallPolicyConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		global  ConfigGlobal
+		want    *FirewallPolicyConfig
+		wantErr bool
+	}{
+		{
+			desc: "no policy",
+		},
+		{
+			desc:   "global policy with default priorities",
+			global: ConfigGlobal{FirewallPolicy: "lb-policy", FirewallTargetServiceAccounts: []string{fakeNodeServiceAccount}},
+			want:   &FirewallPolicyConfig{Name: "lb-policy", MinPriority: 1000, MaxPriority: 9999},
+		},
+		{
+			desc:   "regional policy",
+			global: ConfigGlobal{FirewallPolicy: "lb-policy", FirewallPolicyRegion: "us-central1", FirewallPolicyMinPriority: 20000, FirewallPolicyMaxPriority: 29999, FirewallTargetServiceAccounts: []string{fakeNodeServiceAccount}},
+			want:   &FirewallPolicyConfig{Name: "lb-policy", Region: "us-central1", MinPriority: 20000, MaxPriority: 29999},
+		},
+		{
+			desc:    "inverted priorities",
+			global:  ConfigGlobal{FirewallPolicy: "lb-policy", FirewallPolicyMinPriority: 20000, FirewallPolicyMaxPriority: 19999, FirewallTargetServiceAccounts: []string{fakeNodeServiceAccount}},
+			wantErr: true,
+		},
+		{
+			desc:    "no target service account",
+			global:  ConfigGlobal{FirewallPolicy: "lb-policy"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, err := parseFirewallPolicyConfig(&tc.global)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+		})
+	}
+}
+
+func TestFirewallPolicyAllocatePriority(t *testing.T) {
+	t.Parallel()
+
+	p := &firewallPolicy{config: FirewallPolicyConfig{Name: "lb-policy", MinPriority: 100, MaxPriority: 103}}
+	usedIn := func(used map[int64]bool) func(int64) (bool, error) {
+		return func(priority int64) (bool, error) { return used[priority], nil }
+	}
+	priority, err := p.allocatePriority("k8s-fw-a", usedIn(nil))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, priority, int64(100))
+	assert.LessOrEqual(t, priority, int64(103))
+	again, err := p.allocatePriority("k8s-fw-a", usedIn(nil))
+	require.NoError(t, err)
+	assert.Equal(t, priority, again, "priorities must be deterministic")
+
+	// Used priorities are skipped, wrapping around the range.
+	next, err := p.allocatePriority("k8s-fw-a", usedIn(map[int64]bool{priority: true}))
+	require.NoError(t, err)
+	assert.Equal(t, 100+(priority-100+1)%4, next)
+
+	_, err = p.allocatePriority("k8s-fw-a", usedIn(map[int64]bool{100: true, 101: true, 102: true, 103: true}))
+	assert.Error(t, err)
+	_, err = p.allocatePriority("k8s-fw-a", func(int64) (bool, error) { return false, fmt.Errorf("boom") })
+	assert.Error(t, err)
+}
+
+func TestFirewallPolicyReadsRulesByPriority(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	policy := newFakeFirewallPolicy(gce)
+
+	// The rules are listed once to load the priorities of the rules of the cluster.
+	fw := &compute.Firewall{Name: "k8s-fw-a", SourceRanges: []string{"0.0.0.0/0"}, TargetServiceAccounts: []string{fakeNodeServiceAccount}}
+	_, err = gce.getFirewallPolicyRule(fw.Name)
+	assert.True(t, isNotFound(err), "rule found: %v", err)
+	require.NoError(t, gce.createFirewallPolicyRule(fw))
+	_, err = gce.getFirewallPolicyRule(fw.Name)
+	require.NoError(t, err)
+	fw.SourceRanges = []string{"10.0.0.0/8"}
+	require.NoError(t, gce.patchFirewallPolicyRule(fw))
+	_, err = gce.getFirewallPolicyRule("k8s-fw-b")
+	assert.True(t, isNotFound(err), "rule found: %v", err)
+	assert.Equal(t, 1, policy.listCount())
+
+	// Rules removed by someone else are listed again.
+	require.NoError(t, policy.RemoveRule(context.TODO(), policy.byName()[fw.Name].Priority))
+	_, err = gce.getFirewallPolicyRule(fw.Name)
+	assert.True(t, isNotFound(err), "rule found: %v", err)
+	assert.Equal(t, 2, policy.listCount())
+
+	require.NoError(t, gce.createFirewallPolicyRule(fw))
+	require.NoError(t, gce.deleteFirewallPolicyRule(fw.Name))
+	assert.Empty(t, policy.byName())
+	_, err = gce.getFirewallPolicyRule(fw.Name)
+	assert.True(t, isNotFound(err), "rule found: %v", err)
+	assert.Equal(t, 2, policy.listCount())
+}
+
+func TestFirewallPolicyRuleRequiresTarget(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+	policy := newFakeFirewallPolicy(gce)
+
+	fw := &compute.Firewall{Name: "k8s-fw-a", SourceRanges: []string{"0.0.0.0/0"}, TargetTags: []string{"node"}}
+	assert.Error(t, gce.createFirewallPolicyRule(fw))
+	assert.Empty(t, policy.byName())
+
+	fw.TargetServiceAccounts = []string{fakeNodeServiceAccount}
+	require.NoError(t, gce.createFirewallPolicyRule(fw))
+	fw.TargetServiceAccounts = nil
+	assert.Error(t, gce.patchFirewallPolicyRule(fw))
+	policy.assertRulesHaveTarget(t)
+}
+
+func TestEnsureInternalLoadBalancerWithFirewallPolicy(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	policy := newFakeFirewallPolicy(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	// A rule of the same name written by another cluster.
+	otherRule := &compute.FirewallPolicyRule{RuleName: fwName, Priority: 1, Description: `{"kubernetes.io/cluster-id":"other-cluster"}`, TargetServiceAccounts: []string{"nodes@other-project.iam.gserviceaccount.com"}}
+	require.NoError(t, policy.AddRule(context.TODO(), otherRule))
+
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	_, err = gce.GetFirewall(fwName)
+	assert.True(t, isNotFound(err), "VPC firewall rule must not be created: %v", err)
+	rules := policy.byName()
+	hcFwName := makeHealthCheckFirewallName(lbName, vals.ClusterID, true)
+	require.Contains(t, rules, hcFwName)
+	var priority int64
+	for _, rule := range policy.rules {
+		if rule.RuleName == fwName && rule.Priority != otherRule.Priority {
+			priority = rule.Priority
+			d := &firewallPolicyRuleDescription{}
+			require.NoError(t, json.Unmarshal([]byte(rule.Description), d))
+			assert.Equal(t, vals.ClusterID, d.ClusterID)
+			assert.Equal(t, firewallPolicyRuleActionAllow, rule.Action)
+			assert.Equal(t, firewallPolicyRuleDirectionIngress, rule.Direction)
+			assert.Equal(t, []string{"0.0.0.0/0"}, rule.Match.SrcIpRanges)
+			assert.Equal(t, []*compute.FirewallPolicyRuleMatcherLayer4Config{{IpProtocol: "tcp", Ports: []string{"123"}}}, rule.Match.Layer4Configs)
+		}
+	}
+	assert.GreaterOrEqual(t, priority, int64(defaultFirewallPolicyMinPriority))
+	assert.LessOrEqual(t, priority, int64(defaultFirewallPolicyMaxPriority))
+	policy.assertRulesHaveTarget(t)
+
+	// Updates keep the priority of the rule.
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.Contains(t, policy.rules, priority)
+	assert.Equal(t, []string{"10.0.0.0/8"}, policy.rules[priority].Match.SrcIpRanges)
+
+	// Only the rules of the cluster are removed.
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	assert.Equal(t, map[int64]*compute.FirewallPolicyRule{otherRule.Priority: otherRule}, policy.rules)
+}
+
+func TestEnsureExternalLoadBalancerWithFirewallPolicy(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	policy := newFakeFirewallPolicy(gce)
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	hcFwName := MakeHealthCheckFirewallName(vals.ClusterID, lbName, false)
+
+	rules := policy.byName()
+	require.Contains(t, rules, fwName)
+	require.Contains(t, rules, hcFwName)
+	assert.Equal(t, []string{status.Ingress[0].IP}, rules[fwName].Match.DestIpRanges)
+	_, err = gce.GetFirewall(fwName)
+	assert.True(t, isNotFound(err), "VPC firewall rule must not be created: %v", err)
+	policy.assertRulesHaveTarget(t)
+
+	// Syncing again does not change the rules.
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, rules, policy.byName())
+
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	assert.Empty(t, policy.byName())
+}
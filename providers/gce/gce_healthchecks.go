@@ -46,6 +46,10 @@ func newHealthcheckMetricContextWithVersion(request, version string) *metricCont
 	return newGenericMetricContext("healthcheck", request, unusedMetricLabel, unusedMetricLabel, version)
 }
 
+func newRegionHealthcheckMetricContext(request, region string) *metricContext {
+	return newGenericMetricContext("healthcheck", request, region, unusedMetricLabel, computeV1Version)
+}
+
 // GetHTTPHealthCheck returns the given HttpHealthCheck by name.
 func (g *Cloud) GetHTTPHealthCheck(name string) (*compute.HttpHealthCheck, error) {
 	ctx, cancel := cloud.ContextWithCallTimeout()
@@ -247,6 +251,55 @@ func (g *Cloud) ListHealthChecks() ([]*compute.HealthCheck, error) {
 	return v, mc.Observe(err)
 }
 
+// Regional HealthCheck
+
+// GetRegionHealthCheck returns the given regional HealthCheck by name.
+func (g *Cloud) GetRegionHealthCheck(name, region string) (*compute.HealthCheck, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRegionHealthcheckMetricContext("get", region)
+	v, err := g.c.RegionHealthChecks().Get(ctx, meta.RegionalKey(name, region))
+	return v, mc.Observe(err)
+}
+
+// UpdateRegionHealthCheck applies the given regional HealthCheck as an update.
+func (g *Cloud) UpdateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRegionHealthcheckMetricContext("update", region)
+	return mc.Observe(g.c.RegionHealthChecks().Update(ctx, meta.RegionalKey(hc.Name, region), hc))
+}
+
+// DeleteRegionHealthCheck deletes the given regional HealthCheck by name.
+func (g *Cloud) DeleteRegionHealthCheck(name, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRegionHealthcheckMetricContext("delete", region)
+	return mc.Observe(g.c.RegionHealthChecks().Delete(ctx, meta.RegionalKey(name, region)))
+}
+
+// ListRegionHealthChecks lists all regional HealthChecks in the region.
+func (g *Cloud) ListRegionHealthChecks(region string) ([]*compute.HealthCheck, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRegionHealthcheckMetricContext("list", region)
+	v, err := g.c.RegionHealthChecks().List(ctx, region, filter.None)
+	return v, mc.Observe(err)
+}
+
+// CreateRegionHealthCheck creates the given regional HealthCheck.
+func (g *Cloud) CreateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRegionHealthcheckMetricContext("create", region)
+	return mc.Observe(g.c.RegionHealthChecks().Insert(ctx, meta.RegionalKey(hc.Name, region), hc))
+}
+
 // GetNodesHealthCheckPort returns the health check port used by the GCE load
 // balancers (l4) for performing health checks on nodes.
 func GetNodesHealthCheckPort() int32 {
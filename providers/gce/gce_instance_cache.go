@@ -0,0 +1,367 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// InstanceCacheConsumer names a reader of the instance cache. Each consumer reads cached
+// instances up to its own maximum age.
+type InstanceCacheConsumer string
+
+const (
+	// InstanceCacheConsumerInstanceMetadata is InstanceMetadata.
+	InstanceCacheConsumerInstanceMetadata InstanceCacheConsumer = "InstanceMetadata"
+	// InstanceCacheConsumerInstanceExists is InstanceExists and InstanceExistsByProviderID.
+	InstanceCacheConsumerInstanceExists InstanceCacheConsumer = "InstanceExists"
+	// InstanceCacheConsumerInstanceShutdown is InstanceShutdown and InstanceShutdownByProviderID.
+	InstanceCacheConsumerInstanceShutdown InstanceCacheConsumer = "InstanceShutdown"
+	// InstanceCacheConsumerInstanceType is InstanceTypeByProviderID.
+	InstanceCacheConsumerInstanceType InstanceCacheConsumer = "InstanceType"
+	// InstanceCacheConsumerNodeAddresses is NodeAddressesByProviderID.
+	InstanceCacheConsumerNodeAddresses InstanceCacheConsumer = "NodeAddresses"
+	// InstanceCacheConsumerInstanceByProviderID is InstanceByProviderID, used by the cloud CIDR allocator.
+	InstanceCacheConsumerInstanceByProviderID InstanceCacheConsumer = "InstanceByProviderID"
+	// InstanceCacheConsumerLoadBalancer is the lookup of the instances of the nodes of the load balancers.
+	InstanceCacheConsumerLoadBalancer InstanceCacheConsumer = "LoadBalancer"
+)
+
+// instanceCacheConsumers lists the consumers of the instance cache.
+var instanceCacheConsumers = sets.NewString(
+	string(InstanceCacheConsumerInstanceMetadata),
+	string(InstanceCacheConsumerInstanceExists),
+	string(InstanceCacheConsumerInstanceShutdown),
+	string(InstanceCacheConsumerInstanceType),
+	string(InstanceCacheConsumerNodeAddresses),
+	string(InstanceCacheConsumerInstanceByProviderID),
+	string(InstanceCacheConsumerLoadBalancer),
+)
+
+var instanceCacheLookups = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "cloudprovider_gce_instance_cache_lookups_total",
+		Help:           "Number of lookups of the GCE instance cache, by consumer and result (hit or miss)",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"consumer", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(instanceCacheLookups)
+}
+
+// InstanceCacheConfig configures the cache of the instances of the nodes.
+type InstanceCacheConfig struct {
+	// RefreshPeriod is the period of the aggregated lists of the instances refreshing the cache.
+	// Zero disables the refreshes: instances are only cached when read one by one.
+	RefreshPeriod time.Duration
+	// MaxAge is the maximum age of the cached instances read by the consumers. Zero disables
+	// the cache: the consumers always read the instances from the API.
+	MaxAge time.Duration
+	// ConsumerMaxAges overrides MaxAge for some consumers.
+	ConsumerMaxAges map[InstanceCacheConsumer]time.Duration
+}
+
+// parseInstanceCacheConfig returns the instance cache configuration set in the config.
+// ConsumerMaxAges are formatted as <consumer>,<max age>.
+func parseInstanceCacheConfig(global *ConfigGlobal) (InstanceCacheConfig, error) {
+	var config InstanceCacheConfig
+	var err error
+	if config.RefreshPeriod, err = parseInstanceCacheDuration("instance-cache-refresh-period", global.InstanceCacheRefreshPeriod); err != nil {
+		return config, err
+	}
+	if config.MaxAge, err = parseInstanceCacheDuration("instance-cache-max-age", global.InstanceCacheMaxAge); err != nil {
+		return config, err
+	}
+	for _, spec := range global.InstanceCacheConsumerMaxAges {
+		consumer, maxAge, ok := strings.Cut(spec, ",")
+		consumer = strings.TrimSpace(consumer)
+		if !ok || !instanceCacheConsumers.Has(consumer) {
+			return config, fmt.Errorf("invalid instance-cache-consumer-max-age %q: want <consumer>,<max age> with consumer one of %v", spec, instanceCacheConsumers.List())
+		}
+		d, err := parseInstanceCacheDuration("instance-cache-consumer-max-age", maxAge)
+		if err != nil {
+			return config, err
+		}
+		if config.ConsumerMaxAges == nil {
+			config.ConsumerMaxAges = map[InstanceCacheConsumer]time.Duration{}
+		}
+		config.ConsumerMaxAges[InstanceCacheConsumer(consumer)] = d
+	}
+	return config, nil
+}
+
+func parseInstanceCacheDuration(name, value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, value)
+	}
+	return d, nil
+}
+
+// instanceCache caches the instances read by the consumers and listed by the refreshes. Only
+// existing instances are cached: instances missing from the cache are always read from the API.
+// The zero value is a disabled cache.
+type instanceCache struct {
+	config InstanceCacheConfig
+	clock  clock.PassiveClock
+
+	mu        sync.Mutex
+	instances map[meta.Key]cachedInstance
+	// invalidated holds the time of the last write to the instances written since the last
+	// refresh, so that reads started before the write don't cache the instance as it was.
+	invalidated map[meta.Key]time.Time
+	// pruned is the time the entries no consumer can read anymore were last dropped.
+	pruned time.Time
+}
+
+type cachedInstance struct {
+	instance *compute.Instance
+	fetched  time.Time
+}
+
+func (c *instanceCache) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// maxAge returns the maximum age of the cached instances read by the consumer.
+func (c *instanceCache) maxAge(consumer InstanceCacheConsumer) time.Duration {
+	if maxAge, ok := c.config.ConsumerMaxAges[consumer]; ok {
+		return maxAge
+	}
+	return c.config.MaxAge
+}
+
+// largestMaxAge returns the largest maximum age of the consumers. Older entries are never read.
+func (c *instanceCache) largestMaxAge() time.Duration {
+	largest := c.config.MaxAge
+	for _, maxAge := range c.config.ConsumerMaxAges {
+		if maxAge > largest {
+			largest = maxAge
+		}
+	}
+	return largest
+}
+
+// enabled returns whether any consumer reads the cache.
+func (c *instanceCache) enabled() bool {
+	return c.largestMaxAge() > 0
+}
+
+// get returns the cached instance if it is fresh enough for the consumer.
+func (c *instanceCache) get(consumer InstanceCacheConsumer, key meta.Key) (*compute.Instance, bool) {
+	maxAge := c.maxAge(consumer)
+	if maxAge == 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	cached, ok := c.instances[key]
+	c.mu.Unlock()
+	if !ok || c.now().Sub(cached.fetched) > maxAge {
+		instanceCacheLookups.WithLabelValues(string(consumer), "miss").Inc()
+		return nil, false
+	}
+	instanceCacheLookups.WithLabelValues(string(consumer), "hit").Inc()
+	return cached.instance, true
+}
+
+// add caches the instances read at fetched, unless more recent versions are cached, they were
+// written since or they are too old for any consumer.
+func (c *instanceCache) add(fetched time.Time, instances ...*compute.Instance) {
+	if !c.enabled() || c.now().Sub(fetched) > c.largestMaxAge() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked()
+	if c.instances == nil {
+		c.instances = map[meta.Key]cachedInstance{}
+	}
+	for _, instance := range instances {
+		key := *meta.ZonalKey(instance.Name, lastComponent(instance.Zone))
+		if cached, ok := c.instances[key]; ok && cached.fetched.After(fetched) {
+			continue
+		}
+		if invalidated, ok := c.invalidated[key]; ok {
+			if fetched.Before(invalidated) {
+				continue
+			}
+			delete(c.invalidated, key)
+		}
+		c.instances[key] = cachedInstance{instance: instance, fetched: fetched}
+	}
+}
+
+// replace caches the instances listed at listed, and drops the cached instances read before
+// missing from the list.
+func (c *instanceCache) replace(listed time.Time, instances []*compute.Instance) {
+	c.add(listed, instances...)
+
+	listedKeys := map[meta.Key]bool{}
+	for _, instance := range instances {
+		listedKeys[*meta.ZonalKey(instance.Name, lastComponent(instance.Zone))] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, cached := range c.instances {
+		if !listedKeys[key] && cached.fetched.Before(listed) {
+			delete(c.instances, key)
+		}
+	}
+	for key, invalidated := range c.invalidated {
+		if invalidated.Before(listed) {
+			delete(c.invalidated, key)
+		}
+	}
+}
+
+// pruneLocked drops the entries older than the largest maximum age of the consumers, at most once
+// per largest maximum age. Instances read before a dropped invalidation are too old to be cached
+// again. c.mu must be held.
+func (c *instanceCache) pruneLocked() {
+	largest := c.largestMaxAge()
+	now := c.now()
+	if now.Sub(c.pruned) < largest {
+		return
+	}
+	c.pruned = now
+	for key, cached := range c.instances {
+		if now.Sub(cached.fetched) > largest {
+			delete(c.instances, key)
+		}
+	}
+	for key, invalidated := range c.invalidated {
+		if now.Sub(invalidated) > largest {
+			delete(c.invalidated, key)
+		}
+	}
+}
+
+// invalidate drops the instance from the cache after a write to it.
+func (c *instanceCache) invalidate(zone, name string) {
+	if !c.enabled() {
+		return
+	}
+	key := *meta.ZonalKey(canonicalizeInstanceName(name), zone)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.instances, key)
+	if c.invalidated == nil {
+		c.invalidated = map[meta.Key]time.Time{}
+	}
+	c.invalidated[key] = c.now()
+}
+
+// getCachedInstance returns the instance from the cache if it is fresh enough for the consumer,
+// and from the API otherwise. The returned instance must not be modified.
+func (g *Cloud) getCachedInstance(ctx context.Context, consumer InstanceCacheConsumer, zone, name string) (*compute.Instance, error) {
+	key := meta.ZonalKey(canonicalizeInstanceName(name), zone)
+	if instance, ok := g.instanceCache.get(consumer, *key); ok {
+		return instance, nil
+	}
+	fetched := g.instanceCache.now()
+	mc := newInstancesMetricContext("get", zone)
+	instance, err := g.c.Instances().Get(ctx, key)
+	if err := mc.Observe(err); err != nil {
+		return nil, err
+	}
+	g.instanceCache.add(fetched, instance)
+	return instance, nil
+}
+
+// runInstanceCacheRefresh refreshes the instance cache every refresh period until stop is closed.
+func (g *Cloud) runInstanceCacheRefresh(stop <-chan struct{}) {
+	period := g.instanceCache.config.RefreshPeriod
+	if period == 0 || !g.instanceCache.enabled() {
+		return
+	}
+	klog.Infof("Refreshing the instance cache every %v", period)
+	wait.Until(g.refreshInstanceCache, period, stop)
+}
+
+// refreshInstanceCache replaces the instance cache with an aggregated list of the instances of the
+// managed zones.
+func (g *Cloud) refreshInstanceCache() {
+	listed := g.instanceCache.now()
+	instances, err := g.aggregatedListInstances()
+	if err != nil {
+		klog.Warningf("Failed to refresh the instance cache: %v", err)
+		return
+	}
+	g.instanceCache.replace(listed, instances)
+	klog.V(4).Infof("Refreshed the instance cache with %d instances", len(instances))
+}
+
+// aggregatedListInstances lists the instances of the node instance prefix in the managed zones with
+// a single aggregated list, which the generated GCE client does not provide.
+func (g *Cloud) aggregatedListInstances() ([]*compute.Instance, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	key := &cloud.RateLimitKey{ProjectID: g.projectID, Operation: "AggregatedList", Version: meta.VersionGA, Service: "Instances"}
+	if err := g.s.RateLimiter.Accept(ctx, key); err != nil {
+		return nil, err
+	}
+	mc := newInstancesMetricContext("aggregated_list", "")
+	call := g.service.Instances.AggregatedList(g.projectID).Context(ctx)
+	if g.nodeInstancePrefix != "" {
+		call = call.Filter(fmt.Sprintf("name eq %s.*", g.nodeInstancePrefix))
+	}
+	zones := sets.NewString(g.managedZones...)
+	var instances []*compute.Instance
+	err := call.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		for scope, items := range page.Items {
+			if zones.Has(lastComponent(scope)) {
+				instances = append(instances, items.Instances...)
+			}
+		}
+		return nil
+	})
+	g.s.RateLimiter.Observe(ctx, err, key)
+	if err := mc.Observe(err); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
@@ -0,0 +1,255 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestParseInstanceCacheConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		global  ConfigGlobal
+		want    InstanceCacheConfig
+		wantErr bool
+	}{
+		{
+			desc: "disabled",
+		},
+		{
+			desc: "max ages",
+			global: ConfigGlobal{
+				InstanceCacheRefreshPeriod:   "5m",
+				InstanceCacheMaxAge:          "1m",
+				InstanceCacheConsumerMaxAges: []string{"InstanceShutdown,0s", "LoadBalancer, 10m"},
+			},
+			want: InstanceCacheConfig{
+				RefreshPeriod: 5 * time.Minute,
+				MaxAge:        time.Minute,
+				ConsumerMaxAges: map[InstanceCacheConsumer]time.Duration{
+					InstanceCacheConsumerInstanceShutdown: 0,
+					InstanceCacheConsumerLoadBalancer:     10 * time.Minute,
+				},
+			},
+		},
+		{
+			desc:    "invalid duration",
+			global:  ConfigGlobal{InstanceCacheMaxAge: "1 minute"},
+			wantErr: true,
+		},
+		{
+			desc:    "negative duration",
+			global:  ConfigGlobal{InstanceCacheRefreshPeriod: "-1m"},
+			wantErr: true,
+		},
+		{
+			desc:    "unknown consumer",
+			global:  ConfigGlobal{InstanceCacheConsumerMaxAges: []string{"InstanceID,1m"}},
+			wantErr: true,
+		},
+		{
+			desc:    "missing max age",
+			global:  ConfigGlobal{InstanceCacheConsumerMaxAges: []string{"InstanceMetadata"}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, err := parseInstanceCacheConfig(&tc.global)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+		})
+	}
+}
+
+// countInstanceReads counts the instances read from the API one by one and listed.
+func countInstanceReads(gce *Cloud) (gets, lists *int32) {
+	gets, lists = new(int32), new(int32)
+	c := gce.c.(*cloud.MockGCE)
+	c.MockInstances.GetHook = func(ctx context.Context, key *meta.Key, m *cloud.MockInstances, options ...cloud.Option) (bool, *compute.Instance, error) {
+		atomic.AddInt32(gets, 1)
+		return false, nil, nil
+	}
+	c.MockInstances.ListHook = func(ctx context.Context, zone string, fl *filter.F, m *cloud.MockInstances, options ...cloud.Option) (bool, []*compute.Instance, error) {
+		atomic.AddInt32(lists, 1)
+		return false, nil, nil
+	}
+	return gets, lists
+}
+
+func TestInstanceCache(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	clock := testingclock.NewFakePassiveClock(time.Now())
+	gce.instanceCache.clock = clock
+	gce.instanceCache.config = InstanceCacheConfig{
+		MaxAge:          time.Minute,
+		ConsumerMaxAges: map[InstanceCacheConsumer]time.Duration{InstanceCacheConsumerInstanceShutdown: 0},
+	}
+	_, err = createAndInsertNodes(gce, []string{"node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	gets, _ := countInstanceReads(gce)
+	providerID := fmt.Sprintf("gce://%s/%s/node-1", gce.ProjectID(), vals.ZoneName)
+
+	// The instance is read once, then from the cache until it is too old.
+	for i := 0; i < 2; i++ {
+		exists, err := gce.InstanceExistsByProviderID(context.TODO(), providerID)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		_, err = gce.InstanceTypeByProviderID(context.TODO(), providerID)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(gets))
+	clock.SetTime(clock.Now().Add(2 * time.Minute))
+	_, err = gce.InstanceByProviderID(providerID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(gets))
+
+	// Consumers without max age always read the instance.
+	_, err = gce.InstanceShutdownByProviderID(context.TODO(), providerID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(gets))
+
+	// Missing instances are not cached, and writes invalidate the instances.
+	require.NoError(t, gce.DeleteInstance(gce.ProjectID(), vals.ZoneName, "node-1"))
+	exists, err := gce.InstanceExistsByProviderID(context.TODO(), providerID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = gce.InstanceExistsByProviderID(context.TODO(), providerID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, int32(5), atomic.LoadInt32(gets))
+}
+
+func TestInstanceCacheReplace(t *testing.T) {
+	t.Parallel()
+
+	clock := testingclock.NewFakePassiveClock(time.Now())
+	c := &instanceCache{config: InstanceCacheConfig{MaxAge: time.Hour}, clock: clock}
+	instance := func(name string) *compute.Instance {
+		return &compute.Instance{Name: name, Zone: "zones/us-central1-b"}
+	}
+	cached := func(name string) bool {
+		_, ok := c.get(InstanceCacheConsumerInstanceMetadata, *meta.ZonalKey(name, "us-central1-b"))
+		return ok
+	}
+
+	c.add(clock.Now(), instance("deleted"), instance("written"))
+	listed := clock.Now().Add(time.Second)
+	clock.SetTime(listed.Add(time.Second))
+	// Instances read or written while listing are more recent than the list.
+	c.add(clock.Now(), instance("read"))
+	c.invalidate("us-central1-b", "written")
+	c.replace(listed, []*compute.Instance{instance("listed"), instance("written")})
+
+	assert.True(t, cached("listed"))
+	assert.True(t, cached("read"))
+	assert.False(t, cached("deleted"), "instance missing from the list is cached")
+	assert.False(t, cached("written"), "instance written while listing is cached")
+
+	// The next list caches the written instance again.
+	c.replace(clock.Now().Add(time.Second), []*compute.Instance{instance("written")})
+	assert.True(t, cached("written"))
+	assert.False(t, cached("listed"))
+}
+
+func TestInstanceCachePrune(t *testing.T) {
+	t.Parallel()
+
+	clock := testingclock.NewFakePassiveClock(time.Now())
+	instance := func(name string) *compute.Instance {
+		return &compute.Instance{Name: name, Zone: "zones/us-central1-b"}
+	}
+
+	// A disabled cache keeps nothing.
+	c := &instanceCache{clock: clock}
+	c.add(clock.Now(), instance("read"))
+	c.invalidate("us-central1-b", "written")
+	assert.Empty(t, c.instances)
+	assert.Empty(t, c.invalidated)
+
+	// Entries older than the largest max age of the consumers are dropped.
+	c.config = InstanceCacheConfig{MaxAge: time.Minute, ConsumerMaxAges: map[InstanceCacheConsumer]time.Duration{InstanceCacheConsumerInstanceMetadata: time.Hour}}
+	c.add(clock.Now(), instance("old"))
+	c.invalidate("us-central1-b", "written")
+	clock.SetTime(clock.Now().Add(30 * time.Minute))
+	c.add(clock.Now(), instance("recent"))
+	assert.Len(t, c.instances, 2)
+	clock.SetTime(clock.Now().Add(45 * time.Minute))
+	c.add(clock.Now(), instance("new"))
+	assert.Len(t, c.instances, 2)
+	assert.NotContains(t, c.instances, *meta.ZonalKey("old", "us-central1-b"))
+	assert.Empty(t, c.invalidated)
+
+	// Instances read too long ago for any consumer are not cached.
+	c.add(clock.Now().Add(-2*time.Hour), instance("stale"))
+	assert.NotContains(t, c.instances, *meta.ZonalKey("stale", "us-central1-b"))
+}
+
+func TestGetFoundInstanceByNamesWithInstanceCache(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.instanceCache.config = InstanceCacheConfig{MaxAge: time.Minute}
+	_, err = createAndInsertNodes(gce, []string{"node-1", "node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	_, lists := countInstanceReads(gce)
+
+	// The listed instances are cached.
+	instances, err := gce.getFoundInstanceByNames([]string{"node-1"})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(lists))
+
+	instances, err = gce.getFoundInstanceByNames([]string{"node-1", "node-2"})
+	require.NoError(t, err)
+	assert.Len(t, instances, 2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(lists))
+
+	// Instances missing from the cache are listed.
+	_, err = createAndInsertNodes(gce, []string{"node-3"}, vals.ZoneName)
+	require.NoError(t, err)
+	instances, err = gce.getFoundInstanceByNames([]string{"node-1", "node-3"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"node-1", "node-3"}, []string{instances[0].Name, instances[1].Name})
+	assert.Equal(t, int32(2), atomic.LoadInt32(lists))
+}
@@ -0,0 +1,174 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// InstanceLabelPrefix prefixes the labels describing the instance of a node that InstanceMetadata
+// returns as additional labels of the node.
+const InstanceLabelPrefix = "instance.gce.cloud.google.com/"
+
+// The instance labels, named by their suffix after InstanceLabelPrefix in the instance-label config.
+const (
+	// InstanceLabelMachineFamily is the machine family of the machine type, e.g. n2 or e2.
+	InstanceLabelMachineFamily = "machine-family"
+	// InstanceLabelProvisioningModel is spot, preemptible or standard.
+	InstanceLabelProvisioningModel = "provisioning-model"
+	// InstanceLabelCPUPlatform is the CPU platform of the instance, e.g. intel-cascade-lake.
+	InstanceLabelCPUPlatform = "cpu-platform"
+	// InstanceLabelConfidentialComputeType is the confidential computing technology of the
+	// instance, e.g. sev or tdx. Absent if confidential computing is disabled.
+	InstanceLabelConfidentialComputeType = "confidential-compute-type"
+	// InstanceLabelSoleTenantNodeGroup is the sole-tenant node group the instance runs on.
+	InstanceLabelSoleTenantNodeGroup = "sole-tenant-node-group"
+	// InstanceLabelGPUType is the type of the GPUs attached to the instance, e.g. nvidia-tesla-t4.
+	InstanceLabelGPUType = "gpu-type"
+	// InstanceLabelGPUCount is the number of GPUs attached to the instance.
+	InstanceLabelGPUCount = "gpu-count"
+	// InstanceLabelReservationAffinity is how the instance consumes reservations: any-reservation,
+	// specific-reservation or no-reservation.
+	InstanceLabelReservationAffinity = "reservation-affinity"
+
+	// soleTenantNodeGroupAffinityKey is the node affinity key of the instances scheduled on a
+	// sole-tenant node group.
+	soleTenantNodeGroupAffinityKey = "compute.googleapis.com/node-group-name"
+)
+
+// instanceLabels lists the supported instance labels.
+var instanceLabels = sets.NewString(
+	InstanceLabelMachineFamily,
+	InstanceLabelProvisioningModel,
+	InstanceLabelCPUPlatform,
+	InstanceLabelConfidentialComputeType,
+	InstanceLabelSoleTenantNodeGroup,
+	InstanceLabelGPUType,
+	InstanceLabelGPUCount,
+	InstanceLabelReservationAffinity,
+)
+
+var invalidLabelValueChars = regexp.MustCompile("[^a-z0-9]+")
+
+// parseInstanceLabels returns the allowlist of instance labels set in the config, nil if none is.
+func parseInstanceLabels(global *ConfigGlobal) (sets.String, error) {
+	if len(global.InstanceLabels) == 0 {
+		return nil, nil
+	}
+	allowed := sets.NewString()
+	for _, label := range global.InstanceLabels {
+		label = strings.TrimPrefix(strings.TrimSpace(label), InstanceLabelPrefix)
+		if !instanceLabels.Has(label) {
+			return nil, fmt.Errorf("unsupported instance-label %q, supported labels are %v", label, instanceLabels.List())
+		}
+		allowed.Insert(label)
+	}
+	return allowed, nil
+}
+
+// instanceAdditionalLabels returns the allowed labels describing the instance, prefixed with
+// InstanceLabelPrefix. Labels without value for the instance are omitted.
+func (g *Cloud) instanceAdditionalLabels(instance *compute.Instance) map[string]string {
+	if g.instanceLabels.Len() == 0 {
+		return nil
+	}
+	labels := map[string]string{}
+	for label, value := range instanceLabelValues(instance) {
+		if !g.instanceLabels.Has(label) || value == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			klog.Warningf("instanceAdditionalLabels(%s): skipping label %s with invalid value %q: %v", instance.Name, label, value, errs)
+			continue
+		}
+		labels[InstanceLabelPrefix+label] = value
+	}
+	return labels
+}
+
+// instanceLabelValues returns the values of the instance labels of the instance.
+func instanceLabelValues(instance *compute.Instance) map[string]string {
+	values := map[string]string{
+		InstanceLabelMachineFamily:       machineFamily(lastComponent(instance.MachineType)),
+		InstanceLabelProvisioningModel:   "standard",
+		InstanceLabelCPUPlatform:         labelValue(instance.CpuPlatform),
+		InstanceLabelReservationAffinity: "any-reservation",
+	}
+	if s := instance.Scheduling; s != nil {
+		if s.ProvisioningModel != "" {
+			values[InstanceLabelProvisioningModel] = labelValue(s.ProvisioningModel)
+		}
+		if s.Preemptible && s.ProvisioningModel != "SPOT" {
+			values[InstanceLabelProvisioningModel] = "preemptible"
+		}
+		for _, affinity := range s.NodeAffinities {
+			if affinity.Key == soleTenantNodeGroupAffinityKey && affinity.Operator == "IN" && len(affinity.Values) > 0 {
+				values[InstanceLabelSoleTenantNodeGroup] = affinity.Values[0]
+			}
+		}
+	}
+	if c := instance.ConfidentialInstanceConfig; c != nil {
+		switch {
+		case c.ConfidentialInstanceType != "":
+			values[InstanceLabelConfidentialComputeType] = labelValue(c.ConfidentialInstanceType)
+		case c.EnableConfidentialCompute:
+			// Confidential instances without type use AMD SEV.
+			values[InstanceLabelConfidentialComputeType] = "sev"
+		}
+	}
+	var gpuCount int64
+	for _, accelerator := range instance.GuestAccelerators {
+		if values[InstanceLabelGPUType] == "" {
+			values[InstanceLabelGPUType] = lastComponent(accelerator.AcceleratorType)
+		}
+		gpuCount += accelerator.AcceleratorCount
+	}
+	if gpuCount > 0 {
+		values[InstanceLabelGPUCount] = strconv.FormatInt(gpuCount, 10)
+	}
+	if r := instance.ReservationAffinity; r != nil && r.ConsumeReservationType != "" {
+		values[InstanceLabelReservationAffinity] = labelValue(r.ConsumeReservationType)
+	}
+	return values
+}
+
+// machineFamily returns the machine family of the machine type, which prefixes its name.
+// Custom machine types without family are N1 machines.
+func machineFamily(machineType string) string {
+	family, _, _ := strings.Cut(machineType, "-")
+	if family == "custom" {
+		return "n1"
+	}
+	return family
+}
+
+// labelValue turns an API enum or display name, e.g. SPECIFIC_RESERVATION or Intel Cascade Lake,
+// into a label value, e.g. specific-reservation or intel-cascade-lake.
+func labelValue(s string) string {
+	return strings.Trim(invalidLabelValueChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
@@ -0,0 +1,145 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParseInstanceLabels(t *testing.T) {
+	t.Parallel()
+
+	labels, err := parseInstanceLabels(&ConfigGlobal{})
+	require.NoError(t, err)
+	assert.Nil(t, labels)
+
+	labels, err = parseInstanceLabels(&ConfigGlobal{InstanceLabels: []string{"gpu-type", InstanceLabelPrefix + "gpu-count"}})
+	require.NoError(t, err)
+	assert.Equal(t, sets.NewString(InstanceLabelGPUType, InstanceLabelGPUCount), labels)
+
+	_, err = parseInstanceLabels(&ConfigGlobal{InstanceLabels: []string{"gpu-memory"}})
+	assert.Error(t, err)
+}
+
+func TestInstanceLabelValues(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc     string
+		instance *compute.Instance
+		want     map[string]string
+	}{
+		{
+			desc:     "standard instance",
+			instance: &compute.Instance{MachineType: "zones/us-central1-b/machineTypes/e2-standard-4", CpuPlatform: "Intel Broadwell"},
+			want: map[string]string{
+				InstanceLabelMachineFamily:       "e2",
+				InstanceLabelProvisioningModel:   "standard",
+				InstanceLabelCPUPlatform:         "intel-broadwell",
+				InstanceLabelReservationAffinity: "any-reservation",
+			},
+		},
+		{
+			desc: "spot GPU instance",
+			instance: &compute.Instance{
+				MachineType: "zones/us-central1-b/machineTypes/n1-custom-8-30720",
+				Scheduling:  &compute.Scheduling{ProvisioningModel: "SPOT", Preemptible: true},
+				GuestAccelerators: []*compute.AcceleratorConfig{
+					{AcceleratorType: "projects/p/zones/us-central1-b/acceleratorTypes/nvidia-tesla-t4", AcceleratorCount: 2},
+				},
+				ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "NO_RESERVATION"},
+			},
+			want: map[string]string{
+				InstanceLabelMachineFamily:       "n1",
+				InstanceLabelProvisioningModel:   "spot",
+				InstanceLabelCPUPlatform:         "",
+				InstanceLabelGPUType:             "nvidia-tesla-t4",
+				InstanceLabelGPUCount:            "2",
+				InstanceLabelReservationAffinity: "no-reservation",
+			},
+		},
+		{
+			desc: "preemptible confidential sole-tenant instance",
+			instance: &compute.Instance{
+				MachineType:                "zones/us-central1-b/machineTypes/custom-4-8192",
+				Scheduling:                 &compute.Scheduling{Preemptible: true, NodeAffinities: []*compute.SchedulingNodeAffinity{{Key: soleTenantNodeGroupAffinityKey, Operator: "IN", Values: []string{"tenant-a"}}}},
+				ConfidentialInstanceConfig: &compute.ConfidentialInstanceConfig{ConfidentialInstanceType: "SEV_SNP"},
+				ReservationAffinity:        &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION"},
+			},
+			want: map[string]string{
+				InstanceLabelMachineFamily:           "n1",
+				InstanceLabelProvisioningModel:       "preemptible",
+				InstanceLabelCPUPlatform:             "",
+				InstanceLabelConfidentialComputeType: "sev-snp",
+				InstanceLabelSoleTenantNodeGroup:     "tenant-a",
+				InstanceLabelReservationAffinity:     "specific-reservation",
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.want, instanceLabelValues(tc.instance))
+		})
+	}
+}
+
+func TestInstanceMetadataAdditionalLabels(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{
+		Name:        "node-1",
+		Zone:        vals.ZoneName,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/n2-standard-8", vals.ZoneName),
+		CpuPlatform: "Intel Cascade Lake",
+		Scheduling:  &compute.Scheduling{ProvisioningModel: "SPOT"},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{NetworkIP: "10.1.1.1"},
+		},
+	}))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/node-1", gce.ProjectID(), vals.ZoneName)},
+	}
+
+	// No label is added by default.
+	metadata, err := gce.InstanceMetadata(context.TODO(), node)
+	require.NoError(t, err)
+	assert.Empty(t, metadata.AdditionalLabels)
+
+	// Only the allowed labels with a value are added.
+	gce.instanceLabels = sets.NewString(InstanceLabelMachineFamily, InstanceLabelProvisioningModel, InstanceLabelGPUType)
+	metadata, err = gce.InstanceMetadata(context.TODO(), node)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		InstanceLabelPrefix + InstanceLabelMachineFamily:     "n2",
+		InstanceLabelPrefix + InstanceLabelProvisioningModel: "spot",
+	}, metadata.AdditionalLabels)
+}
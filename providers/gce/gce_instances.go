@@ -241,7 +241,7 @@ func (g *Cloud) NodeAddressesByProviderID(ctx context.Context, providerID string
 		return []v1.NodeAddress{}, err
 	}
 
-	instance, err := g.c.Instances().Get(timeoutCtx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	instance, err := g.getCachedInstance(timeoutCtx, InstanceCacheConsumerNodeAddresses, zone, name)
 	if err != nil {
 		return []v1.NodeAddress{}, fmt.Errorf("error while querying for providerID %q: %v", providerID, err)
 	}
@@ -251,13 +251,16 @@ func (g *Cloud) NodeAddressesByProviderID(ctx context.Context, providerID string
 
 // instanceByProviderID returns the cloudprovider instance of the node
 // with the specified unique providerID
-func (g *Cloud) instanceByProviderID(providerID string) (*gceInstance, error) {
-	project, zone, name, err := splitProviderID(providerID)
+func (g *Cloud) instanceByProviderID(consumer InstanceCacheConsumer, providerID string) (*gceInstance, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	_, zone, name, err := splitProviderID(providerID)
 	if err != nil {
 		return nil, err
 	}
 
-	instance, err := g.getInstanceFromProjectInZoneByName(project, zone, name)
+	res, err := g.getCachedInstance(ctx, consumer, zone, name)
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil, cloudprovider.InstanceNotFound
@@ -265,17 +268,32 @@ func (g *Cloud) instanceByProviderID(providerID string) (*gceInstance, error) {
 		return nil, err
 	}
 
-	return instance, nil
+	return newGCEInstance(lastComponent(res.Zone), res), nil
 }
 
+// instanceShutdownStatuses are the statuses of the instances that are stopped or suspended, or
+// being so. Preempted Spot VMs are TERMINATED.
+var instanceShutdownStatuses = sets.NewString("TERMINATED", "STOPPED", "STOPPING", "SUSPENDED", "SUSPENDING")
+
 // InstanceShutdownByProviderID returns true if the instance is in safe state to detach volumes
 func (g *Cloud) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
-	return false, cloudprovider.NotImplemented
+	instance, err := g.instanceByProviderID(InstanceCacheConsumerInstanceShutdown, providerID)
+	if err != nil {
+		return false, err
+	}
+	return instanceShutdownStatuses.Has(instance.Status), nil
 }
 
 // InstanceShutdown returns true if the instance is in safe state to detach volumes
 func (g *Cloud) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
-	return false, cloudprovider.NotImplemented
+	providerID := node.Spec.ProviderID
+	if providerID == "" {
+		var err error
+		if providerID, err = cloudprovider.GetInstanceProviderID(ctx, g, types.NodeName(node.Name)); err != nil {
+			return false, err
+		}
+	}
+	return g.InstanceShutdownByProviderID(ctx, providerID)
 }
 
 func (g *Cloud) nodeAddressesFromInstance(instance *compute.Instance) ([]v1.NodeAddress, error) {
@@ -314,7 +332,7 @@ func getIPV6AddressFromInterface(nic *compute.NetworkInterface) string {
 // node that is requesting this ID. i.e. metadata service and other local
 // methods cannot be used here
 func (g *Cloud) InstanceTypeByProviderID(ctx context.Context, providerID string) (string, error) {
-	instance, err := g.instanceByProviderID(providerID)
+	instance, err := g.instanceByProviderID(InstanceCacheConsumerInstanceType, providerID)
 	if err != nil {
 		return "", err
 	}
@@ -325,7 +343,7 @@ func (g *Cloud) InstanceTypeByProviderID(ctx context.Context, providerID string)
 // InstanceExistsByProviderID returns true if the instance with the given provider id still exists and is running.
 // If false is returned with no error, the instance will be immediately deleted by the cloud controller manager.
 func (g *Cloud) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
-	_, err := g.instanceByProviderID(providerID)
+	_, err := g.instanceByProviderID(InstanceCacheConsumerInstanceExists, providerID)
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
 			return false, nil
@@ -377,7 +395,7 @@ func (g *Cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 
 	var addresses []v1.NodeAddress
 	var instanceType string
-	instance, err := g.c.Instances().Get(timeoutCtx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	instance, err := g.getCachedInstance(timeoutCtx, InstanceCacheConsumerInstanceMetadata, zone, name)
 	if err != nil {
 		return nil, fmt.Errorf("error while querying for providerID %q: %v", providerID, err)
 	}
@@ -390,11 +408,12 @@ func (g *Cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 	instanceType = lastComponent(instance.MachineType)
 
 	return &cloudprovider.InstanceMetadata{
-		ProviderID:    providerID,
-		InstanceType:  instanceType,
-		NodeAddresses: addresses,
-		Zone:          zone,
-		Region:        region,
+		ProviderID:       providerID,
+		InstanceType:     instanceType,
+		NodeAddresses:    addresses,
+		Zone:             zone,
+		Region:           region,
+		AdditionalLabels: g.instanceAdditionalLabels(instance),
 	}, nil
 }
 
@@ -506,6 +525,23 @@ func (g *Cloud) GetAllCurrentZones() (sets.String, error) {
 	return zones, nil
 }
 
+// currentNodeNames returns the names of the k8s nodes known to the node informer.
+func (g *Cloud) currentNodeNames() (sets.String, error) {
+	if g.nodeInformerSynced == nil {
+		return nil, fmt.Errorf("cloud object does not have informers set")
+	}
+	g.nodeZonesLock.Lock()
+	defer g.nodeZonesLock.Unlock()
+	if !g.nodeInformerSynced() {
+		return nil, fmt.Errorf("node informer is not synced when trying to list the nodes")
+	}
+	names := sets.NewString()
+	for _, nodes := range g.nodeZones {
+		names.Insert(nodes.UnsortedList()...)
+	}
+	return names, nil
+}
+
 // GetAllZonesFromCloudProvider returns all the zones in which nodes are running
 // Only use this in E2E tests to get zones, on real clusters this will
 // get all zones with compute instances in them even if not k8s instances!!!
@@ -535,6 +571,7 @@ func (g *Cloud) InsertInstance(project string, zone string, i *compute.Instance)
 	ctx, cancel := cloud.ContextWithCallTimeout()
 	defer cancel()
 
+	defer g.instanceCache.invalidate(zone, i.Name)
 	mc := newInstancesMetricContext("create", zone)
 	return mc.Observe(g.c.Instances().Insert(ctx, meta.ZonalKey(i.Name, zone), i))
 }
@@ -562,6 +599,7 @@ func (g *Cloud) DeleteInstance(project, zone, name string) error {
 	ctx, cancel := cloud.ContextWithCallTimeout()
 	defer cancel()
 
+	defer g.instanceCache.invalidate(zone, name)
 	return g.c.Instances().Delete(ctx, meta.ZonalKey(name, zone))
 }
 
@@ -650,9 +688,10 @@ func (g *Cloud) AddAliasToInstanceByProviderID(providerID string, alias *net.IPN
 		SubnetworkRangeName: g.secondaryRangeName,
 	})
 
+	defer g.instanceCache.invalidate(zone, name)
 	mc := newInstancesMetricContext("add_alias", zone)
 	err = g.c.BetaInstances().UpdateNetworkInterface(ctx, meta.ZonalKey(instance.Name, lastComponent(instance.Zone)), iface.Name, iface)
-	return mc.Observe(err)
+	return g.classifyError(nodeReference(types.NodeName(instance.Name)), mc.Observe(err))
 }
 
 // Gets the named instances, returning cloudprovider.InstanceNotFound if any
@@ -691,32 +730,41 @@ func (g *Cloud) getFoundInstanceByNames(names []string) ([]*gceInstance, error)
 		found[name] = nil
 	}
 
+	// Instances fresh in the cache are not listed again.
+	cached := sets.NewString()
+	for name := range found {
+		for _, zone := range g.managedZones {
+			if inst, ok := g.instanceCache.get(InstanceCacheConsumerLoadBalancer, *meta.ZonalKey(name, zone)); ok {
+				found[name] = newGCEInstance(zone, inst)
+				cached.Insert(name)
+				remaining--
+				break
+			}
+		}
+	}
+
 	for _, zone := range g.managedZones {
 		if remaining == 0 {
 			break
 		}
+		listed := g.instanceCache.now()
 		instances, err := g.c.Instances().List(ctx, zone, filter.Regexp("name", nodeInstancePrefix+".*"))
 		if err != nil {
 			return nil, err
 		}
+		g.instanceCache.add(listed, instances...)
 		for _, inst := range instances {
 			if remaining == 0 {
 				break
 			}
-			if _, ok := found[inst.Name]; !ok {
+			if _, ok := found[inst.Name]; !ok || cached.Has(inst.Name) {
 				continue
 			}
 			if found[inst.Name] != nil {
 				klog.Errorf("Instance name %q was duplicated (in zone %q and %q)", inst.Name, zone, found[inst.Name].Zone)
 				continue
 			}
-			found[inst.Name] = &gceInstance{
-				Zone:  zone,
-				Name:  inst.Name,
-				ID:    inst.Id,
-				Disks: inst.Disks,
-				Type:  lastComponent(inst.MachineType),
-			}
+			found[inst.Name] = newGCEInstance(zone, inst)
 			remaining--
 		}
 	}
@@ -768,13 +816,19 @@ func (g *Cloud) getInstanceFromProjectInZoneByName(project, zone, name string) (
 	if err != nil {
 		return nil, err
 	}
+	return newGCEInstance(lastComponent(res.Zone), res), nil
+}
+
+// newGCEInstance returns the cloudprovider instance of the instance in the zone.
+func newGCEInstance(zone string, instance *compute.Instance) *gceInstance {
 	return &gceInstance{
-		Zone:  lastComponent(res.Zone),
-		Name:  res.Name,
-		ID:    res.Id,
-		Disks: res.Disks,
-		Type:  lastComponent(res.MachineType),
-	}, nil
+		Zone:   zone,
+		Name:   instance.Name,
+		ID:     instance.Id,
+		Disks:  instance.Disks,
+		Type:   lastComponent(instance.MachineType),
+		Status: instance.Status,
+	}
 }
 
 func getInstanceIDViaMetadata() (string, error) {
@@ -857,17 +911,11 @@ func (g *Cloud) computeHostTags(hosts []*gceInstance) ([]string, error) {
 			if !hostNames[instance.Name] {
 				continue
 			}
-			longestTag := ""
-			for _, tag := range instance.Tags.Items {
-				if strings.HasPrefix(instance.Name, tag) && len(tag) > len(longestTag) {
-					longestTag = tag
-				}
-			}
-			if len(longestTag) > 0 {
-				tags.Insert(longestTag)
-			} else {
-				return nil, fmt.Errorf("could not find any tag that is a prefix of instance name for instance %s", instance.Name)
+			instanceTags, err := g.instanceTags(instance)
+			if err != nil {
+				return nil, err
 			}
+			tags.Insert(instanceTags...)
 		}
 	}
 	if len(tags) == 0 {
@@ -922,7 +970,7 @@ func (g *Cloud) InstanceByProviderID(providerID string) (res *compute.Instance,
 		return nil, err
 	}
 
-	res, err = g.c.Instances().Get(ctx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	res, err = g.getCachedInstance(ctx, InstanceCacheConsumerInstanceByProviderID, zone, name)
 	if err != nil {
 		return nil, err
 	}
@@ -33,6 +33,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
 )
 
 func TestInstanceExists(t *testing.T) {
@@ -73,6 +74,52 @@ func TestInstanceExists(t *testing.T) {
 	}
 }
 
+func TestInstanceShutdown(t *testing.T) {
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+
+	testcases := []struct {
+		status   string
+		shutdown bool
+	}{
+		{status: "PROVISIONING", shutdown: false},
+		{status: "STAGING", shutdown: false},
+		{status: "RUNNING", shutdown: false},
+		{status: "REPAIRING", shutdown: false},
+		{status: "STOPPING", shutdown: true},
+		{status: "STOPPED", shutdown: true},
+		{status: "SUSPENDING", shutdown: true},
+		{status: "SUSPENDED", shutdown: true},
+		{status: "TERMINATED", shutdown: true},
+	}
+
+	for _, test := range testcases {
+		t.Run(test.status, func(t *testing.T) {
+			name := "node-" + strings.ToLower(test.status)
+			require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &ga.Instance{
+				Name:   name,
+				Zone:   vals.ZoneName,
+				Status: test.status,
+			}))
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+			shutdown, err := gce.InstanceShutdown(context.TODO(), node)
+			require.NoError(t, err)
+			assert.Equal(t, test.shutdown, shutdown)
+
+			providerID := fmt.Sprintf("gce://%s/%s/%s", gce.ProjectID(), vals.ZoneName, name)
+			shutdown, err = gce.InstanceShutdownByProviderID(context.TODO(), providerID)
+			require.NoError(t, err)
+			assert.Equal(t, test.shutdown, shutdown)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := gce.InstanceShutdownByProviderID(context.TODO(), fmt.Sprintf("gce://%s/%s/missing", gce.ProjectID(), vals.ZoneName))
+		assert.Equal(t, cloudprovider.InstanceNotFound, err)
+	})
+}
+
 func TestNodeAddresses(t *testing.T) {
 	gce, err := fakeGCECloud(DefaultTestClusterValues())
 	require.NoError(t, err)
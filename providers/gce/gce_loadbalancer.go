@@ -28,12 +28,12 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
-	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	cloudprovider "k8s.io/cloud-provider"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
 	netutils "k8s.io/utils/net"
 )
 
@@ -43,8 +43,9 @@ type cidrs struct {
 }
 
 var (
-	l4LbSrcRngsFlag cidrs
-	l7lbSrcRngsFlag cidrs
+	l4LbSrcRngsFlag     cidrs
+	l4LbIPv6SrcRngsFlag cidrs
+	l7lbSrcRngsFlag     cidrs
 )
 
 func init() {
@@ -54,6 +55,11 @@ func init() {
 	if err != nil {
 		panic("Incorrect default GCE L3/4 source ranges")
 	}
+	// L3/4 IPv6 health checkers have client addresses within these known CIDRs.
+	l4LbIPv6SrcRngsFlag.ipn, err = netutils.ParseIPNets([]string{"2600:2d00:1:b029::/64"}...)
+	if err != nil {
+		panic("Incorrect default GCE L3/4 IPv6 source ranges")
+	}
 	// L7 health checkers have client addresses within these known CIDRs.
 	l7lbSrcRngsFlag.ipn, err = netutils.ParseIPNets([]string{"130.211.0.0/22", "35.191.0.0/16"}...)
 	if err != nil {
@@ -61,6 +67,7 @@ func init() {
 	}
 
 	flag.Var(&l4LbSrcRngsFlag, "cloud-provider-gce-lb-src-cidrs", "CIDRs opened in GCE firewall for L4 LB traffic proxy & health checks")
+	flag.Var(&l4LbIPv6SrcRngsFlag, "cloud-provider-gce-lb-ipv6-src-cidrs", "IPv6 CIDRs opened in GCE firewall for L4 LB health checks")
 	flag.Var(&l7lbSrcRngsFlag, "cloud-provider-gce-l7lb-src-cidrs", "CIDRs opened in GCE firewall for L7 LB traffic proxy & health checks")
 }
 
@@ -98,6 +105,12 @@ func L4LoadBalancerSrcRanges() []string {
 	return l4LbSrcRngsFlag.ipn.StringSlice()
 }
 
+// L4LoadBalancerIPv6SrcRanges contains the IPv6 ranges of ips used by the L3/L4 GCE load balancers
+// for performing health checks.
+func L4LoadBalancerIPv6SrcRanges() []string {
+	return l4LbIPv6SrcRngsFlag.ipn.StringSlice()
+}
+
 // L7LoadBalancerSrcRanges contains the ranges of ips used by the GCE load balancers L7
 // for proxying client requests and performing health checks.
 func L7LoadBalancerSrcRanges() []string {
@@ -108,6 +121,17 @@ func L7LoadBalancerSrcRanges() []string {
 func (g *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, svc *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
 	fwd, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
+	if (err == nil || isNotFound(err)) && g.clusterSupportsIPv6() {
+		// Dual-stack and IPv6-only internal load balancers have a separate IPv6 forwarding rule.
+		if ipv6Fwd, ipv6Err := g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), g.region); ipv6Err == nil {
+			status := &v1.LoadBalancerStatus{}
+			if fwd != nil {
+				status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{IP: fwd.IPAddress})
+			}
+			status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{IP: ipv6AddressWithoutPrefix(ipv6Fwd.IPAddress)})
+			return status, true, nil
+		}
+	}
 	if err == nil {
 		status := &v1.LoadBalancerStatus{}
 		status.Ingress = []v1.LoadBalancerIngress{{IP: fwd.IPAddress}}
@@ -123,9 +147,92 @@ func (g *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, svc *v1
 }
 
 // GetLoadBalancerName is an implementation of LoadBalancer.GetLoadBalancerName.
+// The name recorded in the LoadBalancerNameAnnotationKey annotation of the service is used if it is
+// the legacy or the v2 name of the service, so load balancers keep their names when the naming
+// scheme changes. Otherwise the legacy name is used.
 func (g *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, svc *v1.Service) string {
-	// TODO: replace DefaultLoadBalancerName to generate more meaningful loadbalancer names.
-	return cloudprovider.DefaultLoadBalancerName(svc)
+	legacyName := cloudprovider.DefaultLoadBalancerName(svc)
+	name, ok := svc.Annotations[LoadBalancerNameAnnotationKey]
+	if !ok || name == legacyName {
+		return legacyName
+	}
+	if clusterID, err := g.ClusterID.GetID(); err == nil && name == makeLoadBalancerNameV2(clusterID, svc.Namespace, svc.Name) {
+		return name
+	}
+	klog.V(2).Infof("GetLoadBalancerName(%v/%v): ignoring unexpected load balancer name %q, using %q", svc.Namespace, svc.Name, name, legacyName)
+	return legacyName
+}
+
+// ensureLoadBalancerName records the load balancer name of the service in the LoadBalancerNameAnnotationKey
+// annotation when the v2 naming scheme is enabled, and returns the service with the annotation set.
+// Services whose load balancer was provisioned with the legacy name keep it, other services get a v2 name.
+func (g *Cloud) ensureLoadBalancerName(ctx context.Context, clusterID string, svc *v1.Service) (*v1.Service, error) {
+	name, record, err := g.chooseLoadBalancerName(clusterID, svc)
+	if err != nil {
+		return nil, err
+	}
+	if !record {
+		return svc, nil
+	}
+
+	klog.V(2).Infof("ensureLoadBalancerName(%v/%v): recording load balancer name %q", svc.Namespace, svc.Name, name)
+	// Make a copy so we don't mutate the shared informer cache.
+	updated := svc.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[LoadBalancerNameAnnotationKey] = name
+	if _, err := servicehelper.PatchService(g.serviceClient(), svc, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// chooseLoadBalancerName returns the load balancer name to record in the LoadBalancerNameAnnotationKey
+// annotation of the service, and whether it needs to be recorded.
+func (g *Cloud) chooseLoadBalancerName(clusterID string, svc *v1.Service) (string, bool, error) {
+	if g.loadBalancerNamingScheme != LoadBalancerNamingSchemeV2 {
+		return "", false, nil
+	}
+	legacyName := cloudprovider.DefaultLoadBalancerName(svc)
+	v2Name := makeLoadBalancerNameV2(clusterID, svc.Namespace, svc.Name)
+	if name, ok := svc.Annotations[LoadBalancerNameAnnotationKey]; ok && (name == legacyName || name == v2Name) {
+		return name, false, nil
+	}
+
+	v2Exists, err := g.loadBalancerForwardingRuleExists(v2Name)
+	if err != nil {
+		return "", false, err
+	}
+	if v2Exists {
+		return v2Name, true, nil
+	}
+	legacyExists, err := g.loadBalancerForwardingRuleExists(legacyName)
+	if err != nil {
+		return "", false, err
+	}
+	// The finalizers and the status cover load balancers whose forwarding rule is being recreated.
+	if legacyExists || len(svc.Status.LoadBalancer.Ingress) > 0 || hasFinalizer(svc, ILBFinalizerV1) || hasFinalizer(svc, NetLBRBSFinalizer) {
+		return legacyName, true, nil
+	}
+	return v2Name, true, nil
+}
+
+// loadBalancerForwardingRuleExists returns whether the IPv4 or, in clusters supporting IPv6, the IPv6
+// forwarding rule of the load balancer exists.
+func (g *Cloud) loadBalancerForwardingRuleExists(loadBalancerName string) (bool, error) {
+	names := []string{loadBalancerName}
+	if g.clusterSupportsIPv6() {
+		names = append(names, makeIPv6ResourceName(loadBalancerName))
+	}
+	for _, name := range names {
+		if _, err := g.GetRegionForwardingRule(name, g.region); err == nil {
+			return true, nil
+		} else if !isNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
 }
 
 // EnsureLoadBalancer is an implementation of LoadBalancer.EnsureLoadBalancer.
@@ -133,37 +240,58 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 	// GCE load balancers do not support services with LoadBalancerClass set. LoadBalancerClass can't be updated for an existing load balancer, so here we don't need to clean any resources.
 	// Check API documentation for .Spec.LoadBalancerClass for details on when this field is allowed to be changed.
 	if svc.Spec.LoadBalancerClass != nil {
-		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, svc.Spec.LoadBalancerClass)
+		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, *svc.Spec.LoadBalancerClass)
 		return nil, cloudprovider.ImplementedElsewhere
 	}
 
-	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
-	desiredScheme := getSvcScheme(svc)
+	if g.loadBalancerDryRun != nil {
+		// The current status of the service is returned, so that the service controller does not change it.
+		err := g.dryRunLoadBalancer(svc, "EnsureLoadBalancer", func() error {
+			_, err := g.ensureLoadBalancer(ctx, clusterName, svc, nodes)
+			return err
+		})
+		if err != nil {
+			return nil, g.classifyError(svc, err)
+		}
+		return svc.Status.LoadBalancer.DeepCopy(), nil
+	}
+	status, err := g.ensureLoadBalancer(ctx, clusterName, svc, nodes)
+	return status, g.classifyError(svc, err)
+}
+
+func (g *Cloud) ensureLoadBalancer(ctx context.Context, clusterName string, svc *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	clusterID, err := g.ClusterID.GetID()
 	if err != nil {
 		return nil, err
 	}
+	nodes, err = loadBalancerNodes(svc, nodes)
+	if err != nil {
+		return nil, err
+	}
+	svc, err = g.ensureLoadBalancerName(ctx, clusterID, svc)
+	if err != nil {
+		return nil, err
+	}
+	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
+	desiredScheme := getSvcScheme(svc)
 
-	// Services with multiples protocols are not supported by this controller, warn the users and sets
-	// the corresponding Service Status Condition.
+	// Services mixing TCP and UDP are served by one forwarding rule per protocol. Other protocol mixes
+	// are not supported by this controller, warn the users and set the corresponding Service Status Condition.
 	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-network/1435-mixed-protocol-lb
 	if err := checkMixedProtocol(svc.Spec.Ports); err != nil {
 		if hasLoadBalancerPortsError(svc) {
 			return nil, err
 		}
-		klog.Warningf("Ignoring service %s/%s using different ports protocols", svc.Namespace, svc.Name)
-		g.eventRecorder.Event(svc, v1.EventTypeWarning, v1.LoadBalancerPortsErrorReason, "LoadBalancers with multiple protocols are not supported.")
-		svcApplyStatus := corev1apply.ServiceStatus().WithConditions(
-			metav1apply.Condition().
-				WithType(v1.LoadBalancerPortsError).
-				WithStatus(metav1.ConditionTrue).
-				WithReason(v1.LoadBalancerPortsErrorReason).
-				WithMessage("LoadBalancer with multiple protocols are not supported"))
-		svcApply := corev1apply.Service(svc.Name, svc.Namespace).WithStatus(svcApplyStatus)
-		if _, errApply := g.client.CoreV1().Services(svc.Namespace).ApplyStatus(ctx, svcApply, metav1.ApplyOptions{FieldManager: "gce-cloud-controller", Force: true}); errApply != nil {
+		klog.Warningf("Ignoring service %s/%s using unsupported mix of ports protocols", svc.Namespace, svc.Name)
+		g.eventRecorder.Event(svc, v1.EventTypeWarning, v1.LoadBalancerPortsErrorReason, "LoadBalancers with multiple protocols are only supported for TCP and UDP.")
+		if errApply := g.applyLoadBalancerPortsErrorCondition(ctx, svc, metav1.ConditionTrue); errApply != nil {
 			return nil, errApply
 		}
 		return nil, err
+	} else if hasLoadBalancerPortsError(svc) {
+		if errApply := g.applyLoadBalancerPortsErrorCondition(ctx, svc, metav1.ConditionFalse); errApply != nil {
+			return nil, errApply
+		}
 	}
 
 	klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): ensure %v loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, desiredScheme)
@@ -172,6 +300,14 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 	if err != nil && !isNotFound(err) {
 		return nil, err
 	}
+	if existingFwdRule == nil && desiredScheme != cloud.SchemeInternal && g.clusterSupportsIPv6() {
+		// IPv6-only internal load balancers only have an IPv6 forwarding rule. Use it to detect
+		// that the existing internal load balancer needs to be deleted.
+		existingFwdRule, err = g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), g.region)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+	}
 
 	if existingFwdRule != nil {
 		existingScheme := cloud.LbScheme(strings.ToUpper(existingFwdRule.LoadBalancingScheme))
@@ -202,6 +338,10 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 	default:
 		status, err = g.ensureExternalLoadBalancer(clusterName, clusterID, svc, existingFwdRule, nodes)
 	}
+	// The stage conditions only report on the load balancer, failing to set them does not fail the sync.
+	if errApply := g.applyLoadBalancerStageConditions(ctx, svc, err); errApply != nil {
+		klog.Warningf("EnsureLoadBalancer(%s, %s, %s, %s, %s): failed to set the load balancer conditions: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, errApply)
+	}
 	if err != nil {
 		klog.Errorf("Failed to EnsureLoadBalancer(%s, %s, %s, %s, %s), err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, err)
 		return status, err
@@ -215,31 +355,37 @@ func (g *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, svc
 	// GCE load balancers do not support services with LoadBalancerClass set. LoadBalancerClass can't be updated for an existing load balancer, so here we don't need to clean any resources.
 	// Check API documentation for .Spec.LoadBalancerClass for details on when this field is allowed to be changed.
 	if svc.Spec.LoadBalancerClass != nil {
-		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, svc.Spec.LoadBalancerClass)
+		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, *svc.Spec.LoadBalancerClass)
 		return cloudprovider.ImplementedElsewhere
 	}
 
+	if g.loadBalancerDryRun != nil {
+		return g.classifyError(svc, g.dryRunLoadBalancer(svc, "UpdateLoadBalancer", func() error {
+			return g.updateLoadBalancer(ctx, clusterName, svc, nodes)
+		}))
+	}
+	return g.classifyError(svc, g.updateLoadBalancer(ctx, clusterName, svc, nodes))
+}
+
+func (g *Cloud) updateLoadBalancer(ctx context.Context, clusterName string, svc *v1.Service, nodes []*v1.Node) error {
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
 	scheme := getSvcScheme(svc)
 	clusterID, err := g.ClusterID.GetID()
 	if err != nil {
 		return err
 	}
+	nodes, err = loadBalancerNodes(svc, nodes)
+	if err != nil {
+		return err
+	}
 
-	// Services with multiples protocols are not supported by this controller, warn the users and sets
+	// Services with unsupported protocol mixes are not supported by this controller, warn the users and sets
 	// the corresponding Service Status Condition, but keep processing the Update to not break upgrades.
 	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-network/1435-mixed-protocol-lb
 	if err := checkMixedProtocol(svc.Spec.Ports); err != nil && !hasLoadBalancerPortsError(svc) {
-		klog.Warningf("Ignoring update for service %s/%s using different ports protocols", svc.Namespace, svc.Name)
-		g.eventRecorder.Event(svc, v1.EventTypeWarning, v1.LoadBalancerPortsErrorReason, "LoadBalancer with multiple protocols are not supported.")
-		svcApplyStatus := corev1apply.ServiceStatus().WithConditions(
-			metav1apply.Condition().
-				WithType(v1.LoadBalancerPortsError).
-				WithStatus(metav1.ConditionTrue).
-				WithReason(v1.LoadBalancerPortsErrorReason).
-				WithMessage("LoadBalancer with multiple protocols are not supported"))
-		svcApply := corev1apply.Service(svc.Name, svc.Namespace).WithStatus(svcApplyStatus)
-		if _, errApply := g.client.CoreV1().Services(svc.Namespace).ApplyStatus(ctx, svcApply, metav1.ApplyOptions{FieldManager: "gce-cloud-controller", Force: true}); errApply != nil {
+		klog.Warningf("Ignoring update for service %s/%s using unsupported mix of ports protocols", svc.Namespace, svc.Name)
+		g.eventRecorder.Event(svc, v1.EventTypeWarning, v1.LoadBalancerPortsErrorReason, "LoadBalancers with multiple protocols are only supported for TCP and UDP.")
+		if errApply := g.applyLoadBalancerPortsErrorCondition(ctx, svc, metav1.ConditionTrue); errApply != nil {
 			// the error is retried by the controller loop
 			return errApply
 		}
@@ -259,6 +405,17 @@ func (g *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, svc
 
 // EnsureLoadBalancerDeleted is an implementation of LoadBalancer.EnsureLoadBalancerDeleted.
 func (g *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, svc *v1.Service) error {
+	if g.loadBalancerDryRun != nil {
+		// The deletion of the service is not blocked: the service controller removes its
+		// finalizer and the resources of the load balancer are leaked, see LoadBalancerDryRun.
+		return g.classifyError(svc, g.dryRunLoadBalancer(svc, "EnsureLoadBalancerDeleted", func() error {
+			return g.ensureLoadBalancerDeleted(ctx, clusterName, svc)
+		}))
+	}
+	return g.classifyError(svc, g.ensureLoadBalancerDeleted(ctx, clusterName, svc))
+}
+
+func (g *Cloud) ensureLoadBalancerDeleted(ctx context.Context, clusterName string, svc *v1.Service) error {
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
 	scheme := getSvcScheme(svc)
 	clusterID, err := g.ClusterID.GetID()
@@ -285,8 +442,12 @@ func getSvcScheme(svc *v1.Service) cloud.LbScheme {
 	return cloud.SchemeExternal
 }
 
-// checkMixedProtocol checks if the Service Ports uses different protocols,
-// per examples, TCP and UDP.
+// mixedProtocols are the protocols that can be mixed in a LoadBalancer Service. Each of them
+// is served by its own forwarding rule, all sharing the IP address of the load balancer.
+var mixedProtocols = []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP}
+
+// checkMixedProtocol checks if the Service Ports uses different protocols that
+// can not be mixed, per examples, TCP and SCTP.
 func checkMixedProtocol(ports []v1.ServicePort) error {
 	if len(ports) == 0 {
 		return nil
@@ -294,13 +455,134 @@ func checkMixedProtocol(ports []v1.ServicePort) error {
 
 	firstProtocol := ports[0].Protocol
 	for _, port := range ports[1:] {
-		if port.Protocol != firstProtocol {
-			return fmt.Errorf("mixed protocol is not supported for LoadBalancer")
+		if port.Protocol == firstProtocol {
+			continue
+		}
+		if !isMixedProtocol(firstProtocol) || !isMixedProtocol(port.Protocol) {
+			return fmt.Errorf("mixed protocol is only supported for TCP and UDP LoadBalancer ports")
 		}
 	}
 	return nil
 }
 
+func isMixedProtocol(protocol v1.Protocol) bool {
+	for _, p := range mixedProtocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolPorts are the Service ports served by the forwarding rule of one protocol.
+type protocolPorts struct {
+	protocol v1.Protocol
+	ports    []v1.ServicePort
+	// primary is set for the protocol whose resources keep the names the load balancer
+	// had before it served multiple protocols.
+	primary bool
+}
+
+// groupPortsByProtocol splits the Service ports by protocol, the primary protocol first. The
+// primary protocol is the protocol of the existing forwarding rule if the Service still uses it,
+// so that the forwarding rule can be kept. Otherwise it is the first protocol in alphabetical order.
+func groupPortsByProtocol(svcPorts []v1.ServicePort, existingProtocol string) []protocolPorts {
+	if len(svcPorts) == 0 {
+		_, _, protocol := getPortsAndProtocol(svcPorts)
+		return []protocolPorts{{protocol: protocol, primary: true}}
+	}
+
+	portsByProtocol := make(map[v1.Protocol][]v1.ServicePort)
+	var protocols []v1.Protocol
+	for _, port := range svcPorts {
+		if _, ok := portsByProtocol[port.Protocol]; !ok {
+			protocols = append(protocols, port.Protocol)
+		}
+		portsByProtocol[port.Protocol] = append(portsByProtocol[port.Protocol], port)
+	}
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i] < protocols[j] })
+
+	primary := 0
+	for i, protocol := range protocols {
+		if strings.EqualFold(string(protocol), existingProtocol) {
+			primary = i
+		}
+	}
+	groups := []protocolPorts{{protocol: protocols[primary], ports: portsByProtocol[protocols[primary]], primary: true}}
+	for i, protocol := range protocols {
+		if i != primary {
+			groups = append(groups, protocolPorts{protocol: protocol, ports: portsByProtocol[protocol]})
+		}
+	}
+	return groups
+}
+
+// getProtocolGroups groups the Service ports by protocol. The existing forwarding rule, the first of the
+// given names that exists, is only needed to find the primary protocol, so it is only looked up for
+// Services mixing protocols.
+func (g *Cloud) getProtocolGroups(svcPorts []v1.ServicePort, fwdRuleNames ...string) ([]protocolPorts, error) {
+	groups := groupPortsByProtocol(svcPorts, "")
+	if len(groups) < 2 {
+		return groups, nil
+	}
+	for _, name := range fwdRuleNames {
+		fwd, err := g.GetRegionForwardingRule(name, g.region)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		return groupPortsByProtocol(svcPorts, fwd.IPProtocol), nil
+	}
+	return groups, nil
+}
+
+// unusedProtocolResourceNames returns the names of the per protocol resources derived from name
+// that are not used by the given protocol groups, so they can be deleted.
+func unusedProtocolResourceNames(name string, groups []protocolPorts) []string {
+	used := sets.NewString()
+	for _, group := range groups {
+		used.Insert(makeProtocolResourceName(name, group.protocol, group.primary))
+	}
+	var unused []string
+	for _, protocol := range mixedProtocols {
+		if resourceName := makeProtocolResourceName(name, protocol, false); !used.Has(resourceName) {
+			unused = append(unused, resourceName)
+		}
+	}
+	return unused
+}
+
+// loadBalancerPortsSupportedReason is the reason of the LoadBalancerPortsError condition once the
+// ports of the Service are supported again.
+const loadBalancerPortsSupportedReason = "LoadBalancerPortsSupported"
+
+// newLoadBalancerPortsErrorCondition returns the LoadBalancerPortsError condition with the given status.
+func newLoadBalancerPortsErrorCondition(status metav1.ConditionStatus) metav1.Condition {
+	if status == metav1.ConditionTrue {
+		return metav1.Condition{
+			Type:    v1.LoadBalancerPortsError,
+			Status:  status,
+			Reason:  v1.LoadBalancerPortsErrorReason,
+			Message: "LoadBalancer with multiple protocols are only supported for TCP and UDP",
+		}
+	}
+	return metav1.Condition{
+		Type:    v1.LoadBalancerPortsError,
+		Status:  status,
+		Reason:  loadBalancerPortsSupportedReason,
+		Message: "LoadBalancer ports are supported",
+	}
+}
+
+// applyLoadBalancerPortsErrorCondition sets the LoadBalancerPortsError condition of the Service
+// to the given status.
+func (g *Cloud) applyLoadBalancerPortsErrorCondition(ctx context.Context, svc *v1.Service, status metav1.ConditionStatus) error {
+	condition := withLastTransitionTime(svc, newLoadBalancerPortsErrorCondition(status))
+	return g.applyLoadBalancerConditions(ctx, svc, []metav1.Condition{condition})
+}
+
 // hasLoadBalancerPortsError checks if the Service has the LoadBalancerPortsError set to True
 func hasLoadBalancerPortsError(service *v1.Service) bool {
 	if service == nil {
@@ -0,0 +1,117 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	netutils "k8s.io/utils/net"
+)
+
+// requestedLoadBalancerIPs returns the IPv4 and IPv6 addresses requested for the load balancer of the
+// service: the addresses of the static addresses named in the ServiceAnnotationLoadBalancerIPAddresses
+// annotation, or the address of spec.loadBalancerIP. The named addresses must be reserved in the region
+// of the cluster, and be internal addresses of the subnetwork of internal load balancers, or external
+// addresses of the network tier of external load balancers.
+func (g *Cloud) requestedLoadBalancerIPs(svc *v1.Service, loadBalancerName string, netTier cloud.NetworkTier, subnetworkURL string) (ipv4, ipv6 string, err error) {
+	names := GetLoadBalancerAnnotationIPAddressNames(svc)
+	if len(names) == 0 {
+		if netutils.IsIPv6String(svc.Spec.LoadBalancerIP) {
+			return "", svc.Spec.LoadBalancerIP, nil
+		}
+		return svc.Spec.LoadBalancerIP, "", nil
+	}
+
+	scheme := getSvcScheme(svc)
+	for _, name := range names {
+		// The controller releases the addresses named after the load balancer.
+		if name == loadBalancerName || name == makeIPv6ResourceName(loadBalancerName) {
+			return "", "", fmt.Errorf("reserved address %q can't be named after load balancer %q", name, loadBalancerName)
+		}
+		addr, err := g.GetRegionAddress(name, g.region)
+		if err != nil {
+			if isNotFound(err) {
+				return "", "", fmt.Errorf("reserved address %q not found in region %s", name, g.region)
+			}
+			return "", "", err
+		}
+		if err := g.validateRequestedAddress(addr, scheme, netTier, subnetworkURL); err != nil {
+			return "", "", err
+		}
+		if netutils.IsIPv6String(addr.Address) {
+			if ipv6 != "" {
+				return "", "", fmt.Errorf("reserved addresses %q and %q are both IPv6 addresses", ipv6, addr.Address)
+			}
+			ipv6 = addr.Address
+		} else {
+			if ipv4 != "" {
+				return "", "", fmt.Errorf("reserved addresses %q and %q are both IPv4 addresses", ipv4, addr.Address)
+			}
+			ipv4 = addr.Address
+		}
+	}
+	if svc.Spec.LoadBalancerIP != "" && svc.Spec.LoadBalancerIP != ipv4 && svc.Spec.LoadBalancerIP != ipv6 {
+		return "", "", fmt.Errorf("loadBalancerIP %q is not the address of a reserved address of annotation %s", svc.Spec.LoadBalancerIP, ServiceAnnotationLoadBalancerIPAddresses)
+	}
+	return ipv4, ipv6, nil
+}
+
+// requestedExternalLoadBalancerIP returns the IP address requested for the external load balancer of the
+// service, see requestedLoadBalancerIPs. External load balancers only have IPv4 addresses.
+func (g *Cloud) requestedExternalLoadBalancerIP(svc *v1.Service, loadBalancerName string, netTier cloud.NetworkTier) (string, error) {
+	if len(GetLoadBalancerAnnotationIPAddressNames(svc)) == 0 {
+		return svc.Spec.LoadBalancerIP, nil
+	}
+	ipv4, ipv6, err := g.requestedLoadBalancerIPs(svc, loadBalancerName, netTier, "")
+	if err != nil {
+		return "", err
+	}
+	if ipv6 != "" {
+		return "", fmt.Errorf("reserved IPv6 address %q can't be used by an external load balancer", ipv6)
+	}
+	return ipv4, nil
+}
+
+// validateRequestedAddress checks that the static address can be used by a load balancer of the scheme.
+func (g *Cloud) validateRequestedAddress(addr *compute.Address, scheme cloud.LbScheme, netTier cloud.NetworkTier, subnetworkURL string) error {
+	if addr.Region != "" && getNameFromLink(addr.Region) != g.region {
+		return fmt.Errorf("reserved address %q is in region %s, expected %s", addr.Name, getNameFromLink(addr.Region), g.region)
+	}
+	addressType := cloud.LbScheme(addr.AddressType)
+	if addressType == "" {
+		addressType = cloud.SchemeExternal
+	}
+	if addressType != scheme {
+		return fmt.Errorf("reserved address %q has type %s, expected %s", addr.Name, addressType, scheme)
+	}
+	if scheme == cloud.SchemeInternal {
+		if addr.Subnetwork != "" && subnetworkURL != "" && getNameFromLink(addr.Subnetwork) != getNameFromLink(subnetworkURL) {
+			return fmt.Errorf("reserved address %q is in subnetwork %s, expected %s", addr.Name, getNameFromLink(addr.Subnetwork), getNameFromLink(subnetworkURL))
+		}
+		return nil
+	}
+	if tier := cloud.NetworkTierGCEValueToType(addr.NetworkTier); tier != netTier {
+		return fmt.Errorf("reserved address %q belongs to the %s network tier; expected %s", addr.Name, tier, netTier)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequestedLoadBalancerIPs(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	subnetworkURL := gceSubnetworkURL("", vals.ProjectID, vals.Region, "default")
+	otherSubnetworkURL := gceSubnetworkURL("", vals.ProjectID, vals.Region, "other")
+	for _, addr := range []*compute.Address{
+		{Name: "internal-v4", Address: "10.0.0.10", AddressType: string(cloud.SchemeInternal), Subnetwork: subnetworkURL},
+		{Name: "internal-v4-bis", Address: "10.0.0.11", AddressType: string(cloud.SchemeInternal), Subnetwork: subnetworkURL},
+		{Name: "internal-v6", Address: "fd20::10", AddressType: string(cloud.SchemeInternal), Subnetwork: subnetworkURL, IpVersion: string(IPVersionIPv6)},
+		{Name: "internal-other-subnet", Address: "10.1.0.10", AddressType: string(cloud.SchemeInternal), Subnetwork: otherSubnetworkURL},
+		{Name: "external-premium", Address: "35.0.0.10", AddressType: string(cloud.SchemeExternal), NetworkTier: cloud.NetworkTierPremium.ToGCEValue()},
+		{Name: "external-standard", Address: "35.0.0.11", AddressType: string(cloud.SchemeExternal), NetworkTier: cloud.NetworkTierStandard.ToGCEValue()},
+		{Name: "external-v6", Address: "2600::10", AddressType: string(cloud.SchemeExternal), NetworkTier: cloud.NetworkTierPremium.ToGCEValue(), IpVersion: string(IPVersionIPv6)},
+	} {
+		require.NoError(t, gce.ReserveRegionAddress(addr, vals.Region))
+	}
+
+	for _, tc := range []struct {
+		desc           string
+		lbType         LoadBalancerType
+		names          string
+		loadBalancerIP string
+		netTier        cloud.NetworkTier
+		wantIPv4       string
+		wantIPv6       string
+		wantErr        bool
+	}{
+		{
+			desc:           "no annotation",
+			lbType:         LBTypeInternal,
+			loadBalancerIP: "10.0.0.20",
+			wantIPv4:       "10.0.0.20",
+		},
+		{
+			desc:           "no annotation, IPv6 loadBalancerIP",
+			lbType:         LBTypeInternal,
+			loadBalancerIP: "fd20::20",
+			wantIPv6:       "fd20::20",
+		},
+		{
+			desc:     "internal IPv4 and IPv6 addresses",
+			lbType:   LBTypeInternal,
+			names:    "internal-v4, internal-v6",
+			wantIPv4: "10.0.0.10",
+			wantIPv6: "fd20::10",
+		},
+		{
+			desc:           "loadBalancerIP matching an annotated address",
+			lbType:         LBTypeInternal,
+			names:          "internal-v4",
+			loadBalancerIP: "10.0.0.10",
+			wantIPv4:       "10.0.0.10",
+		},
+		{
+			desc:           "loadBalancerIP not matching the annotated addresses",
+			lbType:         LBTypeInternal,
+			names:          "internal-v4",
+			loadBalancerIP: "10.0.0.20",
+			wantErr:        true,
+		},
+		{
+			desc:    "two IPv4 addresses",
+			lbType:  LBTypeInternal,
+			names:   "internal-v4,internal-v4-bis",
+			wantErr: true,
+		},
+		{
+			desc:    "address not found",
+			lbType:  LBTypeInternal,
+			names:   "missing",
+			wantErr: true,
+		},
+		{
+			desc:    "internal address in another subnetwork",
+			lbType:  LBTypeInternal,
+			names:   "internal-other-subnet",
+			wantErr: true,
+		},
+		{
+			desc:    "external address for an internal load balancer",
+			lbType:  LBTypeInternal,
+			names:   "external-premium",
+			wantErr: true,
+		},
+		{
+			desc:    "internal address for an external load balancer",
+			names:   "internal-v4",
+			netTier: cloud.NetworkTierPremium,
+			wantErr: true,
+		},
+		{
+			desc:     "external address of the network tier",
+			names:    "external-standard",
+			netTier:  cloud.NetworkTierStandard,
+			wantIPv4: "35.0.0.11",
+		},
+		{
+			desc:    "external address of another network tier",
+			names:   "external-standard",
+			netTier: cloud.NetworkTierPremium,
+			wantErr: true,
+		},
+		{
+			desc:    "external IPv6 address",
+			names:   "external-v6",
+			netTier: cloud.NetworkTierPremium,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService(string(tc.lbType))
+			svc.Spec.LoadBalancerIP = tc.loadBalancerIP
+			if tc.names != "" {
+				svc.Annotations[ServiceAnnotationLoadBalancerIPAddresses] = tc.names
+			}
+			lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+			var ipv4, ipv6 string
+			var err error
+			if tc.lbType == LBTypeInternal {
+				ipv4, ipv6, err = gce.requestedLoadBalancerIPs(svc, lbName, cloud.NetworkTierDefault, subnetworkURL)
+			} else {
+				ipv4, err = gce.requestedExternalLoadBalancerIP(svc, lbName, tc.netTier)
+			}
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantIPv4, ipv4)
+			assert.Equal(t, tc.wantIPv6, ipv6)
+		})
+	}
+}
+
+func TestRequestedLoadBalancerIPsRejectsControllerAddressNames(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: lbName, Address: "10.0.0.10", AddressType: string(cloud.SchemeInternal)}, vals.Region))
+
+	svc.Annotations[ServiceAnnotationLoadBalancerIPAddresses] = lbName
+	_, _, err = gce.requestedLoadBalancerIPs(svc, lbName, cloud.NetworkTierDefault, "")
+	assert.Error(t, err)
+}
+
+func TestEnsureInternalLoadBalancerWithReservedAddressName(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: "my-ilb-address", Address: "10.0.0.10", AddressType: string(cloud.SchemeInternal)}, vals.Region))
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerIPAddresses] = "my-ilb-address"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.10", status.Ingress[0].IP)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.10", fwdRule.IPAddress)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	_, err = gce.GetRegionAddress("my-ilb-address", gce.region)
+	assert.NoError(t, err, "reserved address must not be released")
+}
+
+func TestEnsureExternalLoadBalancerWithReservedAddressName(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: "my-address", Address: "35.0.0.10", NetworkTier: cloud.NetworkTierPremium.ToGCEValue()}, vals.Region))
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerIPAddresses] = "my-address"
+	status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, "35.0.0.10", status.Ingress[0].IP)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "35.0.0.10", fwdRule.IPAddress)
+
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetRegionAddress("my-address", gce.region)
+	assert.NoError(t, err, "reserved address must not be released")
+}
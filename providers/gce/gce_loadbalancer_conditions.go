@@ -0,0 +1,236 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+// Service conditions reporting the stages of the provisioning of a load balancer.
+const (
+	// LoadBalancerConditionAddressReserved reports whether the IP address of the load balancer is reserved.
+	LoadBalancerConditionAddressReserved = "AddressReserved"
+	// LoadBalancerConditionHealthCheckReady reports whether the health check of the load balancer is provisioned.
+	LoadBalancerConditionHealthCheckReady = "HealthCheckReady"
+	// LoadBalancerConditionBackendReady reports whether the instance groups and the backend service, or the
+	// target pool, of the load balancer are provisioned.
+	LoadBalancerConditionBackendReady = "BackendReady"
+	// LoadBalancerConditionFirewallReady reports whether the firewalls of the load balancer are provisioned.
+	LoadBalancerConditionFirewallReady = "FirewallReady"
+	// LoadBalancerConditionForwardingRuleReady reports whether the forwarding rules of the load balancer are provisioned.
+	LoadBalancerConditionForwardingRuleReady = "ForwardingRuleReady"
+
+	loadBalancerConditionReadyReason = "Ready"
+	loadBalancerConditionErrorReason = "Error"
+
+	// loadBalancerConditionsFieldManager owns the stage conditions and the LoadBalancerPortsError
+	// condition. Server-side apply removes the conditions of a field manager missing from its apply,
+	// so every apply carries all of them, see applyLoadBalancerConditions.
+	loadBalancerConditionsFieldManager = "gce-cloud-controller"
+)
+
+// loadBalancerStages are the stages of the provisioning of a load balancer.
+var loadBalancerStages = []string{
+	LoadBalancerConditionAddressReserved,
+	LoadBalancerConditionHealthCheckReady,
+	LoadBalancerConditionBackendReady,
+	LoadBalancerConditionFirewallReady,
+	LoadBalancerConditionForwardingRuleReady,
+}
+
+var loadBalancerStageReadyMessages = map[string]string{
+	LoadBalancerConditionAddressReserved:     "The IP address of the load balancer is reserved",
+	LoadBalancerConditionHealthCheckReady:    "The health check of the load balancer is ready",
+	LoadBalancerConditionBackendReady:        "The backends of the load balancer are ready",
+	LoadBalancerConditionFirewallReady:       "The firewalls of the load balancer are ready",
+	LoadBalancerConditionForwardingRuleReady: "The forwarding rules of the load balancer are ready",
+}
+
+// loadBalancerStageError is an error of a stage of the provisioning of a load balancer.
+type loadBalancerStageError struct {
+	stage string
+	err   error
+}
+
+func (e *loadBalancerStageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *loadBalancerStageError) Unwrap() error {
+	return e.err
+}
+
+// newLoadBalancerStageError returns err as an error of the given stage, or nil if err is nil.
+// Errors already attributed to a stage are returned unchanged.
+func newLoadBalancerStageError(stage string, err error) error {
+	var stageErr *loadBalancerStageError
+	if err == nil || errors.As(err, &stageErr) {
+		return err
+	}
+	return &loadBalancerStageError{stage: stage, err: err}
+}
+
+// loadBalancerConditionReason returns the reason of a failed stage condition: the reason of the
+// googleapi error, like QuotaExceeded, or the HTTP status of the error.
+func loadBalancerConditionReason(err error) string {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return loadBalancerConditionErrorReason
+	}
+	reason := ""
+	if len(apiErr.Errors) > 0 {
+		reason = apiErr.Errors[0].Reason
+	}
+	if reason == "" {
+		reason = http.StatusText(apiErr.Code)
+	}
+	// Condition reasons are CamelCase identifiers.
+	var b strings.Builder
+	upper := true
+	for _, r := range reason {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return loadBalancerConditionErrorReason
+	}
+	return b.String()
+}
+
+// loadBalancerStageConditions returns the stage conditions of the service after ensuring its load
+// balancer: every stage is ready when the load balancer is ensured, otherwise the failed stage is
+// reported and the other stages keep their conditions.
+func loadBalancerStageConditions(svc *v1.Service, ensureErr error) []metav1.Condition {
+	var stageErr *loadBalancerStageError
+	if ensureErr != nil && !errors.As(ensureErr, &stageErr) {
+		return nil
+	}
+
+	var conditions []metav1.Condition
+	for _, stage := range loadBalancerStages {
+		existing := findServiceCondition(svc, stage)
+		condition := metav1.Condition{Type: stage}
+		switch {
+		case stageErr == nil:
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = loadBalancerConditionReadyReason
+			condition.Message = loadBalancerStageReadyMessages[stage]
+		case stageErr.stage == stage:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = loadBalancerConditionReason(stageErr.err)
+			condition.Message = stageErr.err.Error()
+		case existing != nil:
+			condition = *existing
+		default:
+			continue
+		}
+		conditions = append(conditions, withLastTransitionTime(svc, condition))
+	}
+	return conditions
+}
+
+// withLastTransitionTime returns the condition with its last transition time: the one of the
+// condition of the service when its status is unchanged, now otherwise.
+func withLastTransitionTime(svc *v1.Service, condition metav1.Condition) metav1.Condition {
+	condition.LastTransitionTime = metav1.Now()
+	if existing := findServiceCondition(svc, condition.Type); existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// applyLoadBalancerStageConditions sets the stage conditions of the service after ensuring its load
+// balancer. The service is only updated when the conditions change.
+func (g *Cloud) applyLoadBalancerStageConditions(ctx context.Context, svc *v1.Service, ensureErr error) error {
+	conditions := loadBalancerStageConditions(svc, ensureErr)
+	changed := false
+	for i := range conditions {
+		existing := findServiceCondition(svc, conditions[i].Type)
+		if existing == nil || existing.Status != conditions[i].Status || existing.Reason != conditions[i].Reason || existing.Message != conditions[i].Message {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	// The ports of the service are supported once its load balancer is ensured. The condition
+	// of the service may predate its update by EnsureLoadBalancer.
+	if findServiceCondition(svc, v1.LoadBalancerPortsError) != nil {
+		conditions = append(conditions, withLastTransitionTime(svc, newLoadBalancerPortsErrorCondition(metav1.ConditionFalse)))
+	}
+	return g.applyLoadBalancerConditions(ctx, svc, conditions)
+}
+
+// applyLoadBalancerConditions applies the conditions of the service owned by the
+// loadBalancerConditionsFieldManager, keeping the owned conditions of the service that are not
+// in conditions.
+func (g *Cloud) applyLoadBalancerConditions(ctx context.Context, svc *v1.Service, conditions []metav1.Condition) error {
+	owned := append([]string{v1.LoadBalancerPortsError}, loadBalancerStages...)
+	for _, conditionType := range owned {
+		if existing := findServiceCondition(svc, conditionType); existing != nil && findCondition(conditions, conditionType) == nil {
+			conditions = append(conditions, *existing)
+		}
+	}
+
+	statusApply := corev1apply.ServiceStatus()
+	for _, c := range conditions {
+		statusApply = statusApply.WithConditions(metav1apply.Condition().
+			WithType(c.Type).
+			WithStatus(c.Status).
+			WithReason(c.Reason).
+			WithMessage(c.Message).
+			WithLastTransitionTime(c.LastTransitionTime))
+	}
+	svcApply := corev1apply.Service(svc.Name, svc.Namespace).WithStatus(statusApply)
+	_, err := g.serviceClient().Services(svc.Namespace).ApplyStatus(ctx, svcApply, metav1.ApplyOptions{FieldManager: loadBalancerConditionsFieldManager, Force: true})
+	return err
+}
+
+// findServiceCondition returns the condition of the service with the given type, or nil.
+func findServiceCondition(svc *v1.Service, conditionType string) *metav1.Condition {
+	return findCondition(svc.Status.Conditions, conditionType)
+}
+
+// findCondition returns the condition with the given type, or nil.
+func findCondition(conditions []metav1.Condition, conditionType string) *metav1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ga "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func insertForwardingRulesQuotaExceededHook(ctx context.Context, key *meta.Key, obj *ga.ForwardingRule, m *cloud.MockForwardingRules, options ...cloud.Option) (bool, error) {
+	return true, &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}
+}
+
+func assertStageConditions(t *testing.T, gce *Cloud, svc *v1.Service, want map[string]metav1.ConditionStatus, wantReasons map[string]string) *v1.Service {
+	t.Helper()
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	for _, stage := range loadBalancerStages {
+		condition := findServiceCondition(svc, stage)
+		status, ok := want[stage]
+		if !ok {
+			assert.Nil(t, condition, "condition %s", stage)
+			continue
+		}
+		require.NotNil(t, condition, "condition %s", stage)
+		assert.Equal(t, status, condition.Status, "condition %s", stage)
+		if reason, ok := wantReasons[stage]; ok {
+			assert.Equal(t, reason, condition.Reason, "condition %s", stage)
+		}
+	}
+	return svc
+}
+
+func allStagesReady() map[string]metav1.ConditionStatus {
+	want := map[string]metav1.ConditionStatus{}
+	for _, stage := range loadBalancerStages {
+		want[stage] = metav1.ConditionTrue
+	}
+	return want
+}
+
+func TestEnsureLoadBalancerSetsStageConditions(t *testing.T) {
+	t.Parallel()
+
+	for desc, lbType := range map[string]LoadBalancerType{"external": "", "internal": LBTypeInternal} {
+		lbType := lbType
+		t.Run(desc, func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+			require.NoError(t, err)
+
+			svc := fakeLoadbalancerService(string(lbType))
+			// The LoadBalancerPortsError condition is owned by the same field manager as the stage
+			// conditions, every apply carries it.
+			svc.Status.Conditions = []metav1.Condition{{Type: v1.LoadBalancerPortsError, Status: metav1.ConditionTrue, Reason: v1.LoadBalancerPortsErrorReason}}
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			// The firewall can't be created.
+			c := gce.c.(*cloud.MockGCE)
+			c.MockFirewalls.InsertHook = mock.InsertFirewallsUnauthorizedErrHook
+			_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+			require.Error(t, err)
+			want := map[string]metav1.ConditionStatus{LoadBalancerConditionFirewallReady: metav1.ConditionFalse}
+			svc = assertStageConditions(t, gce, svc, want, map[string]string{LoadBalancerConditionFirewallReady: "Forbidden"})
+			require.NotNil(t, findServiceCondition(svc, v1.LoadBalancerPortsError))
+			assert.Equal(t, metav1.ConditionFalse, findServiceCondition(svc, v1.LoadBalancerPortsError).Status)
+
+			// The load balancer is provisioned.
+			c.MockFirewalls.InsertHook = nil
+			_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+			require.NoError(t, err)
+			svc = assertStageConditions(t, gce, svc, allStagesReady(), map[string]string{LoadBalancerConditionFirewallReady: loadBalancerConditionReadyReason})
+			require.NotNil(t, findServiceCondition(svc, v1.LoadBalancerPortsError))
+			assert.Equal(t, metav1.ConditionFalse, findServiceCondition(svc, v1.LoadBalancerPortsError).Status)
+		})
+	}
+}
+
+func TestEnsureLoadBalancerKeepsStageConditionsOfOtherStages(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	svc = assertStageConditions(t, gce, svc, allStagesReady(), nil)
+	readySince := findServiceCondition(svc, LoadBalancerConditionAddressReserved).LastTransitionTime
+
+	// The forwarding rule needs to be recreated for the new port, but the quota is exceeded.
+	c := gce.c.(*cloud.MockGCE)
+	c.MockForwardingRules.InsertHook = insertForwardingRulesQuotaExceededHook
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: int32(8080)})
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+	want := allStagesReady()
+	want[LoadBalancerConditionForwardingRuleReady] = metav1.ConditionFalse
+	svc = assertStageConditions(t, gce, svc, want, map[string]string{LoadBalancerConditionForwardingRuleReady: "QuotaExceeded"})
+	assert.Equal(t, readySince, findServiceCondition(svc, LoadBalancerConditionAddressReserved).LastTransitionTime)
+}
+
+func TestLoadBalancerConditionReason(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{desc: "not an API error", err: errors.New("boom"), want: "Error"},
+		{desc: "API error reason", err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, want: "QuotaExceeded"},
+		{desc: "API error code", err: &googleapi.Error{Code: http.StatusNotFound}, want: "NotFound"},
+		{desc: "wrapped API error", err: fmt.Errorf("failed: %w", &googleapi.Error{Code: http.StatusInternalServerError}), want: "InternalServerError"},
+		{desc: "unknown API error code", err: &googleapi.Error{Code: 999}, want: "Error"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.want, loadBalancerConditionReason(tc.err))
+		})
+	}
+}
@@ -0,0 +1,627 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// In dry run mode the load balancer operations run against a recording layer: the writes to the
+// GCE resources and to the services are recorded into the plan of the operation instead of being
+// made, and the reads see the resources as the operation left them.
+
+// dryRunRecorder records the changes of the load balancer operations in dry run mode.
+type dryRunRecorder struct {
+	// operation serializes the load balancer operations, so that the changes are
+	// recorded into the plan of the operation making them.
+	operation sync.Mutex
+
+	mu sync.Mutex
+	// plan is the plan of the operation in progress, nil if there is none.
+	plan *loadBalancerPlan
+	// objects are the resources written by the operation in progress, nil if deleted.
+	objects map[dryRunObjectKey]interface{}
+	// created are the resources created by the operation in progress.
+	created map[dryRunObjectKey]bool
+}
+
+type dryRunObjectKey struct {
+	resource string
+	key      meta.Key
+}
+
+// enableLoadBalancerDryRun makes the load balancer operations record their changes instead of
+// making them, see LoadBalancerDryRun.
+func (g *Cloud) enableLoadBalancerDryRun() {
+	r := &dryRunRecorder{}
+	g.loadBalancerDryRun = r
+	g.c = &dryRunCloud{Cloud: g.c, r: r}
+}
+
+// serviceClient returns the client the load balancer operations update the services with.
+func (g *Cloud) serviceClient() v1core.CoreV1Interface {
+	if g.loadBalancerDryRun != nil {
+		return &dryRunCoreV1{CoreV1Interface: g.client.CoreV1(), r: g.loadBalancerDryRun}
+	}
+	return g.client.CoreV1()
+}
+
+// run runs the load balancer operation and returns the changes it would make.
+func (r *dryRunRecorder) run(operation func() error) (*loadBalancerPlan, error) {
+	r.operation.Lock()
+	defer r.operation.Unlock()
+
+	plan := &loadBalancerPlan{}
+	r.mu.Lock()
+	r.plan, r.objects, r.created = plan, map[dryRunObjectKey]interface{}{}, map[dryRunObjectKey]bool{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.plan, r.objects, r.created = nil, nil, nil
+		r.mu.Unlock()
+	}()
+	return plan, operation()
+}
+
+// active returns whether an operation is in progress.
+func (r *dryRunRecorder) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.plan != nil
+}
+
+// record records the change into the plan of the operation in progress. It returns false if
+// there is none, in which case the change must be made.
+func (r *dryRunRecorder) record(action, resource, name, format string, args ...interface{}) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.plan == nil {
+		return false
+	}
+	r.plan.add(action, resource, name, format, args...)
+	return true
+}
+
+// write records the change of the resource like record, keeping obj as the resource the
+// operation reads back. Deleted resources read back as not found, obj is ignored. Resources
+// created and then deleted by the operation, like the addresses held while the forwarding
+// rules are recreated, are left out of the plan.
+func (r *dryRunRecorder) write(action, resource string, key *meta.Key, obj interface{}, format string, args ...interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.plan == nil {
+		return false
+	}
+	k := dryRunObjectKey{resource: resource, key: *key}
+	switch {
+	case action == planActionDelete && r.created[k]:
+		r.plan.remove(resource, key.Name)
+		delete(r.created, k)
+	case action == planActionCreate:
+		r.created[k] = true
+		fallthrough
+	default:
+		r.plan.add(action, resource, key.Name, format, args...)
+	}
+	switch {
+	case action == planActionDelete:
+		r.objects[k] = nil
+	case obj != nil:
+		r.objects[k] = obj
+	}
+	return true
+}
+
+// dryRunGet returns the resource as written by the operation in progress, or reads it with get
+// if the operation did not write it.
+func dryRunGet[T any](r *dryRunRecorder, resource string, key *meta.Key, get func() (*T, error)) (*T, error) {
+	r.mu.Lock()
+	obj, ok := r.objects[dryRunObjectKey{resource: resource, key: *key}]
+	r.mu.Unlock()
+	if !ok {
+		return get()
+	}
+	if obj == nil {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s %s is deleted by the dry run", resource, key.Name)}
+	}
+	return obj.(*T), nil
+}
+
+// instanceNames returns the names of the linked instances, for the details of the changes.
+func instanceNames(links []string) string {
+	names := make([]string, 0, len(links))
+	for _, link := range links {
+		names = append(names, lastComponent(link))
+	}
+	return strings.Join(names, ", ")
+}
+
+// dryRunCloud records the writes to the GCE resources of the load balancers.
+type dryRunCloud struct {
+	cloud.Cloud
+	r *dryRunRecorder
+}
+
+func (c *dryRunCloud) Addresses() cloud.Addresses {
+	return &dryRunAddresses{Addresses: c.Cloud.Addresses(), r: c.r}
+}
+
+func (c *dryRunCloud) ForwardingRules() cloud.ForwardingRules {
+	return &dryRunForwardingRules{ForwardingRules: c.Cloud.ForwardingRules(), r: c.r}
+}
+
+func (c *dryRunCloud) TargetPools() cloud.TargetPools {
+	return &dryRunTargetPools{TargetPools: c.Cloud.TargetPools(), r: c.r}
+}
+
+func (c *dryRunCloud) RegionBackendServices() cloud.RegionBackendServices {
+	return &dryRunRegionBackendServices{RegionBackendServices: c.Cloud.RegionBackendServices(), r: c.r}
+}
+
+func (c *dryRunCloud) HealthChecks() cloud.HealthChecks {
+	return &dryRunHealthChecks{HealthChecks: c.Cloud.HealthChecks(), r: c.r}
+}
+
+func (c *dryRunCloud) RegionHealthChecks() cloud.RegionHealthChecks {
+	return &dryRunRegionHealthChecks{RegionHealthChecks: c.Cloud.RegionHealthChecks(), r: c.r}
+}
+
+func (c *dryRunCloud) HttpHealthChecks() cloud.HttpHealthChecks {
+	return &dryRunHTTPHealthChecks{HttpHealthChecks: c.Cloud.HttpHealthChecks(), r: c.r}
+}
+
+func (c *dryRunCloud) Firewalls() cloud.Firewalls {
+	return &dryRunFirewalls{Firewalls: c.Cloud.Firewalls(), r: c.r}
+}
+
+func (c *dryRunCloud) InstanceGroups() cloud.InstanceGroups {
+	return &dryRunInstanceGroups{InstanceGroups: c.Cloud.InstanceGroups(), r: c.r}
+}
+
+func (c *dryRunCloud) BetaNetworkEndpointGroups() cloud.BetaNetworkEndpointGroups {
+	return &dryRunNetworkEndpointGroups{BetaNetworkEndpointGroups: c.Cloud.BetaNetworkEndpointGroups(), r: c.r}
+}
+
+type dryRunAddresses struct {
+	cloud.Addresses
+	r *dryRunRecorder
+}
+
+func (a *dryRunAddresses) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.Address, error) {
+	return dryRunGet(a.r, planResourceAddress, key, func() (*compute.Address, error) { return a.Addresses.Get(ctx, key, options...) })
+}
+
+func (a *dryRunAddresses) Insert(ctx context.Context, key *meta.Key, obj *compute.Address, options ...cloud.Option) error {
+	if a.r.write(planActionCreate, planResourceAddress, key, obj, "%s address %s", obj.AddressType, obj.Address) {
+		return nil
+	}
+	return a.Addresses.Insert(ctx, key, obj, options...)
+}
+
+func (a *dryRunAddresses) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if a.r.write(planActionDelete, planResourceAddress, key, nil, "") {
+		return nil
+	}
+	return a.Addresses.Delete(ctx, key, options...)
+}
+
+type dryRunForwardingRules struct {
+	cloud.ForwardingRules
+	r *dryRunRecorder
+}
+
+func (f *dryRunForwardingRules) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.ForwardingRule, error) {
+	return dryRunGet(f.r, planResourceForwardingRule, key, func() (*compute.ForwardingRule, error) { return f.ForwardingRules.Get(ctx, key, options...) })
+}
+
+func (f *dryRunForwardingRules) Insert(ctx context.Context, key *meta.Key, obj *compute.ForwardingRule, options ...cloud.Option) error {
+	ports := obj.PortRange
+	if len(obj.Ports) > 0 {
+		ports = strings.Join(obj.Ports, ",")
+	}
+	if f.r.write(planActionCreate, planResourceForwardingRule, key, obj, "%s ports %s", obj.IPProtocol, ports) {
+		return nil
+	}
+	return f.ForwardingRules.Insert(ctx, key, obj, options...)
+}
+
+func (f *dryRunForwardingRules) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if f.r.write(planActionDelete, planResourceForwardingRule, key, nil, "") {
+		return nil
+	}
+	return f.ForwardingRules.Delete(ctx, key, options...)
+}
+
+type dryRunTargetPools struct {
+	cloud.TargetPools
+	r *dryRunRecorder
+}
+
+func (p *dryRunTargetPools) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.TargetPool, error) {
+	return dryRunGet(p.r, planResourceTargetPool, key, func() (*compute.TargetPool, error) { return p.TargetPools.Get(ctx, key, options...) })
+}
+
+func (p *dryRunTargetPools) Insert(ctx context.Context, key *meta.Key, obj *compute.TargetPool, options ...cloud.Option) error {
+	if p.r.write(planActionCreate, planResourceTargetPool, key, obj, "%d instances", len(obj.Instances)) {
+		return nil
+	}
+	return p.TargetPools.Insert(ctx, key, obj, options...)
+}
+
+func (p *dryRunTargetPools) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if p.r.write(planActionDelete, planResourceTargetPool, key, nil, "") {
+		return nil
+	}
+	return p.TargetPools.Delete(ctx, key, options...)
+}
+
+// The instances added to and removed from the target pools are read back, the load balancer
+// operations check them.
+
+func (p *dryRunTargetPools) AddInstance(ctx context.Context, key *meta.Key, req *compute.TargetPoolsAddInstanceRequest, options ...cloud.Option) error {
+	if !p.r.active() {
+		return p.TargetPools.AddInstance(ctx, key, req, options...)
+	}
+	pool, err := p.Get(ctx, key, options...)
+	if err != nil {
+		return err
+	}
+	updated := *pool
+	updated.Instances = append([]string{}, pool.Instances...)
+	for _, ref := range req.Instances {
+		updated.Instances = append(updated.Instances, ref.Instance)
+	}
+	p.r.write(planActionUpdate, planResourceTargetPool, key, &updated, "add instances %s", instanceNames(updated.Instances[len(pool.Instances):]))
+	return nil
+}
+
+func (p *dryRunTargetPools) RemoveInstance(ctx context.Context, key *meta.Key, req *compute.TargetPoolsRemoveInstanceRequest, options ...cloud.Option) error {
+	if !p.r.active() {
+		return p.TargetPools.RemoveInstance(ctx, key, req, options...)
+	}
+	pool, err := p.Get(ctx, key, options...)
+	if err != nil {
+		return err
+	}
+	removed := sets.NewString()
+	for _, ref := range req.Instances {
+		removed.Insert(ref.Instance)
+	}
+	updated := *pool
+	updated.Instances = nil
+	for _, link := range pool.Instances {
+		if !removed.Has(link) {
+			updated.Instances = append(updated.Instances, link)
+		}
+	}
+	p.r.write(planActionUpdate, planResourceTargetPool, key, &updated, "remove instances %s", instanceNames(removed.List()))
+	return nil
+}
+
+type dryRunRegionBackendServices struct {
+	cloud.RegionBackendServices
+	r *dryRunRecorder
+}
+
+func (b *dryRunRegionBackendServices) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.BackendService, error) {
+	return dryRunGet(b.r, planResourceBackendService, key, func() (*compute.BackendService, error) {
+		return b.RegionBackendServices.Get(ctx, key, options...)
+	})
+}
+
+func (b *dryRunRegionBackendServices) Insert(ctx context.Context, key *meta.Key, obj *compute.BackendService, options ...cloud.Option) error {
+	if b.r.write(planActionCreate, planResourceBackendService, key, obj, "%s %s, %d backends", obj.LoadBalancingScheme, obj.Protocol, len(obj.Backends)) {
+		return nil
+	}
+	return b.RegionBackendServices.Insert(ctx, key, obj, options...)
+}
+
+func (b *dryRunRegionBackendServices) Update(ctx context.Context, key *meta.Key, obj *compute.BackendService, options ...cloud.Option) error {
+	if b.r.write(planActionUpdate, planResourceBackendService, key, obj, "%s %s, %d backends", obj.LoadBalancingScheme, obj.Protocol, len(obj.Backends)) {
+		return nil
+	}
+	return b.RegionBackendServices.Update(ctx, key, obj, options...)
+}
+
+func (b *dryRunRegionBackendServices) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if b.r.write(planActionDelete, planResourceBackendService, key, nil, "") {
+		return nil
+	}
+	return b.RegionBackendServices.Delete(ctx, key, options...)
+}
+
+type dryRunHealthChecks struct {
+	cloud.HealthChecks
+	r *dryRunRecorder
+}
+
+func (h *dryRunHealthChecks) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.HealthCheck, error) {
+	return dryRunGet(h.r, planResourceHealthCheck, key, func() (*compute.HealthCheck, error) { return h.HealthChecks.Get(ctx, key, options...) })
+}
+
+func (h *dryRunHealthChecks) Insert(ctx context.Context, key *meta.Key, obj *compute.HealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionCreate, planResourceHealthCheck, key, obj, "%s", obj.Type) {
+		return nil
+	}
+	return h.HealthChecks.Insert(ctx, key, obj, options...)
+}
+
+func (h *dryRunHealthChecks) Update(ctx context.Context, key *meta.Key, obj *compute.HealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionUpdate, planResourceHealthCheck, key, obj, "%s", obj.Type) {
+		return nil
+	}
+	return h.HealthChecks.Update(ctx, key, obj, options...)
+}
+
+func (h *dryRunHealthChecks) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if h.r.write(planActionDelete, planResourceHealthCheck, key, nil, "") {
+		return nil
+	}
+	return h.HealthChecks.Delete(ctx, key, options...)
+}
+
+type dryRunRegionHealthChecks struct {
+	cloud.RegionHealthChecks
+	r *dryRunRecorder
+}
+
+func (h *dryRunRegionHealthChecks) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.HealthCheck, error) {
+	return dryRunGet(h.r, planResourceRegionHealthCheck, key, func() (*compute.HealthCheck, error) {
+		return h.RegionHealthChecks.Get(ctx, key, options...)
+	})
+}
+
+func (h *dryRunRegionHealthChecks) Insert(ctx context.Context, key *meta.Key, obj *compute.HealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionCreate, planResourceRegionHealthCheck, key, obj, "%s", obj.Type) {
+		return nil
+	}
+	return h.RegionHealthChecks.Insert(ctx, key, obj, options...)
+}
+
+func (h *dryRunRegionHealthChecks) Update(ctx context.Context, key *meta.Key, obj *compute.HealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionUpdate, planResourceRegionHealthCheck, key, obj, "%s", obj.Type) {
+		return nil
+	}
+	return h.RegionHealthChecks.Update(ctx, key, obj, options...)
+}
+
+func (h *dryRunRegionHealthChecks) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if h.r.write(planActionDelete, planResourceRegionHealthCheck, key, nil, "") {
+		return nil
+	}
+	return h.RegionHealthChecks.Delete(ctx, key, options...)
+}
+
+type dryRunHTTPHealthChecks struct {
+	cloud.HttpHealthChecks
+	r *dryRunRecorder
+}
+
+func (h *dryRunHTTPHealthChecks) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.HttpHealthCheck, error) {
+	return dryRunGet(h.r, planResourceHTTPHealthCheck, key, func() (*compute.HttpHealthCheck, error) {
+		return h.HttpHealthChecks.Get(ctx, key, options...)
+	})
+}
+
+func (h *dryRunHTTPHealthChecks) Insert(ctx context.Context, key *meta.Key, obj *compute.HttpHealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionCreate, planResourceHTTPHealthCheck, key, obj, "port %d path %s", obj.Port, obj.RequestPath) {
+		return nil
+	}
+	return h.HttpHealthChecks.Insert(ctx, key, obj, options...)
+}
+
+func (h *dryRunHTTPHealthChecks) Update(ctx context.Context, key *meta.Key, obj *compute.HttpHealthCheck, options ...cloud.Option) error {
+	if h.r.write(planActionUpdate, planResourceHTTPHealthCheck, key, obj, "port %d path %s", obj.Port, obj.RequestPath) {
+		return nil
+	}
+	return h.HttpHealthChecks.Update(ctx, key, obj, options...)
+}
+
+func (h *dryRunHTTPHealthChecks) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if h.r.write(planActionDelete, planResourceHTTPHealthCheck, key, nil, "") {
+		return nil
+	}
+	return h.HttpHealthChecks.Delete(ctx, key, options...)
+}
+
+type dryRunFirewalls struct {
+	cloud.Firewalls
+	r *dryRunRecorder
+}
+
+func (f *dryRunFirewalls) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.Firewall, error) {
+	return dryRunGet(f.r, planResourceFirewall, key, func() (*compute.Firewall, error) { return f.Firewalls.Get(ctx, key, options...) })
+}
+
+func (f *dryRunFirewalls) Insert(ctx context.Context, key *meta.Key, obj *compute.Firewall, options ...cloud.Option) error {
+	if f.r.write(planActionCreate, planResourceFirewall, key, obj, "%d source ranges", len(obj.SourceRanges)) {
+		return nil
+	}
+	return f.Firewalls.Insert(ctx, key, obj, options...)
+}
+
+func (f *dryRunFirewalls) Update(ctx context.Context, key *meta.Key, obj *compute.Firewall, options ...cloud.Option) error {
+	if f.r.write(planActionUpdate, planResourceFirewall, key, obj, "%d source ranges", len(obj.SourceRanges)) {
+		return nil
+	}
+	return f.Firewalls.Update(ctx, key, obj, options...)
+}
+
+func (f *dryRunFirewalls) Patch(ctx context.Context, key *meta.Key, obj *compute.Firewall, options ...cloud.Option) error {
+	if f.r.write(planActionUpdate, planResourceFirewall, key, obj, "%d source ranges", len(obj.SourceRanges)) {
+		return nil
+	}
+	return f.Firewalls.Patch(ctx, key, obj, options...)
+}
+
+func (f *dryRunFirewalls) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if f.r.write(planActionDelete, planResourceFirewall, key, nil, "") {
+		return nil
+	}
+	return f.Firewalls.Delete(ctx, key, options...)
+}
+
+type dryRunInstanceGroups struct {
+	cloud.InstanceGroups
+	r *dryRunRecorder
+}
+
+func (i *dryRunInstanceGroups) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*compute.InstanceGroup, error) {
+	return dryRunGet(i.r, planResourceInstanceGroup, key, func() (*compute.InstanceGroup, error) { return i.InstanceGroups.Get(ctx, key, options...) })
+}
+
+func (i *dryRunInstanceGroups) Insert(ctx context.Context, key *meta.Key, obj *compute.InstanceGroup, options ...cloud.Option) error {
+	if i.r.write(planActionCreate, planResourceInstanceGroup, key, obj, "in zone %s", key.Zone) {
+		return nil
+	}
+	return i.InstanceGroups.Insert(ctx, key, obj, options...)
+}
+
+func (i *dryRunInstanceGroups) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if i.r.write(planActionDelete, planResourceInstanceGroup, key, nil, "in zone %s", key.Zone) {
+		return nil
+	}
+	return i.InstanceGroups.Delete(ctx, key, options...)
+}
+
+func (i *dryRunInstanceGroups) AddInstances(ctx context.Context, key *meta.Key, req *compute.InstanceGroupsAddInstancesRequest, options ...cloud.Option) error {
+	links := make([]string, 0, len(req.Instances))
+	for _, ref := range req.Instances {
+		links = append(links, ref.Instance)
+	}
+	if i.r.write(planActionUpdate, planResourceInstanceGroup, key, nil, "add instances %s", instanceNames(links)) {
+		return nil
+	}
+	return i.InstanceGroups.AddInstances(ctx, key, req, options...)
+}
+
+func (i *dryRunInstanceGroups) RemoveInstances(ctx context.Context, key *meta.Key, req *compute.InstanceGroupsRemoveInstancesRequest, options ...cloud.Option) error {
+	links := make([]string, 0, len(req.Instances))
+	for _, ref := range req.Instances {
+		links = append(links, ref.Instance)
+	}
+	if i.r.write(planActionUpdate, planResourceInstanceGroup, key, nil, "remove instances %s", instanceNames(links)) {
+		return nil
+	}
+	return i.InstanceGroups.RemoveInstances(ctx, key, req, options...)
+}
+
+func (i *dryRunInstanceGroups) SetNamedPorts(ctx context.Context, key *meta.Key, req *compute.InstanceGroupsSetNamedPortsRequest, options ...cloud.Option) error {
+	if i.r.write(planActionUpdate, planResourceInstanceGroup, key, nil, "set %d named ports", len(req.NamedPorts)) {
+		return nil
+	}
+	return i.InstanceGroups.SetNamedPorts(ctx, key, req, options...)
+}
+
+type dryRunNetworkEndpointGroups struct {
+	cloud.BetaNetworkEndpointGroups
+	r *dryRunRecorder
+}
+
+func (n *dryRunNetworkEndpointGroups) Get(ctx context.Context, key *meta.Key, options ...cloud.Option) (*computebeta.NetworkEndpointGroup, error) {
+	return dryRunGet(n.r, planResourceNetworkEndpointGroup, key, func() (*computebeta.NetworkEndpointGroup, error) {
+		return n.BetaNetworkEndpointGroups.Get(ctx, key, options...)
+	})
+}
+
+func (n *dryRunNetworkEndpointGroups) Insert(ctx context.Context, key *meta.Key, obj *computebeta.NetworkEndpointGroup, options ...cloud.Option) error {
+	if n.r.write(planActionCreate, planResourceNetworkEndpointGroup, key, obj, "%s in zone %s", obj.NetworkEndpointType, key.Zone) {
+		return nil
+	}
+	return n.BetaNetworkEndpointGroups.Insert(ctx, key, obj, options...)
+}
+
+func (n *dryRunNetworkEndpointGroups) Delete(ctx context.Context, key *meta.Key, options ...cloud.Option) error {
+	if n.r.write(planActionDelete, planResourceNetworkEndpointGroup, key, nil, "in zone %s", key.Zone) {
+		return nil
+	}
+	return n.BetaNetworkEndpointGroups.Delete(ctx, key, options...)
+}
+
+func (n *dryRunNetworkEndpointGroups) AttachNetworkEndpoints(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsAttachEndpointsRequest, options ...cloud.Option) error {
+	links := make([]string, 0, len(req.NetworkEndpoints))
+	for _, ep := range req.NetworkEndpoints {
+		links = append(links, ep.Instance)
+	}
+	if n.r.write(planActionUpdate, planResourceNetworkEndpointGroup, key, nil, "attach instances %s", instanceNames(links)) {
+		return nil
+	}
+	return n.BetaNetworkEndpointGroups.AttachNetworkEndpoints(ctx, key, req, options...)
+}
+
+func (n *dryRunNetworkEndpointGroups) DetachNetworkEndpoints(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsDetachEndpointsRequest, options ...cloud.Option) error {
+	links := make([]string, 0, len(req.NetworkEndpoints))
+	for _, ep := range req.NetworkEndpoints {
+		links = append(links, ep.Instance)
+	}
+	if n.r.write(planActionUpdate, planResourceNetworkEndpointGroup, key, nil, "detach instances %s", instanceNames(links)) {
+		return nil
+	}
+	return n.BetaNetworkEndpointGroups.DetachNetworkEndpoints(ctx, key, req, options...)
+}
+
+// dryRunCoreV1 records the writes to the services.
+type dryRunCoreV1 struct {
+	v1core.CoreV1Interface
+	r *dryRunRecorder
+}
+
+func (c *dryRunCoreV1) Services(namespace string) v1core.ServiceInterface {
+	return &dryRunServices{ServiceInterface: c.CoreV1Interface.Services(namespace), namespace: namespace, r: c.r}
+}
+
+type dryRunServices struct {
+	v1core.ServiceInterface
+	namespace string
+	r         *dryRunRecorder
+}
+
+func (s *dryRunServices) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*v1.Service, error) {
+	if s.r.record(planActionUpdate, planResourceService, name, "patch %s", data) {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: name}}, nil
+	}
+	return s.ServiceInterface.Patch(ctx, name, pt, data, opts, subresources...)
+}
+
+func (s *dryRunServices) ApplyStatus(ctx context.Context, service *corev1apply.ServiceApplyConfiguration, opts metav1.ApplyOptions) (*v1.Service, error) {
+	if s.r.record(planActionUpdate, planResourceService, *service.Name, "apply %d status conditions", len(service.Status.Conditions)) {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: *service.Name}}, nil
+	}
+	return s.ServiceInterface.ApplyStatus(ctx, service, opts)
+}
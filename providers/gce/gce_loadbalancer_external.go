@@ -56,7 +56,10 @@ const (
 func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string, apiService *v1.Service, existingFwdRule *compute.ForwardingRule, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	// Skip service handling if it uses Regional Backend Services and handled by other controllers
 	if usesL4RBS(apiService, existingFwdRule) {
-		return nil, cloudprovider.ImplementedElsewhere
+		if !g.managesL4RBS(apiService) {
+			return nil, cloudprovider.ImplementedElsewhere
+		}
+		return g.ensureExternalLoadBalancerRBS(clusterName, clusterID, apiService, nodes)
 	}
 
 	if len(nodes) == 0 {
@@ -70,7 +73,6 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	}
 
 	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, apiService)
-	requestedIP := apiService.Spec.LoadBalancerIP
 	ports := apiService.Spec.Ports
 	portStr := []string{}
 	for _, p := range apiService.Spec.Ports {
@@ -79,7 +81,7 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 
 	serviceName := types.NamespacedName{Namespace: apiService.Namespace, Name: apiService.Name}
 	lbRefStr := fmt.Sprintf("%v(%v)", loadBalancerName, serviceName)
-	klog.V(2).Infof("ensureExternalLoadBalancer(%s, %v, %v, %v, %v, %v)", lbRefStr, g.region, requestedIP, portStr, hostNames, apiService.Annotations)
+	klog.V(2).Infof("ensureExternalLoadBalancer(%s, %v, %v, %v, %v, %v)", lbRefStr, g.region, apiService.Spec.LoadBalancerIP, portStr, hostNames, apiService.Annotations)
 
 	// Check the current and the desired network tiers. If they do not match,
 	// tear down the existing resources with the wrong tier.
@@ -89,6 +91,10 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		return nil, err
 	}
 	klog.V(4).Infof("ensureExternalLoadBalancer(%s): Desired network tier %q.", lbRefStr, netTier)
+	requestedIP, err := g.requestedExternalLoadBalancerIP(apiService, loadBalancerName, netTier)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
+	}
 	// TODO: distinguish between unspecified and specified network tiers annotation properly in forwardingrule creation
 	// Only delete ForwardingRule when network tier annotation is specified, otherwise leave it only to avoid wrongful
 	// deletion against user intention when network tier annotation is not specified.
@@ -96,8 +102,15 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		g.deleteWrongNetworkTieredResources(loadBalancerName, lbRefStr, netTier)
 	}
 
+	// Services mixing protocols have one forwarding rule per protocol, all pointing to the target pool.
+	// The forwarding rule of the primary protocol is named after the load balancer.
+	protocolGroups, err := g.getProtocolGroups(ports, loadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if the forwarding rule exists, and if so, what its IP is.
-	fwdRuleExists, fwdRuleNeedsUpdate, fwdRuleIP, err := g.forwardingRuleNeedsUpdate(loadBalancerName, g.region, requestedIP, ports)
+	fwdRuleExists, fwdRuleNeedsUpdate, fwdRuleIP, err := g.forwardingRuleNeedsUpdate(loadBalancerName, g.region, requestedIP, protocolGroups[0].ports)
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +144,11 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	// and key the flag values off of errors returned.
 	isUserOwnedIP := false // if this is set, we never release the IP
 	isSafeToReleaseIP := false
+	// The forwarding rules of a service mixing protocols share the static IP, so it is never released.
+	isSharedIP := len(protocolGroups) > 1
+	staticIPExisted := false
 	defer func() {
-		if isUserOwnedIP {
+		if isUserOwnedIP || isSharedIP {
 			return
 		}
 		if isSafeToReleaseIP {
@@ -153,7 +169,7 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		// the GCE resources will be performed in the verification process.
 		isUserOwnedIP, err = verifyUserRequestedIP(g, g.region, requestedIP, fwdRuleIP, lbRefStr, netTier)
 		if err != nil {
-			return nil, err
+			return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
 		}
 		ipAddressToUse = requestedIP
 	}
@@ -163,7 +179,7 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		// emphemeral IP used by the fwd rule, or create a new static IP.
 		ipAddr, existed, err := ensureStaticIP(g, loadBalancerName, serviceName.String(), g.region, fwdRuleIP, netTier)
 		if err != nil {
-			return nil, fmt.Errorf("failed to ensure a static IP for load balancer (%s): %v", lbRefStr, err)
+			return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, fmt.Errorf("failed to ensure a static IP for load balancer (%s): %w", lbRefStr, err))
 		}
 		klog.Infof("ensureExternalLoadBalancer(%s): Ensured IP address %s (tier: %s).", lbRefStr, ipAddr, netTier)
 		// If the IP was not owned by the user, but it already existed, it
@@ -171,45 +187,20 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		// this IP and try to run through the process again, but we should
 		// not release the IP unless it is explicitly flagged as OK.
 		isSafeToReleaseIP = !existed
+		staticIPExisted = existed
 		ipAddressToUse = ipAddr
 	}
 
 	// Deal with the firewall next. The reason we do this here rather than last
 	// is because the forwarding rule is used as the indicator that the load
 	// balancer is fully created - it's what getLoadBalancer checks for.
-	// Check if user specified the allow source range
-	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(apiService)
-	if err != nil {
-		return nil, err
-	}
-
-	firewallExists, firewallNeedsUpdate, err := g.firewallNeedsUpdate(loadBalancerName, serviceName.String(), ipAddressToUse, ports, sourceRanges)
-	if err != nil {
-		return nil, err
-	}
-
-	if firewallNeedsUpdate {
-		desc := makeFirewallDescription(serviceName.String(), ipAddressToUse)
-		// Unlike forwarding rules and target pools, firewalls can be updated
-		// without needing to be deleted and recreated.
-		if firewallExists {
-			klog.Infof("ensureExternalLoadBalancer(%s): Updating firewall.", lbRefStr)
-			if err := g.updateFirewall(apiService, MakeFirewallName(loadBalancerName), desc, ipAddressToUse, sourceRanges, ports, hosts); err != nil {
-				return nil, err
-			}
-			klog.Infof("ensureExternalLoadBalancer(%s): Updated firewall.", lbRefStr)
-		} else {
-			klog.Infof("ensureExternalLoadBalancer(%s): Creating firewall.", lbRefStr)
-			if err := g.createFirewall(apiService, MakeFirewallName(loadBalancerName), desc, ipAddressToUse, sourceRanges, ports, hosts); err != nil {
-				return nil, err
-			}
-			klog.Infof("ensureExternalLoadBalancer(%s): Created firewall.", lbRefStr)
-		}
+	if err := g.ensureExternalFirewall(apiService, clusterID, loadBalancerName, lbRefStr, ipAddressToUse, hosts); err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
 	}
 
 	tpExists, tpNeedsRecreation, err := g.targetPoolNeedsRecreation(loadBalancerName, g.region, apiService.Spec.SessionAffinity)
 	if err != nil {
-		return nil, err
+		return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
 	}
 	if !tpExists {
 		klog.Infof("ensureExternalLoadBalancer(%s): Target pool for service doesn't exist.", lbRefStr)
@@ -218,21 +209,30 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	// Check which health check needs to create and which health check needs to delete.
 	// Health check management is coupled with target pool operation to prevent leaking.
 	var hcToCreate, hcToDelete *compute.HttpHealthCheck
+	hcParams, err := serviceHealthCheckParams(apiService, false)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
+	}
 	hcLocalTrafficExisting, err := g.GetHTTPHealthCheck(loadBalancerName)
 	if err != nil && !isHTTPErrorCode(err, http.StatusNotFound) {
-		return nil, fmt.Errorf("error checking HTTP health check for load balancer (%s): %v", lbRefStr, err)
+		return nil, newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, fmt.Errorf("error checking HTTP health check for load balancer (%s): %w", lbRefStr, err))
+	}
+	path, healthCheckNodePort := servicehelpers.GetServiceHealthCheckPathPort(apiService)
+	if path == "" && !usesNodesHealthCheck(apiService) {
+		// Load balancers of selected nodes check the nodes with their own health check.
+		path, healthCheckNodePort = GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
 	}
-	if path, healthCheckNodePort := servicehelpers.GetServiceHealthCheckPathPort(apiService); path != "" {
+	if path != "" {
 		klog.V(4).Infof("ensureExternalLoadBalancer(%s): Service needs local traffic health checks on: %d%s.", lbRefStr, healthCheckNodePort, path)
 		if hcLocalTrafficExisting == nil {
 			// This logic exists to detect a transition for non-OnlyLocal to OnlyLocal service
 			// turn on the tpNeedsRecreation flag to delete/recreate fwdrule/tpool updating the
 			// target pool to use local traffic health check.
 			klog.V(2).Infof("ensureExternalLoadBalancer(%s): Updating from nodes health checks to local traffic health checks.", lbRefStr)
-			hcToDelete = makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
+			hcToDelete = makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), nil)
 			tpNeedsRecreation = true
 		}
-		hcToCreate = makeHTTPHealthCheck(loadBalancerName, path, healthCheckNodePort)
+		hcToCreate = makeHTTPHealthCheck(loadBalancerName, path, healthCheckNodePort, hcParams)
 	} else {
 		klog.V(4).Infof("ensureExternalLoadBalancer(%s): Service needs nodes health checks.", lbRefStr)
 		if hcLocalTrafficExisting != nil {
@@ -243,8 +243,19 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 			hcToDelete = hcLocalTrafficExisting
 			tpNeedsRecreation = true
 		}
-		hcToCreate = makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
+		hcToCreate = makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), nil)
 	}
+	// The forwarding rules of the other protocols use the same IP as the primary one.
+	var protocolFwdRules []externalProtocolForwardingRule
+	for _, group := range protocolGroups[1:] {
+		name := makeProtocolResourceName(loadBalancerName, group.protocol, group.primary)
+		exists, needsUpdate, _, err := g.forwardingRuleNeedsUpdate(name, g.region, ipAddressToUse, group.ports)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+		}
+		protocolFwdRules = append(protocolFwdRules, externalProtocolForwardingRule{name: name, ports: group.ports, exists: exists, needsUpdate: needsUpdate})
+	}
+
 	// Now we get to some slightly more interesting logic.
 	// First, neither target pools nor forwarding rules can be updated in place -
 	// they have to be deleted and recreated.
@@ -258,19 +269,36 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		// IP.  That way we can come back to it later.
 		isSafeToReleaseIP = false
 		if err := g.DeleteRegionForwardingRule(loadBalancerName, g.region); err != nil && !isNotFound(err) {
-			return nil, fmt.Errorf("failed to delete existing forwarding rule for load balancer (%s) update: %v", lbRefStr, err)
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to delete existing forwarding rule for load balancer (%s) update: %w", lbRefStr, err))
 		}
 		klog.Infof("ensureExternalLoadBalancer(%s): Deleted forwarding rule.", lbRefStr)
 	}
+	for _, rule := range protocolFwdRules {
+		if rule.exists && (rule.needsUpdate || tpNeedsRecreation) {
+			isSafeToReleaseIP = false
+			if err := g.DeleteRegionForwardingRule(rule.name, g.region); err != nil && !isNotFound(err) {
+				return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to delete existing forwarding rule %s for load balancer (%s) update: %w", rule.name, lbRefStr, err))
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Deleted forwarding rule %s.", lbRefStr, rule.name)
+		}
+	}
+	// The static IP is kept while the service mixes protocols. If it existed, the service may have stopped
+	// doing so: delete the forwarding rules of the protocols it does not use anymore.
+	unusedFwdRulesDeleted := false
+	if isUserOwnedIP || staticIPExisted {
+		if unusedFwdRulesDeleted, err = g.deleteUnusedProtocolForwardingRules(loadBalancerName, lbRefStr, protocolGroups); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+		}
+	}
 
 	if err := g.ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation, apiService, loadBalancerName, clusterID, ipAddressToUse, hosts, hcToCreate, hcToDelete); err != nil {
-		return nil, err
+		return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
 	}
 
 	if tpNeedsRecreation || fwdRuleNeedsUpdate {
 		klog.Infof("ensureExternalLoadBalancer(%s): Creating forwarding rule, IP %s (tier: %s).", lbRefStr, ipAddressToUse, netTier)
 		if err := createForwardingRule(g, loadBalancerName, serviceName.String(), g.region, ipAddressToUse, g.targetPoolURL(loadBalancerName), ports, netTier); err != nil {
-			return nil, fmt.Errorf("failed to create forwarding rule for load balancer (%s): %v", lbRefStr, err)
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to create forwarding rule for load balancer (%s): %w", lbRefStr, err))
 		}
 		// End critical section.  It is safe to release the static IP (which
 		// just demotes it to ephemeral) now that it is attached.  In the case
@@ -279,6 +307,19 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		isSafeToReleaseIP = true
 		klog.Infof("ensureExternalLoadBalancer(%s): Created forwarding rule, IP %s.", lbRefStr, ipAddressToUse)
 	}
+	for _, rule := range protocolFwdRules {
+		if tpNeedsRecreation || rule.needsUpdate {
+			klog.Infof("ensureExternalLoadBalancer(%s): Creating forwarding rule %s, IP %s (tier: %s).", lbRefStr, rule.name, ipAddressToUse, netTier)
+			if err := createForwardingRule(g, rule.name, serviceName.String(), g.region, ipAddressToUse, g.targetPoolURL(loadBalancerName), rule.ports, netTier); err != nil {
+				return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to create forwarding rule %s for load balancer (%s): %w", rule.name, lbRefStr, err))
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Created forwarding rule %s, IP %s.", lbRefStr, rule.name, ipAddressToUse)
+		}
+	}
+	if unusedFwdRulesDeleted {
+		// The service stopped mixing protocols, the IP is only used by the primary forwarding rule now.
+		isSafeToReleaseIP = true
+	}
 
 	status := &v1.LoadBalancerStatus{}
 	status.Ingress = []v1.LoadBalancerIngress{{IP: ipAddressToUse}}
@@ -286,11 +327,103 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	return status, nil
 }
 
+// externalProtocolForwardingRule is the state of the forwarding rule of a non-primary protocol.
+type externalProtocolForwardingRule struct {
+	name        string
+	ports       []v1.ServicePort
+	exists      bool
+	needsUpdate bool
+}
+
+// deleteUnusedProtocolForwardingRules deletes the forwarding rules of the protocols the service
+// does not use anymore. It returns whether any forwarding rule was deleted.
+func (g *Cloud) deleteUnusedProtocolForwardingRules(loadBalancerName, lbRefStr string, groups []protocolPorts) (bool, error) {
+	deleted := false
+	for _, name := range unusedProtocolResourceNames(loadBalancerName, groups) {
+		err := g.DeleteRegionForwardingRule(name, g.region)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete unused forwarding rule %s for load balancer (%s): %v", name, lbRefStr, err)
+		}
+		klog.Infof("deleteUnusedProtocolForwardingRules(%s): Deleted unused forwarding rule %s.", lbRefStr, name)
+		deleted = true
+	}
+	return deleted, nil
+}
+
+// ensureExternalFirewall ensures the firewall allowing traffic from the source
+// ranges of the service to the external load balancer IP.
+func (g *Cloud) ensureExternalFirewall(svc *v1.Service, clusterID, loadBalancerName, lbRefStr, ipAddress string, hosts []*gceInstance) error {
+	serviceName := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	ports := svc.Spec.Ports
+	// Check if user specified the allow source range
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	if err != nil {
+		return err
+	}
+
+	fwConfig, err := g.loadBalancerFirewallConfig(svc)
+	if err != nil {
+		return err
+	}
+
+	desc := makeFirewallDescription(loadBalancerName, serviceName.String(), ipAddress, clusterID)
+	firewallExists, firewallNeedsUpdate, err := g.firewallNeedsUpdate(loadBalancerName, desc, ipAddress, ports, sourceRanges, fwConfig)
+	if err != nil {
+		return err
+	}
+	if !firewallNeedsUpdate {
+		return nil
+	}
+
+	fwName := MakeFirewallName(loadBalancerName)
+	shards, err := g.firewallShards(fwName, sourceRanges.StringSlice())
+	if err != nil {
+		return err
+	}
+	for i, shardRanges := range shards {
+		shardName := firewallShardName(fwName, i)
+		shardIPNets, err := utilnet.ParseIPNets(shardRanges...)
+		if err != nil {
+			return err
+		}
+		shardExists := firewallExists
+		if i > 0 {
+			_, err := g.getLoadBalancerFirewall(shardName)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			shardExists = err == nil
+		}
+		// Unlike forwarding rules and target pools, firewalls can be updated
+		// without needing to be deleted and recreated.
+		if shardExists {
+			klog.Infof("ensureExternalLoadBalancer(%s): Updating firewall %s.", lbRefStr, shardName)
+			if err := g.updateFirewall(svc, shardName, desc, ipAddress, shardIPNets, ports, hosts, fwConfig); err != nil {
+				return err
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Updated firewall %s.", lbRefStr, shardName)
+		} else {
+			klog.Infof("ensureExternalLoadBalancer(%s): Creating firewall %s.", lbRefStr, shardName)
+			if err := g.createFirewall(svc, shardName, desc, ipAddress, shardIPNets, ports, hosts, fwConfig); err != nil {
+				return err
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Created firewall %s.", lbRefStr, shardName)
+		}
+	}
+	return g.deleteFirewallShards(svc, fwName, len(shards))
+}
+
 // updateExternalLoadBalancer is the external implementation of LoadBalancer.UpdateLoadBalancer.
 func (g *Cloud) updateExternalLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) error {
 	// Skip service update if it uses Regional Backend Services and handled by other controllers
 	if usesL4RBS(service, nil) {
-		return cloudprovider.ImplementedElsewhere
+		if !g.managesL4RBS(service) {
+			return cloudprovider.ImplementedElsewhere
+		}
+		return g.updateExternalLoadBalancerRBS(clusterName, service, nodes)
 	}
 
 	hosts, err := g.getInstancesByNames(nodeNames(nodes))
@@ -306,7 +439,10 @@ func (g *Cloud) updateExternalLoadBalancer(clusterName string, service *v1.Servi
 func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string, service *v1.Service) error {
 	// Skip service deletion if it uses Regional Backend Services and handled by other controllers
 	if usesL4RBS(service, nil) {
-		return cloudprovider.ImplementedElsewhere
+		if !g.managesL4RBS(service) {
+			return cloudprovider.ImplementedElsewhere
+		}
+		return g.ensureExternalLoadBalancerDeletedRBS(clusterName, clusterID, service)
 	}
 
 	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, service)
@@ -336,14 +472,7 @@ func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string,
 	errs := utilerrors.AggregateGoroutines(
 		func() error {
 			klog.Infof("ensureExternalLoadBalancerDeleted(%s): Deleting firewall rule.", lbRefStr)
-			fwName := MakeFirewallName(loadBalancerName)
-			err := ignoreNotFound(g.DeleteFirewall(fwName))
-			if isForbidden(err) && g.OnXPN() {
-				klog.V(4).Infof("ensureExternalLoadBalancerDeleted(%s): Do not have permission to delete firewall rule %v (on XPN). Raising event.", lbRefStr, fwName)
-				g.raiseFirewallChangeNeededEvent(service, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
-				return nil
-			}
-			return err
+			return g.deleteFirewallShards(service, MakeFirewallName(loadBalancerName), 0)
 		},
 		// Even though we don't hold on to static IPs for load balancers, it's
 		// possible that EnsureLoadBalancer left one around in a failed
@@ -359,6 +488,10 @@ func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string,
 			if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
 				return err
 			}
+			// Services mixing protocols also have forwarding rules for their other protocols.
+			if _, err := g.deleteUnusedProtocolForwardingRules(loadBalancerName, lbRefStr, nil); err != nil {
+				return err
+			}
 			klog.Infof("ensureExternalLoadBalancerDeleted(%s): Deleting target pool.", lbRefStr)
 			if err := g.DeleteExternalTargetPoolAndChecks(service, loadBalancerName, g.region, clusterID, hcNames...); err != nil {
 				return err
@@ -392,8 +525,8 @@ func (g *Cloud) DeleteExternalTargetPoolAndChecks(service *v1.Service, name, reg
 			if isNodesHealthCheck {
 				// Lock to prevent deleting necessary nodes health check before it gets attached
 				// to target pool.
-				g.sharedResourceLock.Lock()
-				defer g.sharedResourceLock.Unlock()
+				unlock := g.sharedResourceLocks.lock(httpHealthCheckLockKey(hcName))
+				defer unlock()
 			}
 			klog.Infof("DeleteExternalTargetPoolAndChecks(%v): Deleting health check %v.", lbRefStr, hcName)
 			if err := g.DeleteHTTPHealthCheck(hcName); err != nil {
@@ -418,7 +551,7 @@ func (g *Cloud) DeleteExternalTargetPoolAndChecks(service *v1.Service, name, reg
 			// So we should delete the health check firewall as well.
 			fwName := MakeHealthCheckFirewallName(clusterID, hcName, isNodesHealthCheck)
 			klog.Infof("DeleteExternalTargetPoolAndChecks(%v): Deleting health check firewall %v.", lbRefStr, fwName)
-			if err := ignoreNotFound(g.DeleteFirewall(fwName)); err != nil {
+			if err := ignoreNotFound(g.deleteLoadBalancerFirewall(fwName)); err != nil {
 				if isForbidden(err) && g.OnXPN() {
 					klog.V(4).Infof("DeleteExternalTargetPoolAndChecks(%v): Do not have permission to delete firewall rule %v (on XPN). Raising event.", lbRefStr, fwName)
 					g.raiseFirewallChangeNeededEvent(service, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
@@ -496,7 +629,7 @@ func (g *Cloud) ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation bool,
 			hcNames = append(hcNames, hcToDelete.Name)
 		}
 		if err := g.DeleteExternalTargetPoolAndChecks(svc, loadBalancerName, g.region, clusterID, hcNames...); err != nil {
-			return fmt.Errorf("failed to delete existing target pool for load balancer (%s) update: %v", lbRefStr, err)
+			return fmt.Errorf("failed to delete existing target pool for load balancer (%s) update: %w", lbRefStr, err)
 		}
 		klog.Infof("ensureTargetPoolAndHealthCheck(%s): Deleted target pool.", lbRefStr)
 	}
@@ -508,7 +641,7 @@ func (g *Cloud) ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation bool,
 			createInstances = createInstances[:maxTargetPoolCreateInstances]
 		}
 		if err := g.createTargetPoolAndHealthCheck(svc, loadBalancerName, serviceName.String(), ipAddressToUse, g.region, clusterID, createInstances, hcToCreate); err != nil {
-			return fmt.Errorf("failed to create target pool for load balancer (%s): %v", lbRefStr, err)
+			return fmt.Errorf("failed to create target pool for load balancer (%s): %w", lbRefStr, err)
 		}
 		if hcToCreate != nil {
 			klog.Infof("ensureTargetPoolAndHealthCheck(%s): Created health checks %v.", lbRefStr, hcToCreate.Name)
@@ -518,19 +651,23 @@ func (g *Cloud) ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation bool,
 		} else {
 			klog.Infof("ensureTargetPoolAndHealthCheck(%s): Created initial target pool (now updating the remaining %d hosts).", lbRefStr, len(hosts)-maxTargetPoolCreateInstances)
 			if err := g.updateTargetPool(loadBalancerName, hosts); err != nil {
-				return fmt.Errorf("failed to update target pool for load balancer (%s): %v", lbRefStr, err)
+				return fmt.Errorf("failed to update target pool for load balancer (%s): %w", lbRefStr, err)
 			}
 			klog.Infof("ensureTargetPoolAndHealthCheck(%s): Updated target pool (with %d hosts).", lbRefStr, len(hosts)-maxTargetPoolCreateInstances)
 		}
 	} else if tpExists {
 		// Ensure hosts are updated even if there is no other changes required on target pool.
 		if err := g.updateTargetPool(loadBalancerName, hosts); err != nil {
-			return fmt.Errorf("failed to update target pool for load balancer (%s): %v", lbRefStr, err)
+			return fmt.Errorf("failed to update target pool for load balancer (%s): %w", lbRefStr, err)
 		}
 		klog.Infof("ensureTargetPoolAndHealthCheck(%s): Updated target pool (with %d hosts).", lbRefStr, len(hosts))
 		if hcToCreate != nil {
-			if hc, err := g.ensureHTTPHealthCheck(hcToCreate.Name, hcToCreate.RequestPath, int32(hcToCreate.Port)); err != nil || hc == nil {
-				return fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", loadBalancerName, hcToCreate.Port, hcToCreate.RequestPath, err)
+			hcParams, err := serviceHealthCheckParams(svc, hcToCreate.Name != loadBalancerName)
+			if err != nil {
+				return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
+			}
+			if hc, err := g.ensureHTTPHealthCheck(hcToCreate.Name, hcToCreate.RequestPath, int32(hcToCreate.Port), hcParams); err != nil || hc == nil {
+				return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", loadBalancerName, hcToCreate.Port, hcToCreate.RequestPath, err))
 			}
 		}
 	} else {
@@ -550,17 +687,20 @@ func (g *Cloud) createTargetPoolAndHealthCheck(svc *v1.Service, name, serviceNam
 		isNodesHealthCheck := hc.Name != name
 		if isNodesHealthCheck {
 			// Lock to prevent necessary nodes health check / firewall gets deleted.
-			g.sharedResourceLock.Lock()
-			defer g.sharedResourceLock.Unlock()
+			unlock := g.sharedResourceLocks.lock(httpHealthCheckLockKey(hc.Name))
+			defer unlock()
 		}
 
 		if err := g.ensureHTTPHealthCheckFirewall(svc, serviceName, ipAddress, region, clusterID, hosts, hc.Name, int32(hc.Port), isNodesHealthCheck); err != nil {
-			return err
+			return newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+		}
+		hcParams, err := serviceHealthCheckParams(svc, isNodesHealthCheck)
+		if err != nil {
+			return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
 		}
-		var err error
 		hcRequestPath, hcPort := hc.RequestPath, hc.Port
-		if hc, err = g.ensureHTTPHealthCheck(hc.Name, hc.RequestPath, int32(hc.Port)); err != nil || hc == nil {
-			return fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", name, hcPort, hcRequestPath, err)
+		if hc, err = g.ensureHTTPHealthCheck(hc.Name, hc.RequestPath, int32(hc.Port), hcParams); err != nil || hc == nil {
+			return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", name, hcPort, hcRequestPath, err))
 		}
 		hcLinks = append(hcLinks, hc.SelfLink)
 	}
@@ -570,6 +710,7 @@ func (g *Cloud) createTargetPoolAndHealthCheck(svc *v1.Service, name, serviceNam
 		instances = append(instances, host.makeComparableHostPath())
 	}
 	klog.Infof("Creating targetpool %v with %d healthchecks", name, len(hcLinks))
+	// Target pools have no affinity timeout, the ClientIP timeout of the service does not apply.
 	pool := &compute.TargetPool{
 		Name:            name,
 		Description:     fmt.Sprintf(`{"kubernetes.io/service-name":"%s"}`, serviceName),
@@ -589,50 +730,21 @@ func (g *Cloud) updateTargetPool(loadBalancerName string, hosts []*gceInstance)
 	if err != nil {
 		return err
 	}
-	existing := sets.NewString()
-	for _, instance := range pool.Instances {
-		existing.Insert(hostURLToComparablePath(instance))
-	}
+	toAdd, toRemove := targetPoolHostChanges(pool, hosts)
 
-	var toAdd []*compute.InstanceReference
-	var toRemove []*compute.InstanceReference
-	for _, host := range hosts {
-		link := host.makeComparableHostPath()
-		if !existing.Has(link) {
-			toAdd = append(toAdd, &compute.InstanceReference{Instance: link})
-		}
-		existing.Delete(link)
-	}
-	for link := range existing {
-		toRemove = append(toRemove, &compute.InstanceReference{Instance: link})
-	}
-
-	for len(toAdd) > 0 {
-		// Do not remove more than maxInstancesPerTargetPoolUpdate in a single call.
-		instancesCount := len(toAdd)
-		if instancesCount > maxInstancesPerTargetPoolUpdate {
-			instancesCount = maxInstancesPerTargetPoolUpdate
-		}
-		// The operation to add 1000 instances is fairly long (may take minutes), so
-		// we don't need to worry about saturating QPS limits.
-		if err := g.AddInstancesToTargetPool(loadBalancerName, g.region, toAdd[:instancesCount]); err != nil {
+	// Do not add or remove more than maxInstancesPerTargetPoolUpdate in a single call. The operation
+	// to add or remove 1000 instances is fairly long (may take minutes), so we don't need to worry
+	// about saturating QPS limits. The batches all modify the same regional target pool, they are
+	// sent one after another.
+	for _, batch := range batches(toAdd, maxInstancesPerTargetPoolUpdate) {
+		if err := g.AddInstancesToTargetPool(loadBalancerName, g.region, batch); err != nil {
 			return err
 		}
-		toAdd = toAdd[instancesCount:]
 	}
-
-	for len(toRemove) > 0 {
-		// Do not remove more than maxInstancesPerTargetPoolUpdate in a single call.
-		instancesCount := len(toRemove)
-		if instancesCount > maxInstancesPerTargetPoolUpdate {
-			instancesCount = maxInstancesPerTargetPoolUpdate
-		}
-		// The operation to remove 1000 instances is fairly long (may take minutes), so
-		// we don't need to worry about saturating QPS limits.
-		if err := g.RemoveInstancesFromTargetPool(loadBalancerName, g.region, toRemove[:instancesCount]); err != nil {
+	for _, batch := range batches(toRemove, maxInstancesPerTargetPoolUpdate) {
+		if err := g.RemoveInstancesFromTargetPool(loadBalancerName, g.region, batch); err != nil {
 			return err
 		}
-		toRemove = toRemove[instancesCount:]
 	}
 
 	// Try to verify that the correct number of nodes are now in the target pool.
@@ -650,21 +762,45 @@ func (g *Cloud) updateTargetPool(loadBalancerName string, hosts []*gceInstance)
 	return nil
 }
 
+// targetPoolHostChanges returns the instances to add to and remove from the target pool so that
+// it contains the given hosts.
+func targetPoolHostChanges(pool *compute.TargetPool, hosts []*gceInstance) (toAdd, toRemove []*compute.InstanceReference) {
+	existing := sets.NewString()
+	for _, instance := range pool.Instances {
+		existing.Insert(hostURLToComparablePath(instance))
+	}
+
+	for _, host := range hosts {
+		link := host.makeComparableHostPath()
+		if !existing.Has(link) {
+			toAdd = append(toAdd, &compute.InstanceReference{Instance: link})
+		}
+		existing.Delete(link)
+	}
+	for link := range existing {
+		toRemove = append(toRemove, &compute.InstanceReference{Instance: link})
+	}
+	return toAdd, toRemove
+}
+
 func (g *Cloud) targetPoolURL(name string) string {
 	return g.projectsBasePath + strings.Join([]string{g.projectID, "regions", g.region, "targetPools", name}, "/")
 }
 
-func makeHTTPHealthCheck(name, path string, port int32) *compute.HttpHealthCheck {
+// makeHTTPHealthCheck returns the desired HTTP health check, tuned with params or with the
+// default tuning if params is nil.
+func makeHTTPHealthCheck(name, path string, port int32, params *HealthCheckParams) *compute.HttpHealthCheck {
+	p := healthCheckParamsOrDefault(params)
 	return &compute.HttpHealthCheck{
 		Name:               name,
 		Port:               int64(port),
 		RequestPath:        path,
 		Host:               "",
-		Description:        makeHealthCheckDescription(name),
-		CheckIntervalSec:   gceHcCheckIntervalSeconds,
-		TimeoutSec:         gceHcTimeoutSeconds,
-		HealthyThreshold:   gceHcHealthyThreshold,
-		UnhealthyThreshold: gceHcUnhealthyThreshold,
+		Description:        makeHealthCheckDescription(name, params != nil),
+		CheckIntervalSec:   p.CheckIntervalSec,
+		TimeoutSec:         p.TimeoutSec,
+		HealthyThreshold:   p.HealthyThreshold,
+		UnhealthyThreshold: p.UnhealthyThreshold,
 	}
 }
 
@@ -674,7 +810,12 @@ func makeHTTPHealthCheck(name, path string, port int32) *compute.HttpHealthCheck
 // The HC interval will be reconciled to 8 seconds.
 // If the existing health check is larger than the default interval,
 // the configuration will be kept.
-func mergeHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck) {
+// Health checks tuned by the service annotations keep the tuned values, and health
+// checks that were tuned are reset to the default values.
+func mergeHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck, tuned bool) {
+	if tuned || isTunedHealthCheckDescription(hc.Description) {
+		return
+	}
 	if hc.CheckIntervalSec > newHC.CheckIntervalSec {
 		newHC.CheckIntervalSec = hc.CheckIntervalSec
 	}
@@ -690,13 +831,16 @@ func mergeHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck) {
 }
 
 // needToUpdateHTTPHealthChecks checks whether the http healthcheck needs to be
-// updated.
-func needToUpdateHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck) bool {
+// updated. Health checks tuned by the service annotations are updated whenever
+// their tuning differs.
+func needToUpdateHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck, tuned bool) bool {
 	switch {
 	case
 		hc.Port != newHC.Port,
 		hc.RequestPath != newHC.RequestPath,
 		hc.Description != newHC.Description,
+		tuned && (hc.CheckIntervalSec != newHC.CheckIntervalSec || hc.TimeoutSec != newHC.TimeoutSec ||
+			hc.UnhealthyThreshold != newHC.UnhealthyThreshold || hc.HealthyThreshold != newHC.HealthyThreshold),
 		hc.CheckIntervalSec < newHC.CheckIntervalSec,
 		hc.TimeoutSec < newHC.TimeoutSec,
 		hc.UnhealthyThreshold < newHC.UnhealthyThreshold,
@@ -706,8 +850,8 @@ func needToUpdateHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck) bool {
 	return false
 }
 
-func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32) (hc *compute.HttpHealthCheck, err error) {
-	newHC := makeHTTPHealthCheck(name, path, port)
+func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32, params *HealthCheckParams) (hc *compute.HttpHealthCheck, err error) {
+	newHC := makeHTTPHealthCheck(name, path, port, params)
 	hc, err = g.GetHTTPHealthCheck(name)
 	if hc == nil || err != nil && isHTTPErrorCode(err, http.StatusNotFound) {
 		klog.Infof("Did not find health check %v, creating port %v path %v", name, port, path)
@@ -724,9 +868,9 @@ func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32) (hc *comput
 	}
 	// Validate health check fields
 	klog.V(4).Infof("Checking http health check params %s", name)
-	if needToUpdateHTTPHealthChecks(hc, newHC) {
+	if needToUpdateHTTPHealthChecks(hc, newHC, params != nil) {
 		klog.Warningf("Health check %v exists but parameters have drifted - updating...", name)
-		mergeHTTPHealthChecks(hc, newHC)
+		mergeHTTPHealthChecks(hc, newHC, params != nil)
 		if err := g.UpdateHTTPHealthCheck(newHC); err != nil {
 			klog.Warningf("Failed to reconcile http health check %v parameters", name)
 			return nil, err
@@ -772,7 +916,7 @@ func (g *Cloud) forwardingRuleNeedsUpdate(name, region string, loadBalancerIP st
 		klog.Infof("LoadBalancer port range for forwarding rule %v was expected to be %v, but was actually %v", fwd.Name, fwd.PortRange, portRange)
 		return true, true, fwd.IPAddress, nil
 	}
-	// The service controller verified all the protocols match on the ports, just check the first one
+	// The forwarding rule serves the ports of a single protocol, just check the first one
 	if string(ports[0].Protocol) != fwd.IPProtocol {
 		klog.Infof("LoadBalancer protocol for forwarding rule %v was expected to be %v, but was actually %v", fwd.Name, fwd.IPProtocol, string(ports[0].Protocol))
 		return true, true, fwd.IPAddress, nil
@@ -871,68 +1015,89 @@ func translateAffinityType(affinityType v1.ServiceAffinity) string {
 	}
 }
 
-func (g *Cloud) firewallNeedsUpdate(name, serviceName, ipAddress string, ports []v1.ServicePort, sourceRanges utilnet.IPNetSet) (exists bool, needsUpdate bool, err error) {
-	fw, err := g.GetFirewall(MakeFirewallName(name))
+// firewallNeedsUpdate returns whether the traffic firewall of the load balancer exists, and whether
+// any of its shards is missing, differs from the expected one or is not needed anymore.
+func (g *Cloud) firewallNeedsUpdate(name, desc, ipAddress string, ports []v1.ServicePort, sourceRanges utilnet.IPNetSet, fwConfig FirewallConfig) (exists bool, needsUpdate bool, err error) {
+	fwName := MakeFirewallName(name)
+	shards, err := g.firewallShards(fwName, sourceRanges.StringSlice())
 	if err != nil {
-		if isHTTPErrorCode(err, http.StatusNotFound) {
-			return false, true, nil
+		return false, false, fmt.Errorf("error getting load balancer's firewall: %v", err)
+	}
+	for i, shardRanges := range shards {
+		fw, err := g.getLoadBalancerFirewall(firewallShardName(fwName, i))
+		if err != nil {
+			if isHTTPErrorCode(err, http.StatusNotFound) {
+				return i > 0, true, nil
+			}
+			return false, false, fmt.Errorf("error getting load balancer's firewall: %v", err)
 		}
+		if firewallShardNeedsUpdate(fw, desc, ipAddress, ports, shardRanges, fwConfig) {
+			return true, true, nil
+		}
+	}
+	extraShards, err := g.existingFirewallShards(fwName, len(shards))
+	if err != nil {
 		return false, false, fmt.Errorf("error getting load balancer's firewall: %v", err)
 	}
-	if fw.Description != makeFirewallDescription(serviceName, ipAddress) {
-		return true, true, nil
+	return true, len(extraShards) > 0, nil
+}
+
+// firewallShardNeedsUpdate returns whether the shard of the traffic firewall differs from the expected one.
+func firewallShardNeedsUpdate(fw *compute.Firewall, desc, ipAddress string, ports []v1.ServicePort, shardRanges []string, fwConfig FirewallConfig) bool {
+	if fw.Description != desc {
+		return true
 	}
-	if len(fw.Allowed) != 1 || (fw.Allowed[0].IPProtocol != "tcp" && fw.Allowed[0].IPProtocol != "udp") {
-		return true, true, nil
+	expected := &compute.Firewall{}
+	fwConfig.apply(expected)
+	if !firewallConfigEqual(expected, fw) {
+		return true
 	}
-	// Make sure the allowed ports match.
-	portNums, portRanges, _ := getPortsAndProtocol(ports)
-	// This logic checks if the existing firewall rules contains either enumerated service ports or port ranges.
-	// This is to prevent unnecessary noop updates to the firewall rule when the existing firewall rule is
-	// set up via the previous pattern using enumerated ports instead of port ranges.
-	if !equalStringSets(portNums, fw.Allowed[0].Ports) && !equalStringSets(portRanges, fw.Allowed[0].Ports) {
-		return true, true, nil
+	// Make sure the allowed protocols and ports match.
+	if !firewallAllowsPorts(fw.Allowed, ports) {
+		return true
 	}
 
-	// The service controller already verified that the protocol matches on all ports, no need to check.
 	actualSourceRanges, err := utilnet.ParseIPNets(fw.SourceRanges...)
 	if err != nil {
 		// This really shouldn't happen... GCE has returned something unexpected
 		klog.Warningf("Error parsing firewall SourceRanges: %v", fw.SourceRanges)
 		// We don't return the error, because we can hopefully recover from this by reconfiguring the firewall
-		return true, true, nil
+		return true
 	}
-
-	if !sourceRanges.Equal(actualSourceRanges) {
-		return true, true, nil
+	expectedSourceRanges, err := utilnet.ParseIPNets(shardRanges...)
+	if err != nil || !expectedSourceRanges.Equal(actualSourceRanges) {
+		return true
 	}
 
 	destinationRanges := []string{ipAddress}
 
-	if !reflect.DeepEqual(destinationRanges, fw.DestinationRanges) {
-		return true, true, nil
-	}
-
-	return true, false, nil
+	return !reflect.DeepEqual(destinationRanges, fw.DestinationRanges)
 }
 
 func (g *Cloud) ensureHTTPHealthCheckFirewall(svc *v1.Service, serviceName, ipAddress, region, clusterID string, hosts []*gceInstance, hcName string, hcPort int32, isNodesHealthCheck bool) error {
 	// Prepare the firewall params for creating / checking.
 	desc := fmt.Sprintf(`{"kubernetes.io/cluster-id":"%s"}`, clusterID)
 	if !isNodesHealthCheck {
-		desc = makeFirewallDescription(serviceName, ipAddress)
+		desc = makeFirewallDescription(hcName, serviceName, ipAddress, clusterID)
 	}
 	sourceRanges := l4LbSrcRngsFlag.ipn
 	ports := []v1.ServicePort{{Protocol: "tcp", Port: hcPort}}
+	// Health check firewalls only follow the cloud config, as the nodes health check firewall is shared.
+	fwConfig, err := g.loadBalancerFirewallConfig(nil)
+	if err != nil {
+		return err
+	}
+	expected := &compute.Firewall{}
+	fwConfig.apply(expected)
 
 	fwName := MakeHealthCheckFirewallName(clusterID, hcName, isNodesHealthCheck)
-	fw, err := g.GetFirewall(fwName)
+	fw, err := g.getLoadBalancerFirewall(fwName)
 	if err != nil {
 		if !isHTTPErrorCode(err, http.StatusNotFound) {
 			return fmt.Errorf("error getting firewall for health checks: %v", err)
 		}
 		klog.Infof("Creating firewall %v for health checks.", fwName)
-		if err := g.createFirewall(svc, fwName, desc, ipAddress, sourceRanges, ports, hosts); err != nil {
+		if err := g.createFirewall(svc, fwName, desc, ipAddress, sourceRanges, ports, hosts, fwConfig); err != nil {
 			return err
 		}
 		klog.Infof("Created firewall %v for health checks.", fwName)
@@ -943,9 +1108,10 @@ func (g *Cloud) ensureHTTPHealthCheckFirewall(svc *v1.Service, serviceName, ipAd
 		len(fw.Allowed) != 1 ||
 		fw.Allowed[0].IPProtocol != string(ports[0].Protocol) ||
 		!equalStringSets(fw.Allowed[0].Ports, []string{strconv.Itoa(int(ports[0].Port))}) ||
-		!equalStringSets(fw.SourceRanges, sourceRanges.StringSlice()) {
+		!equalStringSets(fw.SourceRanges, sourceRanges.StringSlice()) ||
+		!firewallConfigEqual(expected, fw) {
 		klog.Warningf("Firewall %v exists but parameters have drifted - updating...", fwName)
-		if err := g.updateFirewall(svc, fwName, desc, ipAddress, sourceRanges, ports, hosts); err != nil {
+		if err := g.updateFirewall(svc, fwName, desc, ipAddress, sourceRanges, ports, hosts, fwConfig); err != nil {
 			klog.Warningf("Failed to reconcile firewall %v parameters.", fwName)
 			return err
 		}
@@ -981,12 +1147,13 @@ func createForwardingRule(s CloudForwardingRuleService, name, serviceName, regio
 	return nil
 }
 
-func (g *Cloud) createFirewall(svc *v1.Service, name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance) error {
-	firewall, err := g.firewallObject(name, desc, destinationIP, sourceRanges, ports, hosts)
+func (g *Cloud) createFirewall(svc *v1.Service, name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance, fwConfig FirewallConfig) error {
+	firewall, err := g.firewallObject(name, desc, destinationIP, sourceRanges, ports, hosts, fwConfig)
 	if err != nil {
+		g.raiseNodeTagsDiscoveryFailedEvent(svc, err)
 		return err
 	}
-	if err = g.CreateFirewall(firewall); err != nil {
+	if err = g.createLoadBalancerFirewall(firewall); err != nil {
 		if isHTTPErrorCode(err, http.StatusConflict) {
 			return nil
 		} else if isForbidden(err) && g.OnXPN() {
@@ -999,13 +1166,14 @@ func (g *Cloud) createFirewall(svc *v1.Service, name, desc, destinationIP string
 	return nil
 }
 
-func (g *Cloud) updateFirewall(svc *v1.Service, name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance) error {
-	firewall, err := g.firewallObject(name, desc, destinationIP, sourceRanges, ports, hosts)
+func (g *Cloud) updateFirewall(svc *v1.Service, name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance, fwConfig FirewallConfig) error {
+	firewall, err := g.firewallObject(name, desc, destinationIP, sourceRanges, ports, hosts, fwConfig)
 	if err != nil {
+		g.raiseNodeTagsDiscoveryFailedEvent(svc, err)
 		return err
 	}
 
-	if err = g.PatchFirewall(firewall); err != nil {
+	if err = g.patchLoadBalancerFirewall(firewall); err != nil {
 		if isHTTPErrorCode(err, http.StatusConflict) {
 			return nil
 		} else if isForbidden(err) && g.OnXPN() {
@@ -1018,20 +1186,21 @@ func (g *Cloud) updateFirewall(svc *v1.Service, name, desc, destinationIP string
 	return nil
 }
 
-func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance) (*compute.Firewall, error) {
+func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance, fwConfig FirewallConfig) (*compute.Firewall, error) {
 	// destinationIP can be empty string "" and this means that it is not set.
 	// GCE considers empty destinationRanges as "all" for ingress firewall-rules.
-	// Concatenate service ports into port ranges. This help to workaround the gce firewall limitation where only
-	// 100 ports or port ranges can be used in a firewall rule.
-	_, portRanges, _ := getPortsAndProtocol(ports)
-
 	// If the node tags to be used for this cluster have been predefined in the
-	// provider config, just use them. Otherwise, invoke computeHostTags method to get the tags.
-	hostTags := g.nodeTags
-	if len(hostTags) == 0 {
-		var err error
-		if hostTags, err = g.computeHostTags(hosts); err != nil {
-			return nil, fmt.Errorf("no node tags supplied and also failed to parse the given lists of hosts for tags. Abort creating firewall rule")
+	// provider config, just use them. Otherwise, invoke hostTags method to get the tags.
+	// Rules of network firewall policies can't target network tags, and firewalls
+	// targeting the service accounts of the nodes don't.
+	var hostTags []string
+	if g.firewallPolicy == nil && !fwConfig.targetsServiceAccounts() {
+		hostTags = g.nodeTags
+		if len(hostTags) == 0 {
+			var err error
+			if hostTags, err = g.hostTags(hosts); err != nil {
+				return nil, fmt.Errorf("no node tags supplied and also failed to parse the given lists of hosts for tags. Abort creating firewall rule: %w", err)
+			}
 		}
 	}
 
@@ -1041,24 +1210,55 @@ func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges ut
 		Network:      g.networkURL,
 		SourceRanges: sourceRanges.StringSlice(),
 		TargetTags:   hostTags,
-		Allowed: []*compute.FirewallAllowed{
-			{
-				// TODO: Make this more generic. Currently this method is only
-				// used to create firewall rules for loadbalancers, which have
-				// exactly one protocol, so we can never end up with a list of
-				// mixed TCP and UDP ports. It should be possible to use a
-				// single firewall rule for both a TCP and UDP lb.
-				IPProtocol: strings.ToLower(string(ports[0].Protocol)),
-				Ports:      portRanges,
-			},
-		},
+		Allowed:      firewallAllowedForPorts(ports),
 	}
 	if destinationIP != "" {
 		firewall.DestinationRanges = []string{destinationIP}
 	}
+	fwConfig.apply(firewall)
 	return firewall, nil
 }
 
+// firewallAllowedForPorts returns the allowed entries of a firewall opening the service ports, one
+// entry per protocol. Service ports are concatenated into port ranges. This help to workaround the gce
+// firewall limitation where only 100 ports or port ranges can be used in a firewall rule.
+func firewallAllowedForPorts(ports []v1.ServicePort) []*compute.FirewallAllowed {
+	var allowed []*compute.FirewallAllowed
+	for _, group := range groupPortsByProtocol(ports, "") {
+		_, portRanges, _ := getPortsAndProtocol(group.ports)
+		allowed = append(allowed, &compute.FirewallAllowed{
+			IPProtocol: strings.ToLower(string(group.protocol)),
+			Ports:      portRanges,
+		})
+	}
+	return allowed
+}
+
+// firewallAllowsPorts checks that the allowed entries of a firewall open exactly the service ports.
+// This logic checks if the existing firewall rules contains either enumerated service ports or port ranges.
+// This is to prevent unnecessary noop updates to the firewall rule when the existing firewall rule is
+// set up via the previous pattern using enumerated ports instead of port ranges.
+func firewallAllowsPorts(allowed []*compute.FirewallAllowed, ports []v1.ServicePort) bool {
+	groups := groupPortsByProtocol(ports, "")
+	if len(allowed) != len(groups) {
+		return false
+	}
+	for _, group := range groups {
+		portNums, portRanges, _ := getPortsAndProtocol(group.ports)
+		found := false
+		for _, a := range allowed {
+			if a.IPProtocol == strings.ToLower(string(group.protocol)) {
+				found = equalStringSets(portNums, a.Ports) || equalStringSets(portRanges, a.Ports)
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func ensureStaticIP(s CloudAddressService, name, serviceName, region, existingIP string, netTier cloud.NetworkTier) (ipAddress string, existing bool, err error) {
 	// If the address doesn't exist, this will create it.
 	// If the existingIP exists but is ephemeral, this will promote it to static.
@@ -0,0 +1,473 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NetLBRBSFinalizer key is used to identify external Regional Backend Service based
+	// load balancers whose resources are managed by service controller. It differs from the
+	// gke.networking.io finalizers of the other controllers of L4 load balancers, including
+	// target pool ones, so that their services are never mistaken for each other.
+	NetLBRBSFinalizer = "cloud.google.com/l4-netlb-rbs"
+)
+
+// managesL4RBS returns true if service controller implements the Regional Backend Service
+// based external load balancer of the service. Services handled by other controllers,
+// identified by their finalizers, are left to them.
+func (g *Cloud) managesL4RBS(svc *v1.Service) bool {
+	if !g.AlphaFeatureGate.Enabled(AlphaFeatureNetLBRBS) {
+		return false
+	}
+	return !hasFinalizer(svc, NetLBFinalizerV2) && !hasFinalizer(svc, NetLBFinalizerV3)
+}
+
+// ensureExternalLoadBalancerRBS is the Regional Backend Service based implementation of
+// LoadBalancer.EnsureLoadBalancer for external load balancers. The load balancer consists
+// of a static IP address, firewall rules, a regional health check, a regional external
+// backend service over the cluster instance groups, and a forwarding rule.
+//
+// Services that have a target pool based load balancer are migrated in place: the IP
+// address is kept, the forwarding rule is switched to the backend service and the target
+// pool and its legacy health checks are deleted afterwards.
+func (g *Cloud) ensureExternalLoadBalancerRBS(clusterName, clusterID string, svc *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf(errStrLbNoHosts)
+	}
+	if err := checkInstanceGroupNodeSelector(svc); err != nil {
+		return nil, err
+	}
+
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	lbRefStr := fmt.Sprintf("%v(%v)", loadBalancerName, nm)
+
+	ports, _, _ := getPortsAndProtocol(svc.Spec.Ports)
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
+			return nil, fmt.Errorf("Invalid protocol %s, only TCP and UDP are supported", string(port.Protocol))
+		}
+	}
+	klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s, %v, %v, %v)", lbRefStr, g.region, svc.Spec.LoadBalancerIP, ports)
+
+	klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s): Attaching %q finalizer", lbRefStr, NetLBRBSFinalizer)
+	if err := addFinalizer(svc, g.serviceClient(), NetLBRBSFinalizer); err != nil {
+		klog.Errorf("Failed to attach finalizer '%s' on service %s - %v", NetLBRBSFinalizer, nm, err)
+		return nil, err
+	}
+
+	hosts, err := g.getInstancesByNames(nodeNames(nodes))
+	if err != nil {
+		return nil, err
+	}
+
+	netTier, err := g.getServiceNetworkTier(svc)
+	if err != nil {
+		klog.Errorf("ensureExternalLoadBalancerRBS(%s): Failed to get the desired network tier: %v.", lbRefStr, err)
+		return nil, err
+	}
+	if _, ok := svc.Annotations[NetworkTierAnnotationKey]; ok {
+		g.deleteWrongNetworkTieredResources(loadBalancerName, lbRefStr, netTier)
+	}
+
+	existingFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
+	if err != nil && !isNotFound(err) {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+	}
+	fwdRuleIP, existingProtocol := "", ""
+	if existingFwdRule != nil {
+		fwdRuleIP, existingProtocol = existingFwdRule.IPAddress, existingFwdRule.IPProtocol
+	}
+	// Services mixing protocols have a backend service and a forwarding rule per protocol.
+	// The resources of the primary protocol are named after the load balancer.
+	protocolGroups := groupPortsByProtocol(svc.Spec.Ports, existingProtocol)
+
+	// The IP address is kept static while the forwarding rule may be recreated,
+	// see ensureExternalLoadBalancer for the details.
+	ipAddressToUse := ""
+	isUserOwnedIP := false // if this is set, we never release the IP
+	isSafeToReleaseIP := false
+	// The forwarding rules of a service mixing protocols share the static IP, so it is never released.
+	isSharedIP := len(protocolGroups) > 1
+	staticIPExisted := false
+	defer func() {
+		if isUserOwnedIP || isSharedIP {
+			return
+		}
+		if isSafeToReleaseIP {
+			if err := ignoreNotFound(g.DeleteRegionAddress(loadBalancerName, g.region)); err != nil {
+				klog.Errorf("ensureExternalLoadBalancerRBS(%s): Failed to release static IP %s in region %v: %v.", lbRefStr, ipAddressToUse, g.region, err)
+			}
+		} else {
+			klog.Warningf("ensureExternalLoadBalancerRBS(%s): Orphaning static IP %s in region %v.", lbRefStr, ipAddressToUse, g.region)
+		}
+	}()
+
+	requestedIP, err := g.requestedExternalLoadBalancerIP(svc, loadBalancerName, netTier)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
+	}
+	if requestedIP != "" {
+		isUserOwnedIP, err = verifyUserRequestedIP(g, g.region, requestedIP, fwdRuleIP, lbRefStr, netTier)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
+		}
+		ipAddressToUse = requestedIP
+	}
+	if !isUserOwnedIP {
+		ipAddr, existed, err := ensureStaticIP(g, loadBalancerName, nm.String(), g.region, fwdRuleIP, netTier)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, fmt.Errorf("failed to ensure a static IP for load balancer (%s): %w", lbRefStr, err))
+		}
+		klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s): Ensured IP address %s (tier: %s).", lbRefStr, ipAddr, netTier)
+		isSafeToReleaseIP = !existed
+		staticIPExisted = existed
+		ipAddressToUse = ipAddr
+	}
+
+	if err := g.ensureExternalFirewall(svc, clusterID, loadBalancerName, lbRefStr, ipAddressToUse, hosts); err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+	}
+
+	newFwdRules, err := g.newExternalRBSForwardingRules(loadBalancerName, clusterID, nm, ipAddressToUse, protocolGroups, svc.Spec.SessionAffinity, netTier)
+	if err != nil {
+		return nil, err
+	}
+
+	// The forwarding rules have to be deleted before the backend services can change, and before
+	// the target pool they point to during a migration can be deleted.
+	fwdRulesNeedCreation := make([]bool, len(newFwdRules))
+	for i, newFwdRule := range newFwdRules {
+		existing := existingFwdRule
+		if i > 0 {
+			if existing, err = g.GetRegionForwardingRule(newFwdRule.Name, g.region); err != nil && !isNotFound(err) {
+				return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+			}
+		}
+		if existing == nil {
+			fwdRulesNeedCreation[i] = true
+			continue
+		}
+		if netLBForwardingRulesEqual(existing, newFwdRule) {
+			continue
+		}
+		klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s): Forwarding rule %s changed (target pool: %q, backend service: %q). Deleting existing forwarding rule.", lbRefStr, newFwdRule.Name, existing.Target, existing.BackendService)
+		// Begin critical section. If something fails before the forwarding rule
+		// is recreated, keep the static IP so we can come back to it later.
+		isSafeToReleaseIP = false
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(newFwdRule.Name, g.region)); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to delete existing forwarding rule %s for load balancer (%s) update: %w", newFwdRule.Name, lbRefStr, err))
+		}
+		fwdRulesNeedCreation[i] = true
+	}
+	// The static IP is kept while the service mixes protocols. If it existed, the service may have stopped
+	// doing so: delete the forwarding rules of the protocols it does not use anymore.
+	unusedFwdRulesDeleted := false
+	if isUserOwnedIP || staticIPExisted {
+		if unusedFwdRulesDeleted, err = g.deleteUnusedProtocolForwardingRules(loadBalancerName, lbRefStr, protocolGroups); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+		}
+	}
+
+	if err := g.ensureExternalRBSBackend(svc, loadBalancerName, clusterID, protocolGroups, unusedFwdRulesDeleted, nodes); err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+	}
+
+	for i, newFwdRule := range newFwdRules {
+		if !fwdRulesNeedCreation[i] {
+			continue
+		}
+		klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s): Creating forwarding rule %s, IP %s (tier: %s).", lbRefStr, newFwdRule.Name, ipAddressToUse, netTier)
+		if err := g.CreateRegionForwardingRule(newFwdRule, g.region); err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, fmt.Errorf("failed to create forwarding rule %s for load balancer (%s): %w", newFwdRule.Name, lbRefStr, err))
+		}
+		klog.V(2).Infof("ensureExternalLoadBalancerRBS(%s): Created forwarding rule %s, IP %s.", lbRefStr, newFwdRule.Name, ipAddressToUse)
+	}
+	if fwdRulesNeedCreation[0] || unusedFwdRulesDeleted {
+		// End critical section. The static IP is attached to the forwarding rules.
+		isSafeToReleaseIP = true
+	}
+
+	if fwdRulesNeedCreation[0] {
+		// Remove what is left of a target pool based load balancer the service used before.
+		// The target pool can only be deleted once no forwarding rule refers to it anymore.
+		if err := g.deleteExternalTargetPoolResources(svc, loadBalancerName, clusterID); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+		}
+	}
+
+	status := &v1.LoadBalancerStatus{}
+	status.Ingress = []v1.LoadBalancerIngress{{IP: ipAddressToUse}}
+	return status, nil
+}
+
+// newExternalRBSForwardingRules returns the desired forwarding rules of the external load balancer,
+// one per protocol.
+func (g *Cloud) newExternalRBSForwardingRules(loadBalancerName, clusterID string, nm types.NamespacedName, ipAddress string, protocolGroups []protocolPorts, affinity v1.ServiceAffinity, netTier cloud.NetworkTier) ([]*compute.ForwardingRule, error) {
+	var fwdRules []*compute.ForwardingRule
+	for _, group := range protocolGroups {
+		portRange, err := loadBalancerPortRange(group.ports)
+		if err != nil {
+			return nil, err
+		}
+		fwdRules = append(fwdRules, &compute.ForwardingRule{
+			Name:                makeProtocolResourceName(loadBalancerName, group.protocol, group.primary),
+			Description:         makeServiceDescription(nm.String()),
+			IPAddress:           ipAddress,
+			IPProtocol:          string(group.protocol),
+			PortRange:           portRange,
+			BackendService:      g.getBackendServiceLink(makeExternalRBSBackendServiceName(loadBalancerName, clusterID, group, affinity)),
+			LoadBalancingScheme: string(cloud.SchemeExternal),
+			NetworkTier:         netTier.ToGCEValue(),
+		})
+	}
+	return fwdRules, nil
+}
+
+// makeExternalRBSBackendServiceName returns the name of the backend service for the ports of one protocol.
+func makeExternalRBSBackendServiceName(loadBalancerName, clusterID string, group protocolPorts, affinity v1.ServiceAffinity) string {
+	name := makeBackendServiceName(loadBalancerName, clusterID, false, cloud.SchemeExternal, group.protocol, affinity)
+	return makeProtocolResourceName(name, group.protocol, group.primary)
+}
+
+// ensureExternalRBSBackend ensures the instance groups, the regional health check with its
+// firewall and the regional backend services of the external load balancer, one per protocol.
+// The backend services of protocols the service does not use anymore are deleted if
+// deleteUnused is set.
+func (g *Cloud) ensureExternalRBSBackend(svc *v1.Service, loadBalancerName, clusterID string, protocolGroups []protocolPorts, deleteUnused bool, nodes []*v1.Node) error {
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+
+	// The health check is shared if externalTrafficPolicy=Cluster.
+	sharedHealthCheck, hcPath, hcPort := l4HealthCheckPathPort(svc)
+	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
+	igName := makeInstanceGroupName(clusterID)
+
+	// Lock the shared resources to prevent their deletion while assembling them here
+	unlock := g.sharedResourceLocks.lock(instanceGroupLockKey(igName), regionHealthCheckLockKey(hcName))
+	defer unlock()
+
+	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	if err != nil {
+		return newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+	}
+
+	hcParams, err := serviceHealthCheckParams(svc, sharedHealthCheck)
+	if err != nil {
+		return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
+	}
+	hc, err := g.ensureRegionHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, hcParams)
+	if err != nil {
+		return newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
+	}
+
+	fwHCName := makeNetLBHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)
+	if err := g.ensureInternalFirewall(svc, fwHCName, "", "", L4LoadBalancerSrcRanges(), []string{strconv.Itoa(int(hcPort))}, v1.ProtocolTCP, nodes, ""); err != nil {
+		return newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+	}
+
+	bsDescription := makeBackendServiceDescription(nm, false)
+	for _, group := range protocolGroups {
+		backendServiceName := makeExternalRBSBackendServiceName(loadBalancerName, clusterID, group, svc.Spec.SessionAffinity)
+		if err := g.ensureInternalBackendService(backendServiceName, bsDescription, newBackendServiceAffinity(svc.Spec.SessionAffinity), cloud.SchemeExternal, group.protocol, igLinks, hc.SelfLink); err != nil {
+			return newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+		}
+	}
+	if !deleteUnused {
+		return nil
+	}
+	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, false, cloud.SchemeExternal, protocolGroups[0].protocol, svc.Spec.SessionAffinity)
+	for _, name := range unusedProtocolResourceNames(backendServiceName, protocolGroups) {
+		if err := g.teardownInternalBackendService(name); err != nil {
+			return newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+		}
+	}
+	return nil
+}
+
+// ensureRegionHealthCheck ensures the regional health check used by Regional Backend Service
+// based external load balancers.
+func (g *Cloud) ensureRegionHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32, params *HealthCheckParams) (*compute.HealthCheck, error) {
+	klog.V(2).Infof("ensureRegionHealthCheck(%v, %v, %v): checking existing health check", name, path, port)
+	expectedHC := newInternalLBHealthCheck(name, svcName, shared, path, port, params)
+
+	hc, err := g.GetRegionHealthCheck(name, g.region)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	if hc == nil {
+		klog.V(2).Infof("ensureRegionHealthCheck: did not find health check %v, creating one with port %v path %v", name, port, path)
+		if err = g.CreateRegionHealthCheck(expectedHC, g.region); err != nil {
+			return nil, err
+		}
+		return g.GetRegionHealthCheck(name, g.region)
+	}
+
+	if needToUpdateHealthChecks(hc, expectedHC, params != nil) {
+		klog.V(2).Infof("ensureRegionHealthCheck: health check %v exists but parameters have drifted - updating...", name)
+		mergeHealthChecks(hc, expectedHC, params != nil)
+		if err := g.UpdateRegionHealthCheck(expectedHC, g.region); err != nil {
+			klog.Warningf("Failed to reconcile health check %v parameters", name)
+			return nil, err
+		}
+		return g.GetRegionHealthCheck(name, g.region)
+	}
+	return hc, nil
+}
+
+// updateExternalLoadBalancerRBS is called when the list of nodes has changed. Only the
+// instance groups and possibly the backend service need to be updated.
+func (g *Cloud) updateExternalLoadBalancerRBS(clusterName string, svc *v1.Service, nodes []*v1.Node) error {
+	if err := checkInstanceGroupNodeSelector(svc); err != nil {
+		return err
+	}
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return err
+	}
+
+	igName := makeInstanceGroupName(clusterID)
+	unlock := g.sharedResourceLocks.lock(instanceGroupLockKey(igName))
+	defer unlock()
+
+	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	if err != nil {
+		return err
+	}
+
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+	protocolGroups, err := g.getProtocolGroups(svc.Spec.Ports, loadBalancerName)
+	if err != nil {
+		return err
+	}
+	for _, group := range protocolGroups {
+		backendServiceName := makeExternalRBSBackendServiceName(loadBalancerName, clusterID, group, svc.Spec.SessionAffinity)
+		if err := g.ensureInternalBackendServiceGroups(backendServiceName, igLinks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureExternalLoadBalancerDeletedRBS is the Regional Backend Service based implementation
+// of LoadBalancer.EnsureLoadBalancerDeleted for external load balancers.
+func (g *Cloud) ensureExternalLoadBalancerDeletedRBS(clusterName, clusterID string, svc *v1.Service) error {
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	lbRefStr := fmt.Sprintf("%v(%v)", loadBalancerName, nm)
+	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
+	sharedHealthCheck := usesNodesHealthCheck(svc)
+
+	klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Deleting IP address.", lbRefStr)
+	if err := ignoreNotFound(g.DeleteRegionAddress(loadBalancerName, g.region)); err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Deleting forwarding rules.", lbRefStr)
+	if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
+		return err
+	}
+	if _, err := g.deleteUnusedProtocolForwardingRules(loadBalancerName, lbRefStr, nil); err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Deleting firewall rule.", lbRefStr)
+	if err := g.teardownInternalFirewall(svc, loadBalancerName, MakeFirewallName(loadBalancerName)); err != nil {
+		return err
+	}
+
+	if err := func() error {
+		hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
+		igName := makeInstanceGroupName(clusterID)
+		unlock := g.sharedResourceLocks.lock(instanceGroupLockKey(igName), regionHealthCheckLockKey(hcName))
+		defer unlock()
+
+		backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, false, cloud.SchemeExternal, protocol, svc.Spec.SessionAffinity)
+		for _, name := range append([]string{backendServiceName}, unusedProtocolResourceNames(backendServiceName, nil)...) {
+			klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Deleting backend service %v.", lbRefStr, name)
+			if err := g.teardownInternalBackendService(name); err != nil {
+				return err
+			}
+		}
+
+		klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Deleting health check %v and its firewall.", lbRefStr, hcName)
+		if err := g.DeleteRegionHealthCheck(hcName, g.region); err != nil {
+			if isInUsedByError(err) {
+				klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Health check %v in use.", lbRefStr, hcName)
+				return nil
+			} else if !isNotFound(err) {
+				return fmt.Errorf("failed to delete health check: %v, err: %v", hcName, err)
+			}
+		}
+		if err := g.teardownInternalFirewall(svc, loadBalancerName, makeNetLBHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)); err != nil {
+			return err
+		}
+
+		// Try deleting instance groups - expect ResourceInuse error if needed by other LBs
+		if err := g.ensureInternalInstanceGroupsDeleted(igName); err != nil && !isInUsedByError(err) {
+			return err
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	if err := g.deleteExternalTargetPoolResources(svc, loadBalancerName, clusterID); err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("ensureExternalLoadBalancerDeletedRBS(%s): Removing %q finalizer", lbRefStr, NetLBRBSFinalizer)
+	if err := removeFinalizer(svc, g.serviceClient(), NetLBRBSFinalizer); err != nil {
+		klog.Errorf("Failed to remove finalizer '%s' on service %s - %v", NetLBRBSFinalizer, nm, err)
+		return err
+	}
+	return nil
+}
+
+// deleteExternalTargetPoolResources deletes the target pool of the load balancer together
+// with its legacy HTTP health checks and their firewalls, if they exist.
+func (g *Cloud) deleteExternalTargetPoolResources(svc *v1.Service, loadBalancerName, clusterID string) error {
+	hcNames := []string{loadBalancerName, MakeNodesHealthCheckName(clusterID)}
+	return g.DeleteExternalTargetPoolAndChecks(svc, loadBalancerName, g.region, clusterID, hcNames...)
+}
+
+// netLBForwardingRulesEqual returns true if the existing forwarding rule already sends
+// traffic to the expected backend service with the expected settings.
+func netLBForwardingRulesEqual(existing, expected *compute.ForwardingRule) bool {
+	return existing.Target == "" &&
+		existing.BackendService != "" &&
+		getNameFromLink(existing.BackendService) == getNameFromLink(expected.BackendService) &&
+		(existing.IPAddress == "" || expected.IPAddress == "" || existing.IPAddress == expected.IPAddress) &&
+		existing.IPProtocol == expected.IPProtocol &&
+		existing.PortRange == expected.PortRange &&
+		strings.EqualFold(existing.LoadBalancingScheme, expected.LoadBalancingScheme)
+}
@@ -0,0 +1,227 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func fakeRBSGCECloud(t *testing.T, vals TestClusterValues) *Cloud {
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{AlphaFeatureNetLBRBS})
+	return gce
+}
+
+func fakeRBSService(t *testing.T, gce *Cloud) *v1.Service {
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[RBSAnnotationKey] = RBSEnabled
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return svc
+}
+
+func assertExternalRBSResources(t *testing.T, gce *Cloud, svc *v1.Service, vals TestClusterValues, ip string) {
+	t.Helper()
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	hcName := makeHealthCheckName(lbName, vals.ClusterID, true)
+	hc, err := gce.GetRegionHealthCheck(hcName, gce.region)
+	require.NoError(t, err)
+
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, string(cloud.SchemeExternal), bs.LoadBalancingScheme)
+	assert.Equal(t, []string{hc.SelfLink}, bs.HealthChecks)
+	assert.Len(t, bs.Backends, 1)
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ip, fwdRule.IPAddress)
+	assert.Empty(t, fwdRule.Target)
+	assert.Equal(t, gce.getBackendServiceLink(lbName), fwdRule.BackendService)
+
+	for _, fwName := range []string{MakeFirewallName(lbName), makeNetLBHealthCheckFirewallName(lbName, vals.ClusterID, true)} {
+		_, err := gce.GetFirewall(fwName)
+		assert.NoError(t, err, "firewall %s", fwName)
+	}
+
+	updatedSvc, err := gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, hasFinalizer(updatedSvc, NetLBRBSFinalizer))
+}
+
+func TestEnsureExternalLoadBalancerRBS(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	svc := fakeRBSService(t, gce)
+
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	assertExternalRBSResources(t, gce, svc, vals, status.Ingress[0].IP)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+
+	// A second sync is a no-op and keeps the IP.
+	status2, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, status, status2)
+}
+
+func TestEnsureExternalLoadBalancerRBSMigratesFromTargetPool(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	svc := fakeLoadbalancerService("")
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	tpStatus, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+
+	svc.Annotations[RBSAnnotationKey] = RBSEnabled
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, tpStatus, status, "IP address must be kept during the migration")
+	assertExternalRBSResources(t, gce, svc, vals, tpStatus.Ingress[0].IP)
+
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err), "target pool should be deleted")
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.True(t, isNotFound(err), "legacy health check should be deleted")
+}
+
+func TestUpdateExternalLoadBalancerRBS(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	svc := fakeRBSService(t, gce)
+
+	_, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.updateExternalLoadBalancer(vals.ClusterName, svc, nodes))
+
+	instances, err := gce.ListInstancesInInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	assert.Len(t, instances, 2)
+}
+
+func TestEnsureExternalLoadBalancerDeletedRBS(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	svc := fakeRBSService(t, gce)
+
+	_, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true), gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	for _, fwName := range []string{MakeFirewallName(lbName), makeNetLBHealthCheckFirewallName(lbName, vals.ClusterID, true)} {
+		_, err := gce.GetFirewall(fwName)
+		assert.True(t, isNotFound(err), "firewall %s", fwName)
+	}
+	_, err = gce.GetInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureExternalLoadBalancerRBSMixedProtocols(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	svc := fakeRBSService(t, gce)
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: int32(53)})
+
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	assertExternalRBSResources(t, gce, svc, vals, status.Ingress[0].IP)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName+"-udp", gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "UDP", fwdRule.IPProtocol)
+	assert.Equal(t, status.Ingress[0].IP, fwdRule.IPAddress)
+	assert.Equal(t, gce.getBackendServiceLink(lbName+"-udp"), fwdRule.BackendService)
+	bs, err := gce.GetRegionBackendService(lbName+"-udp", gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "UDP", bs.Protocol)
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.NoError(t, err, "the shared IP must stay reserved")
+
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetRegionForwardingRule(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionBackendService(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureExternalLoadBalancerRBSOtherController(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeRBSGCECloud(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[RBSAnnotationKey] = RBSEnabled
+	svc.Finalizers = []string{NetLBFinalizerV2}
+
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, err)
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, gce.updateExternalLoadBalancer(vals.ClusterName, svc, nodes))
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+}
@@ -659,6 +659,10 @@ func TestEnsureExternalLoadBalancerRBSFinalizer(t *testing.T) {
 			finalizers: []string{},
 			wantError:  nil,
 		},
+		"When has the target pool finalizer of ingress-gce": {
+			finalizers: []string{"gke.networking.io/l4-netlb-v1"},
+			wantError:  nil,
+		},
 	} {
 		t.Run(desc, func(t *testing.T) {
 			vals := DefaultTestClusterValues()
@@ -1162,10 +1166,11 @@ func TestFirewallNeedsUpdate(t *testing.T) {
 
 			exists, needsUpdate, err := gce.firewallNeedsUpdate(
 				tc.lbName,
-				svcName,
+				makeFirewallDescription(tc.lbName, svcName, tc.ipAddr, vals.ClusterID),
 				tc.ipAddr,
 				tc.ports,
-				tc.ipnet)
+				tc.ipnet,
+				FirewallConfig{})
 			assert.Equal(t, tc.exists, exists, "'exists' didn't return as expected "+desc)
 			assert.Equal(t, tc.needsUpdate, needsUpdate, "'needsUpdate' didn't return as expected "+desc)
 			if tc.hasErr {
@@ -1225,8 +1230,8 @@ func TestEnsureTargetPoolAndHealthCheck(t *testing.T) {
 	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
 	region := vals.Region
 
-	hcToCreate := makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
-	hcToDelete := makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
+	hcToCreate := makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), nil)
+	hcToDelete := makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), nil)
 
 	// Apply a tag on the target pool. By verifying the change of the tag, target pool update can be ensured.
 	tag := "A Tag"
@@ -1299,7 +1304,8 @@ func TestCreateAndUpdateFirewallSucceedsOnXPN(t *testing.T) {
 		"A sad little firewall",
 		ipnet,
 		svc.Spec.Ports,
-		hosts)
+		hosts,
+		FirewallConfig{})
 	require.NoError(t, err)
 
 	msg := fmt.Sprintf("%s %s %s", v1.EventTypeNormal, eventReasonManualChange, eventMsgFirewallChange)
@@ -1312,7 +1318,8 @@ func TestCreateAndUpdateFirewallSucceedsOnXPN(t *testing.T) {
 		"10.0.0.1",
 		ipnet,
 		svc.Spec.Ports,
-		hosts)
+		hosts,
+		FirewallConfig{})
 	require.NoError(t, err)
 
 	msg = fmt.Sprintf("%s %s %s", v1.EventTypeNormal, eventReasonManualChange, eventMsgFirewallChange)
@@ -1506,14 +1513,14 @@ func TestExternalLoadBalancerEnsureHttpHealthCheck(t *testing.T) {
 			}
 
 			hcName, hcPath, hcPort := "test-hc", "/healthz", int32(12345)
-			existingHC := makeHTTPHealthCheck(hcName, hcPath, hcPort)
+			existingHC := makeHTTPHealthCheck(hcName, hcPath, hcPort, nil)
 			existingHC = tc.modifier(existingHC)
 			if existingHC != nil {
 				if err := gce.CreateHTTPHealthCheck(existingHC); err != nil {
 					t.Fatalf("gce.CreateHttpHealthCheck(%#v) = %v; want err = nil", existingHC, err)
 				}
 			}
-			if _, err := gce.ensureHTTPHealthCheck(hcName, hcPath, hcPort); err != nil {
+			if _, err := gce.ensureHTTPHealthCheck(hcName, hcPath, hcPort, nil); err != nil {
 				t.Fatalf("gce.ensureHttpHealthCheck(%q, %q, %v) = _, %d; want err = nil", hcName, hcPath, hcPort, err)
 			}
 			if hc, err := gce.GetHTTPHealthCheck(hcName); err != nil {
@@ -1530,6 +1537,69 @@ func TestExternalLoadBalancerEnsureHttpHealthCheck(t *testing.T) {
 
 }
 
+func TestEnsureExternalLoadBalancerWithTunedHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	c := gce.c.(*cloud.MockGCE)
+	c.MockHttpHealthChecks.UpdateHook = func(ctx context.Context, key *meta.Key, obj *compute.HttpHealthCheck, m *cloud.MockHttpHealthChecks, options ...cloud.Option) error {
+		m.Objects[*key] = &cloud.MockHttpHealthChecksObj{Obj: obj}
+		return nil
+	}
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "20"
+	svc.Annotations[ServiceAnnotationHealthCheckTimeout] = "5"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	loadBalancerName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	hc, err := gce.GetHTTPHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), hc.CheckIntervalSec)
+	assert.Equal(t, int64(5), hc.TimeoutSec)
+
+	// Tuned values below the previous ones are applied.
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "2"
+	svc.Annotations[ServiceAnnotationHealthCheckTimeout] = "2"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHTTPHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), hc.CheckIntervalSec)
+	assert.Equal(t, int64(2), hc.TimeoutSec)
+
+	// Removing the annotations resets the health check to the default values, even the ones
+	// above the defaults.
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "20"
+	svc.Annotations[ServiceAnnotationHealthCheckTimeout] = "10"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationHealthCheckInterval)
+	delete(svc.Annotations, ServiceAnnotationHealthCheckTimeout)
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHTTPHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, gceHcCheckIntervalSeconds, hc.CheckIntervalSec)
+	assert.Equal(t, gceHcTimeoutSeconds, hc.TimeoutSec)
+	assert.False(t, isTunedHealthCheckDescription(hc.Description))
+
+	// The shared nodes health check keeps the default values.
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	svc.Spec.HealthCheckNodePort = 0
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	require.NoError(t, err)
+	assert.Equal(t, gceHcCheckIntervalSeconds, hc.CheckIntervalSec)
+	assert.Equal(t, gceHcTimeoutSeconds, hc.TimeoutSec)
+}
+
 func TestMergeHttpHealthChecks(t *testing.T) {
 	t.Parallel()
 	for _, tc := range []struct {
@@ -1554,14 +1624,14 @@ func TestMergeHttpHealthChecks(t *testing.T) {
 		{"unhealthy threshold - user configured - should keep", gceHcCheckIntervalSeconds, gceHcTimeoutSeconds, gceHcHealthyThreshold, gceHcUnhealthyThreshold + 1, gceHcCheckIntervalSeconds, gceHcTimeoutSeconds, gceHcHealthyThreshold, gceHcUnhealthyThreshold + 1},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			wantHC := makeHTTPHealthCheck("hc", "/", 12345)
+			wantHC := makeHTTPHealthCheck("hc", "/", 12345, nil)
 			hc := &compute.HttpHealthCheck{
 				CheckIntervalSec:   tc.checkIntervalSec,
 				TimeoutSec:         tc.timeoutSec,
 				HealthyThreshold:   tc.healthyThreshold,
 				UnhealthyThreshold: tc.unhealthyThreshold,
 			}
-			mergeHTTPHealthChecks(hc, wantHC)
+			mergeHTTPHealthChecks(hc, wantHC, false)
 			if wantHC.CheckIntervalSec != tc.wantCheckIntervalSec {
 				t.Errorf("wantHC.CheckIntervalSec = %d; want %d", wantHC.CheckIntervalSec, tc.checkIntervalSec)
 			}
@@ -1599,12 +1669,12 @@ func TestNeedToUpdateHttpHealthChecks(t *testing.T) {
 		{"unhealthy threshold does not need update", func(hc *compute.HttpHealthCheck) { hc.UnhealthyThreshold = gceHcUnhealthyThreshold + 1 }, false},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			hc := makeHTTPHealthCheck("hc", "/", 12345)
-			wantHC := makeHTTPHealthCheck("hc", "/", 12345)
+			hc := makeHTTPHealthCheck("hc", "/", 12345, nil)
+			wantHC := makeHTTPHealthCheck("hc", "/", 12345, nil)
 			if tc.modifier != nil {
 				tc.modifier(hc)
 			}
-			if gotChanged := needToUpdateHTTPHealthChecks(hc, wantHC); gotChanged != tc.wantChanged {
+			if gotChanged := needToUpdateHTTPHealthChecks(hc, wantHC, false); gotChanged != tc.wantChanged {
 				t.Errorf("needToUpdateHTTPHealthChecks(%#v, %#v) = %t; want changed = %t", hc, wantHC, gotChanged, tc.wantChanged)
 			}
 		})
@@ -1720,7 +1790,7 @@ func TestFirewallObject(t *testing.T) {
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			ret, err := gce.firewallObject(fwName, fwDesc, tc.destinationIP, tc.sourceRanges, tc.svcPorts, nil)
+			ret, err := gce.firewallObject(fwName, fwDesc, tc.destinationIP, tc.sourceRanges, tc.svcPorts, nil, FirewallConfig{})
 			require.NoError(t, err)
 			expectedFirewall := tc.expectedFirewall(baseFw)
 			retSrcRanges := sets.NewString(ret.SourceRanges...)
@@ -0,0 +1,178 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"sort"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// maxFirewallSourceRanges is the maximum number of source ranges of a firewall rule. When the
+// loadBalancerSourceRanges of a service exceed it, the traffic firewall of its load balancer is
+// split into shards: the first one keeps the name of the firewall, the next ones are suffixed
+// by their index.
+const maxFirewallSourceRanges = 256
+
+// firewallShardName returns the name of the shard of index i of the firewall.
+func firewallShardName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+// shardSourceRanges splits the source ranges into shards of at most maxFirewallSourceRanges, given
+// the source ranges of the existing shards. The shards are updated in order, so ranges must never
+// move to a shard of higher index, which would not allow them until the lower shard is updated:
+//   - ranges stay in their existing shard,
+//   - new ranges fill the free room of the shards in order, then new shards,
+//   - the ranges of the last shards are moved into the free room of the previous ones, so that the
+//     shards that are not needed anymore can be deleted once all the others are updated.
+//
+// There is always at least one shard, and the ranges of each shard are sorted.
+func shardSourceRanges(existing [][]string, sourceRanges []string) [][]string {
+	desired := sets.NewString(sourceRanges...)
+	placed := sets.NewString()
+	shards := make([][]string, 0, len(existing))
+	for _, ranges := range existing {
+		var kept []string
+		for _, r := range ranges {
+			if desired.Has(r) && !placed.Has(r) && len(kept) < maxFirewallSourceRanges {
+				kept = append(kept, r)
+				placed.Insert(r)
+			}
+		}
+		shards = append(shards, kept)
+	}
+	added := desired.Difference(placed).List()
+	for i := 0; len(added) > 0; i++ {
+		if i == len(shards) {
+			shards = append(shards, nil)
+		}
+		n := moveSourceRanges(&shards[i], added)
+		added = added[n:]
+	}
+	for last := len(shards) - 1; last > 0; last-- {
+		for i := 0; i < last && len(shards[last]) > 0; i++ {
+			n := moveSourceRanges(&shards[i], shards[last])
+			shards[last] = shards[last][n:]
+		}
+		if len(shards[last]) > 0 {
+			break
+		}
+		shards = shards[:last]
+	}
+	if len(shards) == 0 {
+		shards = append(shards, nil)
+	}
+	for i := range shards {
+		shards[i] = append([]string{}, shards[i]...)
+		sort.Strings(shards[i])
+	}
+	return shards
+}
+
+// moveSourceRanges appends to the shard as many of the ranges as it has room for, and returns how
+// many were appended.
+func moveSourceRanges(shard *[]string, ranges []string) int {
+	n := maxFirewallSourceRanges - len(*shard)
+	if n > len(ranges) {
+		n = len(ranges)
+	}
+	*shard = append(*shard, ranges[:n]...)
+	return n
+}
+
+// firewallShards returns the source ranges of the shards of the firewall, see shardSourceRanges.
+func (g *Cloud) firewallShards(name string, sourceRanges []string) ([][]string, error) {
+	var existing [][]string
+	for i := 0; ; i++ {
+		fw, err := g.getLoadBalancerFirewall(firewallShardName(name, i))
+		if err != nil {
+			if isNotFound(err) {
+				break
+			}
+			return nil, err
+		}
+		existing = append(existing, fw.SourceRanges)
+	}
+	return shardSourceRanges(existing, sourceRanges), nil
+}
+
+// existingFirewallShards returns the names of the existing shards of the firewall, from the shard
+// of index from on. Shards are contiguous, the first missing one ends the list.
+func (g *Cloud) existingFirewallShards(name string, from int) ([]string, error) {
+	var names []string
+	for i := from; ; i++ {
+		shardName := firewallShardName(name, i)
+		if _, err := g.getLoadBalancerFirewall(shardName); err != nil {
+			if isNotFound(err) {
+				return names, nil
+			}
+			return nil, err
+		}
+		names = append(names, shardName)
+	}
+}
+
+// deleteFirewallShards deletes the shards of the firewall from the shard of index from on. The last
+// shards are deleted first so that the remaining ones stay contiguous if a deletion fails.
+func (g *Cloud) deleteFirewallShards(svc *v1.Service, name string, from int) error {
+	names, err := g.existingFirewallShards(name, from)
+	if err != nil {
+		return err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		klog.V(2).Infof("deleteFirewallShards(%v): deleting firewall shard %s", name, names[i])
+		if err := ignoreNotFound(g.deleteLoadBalancerFirewall(names[i])); err != nil {
+			if isForbidden(err) && g.OnXPN() {
+				klog.V(2).Infof("deleteFirewallShards(%v): do not have permission to delete firewall shard %s (on XPN). Raising event.", name, names[i])
+				g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(names[i], g.NetworkProjectID()))
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureInternalFirewallShards ensures the traffic firewall of an internal load balancer, split into
+// as many shards as its source ranges require, and deletes the shards that are not needed anymore.
+func (g *Cloud) ensureInternalFirewallShards(svc *v1.Service, fwName, fwDesc, destinationIP string, sourceRanges []string, allowed []*compute.FirewallAllowed, nodes []*v1.Node, legacyFwName string, fwConfig FirewallConfig) error {
+	shards, err := g.firewallShards(fwName, sourceRanges)
+	if err != nil {
+		return err
+	}
+	for i, shardRanges := range shards {
+		shardLegacyFwName := ""
+		if i == 0 {
+			shardLegacyFwName = legacyFwName
+		}
+		if err := g.ensureInternalFirewallAllowed(svc, firewallShardName(fwName, i), fwDesc, destinationIP, shardRanges, allowed, nodes, shardLegacyFwName, fwConfig); err != nil {
+			return err
+		}
+	}
+	return g.deleteFirewallShards(svc, fwName, len(shards))
+}
@@ -0,0 +1,242 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+)
+
+// makeSourceRanges returns count sorted source ranges.
+func makeSourceRanges(count int) []string {
+	var ranges []string
+	for i := 0; i < count; i++ {
+		ranges = append(ranges, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+	sort.Strings(ranges)
+	return ranges
+}
+
+// firewallShardsSourceRanges returns the sorted source ranges of the shards of the firewall,
+// failing the test if a shard does not exist.
+func firewallShardsSourceRanges(t *testing.T, gce *Cloud, fwName string, shards int) []string {
+	var ranges []string
+	for i := 0; i < shards; i++ {
+		fw, err := gce.GetFirewall(firewallShardName(fwName, i))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(fw.SourceRanges), maxFirewallSourceRanges)
+		ranges = append(ranges, fw.SourceRanges...)
+	}
+	sort.Strings(ranges)
+	return ranges
+}
+
+func TestShardSourceRanges(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, [][]string{{}}, shardSourceRanges(nil, nil))
+	assert.Equal(t, [][]string{{"10.0.0.0/8", "192.168.0.0/16"}}, shardSourceRanges(nil, []string{"192.168.0.0/16", "10.0.0.0/8"}))
+
+	ranges := makeSourceRanges(2*maxFirewallSourceRanges + 1)
+	shards := shardSourceRanges(nil, ranges)
+	require.Len(t, shards, 3)
+	assert.Equal(t, ranges[:maxFirewallSourceRanges], shards[0])
+	assert.Equal(t, ranges[maxFirewallSourceRanges:2*maxFirewallSourceRanges], shards[1])
+	assert.Equal(t, ranges[2*maxFirewallSourceRanges:], shards[2])
+
+	// The shards do not depend on the order of the ranges.
+	reversed := make([]string, len(ranges))
+	for i, r := range ranges {
+		reversed[len(ranges)-1-i] = r
+	}
+	assert.Equal(t, shards, shardSourceRanges(nil, reversed))
+	assert.Equal(t, shards, shardSourceRanges(shards, reversed))
+
+	// Ranges of the last shards move into the free room of the previous ones.
+	assert.Equal(t, [][]string{{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16"}}, shardSourceRanges([][]string{{"10.1.0.0/16", "172.16.0.0/12"}, {"192.168.0.0/16"}}, []string{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16"}))
+	// A range inserted before full shards does not shift the ranges of the next shards, it fills
+	// the free room of the last one.
+	inserted := shardSourceRanges(shards, append([]string{"1.0.0.0/8"}, ranges...))
+	require.Len(t, inserted, 3)
+	assert.Equal(t, shards[0], inserted[0])
+	assert.Equal(t, shards[1], inserted[1])
+	assert.Equal(t, append([]string{"1.0.0.0/8"}, shards[2]...), inserted[2])
+
+	assert.Equal(t, "k8s-fw-a", firewallShardName("k8s-fw-a", 0))
+	assert.Equal(t, "k8s-fw-a-2", firewallShardName("k8s-fw-a", 2))
+}
+
+func TestEnsureExternalLoadBalancerWithManySourceRanges(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	ranges := makeSourceRanges(maxFirewallSourceRanges + 10)
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	assert.Equal(t, ranges, firewallShardsSourceRanges(t, gce, fwName, 2))
+
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	require.NoError(t, err)
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	exists, needsUpdate, err := gce.firewallNeedsUpdate(lbName, makeFirewallDescription(lbName, nm.String(), status.Ingress[0].IP, vals.ClusterID), status.Ingress[0].IP, svc.Spec.Ports, sourceRanges, FirewallConfig{})
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.False(t, needsUpdate)
+
+	// Shrinking the source ranges deletes the shards that are not needed anymore.
+	svc.Spec.LoadBalancerSourceRanges = ranges[:10]
+	sourceRanges, err = servicehelpers.GetLoadBalancerSourceRanges(svc)
+	require.NoError(t, err)
+	_, needsUpdate, err = gce.firewallNeedsUpdate(lbName, makeFirewallDescription(lbName, nm.String(), status.Ingress[0].IP, vals.ClusterID), status.Ingress[0].IP, svc.Spec.Ports, sourceRanges, FirewallConfig{})
+	require.NoError(t, err)
+	assert.True(t, needsUpdate, "the extra shard must be deleted")
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, ranges[:10], firewallShardsSourceRanges(t, gce, fwName, 1))
+	_, err = gce.GetFirewall(firewallShardName(fwName, 1))
+	assert.True(t, isNotFound(err), "firewall shard not deleted: %v", err)
+
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	for i := 0; i < 2; i++ {
+		_, err = gce.GetFirewall(firewallShardName(fwName, i))
+		assert.True(t, isNotFound(err), "firewall shard %d not deleted: %v", i, err)
+	}
+}
+
+func TestEnsureExternalLoadBalancerKeepsSourceRangesWhileUpdatingShards(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	ranges := makeSourceRanges(2*maxFirewallSourceRanges + 1)
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwName := MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svc))
+
+	// Some ranges are removed and more are added, sorting before all the existing ones.
+	var newRanges []string
+	for i := 0; i < 20; i++ {
+		newRanges = append(newRanges, fmt.Sprintf("1.0.%d.0/24", i))
+	}
+	newRanges = append(newRanges, ranges[10:]...)
+	kept := sets.NewString(ranges...).Intersection(sets.NewString(newRanges...))
+
+	// The ranges kept must be allowed by a shard after every change of the shards.
+	allowedRanges := func(deleted string) sets.String {
+		allowed := sets.NewString()
+		for i := 0; i < 4; i++ {
+			name := firewallShardName(fwName, i)
+			if fw, err := gce.GetFirewall(name); err == nil && name != deleted {
+				allowed.Insert(fw.SourceRanges...)
+			}
+		}
+		return allowed
+	}
+	var writes int
+	c := gce.c.(*cloud.MockGCE)
+	updateHook := func(ctx context.Context, key *meta.Key, obj *compute.Firewall, m *cloud.MockFirewalls, options ...cloud.Option) error {
+		if err := mock.UpdateFirewallHook(ctx, key, obj, m, options...); err != nil {
+			return err
+		}
+		writes++
+		assert.Empty(t, kept.Difference(allowedRanges("")).List(), "ranges not allowed after updating %s", key.Name)
+		return nil
+	}
+	c.MockFirewalls.PatchHook = updateHook
+	c.MockFirewalls.UpdateHook = updateHook
+	c.MockFirewalls.DeleteHook = func(ctx context.Context, key *meta.Key, m *cloud.MockFirewalls, options ...cloud.Option) (bool, error) {
+		writes++
+		assert.Empty(t, kept.Difference(allowedRanges(key.Name)).List(), "ranges not allowed after deleting %s", key.Name)
+		return false, nil
+	}
+
+	svc.Spec.LoadBalancerSourceRanges = newRanges
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.NotZero(t, writes)
+	sort.Strings(newRanges)
+	assert.Equal(t, newRanges, firewallShardsSourceRanges(t, gce, fwName, 3))
+}
+
+func TestEnsureInternalLoadBalancerWithManySourceRanges(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	ranges := makeSourceRanges(2*maxFirewallSourceRanges + 1)
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	assert.Equal(t, ranges, firewallShardsSourceRanges(t, gce, fwName, 3))
+
+	svc.Spec.LoadBalancerSourceRanges = ranges[:maxFirewallSourceRanges]
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ranges[:maxFirewallSourceRanges], firewallShardsSourceRanges(t, gce, fwName, 1))
+	for i := 1; i < 3; i++ {
+		_, err = gce.GetFirewall(firewallShardName(fwName, i))
+		assert.True(t, isNotFound(err), "firewall shard %d not deleted: %v", i, err)
+	}
+
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	for i := 0; i < 3; i++ {
+		_, err = gce.GetFirewall(firewallShardName(fwName, i))
+		assert.True(t, isNotFound(err), "firewall shard %d not deleted: %v", i, err)
+	}
+}
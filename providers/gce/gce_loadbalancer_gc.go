@@ -0,0 +1,398 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// LoadBalancerResourceKind is the kind of a GCE resource of a load balancer.
+type LoadBalancerResourceKind string
+
+// Kinds of the GCE resources of load balancers, in the order they can be deleted in:
+// forwarding rules reference target pools and backend services, which reference network
+// endpoint groups and health checks.
+const (
+	LoadBalancerResourceForwardingRule       LoadBalancerResourceKind = "ForwardingRule"
+	LoadBalancerResourceTargetPool           LoadBalancerResourceKind = "TargetPool"
+	LoadBalancerResourceBackendService       LoadBalancerResourceKind = "BackendService"
+	LoadBalancerResourceNetworkEndpointGroup LoadBalancerResourceKind = "NetworkEndpointGroup"
+	LoadBalancerResourceHealthCheck          LoadBalancerResourceKind = "HealthCheck"
+	LoadBalancerResourceRegionHealthCheck    LoadBalancerResourceKind = "RegionHealthCheck"
+	LoadBalancerResourceHTTPHealthCheck      LoadBalancerResourceKind = "HttpHealthCheck"
+	LoadBalancerResourceFirewall             LoadBalancerResourceKind = "Firewall"
+	LoadBalancerResourceFirewallPolicyRule   LoadBalancerResourceKind = "FirewallPolicyRule"
+	LoadBalancerResourceAddress              LoadBalancerResourceKind = "Address"
+)
+
+// LoadBalancerResource is a GCE resource of the load balancer of a service.
+type LoadBalancerResource struct {
+	Kind LoadBalancerResourceKind
+	Name string
+	// Zone is the zone of zonal resources, like network endpoint groups.
+	Zone string
+	// LoadBalancerName is the name of the load balancer the resource belongs to.
+	LoadBalancerName string
+	// Service is the service named in the descriptions of the resources of the load balancer. Load
+	// balancers of the v2 naming scheme belong to the service of that name.
+	Service types.NamespacedName
+	// Legacy is set for the load balancers named after the UID of their service, see
+	// cloudprovider.DefaultLoadBalancerName. They belong to the service of that UID, which differs
+	// from the service named in their descriptions when it was re-created with the same name.
+	Legacy bool
+}
+
+// legacyLoadBalancerNameRegexp matches the names of load balancers named after the UID of their
+// service, see cloudprovider.DefaultLoadBalancerName.
+var legacyLoadBalancerNameRegexp = regexp.MustCompile(`^a[0-9a-f]{31}$`)
+
+// firewallShardIndexRegexp matches the index suffixing the names of the shards of a firewall, see
+// firewallShardName.
+var firewallShardIndexRegexp = regexp.MustCompile(`-[1-9][0-9]*$`)
+
+// loadBalancerResourceDescription holds the field of the descriptions of the resources of load
+// balancers naming their service.
+type loadBalancerResourceDescription struct {
+	ServiceName string `json:"kubernetes.io/service-name"`
+}
+
+// ListLoadBalancerResources lists the GCE resources of the load balancers of the services of
+// the cluster, in the order they can be deleted in.
+//
+// The name of a resource of a load balancer is the name of the load balancer with the prefix and
+// suffix of its kind, see loadBalancerResourceNames. The load balancer belongs to the cluster when:
+//   - it follows the v2 naming scheme, named after the cluster ID and the service named in the
+//     description of one of its resources, see makeLoadBalancerNameV2;
+//   - it is named after the UID of its service and serves the nodes of the cluster: its target
+//     pool or network endpoint groups hold nodes of the cluster, or its backend services use the
+//     instance groups or the nodes health check of the cluster. The rules of the firewall policy
+//     are written by the cluster, see firewallPolicyOwnedRules. Legacy load balancers whose
+//     backends are already deleted cannot be told apart from the ones of other clusters, and
+//     are not listed.
+//
+// Resources shared by the load balancers of the cluster are not listed.
+func (g *Cloud) ListLoadBalancerResources() ([]LoadBalancerResource, error) {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	nodeNames, err := g.currentNodeNames()
+	if err != nil {
+		return nil, err
+	}
+	igName, hcName := makeInstanceGroupName(clusterID), MakeNodesHealthCheckName(clusterID)
+
+	type candidate struct {
+		kind        LoadBalancerResourceKind
+		name        string
+		zone        string
+		description string
+		// owned is set for resources known to belong to the cluster whatever their name.
+		owned bool
+		// servesNodes returns whether the resource serves the nodes of the cluster, when set.
+		servesNodes func() (bool, error)
+	}
+	var candidates []candidate
+	fwdRules, err := g.ListRegionForwardingRules(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, fr := range fwdRules {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceForwardingRule, name: fr.Name, description: fr.Description})
+	}
+	targetPools, err := g.ListTargetPools(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, tp := range targetPools {
+		tp := tp
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceTargetPool, name: tp.Name, description: tp.Description, servesNodes: func() (bool, error) {
+			return linksName(tp.Instances, nodeNames) || linksName(tp.HealthChecks, sets.NewString(hcName)), nil
+		}})
+	}
+	backendServices, err := g.ListRegionBackendServices(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, bs := range backendServices {
+		bs := bs
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceBackendService, name: bs.Name, description: bs.Description, servesNodes: func() (bool, error) {
+			var groups []string
+			for _, backend := range bs.Backends {
+				groups = append(groups, backend.Group)
+			}
+			return linksName(groups, sets.NewString(igName)) || linksName(bs.HealthChecks, sets.NewString(hcName)), nil
+		}})
+	}
+	zones, err := g.ListZonesInRegion(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, zone := range zones {
+		negs, err := g.ListNetworkEndpointGroup(zone.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, neg := range negs {
+			neg, zone := neg, zone.Name
+			candidates = append(candidates, candidate{kind: LoadBalancerResourceNetworkEndpointGroup, name: neg.Name, zone: zone, description: neg.Description, servesNodes: func() (bool, error) {
+				endpoints, err := g.ListNetworkEndpoints(neg.Name, zone, false)
+				if err != nil {
+					return false, err
+				}
+				var instances []string
+				for _, ep := range endpoints {
+					instances = append(instances, ep.NetworkEndpoint.Instance)
+				}
+				return linksName(instances, nodeNames), nil
+			}})
+		}
+	}
+	healthChecks, err := g.ListHealthChecks()
+	if err != nil {
+		return nil, err
+	}
+	for _, hc := range healthChecks {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceHealthCheck, name: hc.Name, description: hc.Description})
+	}
+	regionHealthChecks, err := g.ListRegionHealthChecks(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, hc := range regionHealthChecks {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceRegionHealthCheck, name: hc.Name, description: hc.Description})
+	}
+	httpHealthChecks, err := g.ListHTTPHealthChecks()
+	if err != nil {
+		return nil, err
+	}
+	for _, hc := range httpHealthChecks {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceHTTPHealthCheck, name: hc.Name, description: hc.Description})
+	}
+	// VPC firewalls are listed even when the firewalls are written into a firewall policy, to
+	// collect the ones written before.
+	firewalls, err := g.ListFirewalls()
+	if err != nil {
+		return nil, err
+	}
+	for _, fw := range firewalls {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceFirewall, name: fw.Name, description: fw.Description})
+	}
+	if g.firewallPolicy != nil {
+		rules, err := g.listFirewallPolicyRules()
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			candidates = append(candidates, candidate{kind: LoadBalancerResourceFirewallPolicyRule, name: rule.Name, description: rule.Description, owned: true})
+		}
+	}
+	addresses, err := g.ListRegionAddresses(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addresses {
+		candidates = append(candidates, candidate{kind: LoadBalancerResourceAddress, name: addr.Name, description: addr.Description})
+	}
+
+	// Services named in the descriptions of the resources, by load balancer name.
+	services := map[string]types.NamespacedName{}
+	for _, c := range candidates {
+		nm, ok := describedService(c.description)
+		if !ok {
+			continue
+		}
+		if name := makeLoadBalancerNameV2(clusterID, nm.Namespace, nm.Name); isLoadBalancerResourceName(c.kind, c.name, name, clusterID) {
+			services[name] = nm
+		}
+		for _, name := range loadBalancerNamesOf(c.kind, c.name, clusterID) {
+			if legacyLoadBalancerNameRegexp.MatchString(name) {
+				services[name] = nm
+			}
+		}
+	}
+
+	// Load balancer of each candidate, and legacy load balancers serving the nodes of the cluster.
+	loadBalancerNames := make([]string, len(candidates))
+	servesNodes := map[string]bool{}
+	for i, c := range candidates {
+		for _, name := range loadBalancerNamesOf(c.kind, c.name, clusterID) {
+			if legacyLoadBalancerNameRegexp.MatchString(name) {
+				loadBalancerNames[i] = name
+				switch {
+				case servesNodes[name]:
+				case c.owned:
+					servesNodes[name] = true
+				case c.servesNodes != nil:
+					if servesNodes[name], err = c.servesNodes(); err != nil {
+						return nil, err
+					}
+				}
+				break
+			}
+			if _, ok := services[name]; ok {
+				loadBalancerNames[i] = name
+				break
+			}
+		}
+	}
+
+	var resources []LoadBalancerResource
+	for i, c := range candidates {
+		name := loadBalancerNames[i]
+		legacy := legacyLoadBalancerNameRegexp.MatchString(name)
+		if name == "" || legacy && !servesNodes[name] {
+			continue
+		}
+		resources = append(resources, LoadBalancerResource{Kind: c.kind, Name: c.name, Zone: c.zone, LoadBalancerName: name, Service: services[name], Legacy: legacy})
+	}
+	return resources, nil
+}
+
+// describedService returns the service named in the description of a resource.
+func describedService(description string) (types.NamespacedName, bool) {
+	d := &loadBalancerResourceDescription{}
+	if description == "" || json.Unmarshal([]byte(description), d) != nil {
+		return types.NamespacedName{}, false
+	}
+	namespace, name, ok := strings.Cut(d.ServiceName, "/")
+	if !ok || name == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+// loadBalancerResourceNames returns the names the resources of a kind of a load balancer may have,
+// except the shards of its traffic firewalls. Resources shared by the load balancers of the
+// cluster are not included.
+func loadBalancerResourceNames(kind LoadBalancerResourceKind, loadBalancerName, clusterID string) []string {
+	protocolNames := append([]string{loadBalancerName}, unusedProtocolResourceNames(loadBalancerName, nil)...)
+	switch kind {
+	case LoadBalancerResourceForwardingRule:
+		var names []string
+		for _, name := range protocolNames {
+			names = append(names, name, makeIPv6ResourceName(name))
+		}
+		return names
+	case LoadBalancerResourceBackendService:
+		return protocolNames
+	case LoadBalancerResourceFirewall, LoadBalancerResourceFirewallPolicyRule:
+		fwName, hcFwName := MakeFirewallName(loadBalancerName), makeHealthCheckFirewallName(loadBalancerName, clusterID, false)
+		return []string{
+			// Internal load balancers formerly named their traffic firewall after the load balancer.
+			loadBalancerName,
+			fwName, makeIPv6ResourceName(fwName),
+			hcFwName, makeIPv6ResourceName(hcFwName),
+			MakeHealthCheckFirewallName(clusterID, loadBalancerName, false),
+			makeNetLBHealthCheckFirewallName(loadBalancerName, clusterID, false),
+		}
+	case LoadBalancerResourceAddress:
+		return []string{loadBalancerName, makeIPv6ResourceName(loadBalancerName)}
+	default:
+		return []string{loadBalancerName}
+	}
+}
+
+// isLoadBalancerResourceName returns whether name is the name of a resource of the kind of the load balancer.
+func isLoadBalancerResourceName(kind LoadBalancerResourceKind, name, loadBalancerName, clusterID string) bool {
+	for _, lbName := range loadBalancerNamesOf(kind, name, clusterID) {
+		if lbName == loadBalancerName {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBalancerNamesOf returns the names of the load balancers a resource of the kind may belong to,
+// cutting the prefix and suffix of each of the names of the resources of the kind off its name.
+func loadBalancerNamesOf(kind LoadBalancerResourceKind, name, clusterID string) []string {
+	// Resource names cannot contain the placeholder, which stands for the load balancer name.
+	const placeholder = "*"
+	resourceNames := loadBalancerResourceNames(kind, placeholder, clusterID)
+	if kind == LoadBalancerResourceFirewall || kind == LoadBalancerResourceFirewallPolicyRule {
+		if index := firewallShardIndexRegexp.FindString(name); index != "" {
+			fwName := MakeFirewallName(placeholder)
+			resourceNames = append(resourceNames, fwName+index, makeIPv6ResourceName(fwName)+index)
+		}
+	}
+	var names []string
+	for _, resourceName := range resourceNames {
+		prefix, suffix, _ := strings.Cut(resourceName, placeholder)
+		if len(name) > len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			names = append(names, name[len(prefix):len(name)-len(suffix)])
+		}
+	}
+	return names
+}
+
+// linksName returns whether one of the links names one of the resources.
+func linksName(links []string, names sets.String) bool {
+	for _, link := range links {
+		if names.Has(getNameFromLink(link)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteLoadBalancerResource deletes a GCE resource of a load balancer. Resources that do
+// not exist anymore are ignored.
+func (g *Cloud) DeleteLoadBalancerResource(r LoadBalancerResource) error {
+	if g.loadBalancerDryRun != nil {
+		// Wait for the dry run operation in progress, if any, so that the deletion is not recorded into its plan.
+		g.loadBalancerDryRun.operation.Lock()
+		defer g.loadBalancerDryRun.operation.Unlock()
+	}
+	var err error
+	switch r.Kind {
+	case LoadBalancerResourceForwardingRule:
+		err = g.DeleteRegionForwardingRule(r.Name, g.region)
+	case LoadBalancerResourceTargetPool:
+		err = g.DeleteTargetPool(r.Name, g.region)
+	case LoadBalancerResourceBackendService:
+		err = g.DeleteRegionBackendService(r.Name, g.region)
+	case LoadBalancerResourceNetworkEndpointGroup:
+		err = g.DeleteNetworkEndpointGroup(r.Name, r.Zone)
+	case LoadBalancerResourceHealthCheck:
+		err = g.DeleteHealthCheck(r.Name)
+	case LoadBalancerResourceRegionHealthCheck:
+		err = g.DeleteRegionHealthCheck(r.Name, g.region)
+	case LoadBalancerResourceHTTPHealthCheck:
+		err = g.DeleteHTTPHealthCheck(r.Name)
+	case LoadBalancerResourceFirewall:
+		err = g.DeleteFirewall(r.Name)
+	case LoadBalancerResourceFirewallPolicyRule:
+		if g.firewallPolicy == nil {
+			return fmt.Errorf("no firewall policy is configured to delete rule %q from", r.Name)
+		}
+		err = g.deleteFirewallPolicyRule(r.Name)
+	case LoadBalancerResourceAddress:
+		err = g.DeleteRegionAddress(r.Name, g.region)
+	default:
+		return fmt.Errorf("unknown load balancer resource kind %q", r.Kind)
+	}
+	return ignoreNotFound(err)
+}
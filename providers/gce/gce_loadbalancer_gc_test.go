@@ -0,0 +1,251 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestListAndDeleteLoadBalancerResources(t *testing.T) {
+	t.Parallel()
+
+	for desc, lbType := range map[string]LoadBalancerType{"external": "", "internal": LBTypeInternal} {
+		lbType := lbType
+		t.Run(desc, func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			gce.loadBalancerNamingScheme = LoadBalancerNamingSchemeV2
+			nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+			require.NoError(t, err)
+
+			svc := fakeLoadbalancerService(string(lbType))
+			svc.Namespace = "default"
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+			require.NoError(t, err)
+
+			// Resources of other clusters are not listed.
+			otherName := makeLoadBalancerNameV2("other-cluster", svc.Namespace, svc.Name)
+			require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: MakeFirewallName(otherName), Description: makeFirewallDescription(otherName, "default/"+svc.Name, "", "other-cluster")}))
+
+			resources, err := gce.ListLoadBalancerResources()
+			require.NoError(t, err)
+			lbName := makeLoadBalancerNameV2(vals.ClusterID, svc.Namespace, svc.Name)
+			names := map[LoadBalancerResourceKind][]string{}
+			for _, r := range resources {
+				assert.Equal(t, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, r.Service)
+				names[r.Kind] = append(names[r.Kind], r.Name)
+			}
+			assert.Equal(t, []string{lbName}, names[LoadBalancerResourceForwardingRule])
+			assert.Contains(t, names[LoadBalancerResourceFirewall], MakeFirewallName(lbName))
+			assert.NotContains(t, names[LoadBalancerResourceFirewall], MakeFirewallName(otherName))
+			if lbType == LBTypeInternal {
+				assert.Equal(t, []string{lbName}, names[LoadBalancerResourceBackendService])
+				assert.Empty(t, names[LoadBalancerResourceHealthCheck], "shared health checks must not be listed")
+			} else {
+				assert.Equal(t, []string{lbName}, names[LoadBalancerResourceTargetPool])
+				assert.Empty(t, names[LoadBalancerResourceHTTPHealthCheck], "shared health checks must not be listed")
+			}
+
+			for _, r := range resources {
+				require.NoError(t, gce.DeleteLoadBalancerResource(r), "%s %s", r.Kind, r.Name)
+			}
+			// Deleted resources are ignored.
+			require.NoError(t, gce.DeleteLoadBalancerResource(resources[0]))
+			resources, err = gce.ListLoadBalancerResources()
+			require.NoError(t, err)
+			assert.Empty(t, resources)
+			_, err = gce.GetFirewall(MakeFirewallName(otherName))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestListLoadBalancerResourcesWithoutDescription(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+	lbName := makeLoadBalancerNameV2(vals.ClusterID, svc.Namespace, svc.Name)
+
+	// The health check firewall has no description, it belongs to the load balancer of the forwarding rule.
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: lbName, Description: makeServiceDescription("default/svc")}, gce.region))
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: makeHealthCheckFirewallName(lbName, vals.ClusterID, false)}))
+
+	resources, err := gce.ListLoadBalancerResources()
+	require.NoError(t, err)
+	nm := types.NamespacedName{Namespace: "default", Name: "svc"}
+	assert.Equal(t, []LoadBalancerResource{
+		{Kind: LoadBalancerResourceForwardingRule, Name: lbName, LoadBalancerName: lbName, Service: nm},
+		{Kind: LoadBalancerResourceFirewall, Name: makeHealthCheckFirewallName(lbName, vals.ClusterID, false), LoadBalancerName: lbName, Service: nm},
+	}, resources)
+}
+
+func TestListLoadBalancerResourcesLegacyNames(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Namespace = "default"
+	svc.UID = "0123abcd-4567-89ef-0123-456789abcdef"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	require.Regexp(t, legacyLoadBalancerNameRegexp, lbName)
+
+	// The legacy named load balancer of another cluster of the project, for a service of the same name.
+	otherName := "a89abcdef0123456789abcdef0123456"
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: otherName, Description: makeServiceDescription("default/" + svc.Name)}, gce.region))
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: MakeFirewallName(otherName), Description: makeFirewallDescription(otherName, "default/"+svc.Name, "", "other-cluster")}))
+
+	resources, err := gce.ListLoadBalancerResources()
+	require.NoError(t, err)
+	nm := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	assert.Equal(t, []LoadBalancerResource{
+		{Kind: LoadBalancerResourceForwardingRule, Name: lbName, LoadBalancerName: lbName, Service: nm, Legacy: true},
+		{Kind: LoadBalancerResourceTargetPool, Name: lbName, LoadBalancerName: lbName, Service: nm, Legacy: true},
+		{Kind: LoadBalancerResourceFirewall, Name: MakeFirewallName(lbName), LoadBalancerName: lbName, Service: nm, Legacy: true},
+	}, resources)
+}
+
+func TestListLoadBalancerResourcesLegacyNamesServingNodes(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	gce.updateNodeZones(nil, nodes[0])
+	_, err = createAndInsertNodes(gce, []string{"other-node"}, vals.ZoneName)
+	require.NoError(t, err)
+	instanceLink := func(name string) string {
+		return strings.Join([]string{"projects", vals.ProjectID, "zones", vals.ZoneName, "instances", name}, "/")
+	}
+
+	// The load balancers of services deleted before their firewalls named the cluster, with a
+	// target pool holding the nodes of the cluster or of another cluster.
+	nm := types.NamespacedName{Namespace: "default", Name: "svc"}
+	lbName, otherName := "a0123456789abcdef0123456789abcde", "a89abcdef0123456789abcdef0123456"
+	for name, instance := range map[string]string{lbName: "test-node-1", otherName: "other-node"} {
+		require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: name, Description: makeServiceDescription(nm.String())}, gce.region))
+		require.NoError(t, gce.CreateTargetPool(&compute.TargetPool{Name: name, Description: makeServiceDescription(nm.String()), Instances: []string{instanceLink(instance)}}, gce.region))
+		require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: MakeFirewallName(name), Description: makeServiceDescription(nm.String())}))
+	}
+	// Resources whose name only contains the load balancer name belong to other load balancers.
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: MakeFirewallName(lbName) + "-other"}))
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: "other-" + lbName}, gce.region))
+
+	resources, err := gce.ListLoadBalancerResources()
+	require.NoError(t, err)
+	assert.Equal(t, []LoadBalancerResource{
+		{Kind: LoadBalancerResourceForwardingRule, Name: lbName, LoadBalancerName: lbName, Service: nm, Legacy: true},
+		{Kind: LoadBalancerResourceTargetPool, Name: lbName, LoadBalancerName: lbName, Service: nm, Legacy: true},
+		{Kind: LoadBalancerResourceFirewall, Name: MakeFirewallName(lbName), LoadBalancerName: lbName, Service: nm, Legacy: true},
+	}, resources)
+}
+
+func TestListLoadBalancerResourcesNEGsAndFirewallPolicyRules(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	policy := newFakeFirewallPolicy(gce)
+	nm := types.NamespacedName{Namespace: "default", Name: "svc"}
+	lbName := makeLoadBalancerNameV2(vals.ClusterID, nm.Namespace, nm.Name)
+	legacyNm := types.NamespacedName{Namespace: "default", Name: "legacy"}
+	legacyName := "a0123456789abcdef0123456789abcde"
+
+	require.NoError(t, gce.CreateNetworkEndpointGroup(&computebeta.NetworkEndpointGroup{Name: lbName, Description: makeServiceDescription(nm.String())}, vals.ZoneName))
+	// The VPC firewall was written before the firewall policy was configured.
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: MakeFirewallName(lbName), Description: makeFirewallDescription(lbName, nm.String(), "", vals.ClusterID)}))
+	require.NoError(t, gce.createFirewallPolicyRule(&compute.Firewall{
+		Name:                  MakeFirewallName(lbName),
+		Description:           makeFirewallDescription(lbName, nm.String(), "", vals.ClusterID),
+		SourceRanges:          []string{"0.0.0.0/0"},
+		TargetServiceAccounts: []string{fakeNodeServiceAccount},
+	}))
+	// The rules of the policy belong to the cluster, even when their description does not name it.
+	require.NoError(t, gce.createFirewallPolicyRule(&compute.Firewall{
+		Name:                  MakeFirewallName(legacyName),
+		Description:           makeServiceDescription(legacyNm.String()),
+		SourceRanges:          []string{"0.0.0.0/0"},
+		TargetServiceAccounts: []string{fakeNodeServiceAccount},
+	}))
+
+	resources, err := gce.ListLoadBalancerResources()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []LoadBalancerResource{
+		{Kind: LoadBalancerResourceNetworkEndpointGroup, Name: lbName, Zone: vals.ZoneName, LoadBalancerName: lbName, Service: nm},
+		{Kind: LoadBalancerResourceFirewall, Name: MakeFirewallName(lbName), LoadBalancerName: lbName, Service: nm},
+		{Kind: LoadBalancerResourceFirewallPolicyRule, Name: MakeFirewallName(lbName), LoadBalancerName: lbName, Service: nm},
+		{Kind: LoadBalancerResourceFirewallPolicyRule, Name: MakeFirewallName(legacyName), LoadBalancerName: legacyName, Service: legacyNm, Legacy: true},
+	}, resources)
+
+	for _, r := range resources {
+		require.NoError(t, gce.DeleteLoadBalancerResource(r), "%s %s", r.Kind, r.Name)
+	}
+	resources, err = gce.ListLoadBalancerResources()
+	require.NoError(t, err)
+	assert.Empty(t, resources)
+	assert.Empty(t, policy.byName())
+}
+
+func TestLoadBalancerNamesOf(t *testing.T) {
+	t.Parallel()
+
+	const lbName = "ccm2-cluster-default-svc-12345678"
+	for _, tc := range []struct {
+		kind LoadBalancerResourceKind
+		name string
+		want bool
+	}{
+		{kind: LoadBalancerResourceForwardingRule, name: lbName, want: true},
+		{kind: LoadBalancerResourceForwardingRule, name: lbName + "-udp-ipv6", want: true},
+		{kind: LoadBalancerResourceForwardingRule, name: lbName + "-hc"},
+		{kind: LoadBalancerResourceTargetPool, name: "x" + lbName},
+		{kind: LoadBalancerResourceBackendService, name: lbName + "-tcp", want: true},
+		{kind: LoadBalancerResourceFirewall, name: MakeFirewallName(lbName), want: true},
+		{kind: LoadBalancerResourceFirewall, name: firewallShardName(MakeFirewallName(lbName), 2), want: true},
+		{kind: LoadBalancerResourceFirewall, name: firewallShardName(makeIPv6ResourceName(MakeFirewallName(lbName)), 1), want: true},
+		{kind: LoadBalancerResourceFirewall, name: firewallShardName(makeHealthCheckFirewallName(lbName, "cluster", false), 1)},
+		{kind: LoadBalancerResourceFirewallPolicyRule, name: makeNetLBHealthCheckFirewallName(lbName, "cluster", false), want: true},
+		{kind: LoadBalancerResourceAddress, name: MakeFirewallName(lbName)},
+	} {
+		assert.Equal(t, tc.want, isLoadBalancerResourceName(tc.kind, tc.name, lbName, "cluster"), "%s %s", tc.kind, tc.name)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
@@ -50,6 +51,13 @@ const (
 	maxInstancesPerInstanceGroup = 1000
 	// maxL4ILBPorts is the maximum number of ports that can be specified in an L4 ILB Forwarding Rule. Beyond this, "AllPorts" field should be used.
 	maxL4ILBPorts = 5
+	// minILBAffinityTimeoutSec and maxILBAffinityTimeoutSec bound the idle timeout of the connections
+	// tracked for the session affinity of internal backend services.
+	minILBAffinityTimeoutSec = 10 * 60
+	maxILBAffinityTimeoutSec = 16 * 60 * 60
+	// connectionTrackingModePerSession is the connection tracking mode of backend services with an
+	// idle timeout.
+	connectionTrackingModePerSession = "PER_SESSION"
 	// labelGKESubnetworkName is the key of the label that contains the subnet name the node is connected to.
 	labelGKESubnetworkName = "cloud.google.com/gke-node-pool-subnet"
 )
@@ -57,18 +65,22 @@ const (
 func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v1.Service, existingFwdRule *compute.ForwardingRule, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	if existingFwdRule == nil && !hasFinalizer(svc, ILBFinalizerV1) {
 		// Neither the forwarding rule nor the V1 finalizer exists. This is most likely a new service.
-		if g.AlphaFeatureGate.Enabled(AlphaFeatureILBSubsets) {
-			// When ILBSubsets is enabled, new ILB services will not be processed here.
-			// Services that have existing GCE resources created by this controller or the v1 finalizer
-			// will continue to update.
-			klog.V(2).Infof("Skipped ensureInternalLoadBalancer for service %s/%s, since %s feature is enabled.", svc.Namespace, svc.Name, AlphaFeatureILBSubsets)
-			return nil, cloudprovider.ImplementedElsewhere
-		}
 		if hasFinalizer(svc, ILBFinalizerV2) {
 			// No V1 resources present - Another controller is handling the resources for this service.
 			klog.V(2).Infof("Skipped ensureInternalLoadBalancer for service %s/%s, as service contains %q finalizer.", svc.Namespace, svc.Name, ILBFinalizerV2)
 			return nil, cloudprovider.ImplementedElsewhere
 		}
+		if g.AlphaFeatureGate.Enabled(AlphaFeatureILBSubsets) && !usesILBSubsetting(svc) && svc.Annotations[ServiceAnnotationILBBackendType] != string(ILBBackendTypeInstanceGroup) {
+			// When ILBSubsets is enabled, new ILB services are provisioned with subsetting, unless
+			// they select instance group backends. Services that have existing GCE resources created
+			// by this controller or the v1 finalizer keep their backends. The subsetting and the
+			// network endpoint group backends are recorded in annotations before the v1 finalizer is
+			// attached, so that later syncs keep them.
+			var err error
+			if svc, err = g.recordILBSubsetting(svc); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
@@ -83,14 +95,15 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 
 	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
 	klog.V(2).Infof("ensureInternalLoadBalancer(%v): Attaching %q finalizer", loadBalancerName, ILBFinalizerV1)
-	if err := addFinalizer(svc, g.client.CoreV1(), ILBFinalizerV1); err != nil {
+	if err := addFinalizer(svc, g.serviceClient(), ILBFinalizerV1); err != nil {
 		klog.Errorf("Failed to attach finalizer '%s' on service %s/%s - %v", ILBFinalizerV1, svc.Namespace, svc.Name, err)
 		return nil, err
 	}
 
-	ports, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
-	if protocol != v1.ProtocolTCP && protocol != v1.ProtocolUDP {
-		return nil, fmt.Errorf("Invalid protocol %s, only TCP and UDP are supported", string(protocol))
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
+			return nil, fmt.Errorf("Invalid protocol %s, only TCP and UDP are supported", string(port.Protocol))
+		}
 	}
 	scheme := cloud.SchemeInternal
 	options := getILBOptions(svc)
@@ -98,43 +111,93 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 		g.eventRecorder.Event(svc, v1.EventTypeWarning, "ILBOptionsIgnored", "Internal LoadBalancer options are not supported with Legacy Networks.")
 		options = ILBOptions{}
 	}
+	ipv4Enabled, ipv6Enabled := g.ilbIPFamilies(svc)
+	if ipv6Enabled && g.IsLegacyNetwork() {
+		return nil, fmt.Errorf("IPv6 internal LoadBalancers are not supported with Legacy Networks")
+	}
+	bsAffinity, err := internalBackendServiceAffinity(svc)
+	if err != nil {
+		return nil, err
+	}
 
-	sharedBackend := shareBackendService(svc)
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, svc.Spec.SessionAffinity)
-	backendServiceLink := g.getBackendServiceLink(backendServiceName)
-
-	// Ensure instance groups exist and nodes are assigned to groups
-	igName := makeInstanceGroupName(clusterID)
-	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	// Dual-stack and IPv6-only services have a separate IPv6 forwarding rule.
+	existingIPv6FwdRule, err := g.getInternalIPv6ForwardingRule(loadBalancerName)
 	if err != nil {
 		return nil, err
 	}
 
+	// Services mixing protocols have a backend service and, per IP family, a forwarding rule for each
+	// protocol. The resources of the primary protocol keep the names of a single protocol load balancer.
+	existingProtocol := ""
+	if existingFwdRule != nil {
+		existingProtocol = existingFwdRule.IPProtocol
+	} else if existingIPv6FwdRule != nil {
+		existingProtocol = existingIPv6FwdRule.IPProtocol
+	}
+	protocolGroups := groupPortsByProtocol(svc.Spec.Ports, existingProtocol)
+	mixedProtocol := len(protocolGroups) > 1
+	if mixedProtocol && g.IsLegacyNetwork() {
+		return nil, fmt.Errorf("mixed protocol internal LoadBalancers are not supported with Legacy Networks")
+	}
+	sharedBackend := shareBackendService(svc)
+	backendServiceNames := make([]string, len(protocolGroups))
+	for i, group := range protocolGroups {
+		backendServiceNames[i] = makeInternalBackendServiceName(loadBalancerName, clusterID, sharedBackend, group, svc.Spec.SessionAffinity)
+	}
+
 	// Get existing backend service (if exists)
 	var existingBackendService *compute.BackendService
-	if existingFwdRule != nil && existingFwdRule.BackendService != "" {
-		existingBSName := getNameFromLink(existingFwdRule.BackendService)
+	existingBSLink := ""
+	if existingFwdRule != nil {
+		existingBSLink = existingFwdRule.BackendService
+	} else if existingIPv6FwdRule != nil {
+		existingBSLink = existingIPv6FwdRule.BackendService
+	}
+	if existingBSLink != "" {
+		existingBSName := getNameFromLink(existingBSLink)
 		if existingBackendService, err = g.GetRegionBackendService(existingBSName, g.region); err != nil && !isNotFound(err) {
-			return nil, err
+			return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+		}
+	}
+
+	// Lock the shared resources to prevent their deletion while assembling them here. The backend
+	// service and health check of the previous load balancer are locked too, as they are deleted
+	// if they are not used anymore.
+	lockedBackendServiceNames := append(append([]string{}, backendServiceNames...), unusedInternalBackendServiceNames(loadBalancerName, clusterID, sharedBackend, protocolGroups, svc.Spec.SessionAffinity)...)
+	lockKeys := g.internalBackendLockKeys(svc, loadBalancerName, clusterID, lockedBackendServiceNames)
+	if usesNodesHealthCheck(svc) {
+		lockKeys = append(lockKeys, healthCheckLockKey(makeHealthCheckName(loadBalancerName, clusterID, true)))
+	}
+	if existingBackendService != nil {
+		lockKeys = append(lockKeys, backendServiceLockKey(existingBackendService.Name))
+		for _, hcLink := range existingBackendService.HealthChecks {
+			lockKeys = append(lockKeys, healthCheckLockKey(getNameFromLink(hcLink)))
 		}
 	}
+	unlock := g.sharedResourceLocks.lock(lockKeys...)
+	defer unlock()
 
-	// Lock the sharedResourceLock to prevent any deletions of shared resources while assembling shared resources here
-	g.sharedResourceLock.Lock()
-	defer g.sharedResourceLock.Unlock()
+	// Ensure instance groups or network endpoint groups exist and nodes are assigned to groups
+	backendLinks, backendNodes, err := g.ensureInternalBackends(loadBalancerName, clusterID, svc, nodes)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+	}
+	if usesILBSubsetting(svc) && backendNodes != nil {
+		serviceState.EnabledSubsetting = true
+		serviceState.SubsetSize = backendNodes.Len()
+	}
 
 	// Ensure health check exists before creating the backend service. The health check is shared
 	// if externalTrafficPolicy=Cluster.
-	sharedHealthCheck := !servicehelpers.RequestsOnlyLocalTraffic(svc)
+	sharedHealthCheck, hcPath, hcPort := l4HealthCheckPathPort(svc)
 	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
-	hcPath, hcPort := GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
-	if !sharedHealthCheck {
-		// Service requires a special health check, retrieve the OnlyLocal port & path
-		hcPath, hcPort = servicehelpers.GetServiceHealthCheckPathPort(svc)
+	hcParams, err := serviceHealthCheckParams(svc, sharedHealthCheck)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
 	}
-	hc, err := g.ensureInternalHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort)
+	hc, err := g.ensureInternalHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, hcParams)
 	if err != nil {
-		return nil, err
+		return nil, newLoadBalancerStageError(LoadBalancerConditionHealthCheckReady, err)
 	}
 
 	subnetworkURL := g.SubnetworkURL()
@@ -145,98 +208,169 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	if options.SubnetName != "" {
 		subnetworkURL = gceSubnetworkURL("", g.networkProjectID, g.region, options.SubnetName)
 	}
-	// Determine IP which will be used for this LB. If no forwarding rule has been established
-	// or specified in the Service spec, then requestedIP = "".
-	ipToUse := ilbIPToUse(svc, existingFwdRule, subnetworkURL)
+	requestedIP, requestedIPv6, err := g.requestedLoadBalancerIPs(svc, loadBalancerName, cloud.NetworkTierDefault, subnetworkURL)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
+	}
+
+	fwdRuleDescription := &forwardingRuleDescription{ServiceName: nm.String()}
+	fwdRuleDescriptionString, err := fwdRuleDescription.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// The forwarding rules of protocols the service does not use anymore only need to be looked
+	// for if the service mixes protocols, or did so before and still holds the shared address.
+	deleteUnusedProtocols := mixedProtocol
+	var newFwdRules []*compute.ForwardingRule
+	if ipv4Enabled {
+		// Determine IP which will be used for this LB. If no forwarding rule has been established
+		// or specified in the Service spec, then requestedIP = "".
+		ipToUse := ilbIPToUse(requestedIP, existingFwdRule, subnetworkURL)
+
+		klog.V(2).Infof("ensureInternalLoadBalancer(%v): Using subnet %s for LoadBalancer IP %s", loadBalancerName, options.SubnetName, ipToUse)
+
+		var addrMgr *addressManager
+		// If the network is not a legacy network, use the address manager
+		if !g.IsLegacyNetwork() {
+			addrMgr = newAddressManager(g, nm.String(), g.Region(), subnetworkURL, loadBalancerName, ipToUse, cloud.SchemeInternal, IPVersionIPv4)
+			if mixedProtocol {
+				addrMgr.ShareAddress()
+			}
+			ipToUse, err = addrMgr.HoldAddress()
+			if err != nil {
+				return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
+			}
+			deleteUnusedProtocols = deleteUnusedProtocols || addrMgr.WasShared()
+			klog.V(2).Infof("ensureInternalLoadBalancer(%v): reserved IP %q for the forwarding rule", loadBalancerName, ipToUse)
+			defer func() {
+				// Release the address if all resources were created successfully, or if we error out.
+				if err := addrMgr.ReleaseAddress(); err != nil {
+					klog.Errorf("ensureInternalLoadBalancer: failed to release address reservation, possibly causing an orphan: %v", err)
+				}
+			}()
+		}
+
+		newFwdRules = g.newInternalForwardingRules(loadBalancerName, fwdRuleDescriptionString, ipToUse, subnetworkURL, backendServiceNames, protocolGroups, IPVersionIPv4, options)
+	}
 
-	klog.V(2).Infof("ensureInternalLoadBalancer(%v): Using subnet %s for LoadBalancer IP %s", loadBalancerName, options.SubnetName, ipToUse)
+	var newIPv6FwdRules []*compute.ForwardingRule
+	if ipv6Enabled {
+		ipv6ToUse := ilbIPv6ToUse(requestedIPv6, existingIPv6FwdRule, subnetworkURL)
+		klog.V(2).Infof("ensureInternalLoadBalancer(%v): Using subnet %s for LoadBalancer IPv6 %s", loadBalancerName, options.SubnetName, ipv6ToUse)
 
-	var addrMgr *addressManager
-	// If the network is not a legacy network, use the address manager
-	if !g.IsLegacyNetwork() {
-		addrMgr = newAddressManager(g, nm.String(), g.Region(), subnetworkURL, loadBalancerName, ipToUse, cloud.SchemeInternal)
-		ipToUse, err = addrMgr.HoldAddress()
+		ipv6AddrMgr := newAddressManager(g, nm.String(), g.Region(), subnetworkURL, makeIPv6ResourceName(loadBalancerName), ipv6ToUse, cloud.SchemeInternal, IPVersionIPv6)
+		if mixedProtocol {
+			ipv6AddrMgr.ShareAddress()
+		}
+		ipv6ToUse, err = ipv6AddrMgr.HoldAddress()
 		if err != nil {
-			return nil, err
+			return nil, newLoadBalancerStageError(LoadBalancerConditionAddressReserved, err)
 		}
-		klog.V(2).Infof("ensureInternalLoadBalancer(%v): reserved IP %q for the forwarding rule", loadBalancerName, ipToUse)
+		deleteUnusedProtocols = deleteUnusedProtocols || ipv6AddrMgr.WasShared()
+		klog.V(2).Infof("ensureInternalLoadBalancer(%v): reserved IPv6 %q for the forwarding rule", loadBalancerName, ipv6ToUse)
 		defer func() {
 			// Release the address if all resources were created successfully, or if we error out.
-			if err := addrMgr.ReleaseAddress(); err != nil {
-				klog.Errorf("ensureInternalLoadBalancer: failed to release address reservation, possibly causing an orphan: %v", err)
+			if err := ipv6AddrMgr.ReleaseAddress(); err != nil {
+				klog.Errorf("ensureInternalLoadBalancer: failed to release IPv6 address reservation, possibly causing an orphan: %v", err)
 			}
 		}()
-	}
 
-	fwdRuleDescription := &forwardingRuleDescription{ServiceName: nm.String()}
-	fwdRuleDescriptionString, err := fwdRuleDescription.marshal()
-	if err != nil {
-		return nil, err
-	}
-	newFwdRule := &compute.ForwardingRule{
-		Name:                loadBalancerName,
-		Description:         fwdRuleDescriptionString,
-		IPAddress:           ipToUse,
-		BackendService:      backendServiceLink,
-		Ports:               ports,
-		IPProtocol:          string(protocol),
-		LoadBalancingScheme: string(scheme),
-		// Given that CreateGCECloud will attempt to determine the subnet based off the network,
-		// the subnetwork should rarely be unknown.
-		Subnetwork: subnetworkURL,
-		Network:    g.networkURL,
-	}
-	if options.AllowGlobalAccess {
-		newFwdRule.AllowGlobalAccess = options.AllowGlobalAccess
-	}
-	if len(ports) > maxL4ILBPorts {
-		newFwdRule.Ports = nil
-		newFwdRule.AllPorts = true
+		newIPv6FwdRules = g.newInternalForwardingRules(loadBalancerName, fwdRuleDescriptionString, ipv6ToUse, subnetworkURL, backendServiceNames, protocolGroups, IPVersionIPv6, options)
 	}
 
-	fwdRuleDeleted := false
-	if existingFwdRule != nil && !forwardingRulesEqual(existingFwdRule, newFwdRule) {
-		// Delete existing forwarding rule before making changes to the backend service. For example - changing protocol
-		// of backend service without first deleting forwarding rule will throw an error since the linked forwarding
-		// rule would show the old protocol.
-		if klogV := klog.V(2); klogV.Enabled() {
-			frDiff := cmp.Diff(existingFwdRule, newFwdRule)
-			klogV.Infof("ensureInternalLoadBalancer(%v): forwarding rule changed - Existing - %+v\n, New - %+v\n, Diff(-existing, +new) - %s\n. Deleting existing forwarding rule.", loadBalancerName, existingFwdRule, newFwdRule, frDiff)
-		}
-		if err = ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
-			return nil, err
+	// Delete existing forwarding rules before making changes to the backend services. For example - changing protocol
+	// of backend service without first deleting forwarding rule will throw an error since the linked forwarding
+	// rule would show the old protocol. Forwarding rules of IP families and protocols the service no longer uses
+	// are deleted as well.
+	var unusedFwdRuleNames, unusedIPv6FwdRuleNames []string
+	if deleteUnusedProtocols {
+		unusedFwdRuleNames = unusedInternalForwardingRuleNames(loadBalancerName, newFwdRules, protocolGroups, IPVersionIPv4)
+		if g.clusterSupportsIPv6() {
+			unusedIPv6FwdRuleNames = unusedInternalForwardingRuleNames(loadBalancerName, newIPv6FwdRules, protocolGroups, IPVersionIPv6)
 		}
-		fwdRuleDeleted = true
+	}
+	fwdRulesToCreate, err := g.deleteChangedInternalForwardingRules(loadBalancerName, existingFwdRule, newFwdRules, unusedFwdRuleNames)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+	}
+	ipv6FwdRulesToCreate, err := g.deleteChangedInternalForwardingRules(loadBalancerName, existingIPv6FwdRule, newIPv6FwdRules, unusedIPv6FwdRuleNames)
+	if err != nil {
+		return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
 	}
 
 	bsDescription := makeBackendServiceDescription(nm, sharedBackend)
-	err = g.ensureInternalBackendService(backendServiceName, bsDescription, svc.Spec.SessionAffinity, scheme, protocol, igLinks, hc.SelfLink)
-	if err != nil {
-		return nil, err
+	for i, group := range protocolGroups {
+		err = g.ensureInternalBackendService(backendServiceNames[i], bsDescription, bsAffinity, scheme, group.protocol, backendLinks, hc.SelfLink)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionBackendReady, err)
+		}
 	}
 
-	if fwdRuleDeleted || existingFwdRule == nil {
+	for _, fwdRule := range append(fwdRulesToCreate, ipv6FwdRulesToCreate...) {
 		// existing rule has been deleted, pass in nil
-		if err := g.ensureInternalForwardingRule(nil, newFwdRule); err != nil {
-			return nil, err
+		if err := g.ensureInternalForwardingRule(nil, fwdRule); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
 		}
 	}
 
-	// Get the most recent forwarding rule for the address.
-	updatedFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
-	if err != nil {
-		return nil, err
+	status := &v1.LoadBalancerStatus{}
+	if len(newFwdRules) > 0 {
+		// Get the most recent forwarding rule for the address.
+		updatedFwdRule, err := g.GetRegionForwardingRule(newFwdRules[0].Name, g.region)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+		}
+
+		// Ensure firewall rules if necessary
+		if err = g.ensureInternalFirewalls(loadBalancerName, updatedFwdRule.IPAddress, clusterID, nm, svc, strconv.Itoa(int(hcPort)), sharedHealthCheck, nodes); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+		}
+		status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{IP: updatedFwdRule.IPAddress})
+	} else if g.clusterSupportsIPv6() {
+		if err := g.teardownInternalFirewall(svc, loadBalancerName, MakeFirewallName(loadBalancerName)); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+		}
 	}
 
-	ipToUse = updatedFwdRule.IPAddress
-	// Ensure firewall rules if necessary
-	if err = g.ensureInternalFirewalls(loadBalancerName, ipToUse, clusterID, nm, svc, strconv.Itoa(int(hcPort)), sharedHealthCheck, nodes); err != nil {
-		return nil, err
+	if len(newIPv6FwdRules) > 0 {
+		updatedIPv6FwdRule, err := g.GetRegionForwardingRule(newIPv6FwdRules[0].Name, g.region)
+		if err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionForwardingRuleReady, err)
+		}
+
+		ipv6Address := ipv6AddressWithoutPrefix(updatedIPv6FwdRule.IPAddress)
+		if err = g.ensureInternalIPv6Firewalls(loadBalancerName, ipv6Address, clusterID, nm, svc, strconv.Itoa(int(hcPort)), sharedHealthCheck, nodes); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+		}
+		ipv6Ingress := v1.LoadBalancerIngress{IP: ipv6Address}
+		if len(svc.Spec.IPFamilies) > 0 && svc.Spec.IPFamilies[0] == v1.IPv6Protocol {
+			status.Ingress = append([]v1.LoadBalancerIngress{ipv6Ingress}, status.Ingress...)
+		} else {
+			status.Ingress = append(status.Ingress, ipv6Ingress)
+		}
+	} else if g.clusterSupportsIPv6() {
+		if err := g.teardownInternalFirewall(svc, loadBalancerName, makeIPv6ResourceName(MakeFirewallName(loadBalancerName))); err != nil {
+			return nil, newLoadBalancerStageError(LoadBalancerConditionFirewallReady, err)
+		}
 	}
 
 	// Delete the previous internal load balancer resources if necessary
 	if existingBackendService != nil {
-		g.clearPreviousInternalResources(svc, loadBalancerName, existingBackendService, backendServiceName, hcName)
+		g.clearPreviousInternalResources(svc, loadBalancerName, existingBackendService, backendServiceNames[0], hcName)
+	}
+	if deleteUnusedProtocols {
+		for _, bsName := range unusedInternalBackendServiceNames(loadBalancerName, clusterID, sharedBackend, protocolGroups, svc.Spec.SessionAffinity) {
+			if err := g.teardownInternalBackendService(bsName); err != nil {
+				klog.Warningf("ensureInternalLoadBalancer(%v): could not delete unused backend service: %v, err: %v", loadBalancerName, bsName, err)
+			}
+		}
+	}
+	// The backend services moved from network endpoint groups back to instance groups.
+	if useNEGs, _ := g.usesInternalNEGs(svc); !useNEGs && existingBackendService != nil && backendsUseNEGs(existingBackendService.Backends) {
+		if err := g.ensureInternalNEGsDeleted(loadBalancerName); err != nil {
+			klog.Warningf("ensureInternalLoadBalancer(%v): could not delete unused network endpoint groups, err: %v", loadBalancerName, err)
+		}
 	}
 
 	serviceState.InSuccess = true
@@ -251,11 +385,172 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	}
 	klog.V(6).Infof("Internal Loadbalancer for Service %s ensured, updating its state %v in metrics cache", nm, serviceState)
 
-	status := &v1.LoadBalancerStatus{}
-	status.Ingress = []v1.LoadBalancerIngress{{IP: updatedFwdRule.IPAddress}}
 	return status, nil
 }
 
+// newInternalForwardingRule returns the desired forwarding rule of an internal load balancer.
+func (g *Cloud) newInternalForwardingRule(name, description, ipAddress, backendServiceLink, subnetworkURL string, ports []string, protocol v1.Protocol, options ILBOptions) *compute.ForwardingRule {
+	fwdRule := &compute.ForwardingRule{
+		Name:                name,
+		Description:         description,
+		IPAddress:           ipAddress,
+		BackendService:      backendServiceLink,
+		Ports:               ports,
+		IPProtocol:          string(protocol),
+		LoadBalancingScheme: string(cloud.SchemeInternal),
+		// Given that CreateGCECloud will attempt to determine the subnet based off the network,
+		// the subnetwork should rarely be unknown.
+		Subnetwork: subnetworkURL,
+		Network:    g.networkURL,
+	}
+	if options.AllowGlobalAccess {
+		fwdRule.AllowGlobalAccess = options.AllowGlobalAccess
+	}
+	if len(ports) > maxL4ILBPorts {
+		fwdRule.Ports = nil
+		fwdRule.AllPorts = true
+	}
+	return fwdRule
+}
+
+// newInternalForwardingRules returns the desired forwarding rules of an internal load balancer for
+// one IP family, one per protocol of the service.
+func (g *Cloud) newInternalForwardingRules(loadBalancerName, description, ipAddress, subnetworkURL string, backendServiceNames []string, protocolGroups []protocolPorts, ipVersion IPVersion, options ILBOptions) []*compute.ForwardingRule {
+	var fwdRules []*compute.ForwardingRule
+	for i, group := range protocolGroups {
+		name := makeInternalForwardingRuleName(loadBalancerName, group.protocol, group.primary, ipVersion)
+		ports, _, _ := getPortsAndProtocol(group.ports)
+		fwdRule := g.newInternalForwardingRule(name, description, ipAddress, g.getBackendServiceLink(backendServiceNames[i]), subnetworkURL, ports, group.protocol, options)
+		if ipVersion == IPVersionIPv6 {
+			fwdRule.IpVersion = string(IPVersionIPv6)
+		}
+		fwdRules = append(fwdRules, fwdRule)
+	}
+	return fwdRules
+}
+
+// makeInternalForwardingRuleName returns the name of the forwarding rule serving the protocol
+// for one IP family of an internal load balancer.
+func makeInternalForwardingRuleName(loadBalancerName string, protocol v1.Protocol, primary bool, ipVersion IPVersion) string {
+	name := makeProtocolResourceName(loadBalancerName, protocol, primary)
+	if ipVersion == IPVersionIPv6 {
+		return makeIPv6ResourceName(name)
+	}
+	return name
+}
+
+// unusedInternalForwardingRuleNames returns the names of the forwarding rules of one IP family
+// serving protocols the service does not use anymore. When the IP family is not used at all,
+// none of its non-primary forwarding rules are used.
+func unusedInternalForwardingRuleNames(loadBalancerName string, newFwdRules []*compute.ForwardingRule, protocolGroups []protocolPorts, ipVersion IPVersion) []string {
+	if len(newFwdRules) == 0 {
+		protocolGroups = nil
+	}
+	names := unusedProtocolResourceNames(loadBalancerName, protocolGroups)
+	if ipVersion == IPVersionIPv6 {
+		for i := range names {
+			names[i] = makeIPv6ResourceName(names[i])
+		}
+	}
+	return names
+}
+
+// makeInternalBackendServiceName returns the name of the backend service for the ports of one
+// protocol. Names of shared backend services already contain the protocol.
+func makeInternalBackendServiceName(loadBalancerName, clusterID string, sharedBackend bool, group protocolPorts, affinity v1.ServiceAffinity) string {
+	name := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, cloud.SchemeInternal, group.protocol, affinity)
+	if sharedBackend {
+		return name
+	}
+	return makeProtocolResourceName(name, group.protocol, group.primary)
+}
+
+// unusedInternalBackendServiceNames returns the names of the backend services of protocols the service
+// does not use anymore. Shared backend services may still be used by other services, in which case
+// their deletion fails harmlessly.
+func unusedInternalBackendServiceNames(loadBalancerName, clusterID string, sharedBackend bool, protocolGroups []protocolPorts, affinity v1.ServiceAffinity) []string {
+	if !sharedBackend {
+		return unusedProtocolResourceNames(makeBackendServiceName(loadBalancerName, clusterID, false, cloud.SchemeInternal, "", affinity), protocolGroups)
+	}
+	used := sets.NewString()
+	for _, group := range protocolGroups {
+		used.Insert(makeBackendServiceName(loadBalancerName, clusterID, true, cloud.SchemeInternal, group.protocol, affinity))
+	}
+	var unused []string
+	for _, protocol := range mixedProtocols {
+		if name := makeBackendServiceName(loadBalancerName, clusterID, true, cloud.SchemeInternal, protocol, affinity); !used.Has(name) {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+// deleteChangedInternalForwardingRules deletes the existing forwarding rules of one IP family that no longer
+// match the desired ones, and the forwarding rules with the given unused names. The existing forwarding rule
+// of the primary protocol is passed by the caller, the other ones are looked up.
+// It returns the desired forwarding rules that have to be created.
+func (g *Cloud) deleteChangedInternalForwardingRules(loadBalancerName string, existingFwdRule *compute.ForwardingRule, newFwdRules []*compute.ForwardingRule, unusedFwdRuleNames []string) ([]*compute.ForwardingRule, error) {
+	if len(newFwdRules) == 0 {
+		// The IP family is not used anymore.
+		if _, err := g.deleteChangedInternalForwardingRule(loadBalancerName, existingFwdRule, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var fwdRulesToCreate []*compute.ForwardingRule
+	for i, newFwdRule := range newFwdRules {
+		existing := existingFwdRule
+		if i > 0 {
+			var err error
+			if existing, err = g.GetRegionForwardingRule(newFwdRule.Name, g.region); err != nil {
+				if !isNotFound(err) {
+					return nil, err
+				}
+				existing = nil
+			}
+		}
+		deleted, err := g.deleteChangedInternalForwardingRule(loadBalancerName, existing, newFwdRule)
+		if err != nil {
+			return nil, err
+		}
+		if deleted || existing == nil {
+			fwdRulesToCreate = append(fwdRulesToCreate, newFwdRule)
+		}
+	}
+
+	for _, name := range unusedFwdRuleNames {
+		klog.V(2).Infof("ensureInternalLoadBalancer(%v): deleting forwarding rule %s of unused protocol", loadBalancerName, name)
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(name, g.region)); err != nil {
+			return nil, err
+		}
+	}
+	return fwdRulesToCreate, nil
+}
+
+// deleteChangedInternalForwardingRule deletes the existing forwarding rule if it no longer matches the
+// desired one. A nil desired forwarding rule means the forwarding rule is no longer needed.
+// It returns whether the forwarding rule was deleted.
+func (g *Cloud) deleteChangedInternalForwardingRule(loadBalancerName string, existingFwdRule, newFwdRule *compute.ForwardingRule) (bool, error) {
+	if existingFwdRule == nil {
+		return false, nil
+	}
+	if newFwdRule != nil && forwardingRulesEqual(existingFwdRule, newFwdRule) {
+		return false, nil
+	}
+	if klogV := klog.V(2); klogV.Enabled() {
+		if newFwdRule == nil {
+			klogV.Infof("ensureInternalLoadBalancer(%v): forwarding rule %s is no longer needed. Deleting existing forwarding rule.", loadBalancerName, existingFwdRule.Name)
+		} else {
+			frDiff := cmp.Diff(existingFwdRule, newFwdRule)
+			klogV.Infof("ensureInternalLoadBalancer(%v): forwarding rule changed - Existing - %+v\n, New - %+v\n, Diff(-existing, +new) - %s\n. Deleting existing forwarding rule.", loadBalancerName, existingFwdRule, newFwdRule, frDiff)
+		}
+	}
+	if err := ignoreNotFound(g.DeleteRegionForwardingRule(existingFwdRule.Name, g.region)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func removeNodesInNonDefaultNetworks(nodes []*v1.Node, defaultSubnetName string) []*v1.Node {
 	var newList []*v1.Node
 	var skippedNodes []string
@@ -334,22 +629,32 @@ func (g *Cloud) updateInternalLoadBalancer(clusterName, clusterID string, svc *v
 		klog.V(2).Infof("Skipped updateInternalLoadBalancer for service %s/%s since it does not contain %q finalizer.", svc.Namespace, svc.Name, ILBFinalizerV1)
 		return cloudprovider.ImplementedElsewhere
 	}
-	g.sharedResourceLock.Lock()
-	defer g.sharedResourceLock.Unlock()
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
 
-	igName := makeInstanceGroupName(clusterID)
-	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	// Generate the backend service names, one per protocol of the service
+	protocolGroups, err := g.getProtocolGroups(svc.Spec.Ports, loadBalancerName, makeIPv6ResourceName(loadBalancerName))
 	if err != nil {
 		return err
 	}
+	backendServiceNames := make([]string, len(protocolGroups))
+	for i, group := range protocolGroups {
+		backendServiceNames[i] = makeInternalBackendServiceName(loadBalancerName, clusterID, shareBackendService(svc), group, svc.Spec.SessionAffinity)
+	}
 
-	// Generate the backend service name
-	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
-	scheme := cloud.SchemeInternal
-	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, shareBackendService(svc), scheme, protocol, svc.Spec.SessionAffinity)
-	// Ensure the backend service has the proper backend/instance-group links
-	return g.ensureInternalBackendServiceGroups(backendServiceName, igLinks)
+	unlock := g.sharedResourceLocks.lock(g.internalBackendLockKeys(svc, loadBalancerName, clusterID, backendServiceNames)...)
+	defer unlock()
+
+	backendLinks, _, err := g.ensureInternalBackends(loadBalancerName, clusterID, svc, nodes)
+	if err != nil {
+		return err
+	}
+	for _, backendServiceName := range backendServiceNames {
+		// Ensure the backend service has the proper backend/instance-group links
+		if err := g.ensureInternalBackendServiceGroups(backendServiceName, backendLinks); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string, svc *v1.Service) error {
@@ -358,27 +663,61 @@ func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string,
 	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
 	scheme := cloud.SchemeInternal
 	sharedBackend := shareBackendService(svc)
-	sharedHealthCheck := !servicehelpers.RequestsOnlyLocalTraffic(svc)
+	sharedHealthCheck := usesNodesHealthCheck(svc)
 
-	g.sharedResourceLock.Lock()
-	defer g.sharedResourceLock.Unlock()
+	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, svc.Spec.SessionAffinity)
+	backendServiceNames := []string{backendServiceName}
+	for _, name := range unusedInternalBackendServiceNames(loadBalancerName, clusterID, sharedBackend, nil, svc.Spec.SessionAffinity) {
+		if name != backendServiceName {
+			backendServiceNames = append(backendServiceNames, name)
+		}
+	}
+	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
+	igName := makeInstanceGroupName(clusterID)
+
+	// The instance groups are deleted if they are not used anymore, even by load balancers backed
+	// by network endpoint groups which may have been backed by instance groups before.
+	lockKeys := []string{instanceGroupLockKey(igName), healthCheckLockKey(hcName)}
+	if sharedBackend {
+		for _, name := range backendServiceNames {
+			lockKeys = append(lockKeys, backendServiceLockKey(name))
+		}
+	}
+	unlock := g.sharedResourceLocks.lock(lockKeys...)
+	defer unlock()
 
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): attempting delete of region internal address", loadBalancerName)
 	ensureAddressDeleted(g, loadBalancerName, g.region)
 
-	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region internal forwarding rule", loadBalancerName)
-	if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
-		return err
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region internal forwarding rules", loadBalancerName)
+	for _, fwdRuleName := range append([]string{loadBalancerName}, unusedProtocolResourceNames(loadBalancerName, nil)...) {
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(fwdRuleName, g.region)); err != nil {
+			return err
+		}
 	}
 
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, svc.Spec.SessionAffinity)
-	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region backend service %v", loadBalancerName, backendServiceName)
-	if err := g.teardownInternalBackendService(backendServiceName); err != nil {
-		return err
+	// The IPv6 resources are deleted even if the cluster does not support IPv6 anymore, as they
+	// may have been created before its stack type changed.
+	ipv6FwdRuleName := makeIPv6ResourceName(loadBalancerName)
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): attempting delete of region internal IPv6 address", loadBalancerName)
+	ensureAddressDeleted(g, ipv6FwdRuleName, g.region)
+
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region internal IPv6 forwarding rules", loadBalancerName)
+	for _, fwdRuleName := range append([]string{ipv6FwdRuleName}, unusedInternalForwardingRuleNames(loadBalancerName, nil, nil, IPVersionIPv6)...) {
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(fwdRuleName, g.region)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range backendServiceNames {
+		klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region backend service %v", loadBalancerName, name)
+		if err := g.teardownInternalBackendService(name); err != nil {
+			return err
+		}
 	}
 
 	deleteFunc := func(fwName string) error {
-		if err := ignoreNotFound(g.DeleteFirewall(fwName)); err != nil {
+		if err := ignoreNotFound(g.deleteLoadBalancerFirewall(fwName)); err != nil {
 			if isForbidden(err) && g.OnXPN() {
 				klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): could not delete traffic firewall on XPN cluster. Raising event.", loadBalancerName)
 				g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
@@ -391,29 +730,39 @@ func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string,
 	fwName := MakeFirewallName(loadBalancerName)
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting firewall %s for traffic",
 		loadBalancerName, fwName)
-	if err := deleteFunc(fwName); err != nil {
+	if err := g.deleteFirewallShards(svc, fwName, 0); err != nil {
 		return err
 	}
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting legacy name firewall for traffic", loadBalancerName)
 	if err := deleteFunc(loadBalancerName); err != nil {
 		return err
 	}
+	ipv6FwName := makeIPv6ResourceName(fwName)
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting firewall %s for IPv6 traffic", loadBalancerName, ipv6FwName)
+	if err := g.deleteFirewallShards(svc, ipv6FwName, 0); err != nil {
+		return err
+	}
 
-	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting health check %v and its firewall", loadBalancerName, hcName)
 	if err := g.teardownInternalHealthCheckAndFirewall(svc, hcName); err != nil {
 		return err
 	}
 
+	if useNEGs, _ := g.usesInternalNEGs(svc); useNEGs {
+		klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting network endpoint groups", loadBalancerName)
+		if err := g.ensureInternalNEGsDeleted(loadBalancerName); err != nil {
+			return err
+		}
+	}
+
 	// Try deleting instance groups - expect ResourceInuse error if needed by other LBs
-	igName := makeInstanceGroupName(clusterID)
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): Attempting delete of instanceGroup %v", loadBalancerName, igName)
 	if err := g.ensureInternalInstanceGroupsDeleted(igName); err != nil && !isInUsedByError(err) {
 		return err
 	}
 
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): Removing %q finalizer", loadBalancerName, ILBFinalizerV1)
-	if err := removeFinalizer(svc, g.client.CoreV1(), ILBFinalizerV1); err != nil {
+	if err := removeFinalizer(svc, g.serviceClient(), ILBFinalizerV1); err != nil {
 		klog.Errorf("Failed to remove finalizer '%s' on service %s - %v", ILBFinalizerV1, svcNamespacedName, err)
 		return err
 	}
@@ -454,7 +803,7 @@ func (g *Cloud) teardownInternalHealthCheckAndFirewall(svc *v1.Service, hcName s
 	klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): health check deleted", hcName)
 
 	hcFirewallName := makeHealthCheckFirewallNameFromHC(hcName)
-	if err := ignoreNotFound(g.DeleteFirewall(hcFirewallName)); err != nil {
+	if err := ignoreNotFound(g.deleteLoadBalancerFirewall(hcFirewallName)); err != nil {
 		if isForbidden(err) && g.OnXPN() {
 			klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): could not delete health check traffic firewall on XPN cluster. Raising Event.", hcName)
 			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(hcFirewallName, g.NetworkProjectID()))
@@ -464,17 +813,46 @@ func (g *Cloud) teardownInternalHealthCheckAndFirewall(svc *v1.Service, hcName s
 		return fmt.Errorf("failed to delete health check firewall: %v, err: %v", hcFirewallName, err)
 	}
 	klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): health check firewall deleted", hcFirewallName)
+
+	ipv6HCFirewallName := makeIPv6ResourceName(hcFirewallName)
+	if err := ignoreNotFound(g.deleteLoadBalancerFirewall(ipv6HCFirewallName)); err != nil {
+		if isForbidden(err) && g.OnXPN() {
+			klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): could not delete IPv6 health check traffic firewall on XPN cluster. Raising Event.", hcName)
+			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(ipv6HCFirewallName, g.NetworkProjectID()))
+			return nil
+		}
+
+		return fmt.Errorf("failed to delete IPv6 health check firewall: %v, err: %v", ipv6HCFirewallName, err)
+	}
+	klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): IPv6 health check firewall deleted", ipv6HCFirewallName)
 	return nil
 }
 
 func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinationIP string, sourceRanges []string, portRanges []string, protocol v1.Protocol, nodes []*v1.Node, legacyFwName string) error {
+	allowed := []*compute.FirewallAllowed{
+		{
+			IPProtocol: strings.ToLower(string(protocol)),
+			Ports:      portRanges,
+		},
+	}
+	// Health check firewalls only follow the cloud config, as they can be shared.
+	fwConfig, err := g.loadBalancerFirewallConfig(nil)
+	if err != nil {
+		return err
+	}
+	return g.ensureInternalFirewallAllowed(svc, fwName, fwDesc, destinationIP, sourceRanges, allowed, nodes, legacyFwName, fwConfig)
+}
+
+// ensureInternalFirewallAllowed ensures the firewall allows the given protocols and ports, as
+// needed by the traffic firewall of a load balancer mixing protocols.
+func (g *Cloud) ensureInternalFirewallAllowed(svc *v1.Service, fwName, fwDesc, destinationIP string, sourceRanges []string, allowed []*compute.FirewallAllowed, nodes []*v1.Node, legacyFwName string, fwConfig FirewallConfig) error {
 	klog.V(2).Infof("ensureInternalFirewall(%v): checking existing firewall", fwName)
-	targetTags, err := g.GetNodeTags(nodeNames(nodes))
+	targetTags, err := g.loadBalancerFirewallTargetTags(svc, nodes)
 	if err != nil {
 		return err
 	}
 
-	existingFirewall, err := g.GetFirewall(fwName)
+	existingFirewall, err := g.getLoadBalancerFirewall(fwName)
 	if err != nil && !isNotFound(err) {
 		return err
 	}
@@ -482,7 +860,7 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 	// have triggered service sync and deletion of the legacy rules.
 	if legacyFwName != "" {
 		// Check for firewall named with the legacy naming scheme and delete if found.
-		legacyFirewall, err := g.GetFirewall(legacyFwName)
+		legacyFirewall, err := g.getLoadBalancerFirewall(legacyFwName)
 		if err != nil && !isNotFound(err) {
 			return err
 		}
@@ -490,7 +868,7 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 			// Delete the legacyFirewall rule if the new one was already created. If not, it will be deleted in the
 			// next sync or when the service is deleted.
 			defer func() {
-				err = g.DeleteFirewall(legacyFwName)
+				err = g.deleteLoadBalancerFirewall(legacyFwName)
 				if err != nil {
 					klog.Errorf("Failed to delete legacy firewall %s for service %s/%s, err %v",
 						legacyFwName, svc.Namespace, svc.Name, err)
@@ -502,27 +880,10 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 		}
 	}
 
-	expectedFirewall := &compute.Firewall{
-		Name:         fwName,
-		Description:  fwDesc,
-		Network:      g.networkURL,
-		SourceRanges: sourceRanges,
-		TargetTags:   targetTags,
-		Allowed: []*compute.FirewallAllowed{
-			{
-				IPProtocol: strings.ToLower(string(protocol)),
-				Ports:      portRanges,
-			},
-		},
-	}
-
-	if destinationIP != "" {
-		expectedFirewall.DestinationRanges = []string{destinationIP}
-	}
-
+	expectedFirewall := g.newInternalFirewall(fwName, fwDesc, destinationIP, sourceRanges, allowed, targetTags, fwConfig)
 	if existingFirewall == nil {
 		klog.V(2).Infof("ensureInternalFirewall(%v): creating firewall", fwName)
-		err = g.CreateFirewall(expectedFirewall)
+		err = g.createLoadBalancerFirewall(expectedFirewall)
 		if err != nil && isForbidden(err) && g.OnXPN() {
 			klog.V(2).Infof("ensureInternalFirewall(%v): do not have permission to create firewall rule (on XPN). Raising event.", fwName)
 			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudCreateCmd(expectedFirewall, g.NetworkProjectID()))
@@ -536,7 +897,7 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 	}
 
 	klog.V(2).Infof("ensureInternalFirewall(%v): updating firewall", fwName)
-	err = g.PatchFirewall(expectedFirewall)
+	err = g.patchLoadBalancerFirewall(expectedFirewall)
 	if err != nil && isForbidden(err) && g.OnXPN() {
 		klog.V(2).Infof("ensureInternalFirewall(%v): do not have permission to update firewall rule (on XPN). Raising event.", fwName)
 		g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudUpdateCmd(expectedFirewall, g.NetworkProjectID()))
@@ -545,15 +906,36 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 	return err
 }
 
+// newInternalFirewall returns the desired firewall of a load balancer allowing traffic
+// from the source ranges to the nodes with the target tags.
+func (g *Cloud) newInternalFirewall(fwName, fwDesc, destinationIP string, sourceRanges []string, allowed []*compute.FirewallAllowed, targetTags []string, fwConfig FirewallConfig) *compute.Firewall {
+	firewall := &compute.Firewall{
+		Name:         fwName,
+		Description:  fwDesc,
+		Network:      g.networkURL,
+		SourceRanges: sourceRanges,
+		TargetTags:   targetTags,
+		Allowed:      allowed,
+	}
+	if destinationIP != "" {
+		firewall.DestinationRanges = []string{destinationIP}
+	}
+	fwConfig.apply(firewall)
+	return firewall
+}
+
 func (g *Cloud) ensureInternalFirewalls(loadBalancerName, ipAddress, clusterID string, nm types.NamespacedName, svc *v1.Service, healthCheckPort string, sharedHealthCheck bool, nodes []*v1.Node) error {
 	// First firewall is for ingress traffic
-	fwDesc := makeFirewallDescription(nm.String(), ipAddress)
-	_, portRanges, protocol := getPortsAndProtocol(svc.Spec.Ports)
+	fwDesc := makeFirewallDescription(loadBalancerName, nm.String(), ipAddress, clusterID)
 	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
 	if err != nil {
 		return err
 	}
-	err = g.ensureInternalFirewall(svc, MakeFirewallName(loadBalancerName), fwDesc, ipAddress, sourceRanges.StringSlice(), portRanges, protocol, nodes, loadBalancerName)
+	fwConfig, err := g.loadBalancerFirewallConfig(svc)
+	if err != nil {
+		return err
+	}
+	err = g.ensureInternalFirewallShards(svc, MakeFirewallName(loadBalancerName), fwDesc, ipAddress, sourceRanges.StringSlice(), firewallAllowedForPorts(svc.Spec.Ports), nodes, loadBalancerName, fwConfig)
 	if err != nil {
 		return err
 	}
@@ -564,9 +946,9 @@ func (g *Cloud) ensureInternalFirewalls(loadBalancerName, ipAddress, clusterID s
 	return g.ensureInternalFirewall(svc, fwHCName, "", "", hcSrcRanges, []string{healthCheckPort}, v1.ProtocolTCP, nodes, "")
 }
 
-func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32) (*compute.HealthCheck, error) {
+func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32, params *HealthCheckParams) (*compute.HealthCheck, error) {
 	klog.V(2).Infof("ensureInternalHealthCheck(%v, %v, %v): checking existing health check", name, path, port)
-	expectedHC := newInternalLBHealthCheck(name, svcName, shared, path, port)
+	expectedHC := newInternalLBHealthCheck(name, svcName, shared, path, port, params)
 
 	hc, err := g.GetHealthCheck(name)
 	if err != nil && !isNotFound(err) {
@@ -587,9 +969,9 @@ func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedN
 		return hc, nil
 	}
 
-	if needToUpdateHealthChecks(hc, expectedHC) {
+	if needToUpdateHealthChecks(hc, expectedHC, params != nil) {
 		klog.V(2).Infof("ensureInternalHealthCheck: health check %v exists but parameters have drifted - updating...", name)
-		mergeHealthChecks(hc, expectedHC)
+		mergeHealthChecks(hc, expectedHC, params != nil)
 		if err := g.UpdateHealthCheck(expectedHC); err != nil {
 			klog.Warningf("Failed to reconcile http health check %v parameters", name)
 			return nil, err
@@ -605,11 +987,52 @@ func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedN
 
 func (g *Cloud) ensureInternalInstanceGroup(name, zone string, nodes []*v1.Node) (string, error) {
 	klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): checking group that it contains %v nodes [node names limited, total number of nodes: %d]", name, zone, loggableNodeNames(nodes), len(nodes))
-	ig, err := g.GetInstanceGroup(name, zone)
-	if err != nil && !isNotFound(err) {
+	ig, addNodes, removeNodes, err := g.instanceGroupNodeChanges(name, zone, nodes)
+	if err != nil {
 		return "", err
 	}
 
+	if ig == nil {
+		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): creating instance group", name, zone)
+		newIG := &compute.InstanceGroup{Name: name}
+		if err = g.CreateInstanceGroup(newIG, zone); err != nil {
+			return "", err
+		}
+
+		ig, err = g.GetInstanceGroup(name, zone)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, batch := range batches(removeNodes, maxInstancesPerInstanceGroupUpdate) {
+		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): removing nodes: %v", name, zone, batch)
+		instanceRefs := g.ToInstanceReferences(zone, batch)
+		// Possible we'll receive 404's here if the instance was deleted before getting to this point.
+		if err = g.RemoveInstancesFromInstanceGroup(name, zone, instanceRefs); err != nil && !isNotFound(err) {
+			return "", err
+		}
+	}
+
+	for _, batch := range batches(addNodes, maxInstancesPerInstanceGroupUpdate) {
+		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): adding nodes: %v", name, zone, batch)
+		instanceRefs := g.ToInstanceReferences(zone, batch)
+		if err = g.AddInstancesToInstanceGroup(name, zone, instanceRefs); err != nil {
+			return "", err
+		}
+	}
+
+	return ig.SelfLink, nil
+}
+
+// instanceGroupNodeChanges returns the instance group, or nil if it does not exist, and the
+// nodes to add to and remove from it so that it contains the given nodes.
+func (g *Cloud) instanceGroupNodeChanges(name, zone string, nodes []*v1.Node) (ig *compute.InstanceGroup, addNodes, removeNodes []string, err error) {
+	ig, err = g.GetInstanceGroup(name, zone)
+	if err != nil && !isNotFound(err) {
+		return nil, nil, nil, err
+	}
+
 	kubeNodes := sets.NewString()
 	for _, n := range nodes {
 		kubeNodes.Insert(n.Name)
@@ -627,21 +1050,10 @@ func (g *Cloud) ensureInternalInstanceGroup(name, zone string, nodes []*v1.Node)
 	}
 
 	gceNodes := sets.NewString()
-	if ig == nil {
-		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): creating instance group", name, zone)
-		newIG := &compute.InstanceGroup{Name: name}
-		if err = g.CreateInstanceGroup(newIG, zone); err != nil {
-			return "", err
-		}
-
-		ig, err = g.GetInstanceGroup(name, zone)
-		if err != nil {
-			return "", err
-		}
-	} else {
+	if ig != nil {
 		instances, err := g.ListInstancesInInstanceGroup(name, zone, allInstances)
 		if err != nil {
-			return "", err
+			return nil, nil, nil, err
 		}
 
 		for _, ins := range instances {
@@ -650,32 +1062,56 @@ func (g *Cloud) ensureInternalInstanceGroup(name, zone string, nodes []*v1.Node)
 		}
 	}
 
-	removeNodes := gceNodes.Difference(kubeNodes).List()
-	addNodes := kubeNodes.Difference(gceNodes).List()
+	return ig, kubeNodes.Difference(gceNodes).List(), gceNodes.Difference(kubeNodes).List(), nil
+}
 
-	if len(removeNodes) != 0 {
-		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): removing nodes: %v", name, zone, removeNodes)
-		instanceRefs := g.ToInstanceReferences(zone, removeNodes)
-		// Possible we'll receive 404's here if the instance was deleted before getting to this point.
-		if err = g.RemoveInstancesFromInstanceGroup(name, zone, instanceRefs); err != nil && !isNotFound(err) {
-			return "", err
+// ensureInternalInstanceGroups generates an unmanaged instance group for every zone
+// where a K8s node exists. It also ensures that each node belongs to an instance group.
+// The zones are handled in parallel, see updateConcurrency.
+func (g *Cloud) ensureInternalInstanceGroups(name string, nodes []*v1.Node) ([]string, error) {
+	zonedNodes := g.instanceGroupNodesByZone(nodes)
+	klog.V(2).Infof("ensureInternalInstanceGroups(%v): %d nodes over %d zones in region %v", name, len(nodes), len(zonedNodes), g.region)
+	zones := make([]string, 0, len(zonedNodes))
+	for zone := range zonedNodes {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	zoneLinks := make([][]string, len(zones))
+	err := g.parallelize(len(zones), func(i int) error {
+		zone := zones[i]
+		if g.AlphaFeatureGate.Enabled(AlphaFeatureSkipIGsManagement) {
+			igs, err := g.FilterInstanceGroupsByNamePrefix(name, zone)
+			if err != nil {
+				return err
+			}
+			for _, ig := range igs {
+				zoneLinks[i] = append(zoneLinks[i], ig.SelfLink)
+			}
+			return nil
 		}
-	}
-
-	if len(addNodes) != 0 {
-		klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): adding nodes: %v", name, zone, addNodes)
-		instanceRefs := g.ToInstanceReferences(zone, addNodes)
-		if err = g.AddInstancesToInstanceGroup(name, zone, instanceRefs); err != nil {
-			return "", err
+		start := time.Now()
+		igLink, err := g.ensureInternalInstanceGroup(name, zone, zonedNodes[zone])
+		instanceGroupUpdateLatency.WithLabelValues(zone).Observe(time.Since(start).Seconds())
+		if err != nil {
+			return err
 		}
+		zoneLinks[i] = []string{igLink}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return ig.SelfLink, nil
+	var igLinks []string
+	for _, links := range zoneLinks {
+		igLinks = append(igLinks, links...)
+	}
+	return igLinks, nil
 }
 
-// ensureInternalInstanceGroups generates an unmanaged instance group for every zone
-// where a K8s node exists. It also ensures that each node belongs to an instance group
-func (g *Cloud) ensureInternalInstanceGroups(name string, nodes []*v1.Node) ([]string, error) {
+// instanceGroupNodesByZone returns the nodes of the default subnetwork, which belong to
+// the instance groups of the cluster, split by zone.
+func (g *Cloud) instanceGroupNodesByZone(nodes []*v1.Node) map[string][]*v1.Node {
 	defaultSubnetName, err := subnetNameFromURL(g.SubnetworkURL())
 	// Perform node filtering only if the subnet URL is valid. Do not stop execution in case some clusters have invalid SubnetworkURL configured.
 	if err == nil {
@@ -686,29 +1122,7 @@ func (g *Cloud) ensureInternalInstanceGroups(name string, nodes []*v1.Node) ([]s
 	} else {
 		klog.Errorf("invalid subnetwork URL configured for the controller, assuming all nodes are in the default subnetwork %s, err: %v", g.SubnetworkURL(), err)
 	}
-
-	zonedNodes := splitNodesByZone(nodes)
-	klog.V(2).Infof("ensureInternalInstanceGroups(%v): %d nodes over %d zones in region %v", name, len(nodes), len(zonedNodes), g.region)
-	var igLinks []string
-	for zone, nodes := range zonedNodes {
-		if g.AlphaFeatureGate.Enabled(AlphaFeatureSkipIGsManagement) {
-			igs, err := g.FilterInstanceGroupsByNamePrefix(name, zone)
-			if err != nil {
-				return nil, err
-			}
-			for _, ig := range igs {
-				igLinks = append(igLinks, ig.SelfLink)
-			}
-		} else {
-			igLink, err := g.ensureInternalInstanceGroup(name, zone, nodes)
-			if err != nil {
-				return nil, err
-			}
-			igLinks = append(igLinks, igLink)
-		}
-	}
-
-	return igLinks, nil
+	return splitNodesByZone(nodes)
 }
 
 func (g *Cloud) ensureInternalInstanceGroupsDeleted(name string) error {
@@ -730,23 +1144,14 @@ func (g *Cloud) ensureInternalInstanceGroupsDeleted(name string) error {
 	return nil
 }
 
-func (g *Cloud) ensureInternalBackendService(name, description string, affinityType v1.ServiceAffinity, scheme cloud.LbScheme, protocol v1.Protocol, igLinks []string, hcLink string) error {
+func (g *Cloud) ensureInternalBackendService(name, description string, affinity backendServiceAffinity, scheme cloud.LbScheme, protocol v1.Protocol, igLinks []string, hcLink string) error {
 	klog.V(2).Infof("ensureInternalBackendService(%v, %v, %v): checking existing backend service with %d groups", name, scheme, protocol, len(igLinks))
 	bs, err := g.GetRegionBackendService(name, g.region)
 	if err != nil && !isNotFound(err) {
 		return err
 	}
 
-	backends := backendsFromGroupLinks(igLinks)
-	expectedBS := &compute.BackendService{
-		Name:                name,
-		Protocol:            string(protocol),
-		Description:         description,
-		HealthChecks:        []string{hcLink},
-		Backends:            backends,
-		SessionAffinity:     translateAffinityType(affinityType),
-		LoadBalancingScheme: string(scheme),
-	}
+	expectedBS := newInternalBackendService(name, description, affinity, scheme, protocol, igLinks, hcLink)
 
 	// Create backend service if none was found
 	if bs == nil {
@@ -773,6 +1178,88 @@ func (g *Cloud) ensureInternalBackendService(name, description string, affinityT
 	return nil
 }
 
+// newInternalBackendService returns the desired regional backend service of a load balancer.
+func newInternalBackendService(name, description string, affinity backendServiceAffinity, scheme cloud.LbScheme, protocol v1.Protocol, igLinks []string, hcLink string) *compute.BackendService {
+	bs := &compute.BackendService{
+		Name:                name,
+		Protocol:            string(protocol),
+		Description:         description,
+		HealthChecks:        []string{hcLink},
+		Backends:            backendsFromGroupLinks(igLinks),
+		SessionAffinity:     affinity.sessionAffinity,
+		LoadBalancingScheme: string(scheme),
+	}
+	if affinity.idleTimeoutSec > 0 {
+		// GCE only honors the idle timeout of connections tracked per session.
+		bs.ConnectionTrackingPolicy = &compute.BackendServiceConnectionTrackingPolicy{
+			TrackingMode:   connectionTrackingModePerSession,
+			IdleTimeoutSec: affinity.idleTimeoutSec,
+		}
+	}
+	return bs
+}
+
+// connectionTrackingIdleTimeout returns the idle timeout of the connections tracked per session by
+// the backend service, 0 if GCE defaults it.
+func connectionTrackingIdleTimeout(bs *compute.BackendService) int64 {
+	if bs.ConnectionTrackingPolicy == nil || bs.ConnectionTrackingPolicy.TrackingMode != connectionTrackingModePerSession {
+		return 0
+	}
+	return bs.ConnectionTrackingPolicy.IdleTimeoutSec
+}
+
+// backendServiceAffinity is the session affinity of a regional backend service.
+type backendServiceAffinity struct {
+	// sessionAffinity is the GCE session affinity.
+	sessionAffinity string
+	// idleTimeoutSec is the idle timeout of the tracked connections, 0 for the GCE default.
+	idleTimeoutSec int64
+}
+
+// newBackendServiceAffinity returns the session affinity of a backend service translated from the
+// session affinity of a service, with the default idle timeout.
+func newBackendServiceAffinity(affinityType v1.ServiceAffinity) backendServiceAffinity {
+	return backendServiceAffinity{sessionAffinity: translateAffinityType(affinityType)}
+}
+
+// internalBackendServiceAffinity returns the session affinity of the internal backend services of the
+// service. The ClientIP timeout of the service is the idle timeout of the tracked connections, within
+// the bounds supported by GCE. GCE does not support it with CLIENT_IP_PORT_PROTO. Shared backend
+// services keep the default idle timeout, as their name only depends on the session affinity type.
+func internalBackendServiceAffinity(svc *v1.Service) (backendServiceAffinity, error) {
+	annotated, err := GetLoadBalancerAnnotationILBSessionAffinity(svc)
+	if err != nil {
+		return backendServiceAffinity{}, err
+	}
+	affinity := newBackendServiceAffinity(svc.Spec.SessionAffinity)
+	if shareBackendService(svc) {
+		return affinity, nil
+	}
+	if annotated != "" {
+		affinity.sessionAffinity = annotated
+	}
+	if affinity.sessionAffinity == gceAffinityTypeNone || affinity.sessionAffinity == gceAffinityTypeClientIPPortProto {
+		return affinity, nil
+	}
+	if timeout := clientIPAffinityTimeout(svc); timeout != nil {
+		affinity.idleTimeoutSec = int64(*timeout)
+		if affinity.idleTimeoutSec < minILBAffinityTimeoutSec {
+			affinity.idleTimeoutSec = minILBAffinityTimeoutSec
+		} else if affinity.idleTimeoutSec > maxILBAffinityTimeoutSec {
+			affinity.idleTimeoutSec = maxILBAffinityTimeoutSec
+		}
+	}
+	return affinity, nil
+}
+
+// clientIPAffinityTimeout returns the ClientIP session affinity timeout of the service, nil if unset.
+func clientIPAffinityTimeout(svc *v1.Service) *int32 {
+	if svc.Spec.SessionAffinity != v1.ServiceAffinityClientIP || svc.Spec.SessionAffinityConfig == nil || svc.Spec.SessionAffinityConfig.ClientIP == nil {
+		return nil
+	}
+	return svc.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds
+}
+
 // ensureInternalBackendServiceGroups updates backend services if their list of backend instance groups is incorrect.
 func (g *Cloud) ensureInternalBackendServiceGroups(name string, igLinks []string) error {
 	klog.V(2).Infof("ensureInternalBackendServiceGroups(%v): checking existing backend service's groups", name)
@@ -797,7 +1284,20 @@ func (g *Cloud) ensureInternalBackendServiceGroups(name string, igLinks []string
 	return nil
 }
 
+// shareBackendService returns whether the backend service of the load balancer is shared with other
+// load balancers. Load balancers backed by their own network endpoint groups, or whose session affinity
+// is not entirely described by its type, never share it: the name of a shared backend service only
+// depends on the session affinity type, which also determines its GCE session affinity and idle timeout.
 func shareBackendService(svc *v1.Service) bool {
+	if backendType, _ := internalBackendType(svc); backendType == ILBBackendTypeNEG {
+		return false
+	}
+	if _, ok := svc.Annotations[ServiceAnnotationILBSessionAffinity]; ok {
+		return false
+	}
+	if timeout := clientIPAffinityTimeout(svc); timeout != nil && *timeout != v1.DefaultClientIPServiceAffinitySeconds {
+		return false
+	}
 	return GetLoadBalancerAnnotationBackendShare(svc) && !servicehelpers.RequestsOnlyLocalTraffic(svc)
 }
 
@@ -810,21 +1310,36 @@ func backendsFromGroupLinks(igLinks []string) (backends []*compute.Backend) {
 	return backends
 }
 
-func newInternalLBHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32) *compute.HealthCheck {
+// l4HealthCheckPathPort returns whether the health check of the load balancer is shared, and the
+// path and port it checks. The nodes health check is shared if externalTrafficPolicy=Cluster,
+// unless the service selects the nodes of its load balancer, see usesNodesHealthCheck.
+func l4HealthCheckPathPort(svc *v1.Service) (shared bool, path string, port int32) {
+	if servicehelpers.RequestsOnlyLocalTraffic(svc) {
+		// Service requires a special health check, retrieve the OnlyLocal port & path
+		path, port = servicehelpers.GetServiceHealthCheckPathPort(svc)
+		return false, path, port
+	}
+	return usesNodesHealthCheck(svc), GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
+}
+
+// newInternalLBHealthCheck returns the desired health check, tuned with params or with the
+// default tuning if params is nil.
+func newInternalLBHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32, params *HealthCheckParams) *compute.HealthCheck {
 	httpSettings := compute.HTTPHealthCheck{
 		Port:        int64(port),
 		RequestPath: path,
 	}
 	desc := ""
 	if !shared {
-		desc = makeHealthCheckDescription(svcName.String())
+		desc = makeHealthCheckDescription(svcName.String(), params != nil)
 	}
+	p := healthCheckParamsOrDefault(params)
 	return &compute.HealthCheck{
 		Name:               name,
-		CheckIntervalSec:   gceHcCheckIntervalSeconds,
-		TimeoutSec:         gceHcTimeoutSeconds,
-		HealthyThreshold:   gceHcHealthyThreshold,
-		UnhealthyThreshold: gceHcUnhealthyThreshold,
+		CheckIntervalSec:   p.CheckIntervalSec,
+		TimeoutSec:         p.TimeoutSec,
+		HealthyThreshold:   p.HealthyThreshold,
+		UnhealthyThreshold: p.UnhealthyThreshold,
 		HttpHealthCheck:    &httpSettings,
 		Type:               "HTTP",
 		Description:        desc,
@@ -833,12 +1348,32 @@ func newInternalLBHealthCheck(name string, svcName types.NamespacedName, shared
 
 func firewallRuleEqual(a, b *compute.Firewall) bool {
 	return a.Description == b.Description &&
-		len(a.Allowed) == 1 && len(a.Allowed) == len(b.Allowed) &&
-		a.Allowed[0].IPProtocol == b.Allowed[0].IPProtocol &&
-		equalStringSets(a.Allowed[0].Ports, b.Allowed[0].Ports) &&
+		firewallAllowedEqual(a.Allowed, b.Allowed) &&
 		equalStringSets(a.SourceRanges, b.SourceRanges) &&
 		equalStringSets(a.DestinationRanges, b.DestinationRanges) &&
-		equalStringSets(a.TargetTags, b.TargetTags)
+		equalStringSets(a.TargetTags, b.TargetTags) &&
+		firewallConfigEqual(a, b)
+}
+
+// firewallAllowedEqual returns true if both firewalls allow the same ports for the same
+// protocols, in any order.
+func firewallAllowedEqual(a, b []*compute.FirewallAllowed) bool {
+	if len(a) == 0 || len(a) != len(b) {
+		return false
+	}
+	for _, allowedA := range a {
+		found := false
+		for _, allowedB := range b {
+			if allowedA.IPProtocol == allowedB.IPProtocol {
+				found = equalStringSets(allowedA.Ports, allowedB.Ports)
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // mergeHealthChecks reconciles HealthCheck configures to be no smaller than
@@ -847,7 +1382,12 @@ func firewallRuleEqual(a, b *compute.Firewall) bool {
 // The HC interval will be reconciled to 8 seconds.
 // If the existing health check is larger than the default interval,
 // the configuration will be kept.
-func mergeHealthChecks(hc, newHC *compute.HealthCheck) {
+// Health checks tuned by the service annotations keep the tuned values, and health
+// checks that were tuned are reset to the default values.
+func mergeHealthChecks(hc, newHC *compute.HealthCheck, tuned bool) {
+	if tuned || isTunedHealthCheckDescription(hc.Description) {
+		return
+	}
 	if hc.CheckIntervalSec > newHC.CheckIntervalSec {
 		newHC.CheckIntervalSec = hc.CheckIntervalSec
 	}
@@ -863,7 +1403,8 @@ func mergeHealthChecks(hc, newHC *compute.HealthCheck) {
 }
 
 // needToUpdateHealthChecks checks whether the healthcheck needs to be updated.
-func needToUpdateHealthChecks(hc, newHC *compute.HealthCheck) bool {
+// Health checks tuned by the service annotations are updated whenever their tuning differs.
+func needToUpdateHealthChecks(hc, newHC *compute.HealthCheck, tuned bool) bool {
 	switch {
 	case
 		hc.HttpHealthCheck == nil,
@@ -871,6 +1412,8 @@ func needToUpdateHealthChecks(hc, newHC *compute.HealthCheck) bool {
 		hc.HttpHealthCheck.Port != newHC.HttpHealthCheck.Port,
 		hc.HttpHealthCheck.RequestPath != newHC.HttpHealthCheck.RequestPath,
 		hc.Description != newHC.Description,
+		tuned && (hc.CheckIntervalSec != newHC.CheckIntervalSec || hc.TimeoutSec != newHC.TimeoutSec ||
+			hc.UnhealthyThreshold != newHC.UnhealthyThreshold || hc.HealthyThreshold != newHC.HealthyThreshold),
 		hc.CheckIntervalSec < newHC.CheckIntervalSec,
 		hc.TimeoutSec < newHC.TimeoutSec,
 		hc.UnhealthyThreshold < newHC.UnhealthyThreshold,
@@ -901,10 +1444,14 @@ func backendsListEqual(a, b []*compute.Backend) bool {
 	return aSet.Equal(bSet)
 }
 
+// backendSvcEqual returns whether the existing backend service b matches the expected backend service a.
+// The idle timeout of the connections tracked per session is compared, connections tracked otherwise
+// have the GCE default.
 func backendSvcEqual(a, b *compute.BackendService) bool {
 	return a.Protocol == b.Protocol &&
 		a.Description == b.Description &&
 		a.SessionAffinity == b.SessionAffinity &&
+		connectionTrackingIdleTimeout(a) == connectionTrackingIdleTimeout(b) &&
 		a.LoadBalancingScheme == b.LoadBalancingScheme &&
 		equalStringSets(a.HealthChecks, b.HealthChecks) &&
 		backendsListEqual(a.Backends, b.Backends)
@@ -982,11 +1529,11 @@ func getNameFromLink(link string) string {
 }
 
 // ilbIPToUse determines which IP address needs to be used in the ForwardingRule. If an IP has been
-// specified by the user, that is used. If there is an existing ForwardingRule, the ip address from
+// requested by the user, that is used. If there is an existing ForwardingRule, the ip address from
 // that is reused. In case a subnetwork change is requested, the existing ForwardingRule IP is ignored.
-func ilbIPToUse(svc *v1.Service, fwdRule *compute.ForwardingRule, requestedSubnet string) string {
-	if svc.Spec.LoadBalancerIP != "" {
-		return svc.Spec.LoadBalancerIP
+func ilbIPToUse(requestedIP string, fwdRule *compute.ForwardingRule, requestedSubnet string) string {
+	if requestedIP != "" {
+		return requestedIP
 	}
 	if fwdRule == nil {
 		return ""
@@ -1068,7 +1615,9 @@ func forwardingRulesEqual(old, new *compute.ForwardingRule) bool {
 	if err != nil {
 		klog.Errorf("forwardingRulesEqual(): failed to parse resource URL from new FR, err - %v", err)
 	}
-	return (old.IPAddress == "" || new.IPAddress == "" || old.IPAddress == new.IPAddress) &&
+	// IPv6 addresses of forwarding rules are reported with their prefix length.
+	oldIP, newIP := ipv6AddressWithoutPrefix(old.IPAddress), ipv6AddressWithoutPrefix(new.IPAddress)
+	return (oldIP == "" || newIP == "" || oldIP == newIP) &&
 		old.IPProtocol == new.IPProtocol &&
 		old.LoadBalancingScheme == new.LoadBalancingScheme &&
 		equalStringSets(old.Ports, new.Ports) &&
@@ -0,0 +1,169 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+// clusterSupportsIPv6 returns true if Services of the cluster may have IPv6 addresses.
+func (g *Cloud) clusterSupportsIPv6() bool {
+	return g.stackType == clusterStackDualStack || g.stackType == clusterStackIPV6
+}
+
+// ilbIPFamilies returns which IP families the internal load balancer of the service should serve.
+// IPv4 clusters always get an IPv4-only load balancer. Otherwise the families of the service are used,
+// falling back to the primary family of the cluster when the service does not list any.
+func (g *Cloud) ilbIPFamilies(svc *v1.Service) (ipv4, ipv6 bool) {
+	if !g.clusterSupportsIPv6() {
+		return true, false
+	}
+	families := svc.Spec.IPFamilies
+	if len(families) == 0 {
+		if g.stackType == clusterStackIPV6 {
+			return false, true
+		}
+		return true, false
+	}
+	if svc.Spec.IPFamilyPolicy != nil && *svc.Spec.IPFamilyPolicy == v1.IPFamilyPolicySingleStack {
+		families = families[:1]
+	}
+	for _, family := range families {
+		switch family {
+		case v1.IPv4Protocol:
+			ipv4 = true
+		case v1.IPv6Protocol:
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+// getInternalIPv6ForwardingRule returns the IPv6 forwarding rule of the internal load balancer,
+// or nil if it does not exist. IPv4 clusters never have one, so no lookup is made.
+func (g *Cloud) getInternalIPv6ForwardingRule(loadBalancerName string) (*compute.ForwardingRule, error) {
+	if !g.clusterSupportsIPv6() {
+		return nil, nil
+	}
+	fwdRule, err := g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), g.region)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fwdRule, nil
+}
+
+// ilbIPv6ToUse determines which IPv6 address the forwarding rule should use. Like ilbIPToUse, a requested
+// address takes precedence, followed by the address of the existing forwarding rule in the same subnet.
+func ilbIPv6ToUse(requestedIP string, fwdRule *compute.ForwardingRule, requestedSubnet string) string {
+	if requestedIP != "" {
+		return requestedIP
+	}
+	if fwdRule == nil {
+		return ""
+	}
+	if requestedSubnet != fwdRule.Subnetwork {
+		// reset ip address since subnet is being changed.
+		return ""
+	}
+	return ipv6AddressWithoutPrefix(fwdRule.IPAddress)
+}
+
+// ipv6AddressWithoutPrefix removes the prefix length GCE reports with IPv6 forwarding rule addresses,
+// e.g. "fd20:0:0:1::/96" becomes "fd20:0:0:1::".
+func ipv6AddressWithoutPrefix(address string) string {
+	return strings.Split(address, "/")[0]
+}
+
+// ensureInternalIPv6Firewalls ensures the firewalls for the IPv6 forwarding rule of an internal load balancer.
+// Only the IPv6 ranges of loadBalancerSourceRanges are allowed; when the user only specified IPv4 ranges,
+// no IPv6 traffic is allowed and the traffic firewall is removed.
+func (g *Cloud) ensureInternalIPv6Firewalls(loadBalancerName, ipAddress, clusterID string, nm types.NamespacedName, svc *v1.Service, healthCheckPort string, sharedHealthCheck bool, nodes []*v1.Node) error {
+	fwName := makeIPv6ResourceName(MakeFirewallName(loadBalancerName))
+	fwDesc := makeFirewallDescription(loadBalancerName, nm.String(), ipAddress, clusterID)
+	ipv6SourceRanges, err := ilbIPv6SourceRanges(svc)
+	if err != nil {
+		return err
+	}
+	if len(ipv6SourceRanges) == 0 {
+		klog.V(2).Infof("ensureInternalIPv6Firewalls(%v): no IPv6 source ranges requested, removing IPv6 traffic firewall", loadBalancerName)
+		if err := g.teardownInternalFirewall(svc, loadBalancerName, fwName); err != nil {
+			return err
+		}
+	} else {
+		fwConfig, err := g.loadBalancerFirewallConfig(svc)
+		if err != nil {
+			return err
+		}
+		if err := g.ensureInternalFirewallShards(svc, fwName, fwDesc, ipAddress, ipv6SourceRanges, firewallAllowedForPorts(svc.Spec.Ports), nodes, "", fwConfig); err != nil {
+			return err
+		}
+	}
+
+	fwHCName := makeIPv6ResourceName(makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck))
+	hcSrcRanges := L4LoadBalancerIPv6SrcRanges()
+	return g.ensureInternalFirewall(svc, fwHCName, "", "", hcSrcRanges, []string{healthCheckPort}, v1.ProtocolTCP, nodes, "")
+}
+
+// ilbIPv6SourceRanges returns the IPv6 ranges of loadBalancerSourceRanges, or all IPv6 addresses
+// if the user did not request any source range.
+func ilbIPv6SourceRanges(svc *v1.Service) ([]string, error) {
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	if err != nil {
+		return nil, err
+	}
+	var ipv6SourceRanges []string
+	for _, sourceRange := range sourceRanges.StringSlice() {
+		if netutils.IsIPv6CIDRString(sourceRange) {
+			ipv6SourceRanges = append(ipv6SourceRanges, sourceRange)
+		}
+	}
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 && len(ipv6SourceRanges) == 0 {
+		// No source ranges were requested by the user, allow traffic from everywhere.
+		ipv6SourceRanges = []string{"::/0"}
+	}
+	return ipv6SourceRanges, nil
+}
+
+// teardownInternalFirewall deletes the firewall and its shards, raising an event instead if the
+// cluster is on XPN and lacks the permission to do so.
+func (g *Cloud) teardownInternalFirewall(svc *v1.Service, loadBalancerName, fwName string) error {
+	if err := g.deleteFirewallShards(svc, fwName, 1); err != nil {
+		return err
+	}
+	if err := ignoreNotFound(g.deleteLoadBalancerFirewall(fwName)); err != nil {
+		if isForbidden(err) && g.OnXPN() {
+			klog.V(2).Infof("teardownInternalFirewall(%v): could not delete traffic firewall %s on XPN cluster. Raising event.", loadBalancerName, fwName)
+			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
+			return nil
+		}
+		return err
+	}
+	return nil
+}
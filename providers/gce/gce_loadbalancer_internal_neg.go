@@ -0,0 +1,250 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// negTypeGCEVMIP is the type of the network endpoint groups backing internal load balancers.
+	negTypeGCEVMIP = "GCE_VM_IP"
+	// maxNetworkEndpointsPerRequest is the maximum number of endpoints attached to or detached
+	// from a network endpoint group in one request.
+	maxNetworkEndpointsPerRequest = 500
+)
+
+// ensureInternalBackends ensures the backends of the internal load balancer and returns their links:
+// the instance groups of the cluster, or the network endpoint groups of the load balancer if the
+// service uses the GCE_VM_IP_NEG backend type. The names of the nodes in the network endpoint groups
+// are returned too, nil for instance groups.
+func (g *Cloud) ensureInternalBackends(loadBalancerName, clusterID string, svc *v1.Service, nodes []*v1.Node) ([]string, sets.String, error) {
+	useNEGs, err := g.usesInternalNEGs(svc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !useNEGs {
+		igLinks, err := g.ensureInternalInstanceGroups(makeInstanceGroupName(clusterID), nodes)
+		return igLinks, nil, err
+	}
+	members, err := g.internalNEGNodeNames(svc, nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	negLinks, err := g.ensureInternalNEGs(loadBalancerName, makeServiceDescription(nm.String()), nodes, members)
+	if err != nil {
+		return nil, nil, err
+	}
+	return negLinks, members, nil
+}
+
+// internalBackendType returns the annotated backend type of the internal load balancer of the
+// service. Instance group backends contain all the nodes, so selecting the nodes of the load
+// balancer requires network endpoint group backends to be annotated.
+func internalBackendType(svc *v1.Service) (ILBBackendType, error) {
+	backendType, err := GetLoadBalancerAnnotationILBBackendType(svc)
+	if err != nil || backendType != ILBBackendTypeInstanceGroup {
+		return backendType, err
+	}
+	if err := checkInstanceGroupNodeSelector(svc); err != nil {
+		return backendType, fmt.Errorf("%w, set annotation %s to %q to select the nodes of internal load balancers", err, ServiceAnnotationILBBackendType, ILBBackendTypeNEG)
+	}
+	return backendType, nil
+}
+
+// usesInternalNEGs returns whether the internal load balancer of the service is backed by
+// network endpoint groups.
+func (g *Cloud) usesInternalNEGs(svc *v1.Service) (bool, error) {
+	backendType, err := internalBackendType(svc)
+	if err != nil {
+		return false, err
+	}
+	if backendType != ILBBackendTypeNEG {
+		return false, nil
+	}
+	if g.IsLegacyNetwork() {
+		return false, fmt.Errorf("%s internal LoadBalancer backends are not supported with Legacy Networks", ILBBackendTypeNEG)
+	}
+	return true, nil
+}
+
+// internalNEGNodeNames returns the names of the nodes to attach to the network endpoint groups of
+// the load balancer: the nodes with ready endpoints of the service if it routes traffic to local
+// endpoints only, all the nodes otherwise. They are limited to the subset of the service if the
+// load balancer was provisioned with subsetting.
+func (g *Cloud) internalNEGNodeNames(svc *v1.Service, nodes []*v1.Node) (sets.String, error) {
+	if servicehelpers.RequestsOnlyLocalTraffic(svc) {
+		endpointNodeNames, err := g.serviceReadyEndpointNodeNames(svc)
+		if err != nil {
+			return nil, err
+		}
+		var endpointNodes []*v1.Node
+		for _, node := range nodes {
+			if endpointNodeNames.Has(node.Name) {
+				endpointNodes = append(endpointNodes, node)
+			}
+		}
+		nodes = endpointNodes
+	}
+	if usesILBSubsetting(svc) {
+		return g.ilbSubsetNodeNames(svc, nodes), nil
+	}
+	return sets.NewString(nodeNames(nodes)...), nil
+}
+
+// ensureInternalNEGs ensures a GCE_VM_IP network endpoint group in every zone where a node
+// exists. The group of each zone contains the nodes of the zone that are members.
+func (g *Cloud) ensureInternalNEGs(name, description string, nodes []*v1.Node, members sets.String) ([]string, error) {
+	zonedNodes := g.instanceGroupNodesByZone(nodes)
+	klog.V(2).Infof("ensureInternalNEGs(%v): %d members out of %d nodes over %d zones in region %v", name, members.Len(), len(nodes), len(zonedNodes), g.region)
+	zones := make([]string, 0, len(zonedNodes))
+	for zone := range zonedNodes {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	var negLinks []string
+	for _, zone := range zones {
+		zoneMembers := sets.NewString()
+		for _, n := range zonedNodes[zone] {
+			if members.Has(n.Name) {
+				zoneMembers.Insert(n.Name)
+			}
+		}
+		negLink, err := g.ensureInternalNEG(name, zone, description, zoneMembers)
+		if err != nil {
+			return nil, err
+		}
+		negLinks = append(negLinks, negLink)
+	}
+	return negLinks, nil
+}
+
+// ensureInternalNEG ensures the GCE_VM_IP network endpoint group exists in the zone and contains
+// exactly the given nodes, and returns its link.
+func (g *Cloud) ensureInternalNEG(name, zone, description string, nodeNames sets.String) (string, error) {
+	neg, addNodes, removeNodes, err := g.networkEndpointGroupNodeChanges(name, zone, nodeNames)
+	if err != nil {
+		return "", err
+	}
+	if neg == nil {
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): creating network endpoint group", name, zone)
+		neg = &computebeta.NetworkEndpointGroup{
+			Name:                name,
+			Description:         description,
+			NetworkEndpointType: negTypeGCEVMIP,
+			Network:             g.networkURL,
+			Subnetwork:          g.SubnetworkURL(),
+		}
+		if err := g.CreateNetworkEndpointGroup(neg, zone); err != nil {
+			return "", err
+		}
+	}
+
+	for len(addNodes) > 0 {
+		batch := truncateList(addNodes, maxNetworkEndpointsPerRequest)
+		addNodes = addNodes[len(batch):]
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): attaching nodes %v", name, zone, truncateList(batch, maxNodeNamesToLog))
+		if err := g.AttachNetworkEndpoints(name, zone, networkEndpointsFromNodeNames(batch)); err != nil {
+			return "", err
+		}
+	}
+	for len(removeNodes) > 0 {
+		batch := truncateList(removeNodes, maxNetworkEndpointsPerRequest)
+		removeNodes = removeNodes[len(batch):]
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): detaching nodes %v", name, zone, truncateList(batch, maxNodeNamesToLog))
+		if err := g.DetachNetworkEndpoints(name, zone, networkEndpointsFromNodeNames(batch)); err != nil {
+			return "", err
+		}
+	}
+	return g.networkEndpointGroupURL(name, zone), nil
+}
+
+// networkEndpointGroupNodeChanges returns the network endpoint group, or nil if it does not exist,
+// and the nodes to attach to and detach from it so that it contains the given nodes.
+func (g *Cloud) networkEndpointGroupNodeChanges(name, zone string, nodeNames sets.String) (neg *computebeta.NetworkEndpointGroup, addNodes, removeNodes []string, err error) {
+	neg, err = g.GetNetworkEndpointGroup(name, zone)
+	if err != nil && !isNotFound(err) {
+		return nil, nil, nil, err
+	}
+	negNodes := sets.NewString()
+	if neg != nil {
+		endpoints, err := g.ListNetworkEndpoints(name, zone, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, ep := range endpoints {
+			if ep.NetworkEndpoint != nil {
+				parts := strings.Split(ep.NetworkEndpoint.Instance, "/")
+				negNodes.Insert(parts[len(parts)-1])
+			}
+		}
+	}
+	return neg, nodeNames.Difference(negNodes).List(), negNodes.Difference(nodeNames).List(), nil
+}
+
+// ensureInternalNEGsDeleted deletes the network endpoint groups of the load balancer in all the
+// zones of the region.
+func (g *Cloud) ensureInternalNEGsDeleted(name string) error {
+	zones, err := g.ListZonesInRegion(g.region)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("ensureInternalNEGsDeleted(%v): attempting delete network endpoint group in all %d zones", name, len(zones))
+	for _, z := range zones {
+		if err := g.DeleteNetworkEndpointGroup(name, z.Name); err != nil && !isNotFoundOrInUse(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Cloud) networkEndpointGroupURL(name, zone string) string {
+	return g.projectsBasePath + strings.Join([]string{g.projectID, "zones", zone, "networkEndpointGroups", name}, "/")
+}
+
+func networkEndpointsFromNodeNames(nodeNames []string) []*computebeta.NetworkEndpoint {
+	endpoints := make([]*computebeta.NetworkEndpoint, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		endpoints = append(endpoints, &computebeta.NetworkEndpoint{Instance: name})
+	}
+	return endpoints
+}
+
+// backendsUseNEGs returns whether any of the backends is a network endpoint group.
+func backendsUseNEGs(backends []*compute.Backend) bool {
+	for _, b := range backends {
+		if strings.Contains(b.Group, "/networkEndpointGroups/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// The network endpoint groups of the internal load balancers routing traffic to local endpoints
+// only contain the nodes with ready endpoints of their service. The service controller only syncs
+// the load balancers when the nodes or the services change, so the endpoint slices are watched
+// and the load balancers are updated when the nodes of their ready endpoints change.
+
+// internalNEGEndpointsQueueName is the name of the queue of the services whose network endpoint
+// groups are resynced because the nodes of their ready endpoints changed.
+const internalNEGEndpointsQueueName = "internal-neg-endpoints"
+
+func newInternalNEGEndpointsQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: internalNEGEndpointsQueueName})
+}
+
+// setEndpointSliceInformers watches the endpoint slices, and sets up the listers the load balancers
+// of the services are resynced with.
+func (g *Cloud) setEndpointSliceInformers(informerFactory informers.SharedInformerFactory) {
+	endpointSliceInformer := informerFactory.Discovery().V1().EndpointSlices()
+	serviceInformer := informerFactory.Core().V1().Services()
+	g.endpointSliceLister = endpointSliceInformer.Lister()
+	g.endpointSlicesSynced = endpointSliceInformer.Informer().HasSynced
+	g.serviceLister = serviceInformer.Lister()
+	g.servicesSynced = serviceInformer.Informer().HasSynced
+	g.nodeLister = informerFactory.Core().V1().Nodes().Lister()
+	endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			g.enqueueEndpointSliceService(nil, endpointSliceFromObject(obj))
+		},
+		UpdateFunc: func(prev, obj interface{}) {
+			g.enqueueEndpointSliceService(endpointSliceFromObject(prev), endpointSliceFromObject(obj))
+		},
+		DeleteFunc: func(obj interface{}) {
+			g.enqueueEndpointSliceService(endpointSliceFromObject(obj), nil)
+		},
+	})
+}
+
+// endpointSliceFromObject returns the endpoint slice of an informer event, or nil.
+func endpointSliceFromObject(obj interface{}) *discoveryv1.EndpointSlice {
+	// We can get DeletedFinalStateUnknown instead of *discoveryv1.EndpointSlice here
+	// and we need to handle that correctly.
+	if deletedState, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = deletedState.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		klog.Errorf("Received unexpected object: %v", obj)
+		return nil
+	}
+	return slice
+}
+
+// enqueueEndpointSliceService queues the service of the endpoint slice when the nodes of its ready
+// endpoints change.
+func (g *Cloud) enqueueEndpointSliceService(prev, cur *discoveryv1.EndpointSlice) {
+	slice := cur
+	if slice == nil {
+		slice = prev
+	}
+	if slice == nil {
+		return
+	}
+	serviceName, ok := slice.Labels[discoveryv1.LabelServiceName]
+	if !ok {
+		return
+	}
+	if prev != nil && cur != nil && readyEndpointNodeNames(prev).Equal(readyEndpointNodeNames(cur)) {
+		return
+	}
+	g.internalNEGEndpointsQueue.Add(types.NamespacedName{Namespace: slice.Namespace, Name: serviceName}.String())
+}
+
+// readyEndpointNodeNames returns the names of the nodes of the ready endpoints of the slice.
+func readyEndpointNodeNames(slice *discoveryv1.EndpointSlice) sets.String {
+	names := sets.NewString()
+	for _, ep := range slice.Endpoints {
+		// A nil ready condition is interpreted as ready.
+		if ep.NodeName != nil && (ep.Conditions.Ready == nil || *ep.Conditions.Ready) {
+			names.Insert(*ep.NodeName)
+		}
+	}
+	return names
+}
+
+// serviceReadyEndpointNodeNames returns the names of the nodes with ready endpoints of the service.
+func (g *Cloud) serviceReadyEndpointNodeNames(svc *v1.Service) (sets.String, error) {
+	if g.endpointSliceLister == nil {
+		return nil, fmt.Errorf("the endpoint slices of service %s/%s are not watched", svc.Namespace, svc.Name)
+	}
+	// Attaching the nodes of the endpoints listed before the informer synced would detach the others.
+	if !g.endpointSlicesSynced() {
+		return nil, fmt.Errorf("the endpoint slices of service %s/%s are not synced yet", svc.Namespace, svc.Name)
+	}
+	slices, err := g.endpointSliceLister.EndpointSlices(svc.Namespace).List(labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: svc.Name}))
+	if err != nil {
+		return nil, err
+	}
+	names := sets.NewString()
+	for _, slice := range slices {
+		names = names.Union(readyEndpointNodeNames(slice))
+	}
+	return names, nil
+}
+
+// runInternalNEGEndpointsSync resyncs the internal load balancers of the queued services until stop
+// is closed.
+func (g *Cloud) runInternalNEGEndpointsSync(stop <-chan struct{}) {
+	go func() {
+		<-stop
+		g.internalNEGEndpointsQueue.ShutDown()
+	}()
+	for g.processNextInternalNEGEndpoints() {
+	}
+}
+
+func (g *Cloud) processNextInternalNEGEndpoints() bool {
+	key, quit := g.internalNEGEndpointsQueue.Get()
+	if quit {
+		return false
+	}
+	defer g.internalNEGEndpointsQueue.Done(key)
+
+	if err := g.syncInternalNEGEndpoints(key.(string)); err != nil {
+		klog.Errorf("Failed to resync the network endpoint groups of service %s: %v", key, err)
+		g.internalNEGEndpointsQueue.AddRateLimited(key)
+		return true
+	}
+	g.internalNEGEndpointsQueue.Forget(key)
+	return true
+}
+
+// syncInternalNEGEndpoints updates the internal load balancer of the service if it routes traffic to
+// local endpoints and is backed by network endpoint groups, so that they contain the nodes with ready
+// endpoints. Load balancers not provisioned yet are left to the service controller.
+func (g *Cloud) syncInternalNEGEndpoints(key string) error {
+	if !g.servicesSynced() || !g.nodeInformerSynced() {
+		return fmt.Errorf("the services and the nodes are not synced yet")
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	svc, err := g.serviceLister.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer || svc.Spec.LoadBalancerClass != nil || getSvcScheme(svc) != cloud.SchemeInternal ||
+		!servicehelpers.RequestsOnlyLocalTraffic(svc) || !hasFinalizer(svc, ILBFinalizerV1) || svc.DeletionTimestamp != nil {
+		return nil
+	}
+	// Errors of the backend type are reported by the service controller.
+	if useNEGs, err := g.usesInternalNEGs(svc); err != nil || !useNEGs {
+		return nil
+	}
+	nodes, err := g.internalNEGCandidateNodes()
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("syncInternalNEGEndpoints(%v): the nodes of the ready endpoints changed, updating the load balancer", key)
+	return g.UpdateLoadBalancer(context.TODO(), "", svc, nodes)
+}
+
+// internalNEGCandidateNodes returns the nodes the service controller passes to the load balancers:
+// the nodes that are not excluded from load balancers nor being deleted.
+func (g *Cloud) internalNEGCandidateNodes() ([]*v1.Node, error) {
+	all, err := g.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*v1.Node
+	for _, node := range all {
+		if _, excluded := node.Labels[v1.LabelNodeExcludeBalancers]; excluded || node.DeletionTimestamp != nil {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,272 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/utils/pointer"
+)
+
+// fakeNetworkEndpoints keeps the endpoints attached to the mock network endpoint groups.
+type fakeNetworkEndpoints map[meta.Key]sets.String
+
+func newFakeNetworkEndpoints(gce *Cloud) fakeNetworkEndpoints {
+	endpoints := fakeNetworkEndpoints{}
+	c := gce.c.(*cloud.MockGCE)
+	c.MockBetaNetworkEndpointGroups.AttachNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsAttachEndpointsRequest, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) error {
+		if endpoints[*key] == nil {
+			endpoints[*key] = sets.NewString()
+		}
+		for _, ep := range req.NetworkEndpoints {
+			endpoints[*key].Insert(ep.Instance)
+		}
+		return nil
+	}
+	c.MockBetaNetworkEndpointGroups.DetachNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsDetachEndpointsRequest, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) error {
+		for _, ep := range req.NetworkEndpoints {
+			endpoints[*key].Delete(ep.Instance)
+		}
+		return nil
+	}
+	c.MockBetaNetworkEndpointGroups.ListNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsListEndpointsRequest, fl *filter.F, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) ([]*computebeta.NetworkEndpointWithHealthStatus, error) {
+		var list []*computebeta.NetworkEndpointWithHealthStatus
+		for _, name := range endpoints[*key].List() {
+			list = append(list, &computebeta.NetworkEndpointWithHealthStatus{NetworkEndpoint: &computebeta.NetworkEndpoint{Instance: name}})
+		}
+		return list, nil
+	}
+	return endpoints
+}
+
+func TestEnsureInternalLoadBalancerWithNEGs(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	endpoints := newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	neg, err := gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, negTypeGCEVMIP, neg.NetworkEndpointType)
+	assert.Equal(t, []string{"test-node-1", "test-node-2"}, endpoints[*meta.ZonalKey(lbName, vals.ZoneName)].List())
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, backendsFromGroupLinks([]string{gce.networkEndpointGroupURL(lbName, vals.ZoneName)}), bs.Backends)
+
+	// Removed nodes are detached.
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes[:1]))
+	assert.Equal(t, []string{"test-node-1"}, endpoints[*meta.ZonalKey(lbName, vals.ZoneName)].List())
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+}
+
+// startEndpointSliceInformers starts the informers the network endpoint groups of the services
+// routing traffic to local endpoints are built from.
+func startEndpointSliceInformers(t *testing.T, gce *Cloud) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	informerFactory := informers.NewSharedInformerFactory(gce.client, 0)
+	gce.SetInformers(informerFactory)
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+}
+
+// newEndpointSlice returns an endpoint slice of the service with an endpoint on each node, ready
+// or not.
+func newEndpointSlice(svc *v1.Service, name string, readyNodeNames, unreadyNodeNames []string) *discoveryv1.EndpointSlice {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: svc.Namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: svc.Name},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	for _, names := range []struct {
+		nodeNames []string
+		ready     bool
+	}{{readyNodeNames, true}, {unreadyNodeNames, false}} {
+		for _, nodeName := range names.nodeNames {
+			slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: pointer.Bool(names.ready)},
+				NodeName:   pointer.String(nodeName),
+			})
+		}
+	}
+	return slice
+}
+
+func TestEnsureInternalLoadBalancerWithNEGsAttachesReadyEndpointNodesForLocalTraffic(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	endpoints := newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2", "test-node-3"}, vals.ZoneName)
+	require.NoError(t, err)
+	for _, node := range nodes {
+		_, err = gce.client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	svc.Namespace = "default"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	slice, err := gce.client.DiscoveryV1().EndpointSlices(svc.Namespace).Create(context.TODO(), newEndpointSlice(svc, "slice-1", []string{"test-node-1"}, []string{"test-node-2"}), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The endpoint slices must be synced for the members to be known.
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+
+	startEndpointSliceInformers(t, gce)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	key := *meta.ZonalKey(lbName, vals.ZoneName)
+	assert.Equal(t, []string{"test-node-1"}, endpoints[key].List())
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(getNameFromLink(bs.HealthChecks[0]))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10101), hc.HttpHealthCheck.Port)
+
+	// Changes of the nodes of the ready endpoints queue the service, whose load balancer is then
+	// resynced.
+	require.Eventually(t, func() bool {
+		svc, err := gce.serviceLister.Services(svc.Namespace).Get(svc.Name)
+		return err == nil && hasFinalizer(svc, ILBFinalizerV1)
+	}, wait.ForeverTestTimeout, 10*time.Millisecond)
+	slice = newEndpointSlice(svc, slice.Name, []string{"test-node-2", "test-node-3"}, nil)
+	_, err = gce.client.DiscoveryV1().EndpointSlices(svc.Namespace).Update(context.TODO(), slice, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return gce.internalNEGEndpointsQueue.Len() == 1
+	}, wait.ForeverTestTimeout, 10*time.Millisecond)
+	queued, _ := gce.internalNEGEndpointsQueue.Get()
+	assert.Equal(t, svc.Namespace+"/"+svc.Name, queued)
+	require.NoError(t, gce.syncInternalNEGEndpoints(queued.(string)))
+	gce.internalNEGEndpointsQueue.Done(queued)
+	assert.Equal(t, []string{"test-node-2", "test-node-3"}, endpoints[key].List())
+
+	// Changes of the endpoints that do not change their nodes do not queue the service.
+	slice.Endpoints[0].Addresses = []string{"10.0.0.2"}
+	_, err = gce.client.DiscoveryV1().EndpointSlices(svc.Namespace).Update(context.TODO(), slice, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		slice, err := gce.endpointSliceLister.EndpointSlices(svc.Namespace).Get(slice.Name)
+		return err == nil && slice.Endpoints[0].Addresses[0] == "10.0.0.2"
+	}, wait.ForeverTestTimeout, 10*time.Millisecond)
+	assert.Zero(t, gce.internalNEGEndpointsQueue.Len())
+}
+
+func TestEnsureInternalLoadBalancerMigratesBetweenInstanceGroupsAndNEGs(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	igLinks, err := gce.ensureInternalInstanceGroups(makeInstanceGroupName(vals.ClusterID), nodes)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+
+	// The backends of the backend service are replaced in place.
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, backendsFromGroupLinks([]string{gce.networkEndpointGroupURL(lbName, vals.ZoneName)}), bs.Backends)
+	updatedFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, fwdRule, updatedFwdRule, "forwarding rule must not be recreated")
+
+	// Migrating back deletes the network endpoint groups.
+	delete(svc.Annotations, ServiceAnnotationILBBackendType)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	bs, err = gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, backendsFromGroupLinks(igLinks), bs.Backends)
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+}
+
+func TestEnsureInternalLoadBalancerWithInvalidBackendType(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendType] = "Unknown"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// maxILBSubsetSizePerZone is the maximum number of nodes per zone in the network endpoint groups
+// of an internal load balancer provisioned with subsetting.
+const maxILBSubsetSizePerZone = 25
+
+// usesILBSubsetting returns whether the internal load balancer of the service was provisioned with
+// subsetting.
+func usesILBSubsetting(svc *v1.Service) bool {
+	return svc.Annotations[ILBSubsettingAnnotationKey] == "true"
+}
+
+// recordILBSubsetting records in the ILBSubsettingAnnotationKey annotation of the service that its
+// internal load balancer is provisioned with subsetting, and in the ServiceAnnotationILBBackendType
+// annotation that it is backed by network endpoint groups, which subsetting requires. It returns
+// the service with the annotations set.
+func (g *Cloud) recordILBSubsetting(svc *v1.Service) (*v1.Service, error) {
+	klog.V(2).Infof("recordILBSubsetting(%v/%v): provisioning internal load balancer with subsetting", svc.Namespace, svc.Name)
+	// Make a copy so we don't mutate the shared informer cache.
+	updated := svc.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[ILBSubsettingAnnotationKey] = "true"
+	updated.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	if _, err := servicehelper.PatchService(g.serviceClient(), svc, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// ilbSubsetNodeNames returns the names of the nodes in the subset of the service: at most
+// maxILBSubsetSizePerZone nodes in every zone. The nodes of a zone are ranked by a hash of their name
+// and of the service (rendezvous hashing), so the subset of a service is stable and adding or removing
+// a node changes at most one node of the subset of its zone.
+func (g *Cloud) ilbSubsetNodeNames(svc *v1.Service, nodes []*v1.Node) sets.String {
+	svcKey := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}.String()
+	subset := sets.NewString()
+	for _, zoneNodes := range g.instanceGroupNodesByZone(nodes) {
+		names := make([]string, 0, len(zoneNodes))
+		scores := make(map[string]uint64, len(zoneNodes))
+		for _, n := range zoneNodes {
+			names = append(names, n.Name)
+			scores[n.Name] = subsetScore(svcKey, n.Name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if scores[names[i]] != scores[names[j]] {
+				return scores[names[i]] > scores[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		subset.Insert(truncateList(names, maxILBSubsetSizePerZone)...)
+	}
+	return subset
+}
+
+// subsetScore returns the rank of the node in the subsets of the service.
+func subsetScore(svcKey, nodeName string) uint64 {
+	sum := sha256.Sum256([]byte(svcKey + "/" + nodeName))
+	return binary.BigEndian.Uint64(sum[:8])
+}
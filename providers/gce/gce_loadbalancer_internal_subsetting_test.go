@@ -0,0 +1,177 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func makeNodeNames(prefix string, count int) []string {
+	var names []string
+	for i := 0; i < count; i++ {
+		names = append(names, fmt.Sprintf("%s-%d", prefix, i))
+	}
+	return names
+}
+
+func TestILBSubsetNodeNames(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, makeNodeNames("node-a", 40), "us-central1-a")
+	require.NoError(t, err)
+	zoneBNodes, err := createAndInsertNodes(gce, makeNodeNames("node-b", 10), "us-central1-b")
+	require.NoError(t, err)
+	nodes = append(nodes, zoneBNodes...)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	subset := gce.ilbSubsetNodeNames(svc, nodes)
+	// At most maxILBSubsetSizePerZone nodes per zone, all the nodes of smaller zones.
+	assert.Equal(t, maxILBSubsetSizePerZone+len(zoneBNodes), subset.Len())
+	for _, n := range zoneBNodes {
+		assert.True(t, subset.Has(n.Name), "node %s of zone us-central1-b not in subset", n.Name)
+	}
+
+	// The subset does not depend on the order of the nodes.
+	reversed := make([]*v1.Node, len(nodes))
+	for i, n := range nodes {
+		reversed[len(nodes)-1-i] = n
+	}
+	assert.Equal(t, subset, gce.ilbSubsetNodeNames(svc, reversed))
+
+	// Removing a node outside of the subset does not change it, removing a node of the subset
+	// replaces it only.
+	var inSubset, outOfSubset int
+	for i, n := range nodes[:40] {
+		if subset.Has(n.Name) {
+			inSubset = i
+		} else {
+			outOfSubset = i
+		}
+	}
+	without := func(i int) []*v1.Node {
+		return append(append([]*v1.Node{}, nodes[:i]...), nodes[i+1:]...)
+	}
+	assert.Equal(t, subset, gce.ilbSubsetNodeNames(svc, without(outOfSubset)))
+	changed := gce.ilbSubsetNodeNames(svc, without(inSubset))
+	assert.Equal(t, subset.Len(), changed.Len())
+	assert.Equal(t, []string{nodes[inSubset].Name}, subset.Difference(changed).List())
+
+	// Other services get other subsets.
+	otherSvc := fakeLoadbalancerService(string(LBTypeInternal))
+	otherSvc.Name = "other-service"
+	assert.NotEqual(t, subset, gce.ilbSubsetNodeNames(otherSvc, nodes))
+}
+
+func TestEnsureInternalLoadBalancerWithSubsetting(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{AlphaFeatureILBSubsets})
+	endpoints := newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, makeNodeNames("test-node", 30), vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, usesILBSubsetting(svc))
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	key := *meta.ZonalKey(lbName, vals.ZoneName)
+	assert.Equal(t, gce.ilbSubsetNodeNames(svc, nodes).List(), endpoints[key].List())
+	assert.Len(t, endpoints[key], maxILBSubsetSizePerZone)
+	state := gce.metricsCollector.(*LoadBalancerMetrics).l4ILBServiceMap[svc.Namespace+"/"+svc.Name]
+	assert.True(t, state.EnabledSubsetting)
+	assert.Equal(t, maxILBSubsetSizePerZone, state.SubsetSize)
+	// The backend type is recorded so that later syncs keep the network endpoint groups.
+	assert.Equal(t, string(ILBBackendTypeNEG), svc.Annotations[ServiceAnnotationILBBackendType])
+
+	// The subset of the services routing traffic to local endpoints is taken among the nodes with
+	// ready endpoints.
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	endpointNodeNames := makeNodeNames("test-node", 30)[:maxILBSubsetSizePerZone+5]
+	_, err = gce.client.DiscoveryV1().EndpointSlices(svc.Namespace).Create(context.TODO(), newEndpointSlice(svc, "slice-1", endpointNodeNames, nil), metav1.CreateOptions{})
+	require.NoError(t, err)
+	startEndpointSliceInformers(t, gce)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Len(t, endpoints[key], maxILBSubsetSizePerZone)
+	assert.True(t, sets.NewString(endpointNodeNames...).IsSuperset(endpoints[key]), "nodes without ready endpoints in subset: %v", endpoints[key].List())
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+}
+
+func TestEnsureInternalLoadBalancerWithSubsettingKeepsInstanceGroups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, makeNodeNames("test-node", 3), vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// Enabling subsetting does not convert the load balancers backed by instance groups.
+	gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{AlphaFeatureILBSubsets})
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, usesILBSubsetting(svc))
+	assert.Empty(t, svc.Annotations[ServiceAnnotationILBBackendType])
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	igLinks, err := gce.ensureInternalInstanceGroups(makeInstanceGroupName(vals.ClusterID), nodes)
+	require.NoError(t, err)
+	assert.Equal(t, backendsFromGroupLinks(igLinks), bs.Backends)
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group created: %v", err)
+}
@@ -40,6 +40,7 @@ import (
 	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/utils/pointer"
 )
 
 func createInternalLoadBalancer(gce *Cloud, svc *v1.Service, existingFwdRule *compute.ForwardingRule, nodeNames []string, clusterName, clusterID, zoneName string) (*v1.LoadBalancerStatus, error) {
@@ -76,11 +77,11 @@ func TestEnsureInternalBackendServiceUpdates(t *testing.T) {
 
 	sharedBackend := shareBackendService(svc)
 	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
-	err = gce.ensureInternalBackendService(bsName, "description", svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, "")
+	err = gce.ensureInternalBackendService(bsName, "description", newBackendServiceAffinity(svc.Spec.SessionAffinity), cloud.SchemeInternal, "TCP", igLinks, "")
 	require.NoError(t, err)
 
 	// Update the Internal Backend Service with a new ServiceAffinity
-	err = gce.ensureInternalBackendService(bsName, "description", v1.ServiceAffinityNone, cloud.SchemeInternal, "TCP", igLinks, "")
+	err = gce.ensureInternalBackendService(bsName, "description", newBackendServiceAffinity(v1.ServiceAffinityNone), cloud.SchemeInternal, "TCP", igLinks, "")
 	require.NoError(t, err)
 
 	bs, err := gce.GetRegionBackendService(bsName, gce.region)
@@ -88,6 +89,187 @@ func TestEnsureInternalBackendServiceUpdates(t *testing.T) {
 	assert.Equal(t, bs.SessionAffinity, strings.ToUpper(string(v1.ServiceAffinityNone)))
 }
 
+func TestInternalBackendServiceAffinity(t *testing.T) {
+	t.Parallel()
+
+	for desc, tc := range map[string]struct {
+		affinity    v1.ServiceAffinity
+		timeout     *int32
+		annotation  string
+		shared      bool
+		expected    backendServiceAffinity
+		expectedErr bool
+	}{
+		"No affinity": {
+			affinity: v1.ServiceAffinityNone,
+			expected: backendServiceAffinity{sessionAffinity: gceAffinityTypeNone},
+		},
+		"Client IP": {
+			affinity: v1.ServiceAffinityClientIP,
+			timeout:  pointer.Int32(v1.DefaultClientIPServiceAffinitySeconds),
+			expected: backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIP, idleTimeoutSec: int64(v1.DefaultClientIPServiceAffinitySeconds)},
+		},
+		"Shared client IP keeps the default timeout": {
+			affinity: v1.ServiceAffinityClientIP,
+			timeout:  pointer.Int32(v1.DefaultClientIPServiceAffinitySeconds),
+			shared:   true,
+			expected: backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIP},
+		},
+		"Client IP timeout below the minimum": {
+			affinity: v1.ServiceAffinityClientIP,
+			timeout:  pointer.Int32(60),
+			expected: backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIP, idleTimeoutSec: minILBAffinityTimeoutSec},
+		},
+		"Client IP timeout above the maximum": {
+			affinity: v1.ServiceAffinityClientIP,
+			timeout:  pointer.Int32(86400),
+			expected: backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIP, idleTimeoutSec: maxILBAffinityTimeoutSec},
+		},
+		"Client IP and protocol": {
+			affinity:   v1.ServiceAffinityClientIP,
+			timeout:    pointer.Int32(3600),
+			annotation: gceAffinityTypeClientIPProto,
+			expected:   backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIPProto, idleTimeoutSec: 3600},
+		},
+		"Client IP, port and protocol has no timeout": {
+			affinity:   v1.ServiceAffinityClientIP,
+			timeout:    pointer.Int32(3600),
+			annotation: gceAffinityTypeClientIPPortProto,
+			expected:   backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIPPortProto},
+		},
+		"Client IP without destination": {
+			affinity:   v1.ServiceAffinityClientIP,
+			annotation: gceAffinityTypeClientIPNoDestination,
+			expected:   backendServiceAffinity{sessionAffinity: gceAffinityTypeClientIPNoDestination},
+		},
+		"Annotation without Client IP affinity": {
+			affinity:    v1.ServiceAffinityNone,
+			annotation:  gceAffinityTypeClientIPProto,
+			expectedErr: true,
+		},
+		"Unsupported annotation": {
+			affinity:    v1.ServiceAffinityClientIP,
+			annotation:  "GENERATED_COOKIE",
+			expectedErr: true,
+		},
+	} {
+		tc := tc
+		t.Run(desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService(string(LBTypeInternal))
+			svc.Spec.SessionAffinity = tc.affinity
+			if tc.timeout != nil {
+				svc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: tc.timeout}}
+			}
+			if tc.annotation != "" {
+				svc.Annotations[ServiceAnnotationILBSessionAffinity] = tc.annotation
+			}
+			if tc.shared {
+				svc.Annotations[ServiceAnnotationILBBackendShare] = "true"
+			}
+			affinity, err := internalBackendServiceAffinity(svc)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, affinity)
+		})
+	}
+}
+
+func TestEnsureInternalLoadBalancerSessionAffinity(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The backend service of a service with a custom session affinity is not shared.
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendShare] = "true"
+	svc.Annotations[ServiceAnnotationILBSessionAffinity] = gceAffinityTypeClientIPNoDestination
+	svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	svc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: pointer.Int32(3600)}}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, gceAffinityTypeClientIPNoDestination, bs.SessionAffinity)
+	require.NotNil(t, bs.ConnectionTrackingPolicy)
+	assert.Equal(t, connectionTrackingModePerSession, bs.ConnectionTrackingPolicy.TrackingMode)
+	assert.Equal(t, int64(3600), bs.ConnectionTrackingPolicy.IdleTimeoutSec)
+
+	// Changing the affinity updates the backend service in place.
+	svc.Annotations[ServiceAnnotationILBSessionAffinity] = gceAffinityTypeClientIPPortProto
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	bs, err = gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, gceAffinityTypeClientIPPortProto, bs.SessionAffinity)
+	assert.Nil(t, bs.ConnectionTrackingPolicy)
+
+	// Without custom affinity, the shared backend service replaces it.
+	delete(svc.Annotations, ServiceAnnotationILBSessionAffinity)
+	svc.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds = pointer.Int32(v1.DefaultClientIPServiceAffinitySeconds)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	sharedBSName := makeBackendServiceName(lbName, vals.ClusterID, true, cloud.SchemeInternal, v1.ProtocolTCP, v1.ServiceAffinityClientIP)
+	bs, err = gce.GetRegionBackendService(sharedBSName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, gceAffinityTypeClientIP, bs.SessionAffinity)
+	assert.Nil(t, bs.ConnectionTrackingPolicy)
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.True(t, isNotFound(err), "backend service %s not deleted: %v", lbName, err)
+}
+
+func TestBackendSvcEqualIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	perSession := func(idleTimeoutSec int64) *compute.BackendServiceConnectionTrackingPolicy {
+		return &compute.BackendServiceConnectionTrackingPolicy{TrackingMode: connectionTrackingModePerSession, IdleTimeoutSec: idleTimeoutSec}
+	}
+	for desc, tc := range map[string]struct {
+		expected *compute.BackendServiceConnectionTrackingPolicy
+		existing *compute.BackendServiceConnectionTrackingPolicy
+		equal    bool
+	}{
+		"Default timeout": {
+			equal: true,
+		},
+		"Default timeout set by GCE": {
+			existing: &compute.BackendServiceConnectionTrackingPolicy{TrackingMode: "PER_CONNECTION", IdleTimeoutSec: 600},
+			equal:    true,
+		},
+		"Same timeout": {
+			expected: perSession(3600),
+			existing: perSession(3600),
+			equal:    true,
+		},
+		"Changed timeout": {
+			expected: perSession(3600),
+			existing: perSession(600),
+		},
+		"Added timeout": {
+			expected: perSession(3600),
+		},
+		"Removed timeout": {
+			existing: perSession(3600),
+		},
+	} {
+		tc := tc
+		t.Run(desc, func(t *testing.T) {
+			expected := &compute.BackendService{Name: "bs", ConnectionTrackingPolicy: tc.expected}
+			existing := &compute.BackendService{Name: "bs", ConnectionTrackingPolicy: tc.existing}
+			assert.Equal(t, tc.equal, backendSvcEqual(expected, existing))
+		})
+	}
+}
+
 func TestEnsureInternalBackendServiceGroups(t *testing.T) {
 	t.Parallel()
 
@@ -124,7 +306,7 @@ func TestEnsureInternalBackendServiceGroups(t *testing.T) {
 			sharedBackend := shareBackendService(svc)
 			bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
 
-			err = gce.ensureInternalBackendService(bsName, "description", svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, "")
+			err = gce.ensureInternalBackendService(bsName, "description", newBackendServiceAffinity(svc.Spec.SessionAffinity), cloud.SchemeInternal, "TCP", igLinks, "")
 			require.NoError(t, err)
 
 			// Update the BackendService with new InstanceGroups
@@ -451,7 +633,7 @@ func TestEnsureInternalLoadBalancerWithExistingResources(t *testing.T) {
 	sharedHealthCheck := !servicehelper.RequestsOnlyLocalTraffic(svc)
 	hcName := makeHealthCheckName(lbName, vals.ClusterID, sharedHealthCheck)
 	hcPath, hcPort := GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
-	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort)
+	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, nil)
 	err = gce.CreateHealthCheck(existingHC)
 	require.NoError(t, err)
 
@@ -464,7 +646,7 @@ func TestEnsureInternalLoadBalancerWithExistingResources(t *testing.T) {
 	sharedBackend := shareBackendService(svc)
 	bsDescription := makeBackendServiceDescription(nm, sharedBackend)
 	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
-	err = gce.ensureInternalBackendService(bsName, bsDescription, svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, existingHC.SelfLink)
+	err = gce.ensureInternalBackendService(bsName, bsDescription, newBackendServiceAffinity(svc.Spec.SessionAffinity), cloud.SchemeInternal, "TCP", igLinks, existingHC.SelfLink)
 	require.NoError(t, err)
 
 	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
@@ -512,7 +694,7 @@ func TestEnsureInternalLoadBalancerClearPreviousResources(t *testing.T) {
 	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
 
 	// Create a healthcheck with an incorrect threshold
-	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort)
+	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, nil)
 	existingHC.CheckIntervalSec = gceHcCheckIntervalSeconds - 1
 	gce.CreateHealthCheck(existingHC)
 
@@ -568,7 +750,7 @@ func TestEnsureInternalLoadBalancerHealthCheckConfigurable(t *testing.T) {
 	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
 
 	// Create a healthcheck with an incorrect threshold
-	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort)
+	existingHC := newInternalLBHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, nil)
 	existingHC.CheckIntervalSec = gceHcCheckIntervalSeconds * 10
 	gce.CreateHealthCheck(existingHC)
 
@@ -724,6 +906,228 @@ func TestEnsureInternalLoadBalancerDeleted(t *testing.T) {
 	assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
 }
 
+func TestEnsureInternalLoadBalancerDualStack(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	vals.StackType = clusterStackDualStack
+	nodeNames := []string{"test-node-1"}
+
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assertInternalLbResources(t, gce, svc, vals, nodeNames)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	ipv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "IPV6", ipv6FwdRule.IpVersion)
+	assert.Equal(t, fwdRule.BackendService, ipv6FwdRule.BackendService)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: fwdRule.IPAddress}, {IP: ipv6FwdRule.IPAddress}}, status.Ingress)
+
+	for _, fwName := range []string{
+		makeIPv6ResourceName(MakeFirewallName(lbName)),
+		makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)),
+	} {
+		firewall, err := gce.GetFirewall(fwName)
+		require.NoError(t, err)
+		assert.Equal(t, nodeNames, firewall.TargetTags)
+		assert.NotEmpty(t, firewall.SourceRanges)
+	}
+
+	// A second sync must not recreate the forwarding rules.
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	newIPv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipv6FwdRule.IPAddress, newIPv6FwdRule.IPAddress)
+
+	// Switching to IPv4 only removes the IPv6 forwarding rule and traffic firewall.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(makeIPv6ResourceName(MakeFirewallName(lbName)))
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerIPv6Only(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	vals.StackType = clusterStackIPV6
+	nodeNames := []string{"test-node-1"}
+
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol}
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8", "fd00::/64"}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(MakeFirewallName(lbName))
+	assert.True(t, isNotFound(err))
+
+	ipv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ipv6FwdRule.IPAddress}}, status.Ingress)
+
+	firewall, err := gce.GetFirewall(makeIPv6ResourceName(MakeFirewallName(lbName)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fd00::/64"}, firewall.SourceRanges)
+	assert.Equal(t, []string{ipv6FwdRule.IPAddress}, firewall.DestinationRanges)
+}
+
+func TestEnsureInternalLoadBalancerDeletedDualStack(t *testing.T) {
+	t.Parallel()
+
+	// The IPv6 resources are deleted even if the cluster switched back to IPv4 since their creation.
+	for _, stackType := range []StackType{clusterStackDualStack, clusterStackIPV4} {
+		t.Run(string(stackType), func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			vals.StackType = clusterStackDualStack
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+
+			svc := fakeLoadbalancerService(string(LBTypeInternal))
+			svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol}
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			status, err := createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+			require.NoError(t, err)
+			require.Len(t, status.Ingress, 2)
+			assert.Contains(t, status.Ingress[0].IP, ":", "IPv6 address should be listed first")
+
+			gce.stackType = stackType
+			err = gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc)
+			assert.NoError(t, err)
+			assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
+
+			lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+			_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+			assert.True(t, isNotFound(err))
+			_, err = gce.GetRegionAddress(makeIPv6ResourceName(lbName), gce.region)
+			assert.True(t, isNotFound(err))
+			for _, fwName := range []string{
+				makeIPv6ResourceName(MakeFirewallName(lbName)),
+				makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)),
+			} {
+				_, err = gce.GetFirewall(fwName)
+				assert.True(t, isNotFound(err))
+			}
+		})
+	}
+}
+
+func TestEnsureInternalLoadBalancerMixedProtocols(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	nodeNames := []string{"test-node-1"}
+
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: int32(53)})
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	ip := status.Ingress[0].IP
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	for name, protocol := range map[string]string{lbName: "TCP", lbName + "-udp": "UDP"} {
+		fwdRule, err := gce.GetRegionForwardingRule(name, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, protocol, fwdRule.IPProtocol)
+		assert.Equal(t, ip, fwdRule.IPAddress)
+		assert.Equal(t, gce.getBackendServiceLink(name), fwdRule.BackendService)
+		bs, err := gce.GetRegionBackendService(name, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, protocol, bs.Protocol)
+	}
+	// The forwarding rules share the reserved address.
+	addr, err := gce.GetRegionAddress(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ip, addr.Address)
+	assert.Equal(t, addressPurposeSharedLoadBalancerVIP, addr.Purpose)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"123"}}, {IPProtocol: "udp", Ports: []string{"53"}}}, fw.Allowed)
+
+	// Node updates reach the backend services of both protocols.
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.updateInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nodes))
+	instances, err := gce.ListInstancesInInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	assert.Len(t, instances, 2)
+
+	// Dropping the TCP port moves the UDP port to the primary forwarding rule and backend service.
+	svc.Spec.Ports = svc.Spec.Ports[1:]
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	status, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, ip, status.Ingress[0].IP)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "UDP", fwdRule.IPProtocol)
+	_, err = gce.GetRegionForwardingRule(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionBackendService(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerDeletedMixedProtocols(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	vals.StackType = clusterStackDualStack
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: int32(53)})
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName+"-udp"), gce.region)
+	require.NoError(t, err)
+
+	err = gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc)
+	assert.NoError(t, err)
+	assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
+	for _, name := range []string{lbName + "-udp", makeIPv6ResourceName(lbName + "-udp")} {
+		_, err = gce.GetRegionForwardingRule(name, gce.region)
+		assert.True(t, isNotFound(err), "forwarding rule %s", name)
+	}
+	_, err = gce.GetRegionBackendService(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+}
+
 func TestSkipInstanceGroupDeletion(t *testing.T) {
 	t.Parallel()
 
@@ -793,6 +1197,72 @@ func TestEnsureInternalLoadBalancerWithSpecialHealthCheck(t *testing.T) {
 	assert.Equal(t, int64(healthCheckNodePort), hc.HttpHealthCheck.Port)
 }
 
+func TestEnsureInternalLoadBalancerWithTunedHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.HealthCheckNodePort = int32(10101)
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "2"
+	svc.Annotations[ServiceAnnotationHealthCheckUnhealthyThreshold] = "2"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	loadBalancerName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	hc, err := gce.GetHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), hc.CheckIntervalSec)
+	assert.Equal(t, int64(2), hc.UnhealthyThreshold)
+	assert.Equal(t, gceHcHealthyThreshold, hc.HealthyThreshold)
+
+	// Tuned values below the previous ones are applied.
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "1"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), hc.CheckIntervalSec)
+
+	// Removing the annotations resets the health check to the default values, even the ones
+	// above the defaults.
+	svc.Annotations[ServiceAnnotationHealthCheckInterval] = "30"
+	svc.Annotations[ServiceAnnotationHealthCheckUnhealthyThreshold] = "9"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationHealthCheckInterval)
+	delete(svc.Annotations, ServiceAnnotationHealthCheckUnhealthyThreshold)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(loadBalancerName)
+	require.NoError(t, err)
+	assert.Equal(t, gceHcCheckIntervalSeconds, hc.CheckIntervalSec)
+	assert.Equal(t, gceHcUnhealthyThreshold, hc.UnhealthyThreshold)
+	assert.False(t, isTunedHealthCheckDescription(hc.Description))
+
+	// Shared health checks keep the default values.
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	svc.Spec.HealthCheckNodePort = 0
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(makeHealthCheckName(loadBalancerName, vals.ClusterID, true))
+	require.NoError(t, err)
+	assert.Equal(t, gceHcCheckIntervalSeconds, hc.CheckIntervalSec)
+	assert.Equal(t, gceHcUnhealthyThreshold, hc.UnhealthyThreshold)
+
+	// Invalid values are reported.
+	svc.Annotations[ServiceAnnotationHealthCheckTimeout] = "0"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+}
+
 func TestClearPreviousInternalResources(t *testing.T) {
 	// Configure testing environment.
 	vals := DefaultTestClusterValues()
@@ -806,13 +1276,13 @@ func TestClearPreviousInternalResources(t *testing.T) {
 	c := gce.c.(*cloud.MockGCE)
 	require.NoError(t, err)
 
-	hc1, err := gce.ensureInternalHealthCheck("hc1", nm, false, "healthz", 12345)
+	hc1, err := gce.ensureInternalHealthCheck("hc1", nm, false, "healthz", 12345, nil)
 	require.NoError(t, err)
 
-	hc2, err := gce.ensureInternalHealthCheck("hc2", nm, false, "healthz", 12346)
+	hc2, err := gce.ensureInternalHealthCheck("hc2", nm, false, "healthz", 12346, nil)
 	require.NoError(t, err)
 
-	err = gce.ensureInternalBackendService(svc.ObjectMeta.Name, "", svc.Spec.SessionAffinity, cloud.SchemeInternal, v1.ProtocolTCP, []string{}, "")
+	err = gce.ensureInternalBackendService(svc.ObjectMeta.Name, "", newBackendServiceAffinity(svc.Spec.SessionAffinity), cloud.SchemeInternal, v1.ProtocolTCP, []string{}, "")
 	require.NoError(t, err)
 	backendSvc, err := gce.GetRegionBackendService(svc.ObjectMeta.Name, gce.region)
 	require.NoError(t, err)
@@ -1213,14 +1683,14 @@ func TestMergeHealthChecks(t *testing.T) {
 		{"unhealthy threshold - user configured - should keep", gceHcCheckIntervalSeconds, gceHcTimeoutSeconds, gceHcHealthyThreshold, gceHcUnhealthyThreshold + 1, gceHcCheckIntervalSeconds, gceHcTimeoutSeconds, gceHcHealthyThreshold, gceHcUnhealthyThreshold + 1},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			wantHC := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
+			wantHC := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345, nil)
 			hc := &compute.HealthCheck{
 				CheckIntervalSec:   tc.checkIntervalSec,
 				TimeoutSec:         tc.timeoutSec,
 				HealthyThreshold:   tc.healthyThreshold,
 				UnhealthyThreshold: tc.unhealthyThreshold,
 			}
-			mergeHealthChecks(hc, wantHC)
+			mergeHealthChecks(hc, wantHC, false)
 			if wantHC.CheckIntervalSec != tc.wantCheckIntervalSec {
 				t.Errorf("wantHC.CheckIntervalSec = %d; want %d", wantHC.CheckIntervalSec, tc.checkIntervalSec)
 			}
@@ -1259,12 +1729,12 @@ func TestCompareHealthChecks(t *testing.T) {
 		{"unhealthy threshold does not need update", func(hc *compute.HealthCheck) { hc.UnhealthyThreshold = gceHcUnhealthyThreshold + 1 }, false},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			hc := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
-			wantHC := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
+			hc := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345, nil)
+			wantHC := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345, nil)
 			if tc.modifier != nil {
 				tc.modifier(hc)
 			}
-			if gotChanged := needToUpdateHealthChecks(hc, wantHC); gotChanged != tc.wantChanged {
+			if gotChanged := needToUpdateHealthChecks(hc, wantHC, false); gotChanged != tc.wantChanged {
 				t.Errorf("needToUpdateHealthChecks(%#v, %#v) = %t; want changed = %t", hc, wantHC, gotChanged, tc.wantChanged)
 			}
 		})
@@ -1279,8 +1749,9 @@ func TestEnsureInternalLoadBalancerSubsetting(t *testing.T) {
 		finalizers           []string
 		createForwardingRule bool
 		expectErrorMsg       string
+		expectSubsetting     bool
 	}{
-		{desc: "New service creation fails with Implemented Elsewhere", expectErrorMsg: cloudprovider.ImplementedElsewhere.Error()},
+		{desc: "New service is provisioned with subsetting", expectSubsetting: true},
 		{desc: "Service with existing ForwardingRule is processed", createForwardingRule: true},
 		{desc: "Service with v1 finalizer is processed", finalizers: []string{ILBFinalizerV1}},
 		{desc: "Service with v2 finalizer is skipped", finalizers: []string{ILBFinalizerV2}, expectErrorMsg: cloudprovider.ImplementedElsewhere.Error()},
@@ -1322,10 +1793,20 @@ func TestEnsureInternalLoadBalancerSubsetting(t *testing.T) {
 			if gotErrorMsg != tc.expectErrorMsg {
 				t.Errorf("createInternalLoadBalancer() = %q, want error %q", err, tc.expectErrorMsg)
 			}
-			if err != nil {
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectSubsetting, usesILBSubsetting(svc))
+			lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+			switch {
+			case tc.expectErrorMsg != "":
 				assert.Empty(t, status)
 				assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
-			} else {
+			case tc.expectSubsetting:
+				assert.NotEmpty(t, status.Ingress)
+				bs, err := gce.GetRegionBackendService(lbName, gce.region)
+				require.NoError(t, err)
+				assert.Equal(t, backendsFromGroupLinks([]string{gce.networkEndpointGroupURL(lbName, vals.ZoneName)}), bs.Backends)
+			default:
 				assert.NotEmpty(t, status.Ingress)
 				assertInternalLbResources(t, gce, svc, vals, nodeNames)
 			}
@@ -0,0 +1,136 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// keyedMutex is a set of mutexes identified by keys. The mutex of a key is created when it is first
+// locked, and dropped when no goroutine holds or waits for it anymore.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	// refs is the number of goroutines holding or waiting for the mutex.
+	refs int
+}
+
+// lock locks the mutexes of the keys and returns the function unlocking them. The mutexes are
+// locked in the order of their keys, so that callers locking overlapping keys cannot deadlock.
+func (m *keyedMutex) lock(keys ...string) (unlock func()) {
+	keys = sets.NewString(keys...).List()
+	entries := make([]*keyedMutexEntry, len(keys))
+	start := time.Now()
+	for i, key := range keys {
+		entries[i] = m.acquire(key)
+		entries[i].Lock()
+	}
+	wait := time.Since(start)
+	sharedResourceLockWaitLatency.Observe(wait.Seconds())
+	klog.V(4).Infof("keyedMutex.lock(%v): locked after %v", keys, wait)
+	return func() {
+		for i := len(keys) - 1; i >= 0; i-- {
+			entries[i].Unlock()
+			m.release(keys[i])
+		}
+	}
+}
+
+// acquire returns the mutex of the key, creating it if needed, and references it.
+func (m *keyedMutex) acquire(key string) *keyedMutexEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedMutexEntry{}
+	}
+	entry, ok := m.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		m.locks[key] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+// release dereferences the mutex of the key, and drops it if it is not referenced anymore.
+func (m *keyedMutex) release(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.locks[key]
+	entry.refs--
+	if entry.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// The lock keys of the resources shared by load balancers. Health checks of different kinds share
+// their names, so the keys are prefixed by the collection of the resource.
+
+func instanceGroupLockKey(name string) string {
+	return "instanceGroups/" + name
+}
+
+func networkEndpointGroupLockKey(name string) string {
+	return "networkEndpointGroups/" + name
+}
+
+func backendServiceLockKey(name string) string {
+	return "backendServices/" + name
+}
+
+func healthCheckLockKey(name string) string {
+	return "healthChecks/" + name
+}
+
+func regionHealthCheckLockKey(name string) string {
+	return "regionHealthChecks/" + name
+}
+
+func httpHealthCheckLockKey(name string) string {
+	return "httpHealthChecks/" + name
+}
+
+// internalBackendLockKeys returns the lock keys of the backends of the internal load balancer of the
+// service: the instance groups of the cluster, or the network endpoint groups of the load balancer,
+// which are also updated when the endpoints of the service change, and the backend services if they
+// are shared by the load balancers of the cluster.
+func (g *Cloud) internalBackendLockKeys(svc *v1.Service, loadBalancerName, clusterID string, backendServiceNames []string) []string {
+	var keys []string
+	if useNEGs, _ := g.usesInternalNEGs(svc); useNEGs {
+		keys = append(keys, networkEndpointGroupLockKey(loadBalancerName))
+	} else {
+		keys = append(keys, instanceGroupLockKey(makeInstanceGroupName(clusterID)))
+	}
+	if shareBackendService(svc) {
+		for _, name := range backendServiceNames {
+			keys = append(keys, backendServiceLockKey(name))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,134 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lockedWithin returns whether the keys are locked within the timeout, and unlocks them if so.
+func lockedWithin(m *keyedMutex, timeout time.Duration, keys ...string) bool {
+	locked := make(chan func())
+	go func() { locked <- m.lock(keys...) }()
+	select {
+	case unlock := <-locked:
+		unlock()
+		return true
+	case <-time.After(timeout):
+		// Unlock the keys once they are eventually locked.
+		go func() { (<-locked)() }()
+		return false
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	t.Parallel()
+
+	m := &keyedMutex{}
+	unlock := m.lock("b", "a", "b")
+	assert.True(t, lockedWithin(m, time.Second, "c"), "unrelated key blocked")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.lock("c", "b")()
+	}()
+	select {
+	case <-done:
+		t.Fatal("locked key not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("unlocked key still blocked")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Empty(t, m.locks, "unreferenced mutexes are dropped")
+}
+
+func TestInternalBackendLockKeys(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	igKey := instanceGroupLockKey(makeInstanceGroupName(vals.ClusterID))
+	assert.Equal(t, []string{igKey}, gce.internalBackendLockKeys(svc, "lb", vals.ClusterID, []string{"lb"}))
+
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	assert.Equal(t, []string{networkEndpointGroupLockKey("lb")}, gce.internalBackendLockKeys(svc, "lb", vals.ClusterID, []string{"lb"}))
+}
+
+func TestEnsureInternalLoadBalancerLocksSharedResources(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	newFakeNetworkEndpoints(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	unlock := gce.sharedResourceLocks.lock(instanceGroupLockKey(makeInstanceGroupName(vals.ClusterID)))
+
+	// A load balancer backed by its own network endpoint groups does not wait for the instance groups.
+	negSvc := fakeLoadbalancerService(string(LBTypeInternal))
+	negSvc.Name, negSvc.UID = "neg", "neg-uid"
+	negSvc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	negSvc, err = gce.client.CoreV1().Services(negSvc.Namespace).Create(context.TODO(), negSvc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, negSvc, nodes)
+	require.NoError(t, err)
+
+	// A load balancer backed by the instance groups waits for them to be unlocked.
+	igSvc := fakeLoadbalancerService(string(LBTypeInternal))
+	igSvc.Name, igSvc.UID = "ig", "ig-uid"
+	igSvc, err = gce.client.CoreV1().Services(igSvc.Namespace).Create(context.TODO(), igSvc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, igSvc, nodes)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("load balancer ensured while its instance groups are locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("load balancer not ensured after its instance groups are unlocked")
+	}
+}
@@ -30,7 +30,12 @@ import (
 )
 
 const (
-	label = "feature"
+	label     = "feature"
+	statLabel = "stat"
+	zoneLabel = "zone"
+
+	subsetSizeMax   = "max"
+	subsetSizeTotal = "total"
 )
 
 var (
@@ -43,12 +48,40 @@ var (
 		},
 		[]string{label},
 	)
+	l4ILBSubsetSize = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "l4_ilb_subset_size",
+			Help:           "Number of nodes in the subsets of L4 ILBs with subsetting, the largest subset or all subsets",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{statLabel},
+	)
+	instanceGroupUpdateLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:           "l4_instance_group_update_duration_seconds",
+			Help:           "Latency of the updates of the instance groups of the cluster, per zone",
+			Buckets:        metrics.ExponentialBuckets(0.5, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{zoneLabel},
+	)
+	sharedResourceLockWaitLatency = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Name:           "l4_shared_resource_lock_wait_duration_seconds",
+			Help:           "Time waited by L4 load balancer operations for the locks of the resources they share",
+			Buckets:        metrics.ExponentialBuckets(0.01, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
 )
 
 // init registers L4 internal loadbalancer usage metrics.
 func init() {
 	klog.V(3).Infof("Registering Service Controller loadbalancer usage metrics %v", l4ILBCount)
 	legacyregistry.MustRegister(l4ILBCount)
+	legacyregistry.MustRegister(l4ILBSubsetSize)
+	legacyregistry.MustRegister(instanceGroupUpdateLatency)
+	legacyregistry.MustRegister(sharedResourceLockWaitLatency)
 }
 
 // LoadBalancerMetrics is a cache that contains loadbalancer service resource
@@ -70,6 +103,7 @@ const (
 	l4ILBService      = feature("L4ILBService")
 	l4ILBGlobalAccess = feature("L4ILBGlobalAccess")
 	l4ILBCustomSubnet = feature("L4ILBCustomSubnet")
+	l4ILBSubsetting   = feature("L4ILBSubsetting")
 	// l4ILBInSuccess feature specifies that ILB VIP is configured.
 	l4ILBInSuccess = feature("L4ILBInSuccess")
 	// l4ILBInInError feature specifies that an error had occurred for this service
@@ -86,6 +120,10 @@ type L4ILBServiceState struct {
 	EnabledCustomSubnet bool
 	// InSuccess specifies if the ILB service VIP is configured.
 	InSuccess bool
+	// EnabledSubsetting specifies if the ILB was provisioned with subsetting.
+	EnabledSubsetting bool
+	// SubsetSize is the number of nodes in the backends of an ILB with subsetting.
+	SubsetSize int
 }
 
 // loadbalancerMetricsCollector is an interface to update/delete L4 loadbalancer
@@ -145,6 +183,11 @@ func (lm *LoadBalancerMetrics) export() {
 	for feature, count := range ilbCount {
 		l4ILBCount.With(map[string]string{label: feature.String()}).Set(float64(count))
 	}
+	subsetSizes := lm.computeL4ILBSubsetSizes()
+	klog.V(5).Infof("Exporting L4 ILB subset sizes: %#v", subsetSizes)
+	for stat, size := range subsetSizes {
+		l4ILBSubsetSize.With(map[string]string{statLabel: stat}).Set(float64(size))
+	}
 	klog.V(5).Infof("L4 ILB usage metrics exported.")
 }
 
@@ -157,12 +200,13 @@ func (lm *LoadBalancerMetrics) computeL4ILBMetrics() map[feature]int {
 		l4ILBService:      0,
 		l4ILBGlobalAccess: 0,
 		l4ILBCustomSubnet: 0,
+		l4ILBSubsetting:   0,
 		l4ILBInSuccess:    0,
 		l4ILBInError:      0,
 	}
 
 	for key, state := range lm.l4ILBServiceMap {
-		klog.V(6).Infof("ILB Service %s has EnabledGlobalAccess: %t, EnabledCustomSubnet: %t, InSuccess: %t", key, state.EnabledGlobalAccess, state.EnabledCustomSubnet, state.InSuccess)
+		klog.V(6).Infof("ILB Service %s has EnabledGlobalAccess: %t, EnabledCustomSubnet: %t, EnabledSubsetting: %t, InSuccess: %t", key, state.EnabledGlobalAccess, state.EnabledCustomSubnet, state.EnabledSubsetting, state.InSuccess)
 		counts[l4ILBService]++
 		if !state.InSuccess {
 			counts[l4ILBInError]++
@@ -176,7 +220,30 @@ func (lm *LoadBalancerMetrics) computeL4ILBMetrics() map[feature]int {
 		if state.EnabledCustomSubnet {
 			counts[l4ILBCustomSubnet]++
 		}
+		if state.EnabledSubsetting {
+			counts[l4ILBSubsetting]++
+		}
 	}
 	klog.V(4).Info("L4 ILB usage metrics computed.")
 	return counts
 }
+
+// computeL4ILBSubsetSizes aggregates the subset sizes of the L4 ILBs with subsetting in the cache.
+func (lm *LoadBalancerMetrics) computeL4ILBSubsetSizes() map[string]int {
+	lm.Lock()
+	defer lm.Unlock()
+	sizes := map[string]int{
+		subsetSizeMax:   0,
+		subsetSizeTotal: 0,
+	}
+	for _, state := range lm.l4ILBServiceMap {
+		if !state.EnabledSubsetting || !state.InSuccess {
+			continue
+		}
+		if state.SubsetSize > sizes[subsetSizeMax] {
+			sizes[subsetSizeMax] = state.SubsetSize
+		}
+		sizes[subsetSizeTotal] += state.SubsetSize
+	}
+	return sizes
+}
@@ -40,6 +40,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      0,
 				l4ILBGlobalAccess: 0,
 				l4ILBCustomSubnet: 0,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    0,
 				l4ILBInError:      0,
 			},
@@ -53,6 +54,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      1,
 				l4ILBGlobalAccess: 0,
 				l4ILBCustomSubnet: 0,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    1,
 				l4ILBInError:      0,
 			},
@@ -66,6 +68,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      1,
 				l4ILBGlobalAccess: 0,
 				l4ILBCustomSubnet: 0,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    0,
 				l4ILBInError:      1,
 			},
@@ -79,6 +82,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      1,
 				l4ILBGlobalAccess: 1,
 				l4ILBCustomSubnet: 0,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    1,
 				l4ILBInError:      0,
 			},
@@ -92,6 +96,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      1,
 				l4ILBGlobalAccess: 0,
 				l4ILBCustomSubnet: 1,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    1,
 				l4ILBInError:      0,
 			},
@@ -105,6 +110,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      1,
 				l4ILBGlobalAccess: 1,
 				l4ILBCustomSubnet: 1,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    1,
 				l4ILBInError:      0,
 			},
@@ -121,6 +127,7 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      4,
 				l4ILBGlobalAccess: 2,
 				l4ILBCustomSubnet: 2,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    4,
 				l4ILBInError:      0,
 			},
@@ -139,10 +146,27 @@ func TestComputeL4ILBMetrics(t *testing.T) {
 				l4ILBService:      6,
 				l4ILBGlobalAccess: 2,
 				l4ILBCustomSubnet: 2,
+				l4ILBSubsetting:   0,
 				l4ILBInSuccess:    4,
 				l4ILBInError:      2,
 			},
 		},
+		{
+			desc: "l4 ilb services with subsetting",
+			serviceStates: []L4ILBServiceState{
+				newL4ILBServiceState(false, false, true),
+				newL4ILBSubsettingServiceState(25, true),
+				newL4ILBSubsettingServiceState(3, false),
+			},
+			expectL4ILBCount: map[feature]int{
+				l4ILBService:      3,
+				l4ILBGlobalAccess: 0,
+				l4ILBCustomSubnet: 0,
+				l4ILBSubsetting:   1,
+				l4ILBInSuccess:    2,
+				l4ILBInError:      1,
+			},
+		},
 	} {
 		tc := tc
 		t.Run(tc.desc, func(t *testing.T) {
@@ -168,3 +192,49 @@ func newL4ILBServiceState(globalAccess, customSubnet, inSuccess bool) L4ILBServi
 		InSuccess:           inSuccess,
 	}
 }
+
+func newL4ILBSubsettingServiceState(subsetSize int, inSuccess bool) L4ILBServiceState {
+	return L4ILBServiceState{
+		EnabledSubsetting: true,
+		SubsetSize:        subsetSize,
+		InSuccess:         inSuccess,
+	}
+}
+
+func TestComputeL4ILBSubsetSizes(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc          string
+		serviceStates []L4ILBServiceState
+		expectSizes   map[string]int
+	}{
+		{
+			desc:          "no l4 ilb service with subsetting",
+			serviceStates: []L4ILBServiceState{newL4ILBServiceState(false, false, true)},
+			expectSizes:   map[string]int{subsetSizeMax: 0, subsetSizeTotal: 0},
+		},
+		{
+			desc: "l4 ilb services with subsetting",
+			serviceStates: []L4ILBServiceState{
+				newL4ILBSubsettingServiceState(25, true),
+				newL4ILBSubsettingServiceState(10, true),
+				newL4ILBSubsettingServiceState(40, false),
+			},
+			expectSizes: map[string]int{subsetSizeMax: 25, subsetSizeTotal: 35},
+		},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			newMetrics := LoadBalancerMetrics{
+				l4ILBServiceMap: make(map[string]L4ILBServiceState),
+			}
+			for i, serviceState := range tc.serviceStates {
+				newMetrics.SetL4ILBService(strconv.Itoa(i), serviceState)
+			}
+			if diff := cmp.Diff(tc.expectSizes, newMetrics.computeL4ILBSubsetSizes()); diff != "" {
+				t.Fatalf("Got diff for L4 ILB subset sizes (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
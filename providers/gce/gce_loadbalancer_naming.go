@@ -22,6 +22,7 @@ package gce
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -84,6 +85,21 @@ func makeHealthCheckFirewallName(loadBalancerName, clusterID string, shared bool
 	return loadBalancerName + "-hc"
 }
 
+// makeIPv6ResourceName returns the name of the IPv6 counterpart of a load balancer resource.
+// Dual-stack internal load balancers need a separate IPv6 forwarding rule, address and firewalls.
+func makeIPv6ResourceName(name string) string {
+	return name + "-ipv6"
+}
+
+// makeProtocolResourceName returns the name of a resource serving one protocol of a load balancer with
+// mixed protocols. Resources of the primary protocol keep the name they had with a single protocol.
+func makeProtocolResourceName(name string, protocol v1.Protocol, primary bool) string {
+	if primary {
+		return name
+	}
+	return name + "-" + strings.ToLower(string(protocol))
+}
+
 func makeBackendServiceDescription(nm types.NamespacedName, shared bool) string {
 	if shared {
 		return ""
@@ -91,6 +107,88 @@ func makeBackendServiceDescription(nm types.NamespacedName, shared bool) string
 	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s"}`, nm.String())
 }
 
+// Load Balancer Name
+
+// LoadBalancerNamingScheme is the naming scheme of the load balancers of new services.
+type LoadBalancerNamingScheme string
+
+const (
+	// LoadBalancerNamingSchemeV1 names load balancers "a" followed by the service UID,
+	// see cloudprovider.DefaultLoadBalancerName. It is the default.
+	LoadBalancerNamingSchemeV1 LoadBalancerNamingScheme = "v1"
+	// LoadBalancerNamingSchemeV2 names load balancers after the cluster ID, namespace and
+	// name of the service, see makeLoadBalancerNameV2.
+	LoadBalancerNamingSchemeV2 LoadBalancerNamingScheme = "v2"
+)
+
+const (
+	// loadBalancerNamePrefixV2 is the prefix of load balancer names of the v2 naming scheme. It
+	// differs from the "k8s1" and "k8s2" prefixes of the load balancers of ingress-gce.
+	loadBalancerNamePrefixV2 = "ccm2"
+	// maxResourceNameLength is the maximum length of the names of GCE resources.
+	maxResourceNameLength = 63
+	// maxLoadBalancerNameAffixesLength is the length of the longest prefix and suffixes added
+	// together to the load balancer name by its resources: the shards of the IPv6 traffic
+	// firewall, "k8s-fw-{name}-ipv6-{shard}", with up to 999 shards.
+	maxLoadBalancerNameAffixesLength = len("k8s-fw-") + len("-ipv6") + len("-999")
+	// maxLoadBalancerNameLengthV2 leaves room for maxLoadBalancerNameAffixesLength within
+	// maxResourceNameLength.
+	maxLoadBalancerNameLengthV2 = maxResourceNameLength - maxLoadBalancerNameAffixesLength
+	// clusterIDLengthV2 and hashLengthV2 are the lengths of the cluster ID and the hash in v2 names.
+	clusterIDLengthV2 = 8
+	hashLengthV2      = 8
+)
+
+// makeLoadBalancerNameV2 returns the v2 name of the load balancer of a service:
+//
+//	ccm2-{cluster id}-{namespace}-{name}-{hash}
+//
+// The cluster ID, namespace and name are truncated to fit in maxLoadBalancerNameLengthV2,
+// the hash of their full values keeps the names of different services apart.
+func makeLoadBalancerNameV2(clusterID, namespace, name string) string {
+	hash := sha1.Sum([]byte(strings.Join([]string{clusterID, namespace, name}, ";")))
+	suffix := hex.EncodeToString(hash[:])[:hashLengthV2]
+
+	prefix := loadBalancerNamePrefixV2
+	if clusterID != "" {
+		prefix += "-" + truncate(strings.ToLower(clusterID), clusterIDLengthV2)
+	}
+	// Three dashes separate the prefix, namespace, name and suffix.
+	budget := maxLoadBalancerNameLengthV2 - len(prefix) - len(suffix) - 3
+	namespace, name = truncatePair(namespace, name, budget)
+	return fmt.Sprintf("%s-%s-%s-%s", prefix, namespace, name, suffix)
+}
+
+// isLoadBalancerNameV2 returns whether the load balancer name follows the v2 naming scheme.
+func isLoadBalancerNameV2(loadBalancerName string) bool {
+	return strings.HasPrefix(loadBalancerName, loadBalancerNamePrefixV2+"-")
+}
+
+// truncatePair truncates a and b so that their combined length is at most maxLen, sharing
+// the characters fairly between them: the shorter one is kept whole when possible.
+func truncatePair(a, b string, maxLen int) (string, string) {
+	if len(a)+len(b) <= maxLen {
+		return a, b
+	}
+	half := maxLen / 2
+	switch {
+	case len(a) <= half:
+		return a, truncate(b, maxLen-len(a))
+	case len(b) <= half:
+		return truncate(a, maxLen-len(b)), b
+	default:
+		return truncate(a, half), truncate(b, maxLen-half)
+	}
+}
+
+// truncate returns the first maxLen characters of s.
+func truncate(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen]
+}
+
 // External Load Balancer
 
 // makeServiceDescription is used to generate descriptions for forwarding rules and addresses.
@@ -104,10 +202,25 @@ func MakeNodesHealthCheckName(clusterID string) string {
 	return fmt.Sprintf("k8s-%v-node", clusterID)
 }
 
-func makeHealthCheckDescription(serviceName string) string {
+// makeHealthCheckDescription returns the description of the health check of a load balancer. The
+// health checks tuned by the annotations of their service are marked, so that they are reset to the
+// default tuning once the annotations are removed.
+func makeHealthCheckDescription(serviceName string, tuned bool) string {
+	if tuned {
+		return fmt.Sprintf(`{"kubernetes.io/service-name":"%s", "kubernetes.io/health-check-tuned":"true"}`, serviceName)
+	}
 	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s"}`, serviceName)
 }
 
+// isTunedHealthCheckDescription returns whether the description marks a health check tuned by the
+// annotations of its service, see makeHealthCheckDescription.
+func isTunedHealthCheckDescription(description string) bool {
+	d := struct {
+		Tuned string `json:"kubernetes.io/health-check-tuned"`
+	}{}
+	return json.Unmarshal([]byte(description), &d) == nil && d.Tuned == "true"
+}
+
 // MakeHealthCheckFirewallName returns the firewall name used by the GCE load
 // balancers (l4) for performing health checks.
 func MakeHealthCheckFirewallName(clusterID, hcName string, isNodesHealthCheck bool) string {
@@ -123,7 +236,24 @@ func MakeFirewallName(name string) string {
 	return fmt.Sprintf("k8s-fw-%s", name)
 }
 
-func makeFirewallDescription(serviceName, ipAddress string) string {
-	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s", "kubernetes.io/service-ip":"%s"}`,
-		serviceName, ipAddress)
+// makeNetLBHealthCheckFirewallName returns the name of the firewall allowing health checks of
+// backend service based external load balancers. It differs from the health check firewalls of
+// target pools and internal load balancers, so that shared firewalls are deleted independently.
+func makeNetLBHealthCheckFirewallName(loadBalancerName, clusterID string, shared bool) string {
+	if shared {
+		return fmt.Sprintf("k8s-%s-node-netlb-hc", clusterID)
+	}
+	return loadBalancerName + "-netlb-hc"
+}
+
+// makeFirewallDescription returns the description of the firewalls of a load balancer. The firewalls
+// of v2 named load balancers also name the cluster ID. The firewalls of legacy load balancers keep
+// their former description, so that they are not all updated on upgrade.
+func makeFirewallDescription(loadBalancerName, serviceName, ipAddress, clusterID string) string {
+	if !isLoadBalancerNameV2(loadBalancerName) {
+		return fmt.Sprintf(`{"kubernetes.io/service-name":"%s", "kubernetes.io/service-ip":"%s"}`,
+			serviceName, ipAddress)
+	}
+	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s", "kubernetes.io/service-ip":"%s", "kubernetes.io/cluster-id":"%s"}`,
+		serviceName, ipAddress, clusterID)
 }
@@ -0,0 +1,76 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// hasLoadBalancerNodeSelector returns whether the service selects the nodes of its load balancer.
+func hasLoadBalancerNodeSelector(svc *v1.Service) bool {
+	_, ok := svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector]
+	return ok
+}
+
+// loadBalancerNodes returns the nodes matching the node selector of the service, which are the only
+// nodes its load balancer sends traffic to. No node matching is an error, as firewalls without
+// target would apply to all the instances of the network.
+func loadBalancerNodes(svc *v1.Service, nodes []*v1.Node) ([]*v1.Node, error) {
+	if !hasLoadBalancerNodeSelector(svc) || len(nodes) == 0 {
+		return nodes, nil
+	}
+	selector, err := GetLoadBalancerAnnotationNodeSelector(svc)
+	if err != nil {
+		return nil, err
+	}
+	var selected []*v1.Node
+	for _, node := range nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			selected = append(selected, node)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("none of the %d nodes matches the selector %q of annotation %s", len(nodes), selector, ServiceAnnotationLoadBalancerNodeSelector)
+	}
+	klog.V(2).Infof("loadBalancerNodes(%v/%v): selected %d of %d nodes with %q", svc.Namespace, svc.Name, len(selected), len(nodes), selector)
+	return selected, nil
+}
+
+// usesNodesHealthCheck returns whether the load balancer of the service is health checked by the
+// nodes health check shared by the load balancers of the cluster. Load balancers routing traffic to
+// local endpoints only, or to selected nodes, have their own health check and health check firewall.
+func usesNodesHealthCheck(svc *v1.Service) bool {
+	return !servicehelpers.RequestsOnlyLocalTraffic(svc) && !hasLoadBalancerNodeSelector(svc)
+}
+
+// checkInstanceGroupNodeSelector returns an error if the service selects the nodes of its load
+// balancer while the load balancer is backed by the instance groups of the cluster, which are shared
+// by the load balancers and contain all the nodes.
+func checkInstanceGroupNodeSelector(svc *v1.Service) error {
+	if hasLoadBalancerNodeSelector(svc) {
+		return fmt.Errorf("annotation %s is not supported by load balancers backed by the instance groups of the cluster", ServiceAnnotationLoadBalancerNodeSelector)
+	}
+	return nil
+}
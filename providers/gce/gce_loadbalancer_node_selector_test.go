@@ -0,0 +1,234 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+// createAndInsertPoolNodes creates the nodes and labels them with the pool of their name.
+func createAndInsertPoolNodes(t *testing.T, gce *Cloud, zone string, pools map[string]string) []*v1.Node {
+	var names []string
+	for name := range pools {
+		names = append(names, name)
+	}
+	nodes, err := createAndInsertNodes(gce, names, zone)
+	require.NoError(t, err)
+	for _, node := range nodes {
+		node.Labels["pool"] = pools[node.Name]
+	}
+	return nodes
+}
+
+func TestLoadBalancerNodes(t *testing.T) {
+	t.Parallel()
+
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ingress-1", Labels: map[string]string{"pool": "ingress"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "batch-1", Labels: map[string]string{"pool": "batch", "batch": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "default-1"}},
+	}
+	for _, tc := range []struct {
+		desc     string
+		selector *string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			desc: "no annotation",
+			want: []string{"ingress-1", "batch-1", "default-1"},
+		},
+		{
+			desc:     "equality",
+			selector: pointer.String("pool=ingress"),
+			want:     []string{"ingress-1"},
+		},
+		{
+			desc:     "not existing",
+			selector: pointer.String("!batch"),
+			want:     []string{"ingress-1", "default-1"},
+		},
+		{
+			desc:     "no matching node",
+			selector: pointer.String("pool=gpu"),
+			wantErr:  true,
+		},
+		{
+			desc:     "invalid selector",
+			selector: pointer.String("pool in ingress"),
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService("")
+			if tc.selector != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector] = *tc.selector
+			}
+			selected, err := loadBalancerNodes(svc, nodes)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, nodeNames(selected))
+		})
+	}
+}
+
+func TestEnsureInternalLoadBalancerWithNodeSelector(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	endpoints := newFakeNetworkEndpoints(gce)
+	nodes := createAndInsertPoolNodes(t, gce, vals.ZoneName, map[string]string{"ingress-1": "ingress", "ingress-2": "ingress", "batch-1": "batch"})
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector] = "pool=ingress"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The network endpoint group backends must be selected explicitly.
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ServiceAnnotationILBBackendType)
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeNEG)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// The load balancer is backed by its own network endpoint groups and health check.
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	assert.Equal(t, []string{"ingress-1", "ingress-2"}, endpoints[*meta.ZonalKey(lbName, vals.ZoneName)].List())
+	_, err = gce.GetInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName)
+	assert.True(t, isNotFound(err), "instance group created: %v", err)
+	hcName := makeHealthCheckName(lbName, vals.ClusterID, false)
+	_, err = gce.GetHealthCheck(hcName)
+	require.NoError(t, err)
+	for _, fwName := range []string{MakeFirewallName(lbName), makeHealthCheckFirewallName(lbName, vals.ClusterID, false)} {
+		fw, err := gce.GetFirewall(fwName)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"ingress-1", "ingress-2"}, fw.TargetTags, "firewall %s", fwName)
+	}
+
+	// Relabeled nodes follow the selector.
+	nodes[0].Labels["pool"] = "ingress"
+	nodes[1].Labels["pool"] = "ingress"
+	nodes[2].Labels["pool"] = "ingress"
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes))
+	assert.Equal(t, []string{"batch-1", "ingress-1", "ingress-2"}, endpoints[*meta.ZonalKey(lbName, vals.ZoneName)].List())
+
+	// Instance group backends contain all the nodes.
+	svc.Annotations[ServiceAnnotationILBBackendType] = string(ILBBackendTypeInstanceGroup)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+}
+
+func TestEnsureInternalLoadBalancerWithNodeSelectorKeepsInstanceGroups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	newFakeNetworkEndpoints(gce)
+	nodes := createAndInsertPoolNodes(t, gce, vals.ZoneName, map[string]string{"ingress-1": "ingress", "batch-1": "batch"})
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+
+	// Selecting the nodes of an existing load balancer does not replace its instance groups.
+	svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector] = "pool=ingress"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+	updatedBS, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, bs.Backends, updatedBS.Backends)
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group created: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerWithNodeSelector(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes := createAndInsertPoolNodes(t, gce, vals.ZoneName, map[string]string{"ingress-1": "ingress", "batch-1": "batch"})
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector] = "pool=ingress"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	require.Len(t, pool.Instances, 1)
+	assert.Contains(t, pool.Instances[0], "ingress-1")
+
+	// The load balancer checks the nodes with its own health check.
+	hc, err := gce.GetHTTPHealthCheck(lbName)
+	require.NoError(t, err)
+	assert.Equal(t, GetNodesHealthCheckPath(), hc.RequestPath)
+	assert.Equal(t, []string{hc.SelfLink}, pool.HealthChecks)
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.True(t, isNotFound(err), "nodes health check created: %v", err)
+	for _, fwName := range []string{MakeFirewallName(lbName), MakeHealthCheckFirewallName(vals.ClusterID, lbName, false)} {
+		fw, err := gce.GetFirewall(fwName)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ingress-1"}, fw.TargetTags, "firewall %s", fwName)
+	}
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	_, err = gce.GetHTTPHealthCheck(lbName)
+	assert.True(t, isNotFound(err), "health check not deleted: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerRBSWithNodeSelector(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{AlphaFeatureNetLBRBS})
+	nodes := createAndInsertPoolNodes(t, gce, vals.ZoneName, map[string]string{"ingress-1": "ingress"})
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[RBSAnnotationKey] = RBSEnabled
+	svc.Annotations[ServiceAnnotationLoadBalancerNodeSelector] = "pool=ingress"
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.ErrorContains(t, err, ServiceAnnotationLoadBalancerNodeSelector)
+}
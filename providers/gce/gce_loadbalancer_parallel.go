@@ -0,0 +1,67 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// defaultLoadBalancerUpdateConcurrency is the number of zonal instance groups updated at once
+	// if load-balancer-update-concurrency is not set.
+	defaultLoadBalancerUpdateConcurrency = 4
+	// maxInstancesPerInstanceGroupUpdate is the maximum number of instances added to or removed
+	// from an instance group in one request.
+	maxInstancesPerInstanceGroupUpdate = 500
+)
+
+// updateConcurrency returns the maximum number of zonal instance groups updated at once.
+func (g *Cloud) updateConcurrency() int {
+	if g.loadBalancerUpdateConcurrency > 0 {
+		return g.loadBalancerUpdateConcurrency
+	}
+	return defaultLoadBalancerUpdateConcurrency
+}
+
+// parallelize calls f for every piece from 0 to pieces-1, at most updateConcurrency at once, and
+// returns the errors of all the calls.
+func (g *Cloud) parallelize(pieces int, f func(piece int) error) error {
+	errs := make([]error, pieces)
+	workqueue.ParallelizeUntil(context.TODO(), g.updateConcurrency(), pieces, func(piece int) {
+		errs[piece] = f(piece)
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
+// batches splits the items in consecutive batches of at most size items.
+func batches[T any](items []T, size int) [][]T {
+	var b [][]T
+	for len(items) > size {
+		b = append(b, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		b = append(b, items)
+	}
+	return b
+}
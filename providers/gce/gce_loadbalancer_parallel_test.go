@@ -0,0 +1,115 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestBatches(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, batches([]int{}, 2))
+	assert.Equal(t, [][]int{{1, 2}}, batches([]int{1, 2}, 2))
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, batches([]int{1, 2, 3, 4, 5}, 2))
+}
+
+func TestParallelize(t *testing.T) {
+	t.Parallel()
+
+	g := &Cloud{loadBalancerUpdateConcurrency: 2}
+	var running, maxRunning int32
+	var mu sync.Mutex
+	done := map[int]bool{}
+	err := g.parallelize(6, func(piece int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		done[piece] = true
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		if piece%2 == 1 {
+			return fmt.Errorf("piece %d failed", piece)
+		}
+		return nil
+	})
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Len(t, done, 6, "all the pieces are processed despite the errors")
+	require.Error(t, err)
+	for _, piece := range []int{1, 3, 5} {
+		assert.Contains(t, err.Error(), fmt.Sprintf("piece %d failed", piece))
+	}
+	assert.Equal(t, defaultLoadBalancerUpdateConcurrency, (&Cloud{}).updateConcurrency())
+}
+
+func TestEnsureInternalInstanceGroupsInBatches(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	addRequests := map[string][]int{}
+	c := gce.c.(*cloud.MockGCE)
+	addInstancesHook := c.MockInstanceGroups.AddInstancesHook
+	c.MockInstanceGroups.AddInstancesHook = func(ctx context.Context, key *meta.Key, req *compute.InstanceGroupsAddInstancesRequest, m *cloud.MockInstanceGroups, options ...cloud.Option) error {
+		mu.Lock()
+		defer mu.Unlock()
+		addRequests[key.Zone] = append(addRequests[key.Zone], len(req.Instances))
+		return addInstancesHook(ctx, key, req, m, options...)
+	}
+
+	var names []string
+	for i := 0; i < maxInstancesPerInstanceGroupUpdate+10; i++ {
+		names = append(names, fmt.Sprintf("node-%d", i))
+	}
+	nodes, err := createAndInsertNodes(gce, names, vals.ZoneName)
+	require.NoError(t, err)
+	secondaryNodes, err := createAndInsertNodes(gce, []string{"secondary-node"}, vals.SecondaryZoneName)
+	require.NoError(t, err)
+
+	igName := makeInstanceGroupName(vals.ClusterID)
+	igLinks, err := gce.ensureInternalInstanceGroups(igName, append(append([]*v1.Node{}, nodes...), secondaryNodes...))
+	require.NoError(t, err)
+	assert.Len(t, igLinks, 2)
+	assert.Equal(t, map[string][]int{
+		vals.ZoneName:          {maxInstancesPerInstanceGroupUpdate, 10},
+		vals.SecondaryZoneName: {1},
+	}, addRequests)
+	instances, err := gce.ListInstancesInInstanceGroup(igName, vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	assert.Len(t, instances, len(names))
+}
@@ -0,0 +1,106 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// In dry run mode the load balancer implementation reports the changes it would make to the GCE
+// resources of a load balancer and to its service as Service events and structured logs, without
+// changing anything. The changes are recorded by the dry run layer, see dryRunRecorder.
+
+const (
+	planActionCreate = "Create"
+	planActionUpdate = "Update"
+	planActionDelete = "Delete"
+
+	planResourceForwardingRule       = "ForwardingRule"
+	planResourceBackendService       = "BackendService"
+	planResourceHealthCheck          = "HealthCheck"
+	planResourceRegionHealthCheck    = "RegionHealthCheck"
+	planResourceHTTPHealthCheck      = "HttpHealthCheck"
+	planResourceTargetPool           = "TargetPool"
+	planResourceFirewall             = "Firewall"
+	planResourceFirewallPolicyRule   = "FirewallPolicyRule"
+	planResourceInstanceGroup        = "InstanceGroup"
+	planResourceNetworkEndpointGroup = "NetworkEndpointGroup"
+	planResourceAddress              = "Address"
+	planResourceService              = "Service"
+
+	eventReasonLoadBalancerDryRun = "LoadBalancerDryRun"
+)
+
+// loadBalancerChange is a change the controller would make to a resource of a load balancer.
+type loadBalancerChange struct {
+	action   string
+	resource string
+	name     string
+	detail   string
+}
+
+// loadBalancerPlan lists the changes the controller would make to the resources of a load balancer.
+type loadBalancerPlan struct {
+	changes []loadBalancerChange
+}
+
+func (p *loadBalancerPlan) add(action, resource, name, format string, args ...interface{}) {
+	p.changes = append(p.changes, loadBalancerChange{action: action, resource: resource, name: name, detail: fmt.Sprintf(format, args...)})
+}
+
+// remove removes the creation and the updates of the resource from the plan.
+func (p *loadBalancerPlan) remove(resource, name string) {
+	changes := p.changes[:0]
+	for _, change := range p.changes {
+		if change.resource != resource || change.name != name || change.action == planActionDelete {
+			changes = append(changes, change)
+		}
+	}
+	p.changes = changes
+}
+
+// reportLoadBalancerPlan reports the planned changes as events of the service and structured logs.
+func (g *Cloud) reportLoadBalancerPlan(svc *v1.Service, operation string, plan *loadBalancerPlan) {
+	if len(plan.changes) == 0 {
+		klog.InfoS("Dry run: no load balancer change planned", "service", klog.KObj(svc), "operation", operation)
+		return
+	}
+	for _, change := range plan.changes {
+		klog.InfoS("Dry run: planned load balancer change", "service", klog.KObj(svc), "operation", operation,
+			"action", change.action, "resource", change.resource, "name", change.name, "detail", change.detail)
+		msg := fmt.Sprintf("Dry run: %s would %s %s %s", operation, strings.ToLower(change.action), change.resource, change.name)
+		if change.detail != "" {
+			msg += ": " + change.detail
+		}
+		g.eventRecorder.Event(svc, v1.EventTypeNormal, eventReasonLoadBalancerDryRun, msg)
+	}
+}
+
+// dryRunLoadBalancer runs the load balancer operation in dry run mode and reports the changes it
+// would make, up to its error if it fails.
+func (g *Cloud) dryRunLoadBalancer(svc *v1.Service, operation string, run func() error) error {
+	plan, err := g.loadBalancerDryRun.run(run)
+	g.reportLoadBalancerPlan(svc, operation, plan)
+	return err
+}
@@ -0,0 +1,289 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func fakeDryRunGCECloud(t *testing.T, vals TestClusterValues) (*Cloud, *record.FakeRecorder) {
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	gce.enableLoadBalancerDryRun()
+	return gce, recorder
+}
+
+// dryRunEvents returns the dry run events recorded so far.
+func dryRunEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func dryRunEvent(operation, action, resource, name string) string {
+	return fmt.Sprintf("%s %s Dry run: %s would %s %s %s", v1.EventTypeNormal, eventReasonLoadBalancerDryRun, operation, action, resource, name)
+}
+
+func assertDryRunEvent(t *testing.T, events []string, expected string) {
+	t.Helper()
+	for _, event := range events {
+		if len(event) >= len(expected) && event[:len(expected)] == expected {
+			return
+		}
+	}
+	t.Errorf("missing event %q in %v", expected, events)
+}
+
+// ensureLoadBalancerOutsideDryRun ensures the load balancer of the service outside of a dry run
+// operation, and returns the service as updated by EnsureLoadBalancer and the service controller.
+func ensureLoadBalancerOutsideDryRun(t *testing.T, gce *Cloud, vals TestClusterValues, svc *v1.Service, nodes []*v1.Node) (*v1.Service, *v1.LoadBalancerStatus) {
+	t.Helper()
+	status, err := gce.ensureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	svc.Status.LoadBalancer = *status
+	return svc, status
+}
+
+func TestEnsureExternalLoadBalancerDryRun(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+
+	status, err := gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, &v1.LoadBalancerStatus{}, status)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	events := dryRunEvents(recorder)
+	for _, change := range []struct{ resource, name string }{
+		{planResourceFirewall, MakeFirewallName(lbName)},
+		{planResourceHTTPHealthCheck, MakeNodesHealthCheckName(vals.ClusterID)},
+		{planResourceTargetPool, lbName},
+		{planResourceForwardingRule, lbName},
+	} {
+		assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "create", change.resource, change.name))
+	}
+
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(MakeFirewallName(lbName))
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerDryRun(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	igName := makeInstanceGroupName(vals.ClusterID)
+	hcName := makeHealthCheckName(lbName, vals.ClusterID, true)
+	events := dryRunEvents(recorder)
+	for _, change := range []struct{ resource, name string }{
+		{planResourceInstanceGroup, igName},
+		{planResourceHealthCheck, hcName},
+		{planResourceBackendService, lbName},
+		{planResourceForwardingRule, lbName},
+		{planResourceFirewall, MakeFirewallName(lbName)},
+		{planResourceFirewall, makeHealthCheckFirewallName(lbName, vals.ClusterID, true)},
+	} {
+		assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "create", change.resource, change.name))
+	}
+
+	_, err = gce.GetInstanceGroup(igName, vals.ZoneName)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetHealthCheck(hcName)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureLoadBalancerDryRunExistingLoadBalancer(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	svc, err := gce.client.CoreV1().Services("").Create(context.TODO(), fakeLoadbalancerService(string(LBTypeInternal)), metav1.CreateOptions{})
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc, status := ensureLoadBalancerOutsideDryRun(t, gce, vals, svc, nodes)
+
+	// The load balancer matches the service, nothing is planned.
+	dryRunStatus, err := gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, status, dryRunStatus)
+	assert.Empty(t, dryRunEvents(recorder))
+
+	// Changed ports recreate the forwarding rule and update the firewall.
+	svc.Spec.Ports[0].Port = 8080
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	events := dryRunEvents(recorder)
+	assert.Len(t, events, 3)
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "delete", planResourceForwardingRule, lbName))
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "create", planResourceForwardingRule, lbName))
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "update", planResourceFirewall, MakeFirewallName(lbName)))
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"123"}, fwdRule.Ports)
+}
+
+func TestUpdateLoadBalancerDryRun(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	svc, err := gce.client.CoreV1().Services("").Create(context.TODO(), fakeLoadbalancerService(string(LBTypeInternal)), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes))
+
+	igName := makeInstanceGroupName(vals.ClusterID)
+	assertDryRunEvent(t, dryRunEvents(recorder), dryRunEvent("UpdateLoadBalancer", "update", planResourceInstanceGroup, igName))
+	instances, err := gce.ListInstancesInInstanceGroup(igName, vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	assert.Len(t, instances, 1)
+}
+
+func TestUpdateExternalLoadBalancerDryRun(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	svc := fakeLoadbalancerService("")
+	_, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes))
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	assertDryRunEvent(t, dryRunEvents(recorder), dryRunEvent("UpdateLoadBalancer", "update", planResourceTargetPool, lbName))
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Len(t, pool.Instances, 1)
+}
+
+func TestEnsureLoadBalancerDeletedDryRun(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	svc := fakeLoadbalancerService("")
+	_, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	events := dryRunEvents(recorder)
+	for _, change := range []struct{ resource, name string }{
+		{planResourceForwardingRule, lbName},
+		{planResourceTargetPool, lbName},
+		{planResourceFirewall, MakeFirewallName(lbName)},
+	} {
+		assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancerDeleted", "delete", change.resource, change.name))
+	}
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.NoError(t, err)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.NoError(t, err)
+}
+
+func TestEnsureLoadBalancerDryRunFirewallShards(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, recorder := fakeDryRunGCECloud(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	ranges := makeSourceRanges(maxFirewallSourceRanges + 1)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.LoadBalancerSourceRanges = ranges
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	events := dryRunEvents(recorder)
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "create", planResourceFirewall, fwName))
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "create", planResourceFirewall, firewallShardName(fwName, 1)))
+
+	// Nothing is planned once the shards are in sync.
+	svc, _ = ensureLoadBalancerOutsideDryRun(t, gce, vals, svc, nodes)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Empty(t, dryRunEvents(recorder))
+
+	// The extra shard is deleted once the source ranges fit in one shard.
+	svc.Spec.LoadBalancerSourceRanges = ranges[:1]
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	events = dryRunEvents(recorder)
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "update", planResourceFirewall, fwName))
+	assertDryRunEvent(t, events, dryRunEvent("EnsureLoadBalancer", "delete", planResourceFirewall, firewallShardName(fwName, 1)))
+	_, err = gce.GetFirewall(firewallShardName(fwName, 1))
+	assert.NoError(t, err)
+}
@@ -22,10 +22,13 @@ package gce
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	cloudprovider "k8s.io/cloud-provider"
@@ -213,14 +216,101 @@ func TestEnsureLoadBalancerMixedProtocols(t *testing.T) {
 		Protocol: v1.ProtocolUDP,
 		Port:     int32(8080),
 	})
+	// The condition set by a previous version rejecting the Service is cleared.
+	apiService.Status.Conditions = []metav1.Condition{{Type: v1.LoadBalancerPortsError, Status: metav1.ConditionTrue}}
+	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Create(context.TODO(), apiService, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	ip := status.Ingress[0].IP
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", apiService)
+	for name, protocol := range map[string]string{lbName: "TCP", lbName + "-udp": "UDP"} {
+		fwdRule, err := gce.GetRegionForwardingRule(name, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, protocol, fwdRule.IPProtocol)
+		assert.Equal(t, ip, fwdRule.IPAddress)
+		assert.Equal(t, gce.targetPoolURL(lbName), fwdRule.Target)
+	}
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"123"}}, {IPProtocol: "udp", Ports: []string{"8080"}}}, fw.Allowed)
+	// The forwarding rules share the static IP.
+	addr, err := gce.GetRegionAddress(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ip, addr.Address)
+
+	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Get(context.TODO(), apiService.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, hasLoadBalancerPortsError(apiService))
+
+	// Dropping the UDP port removes its forwarding rule and releases the static IP.
+	apiService.Spec.Ports = apiService.Spec.Ports[:1]
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ip, status.Ingress[0].IP)
+	_, err = gce.GetRegionForwardingRule(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	fw, err = gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"123"}}}, fw.Allowed)
+}
+
+func TestEnsureLoadBalancerDeletedMixedProtocols(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService.Spec.Ports = append(apiService.Spec.Ports, v1.ServicePort{
+		Protocol: v1.ProtocolUDP,
+		Port:     int32(8080),
+	})
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, apiService))
+	assertExternalLbResourcesDeleted(t, gce, apiService, vals, true)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", apiService)
+	_, err = gce.GetRegionForwardingRule(lbName+"-udp", gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureLoadBalancerUnsupportedMixedProtocols(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService.Spec.Ports = append(apiService.Spec.Ports, v1.ServicePort{
+		Protocol: v1.ProtocolSCTP,
+		Port:     int32(8080),
+	})
 	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Create(context.TODO(), apiService, metav1.CreateOptions{})
 	require.NoError(t, err)
 	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
 	if err == nil {
-		t.Errorf("Expected error ensuring loadbalancer for Service with multiple ports")
+		t.Fatalf("Expected error ensuring loadbalancer for Service with multiple ports")
 	}
-	if err.Error() != "mixed protocol is not supported for LoadBalancer" {
-		t.Fatalf("unexpected error, got: %s wanted \"mixed protocol is not supported for LoadBalancer\"", err.Error())
+	if err.Error() != "mixed protocol is only supported for TCP and UDP LoadBalancer ports" {
+		t.Fatalf("unexpected error, got: %s wanted \"mixed protocol is only supported for TCP and UDP LoadBalancer ports\"", err.Error())
 	}
 	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Get(context.TODO(), apiService.Name, metav1.GetOptions{})
 	if err != nil {
@@ -244,7 +334,7 @@ func TestUpdateLoadBalancerMixedProtocols(t *testing.T) {
 
 	apiService := fakeLoadbalancerService("")
 	apiService.Spec.Ports = append(apiService.Spec.Ports, v1.ServicePort{
-		Protocol: v1.ProtocolUDP,
+		Protocol: v1.ProtocolSCTP,
 		Port:     int32(8080),
 	})
 	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Create(context.TODO(), apiService, metav1.CreateOptions{})
@@ -344,7 +434,22 @@ func TestCheckMixedProtocol(t *testing.T) {
 					Port:     int32(53),
 				},
 			},
-			wantErr: fmt.Errorf("mixed protocol is not supported for LoadBalancer"),
+			wantErr: nil,
+		},
+		{
+			name:        "TCP and SCTP",
+			annotations: make(map[string]string),
+			ports: []v1.ServicePort{
+				{
+					Protocol: v1.ProtocolTCP,
+					Port:     int32(80),
+				},
+				{
+					Protocol: v1.ProtocolSCTP,
+					Port:     int32(80),
+				},
+			},
+			wantErr: fmt.Errorf("mixed protocol is only supported for TCP and UDP LoadBalancer ports"),
 		},
 	}
 	for _, test := range tests {
@@ -499,3 +604,155 @@ func TestUpdateLoadBalancerWithLoadBalancerClass(t *testing.T) {
 	err = gce.UpdateLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
 	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
 }
+
+func TestMakeLoadBalancerNameV2(t *testing.T) {
+	gceNameRegexp := regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+	long := strings.Repeat("x", 63)
+	for _, tc := range []struct {
+		desc      string
+		clusterID string
+		namespace string
+		name      string
+		want      string
+	}{
+		{
+			desc:      "short names",
+			clusterID: "0123456789abcdef",
+			namespace: "default",
+			name:      "web",
+			want:      "ccm2-01234567-default-web-",
+		},
+		{
+			desc:      "long name",
+			clusterID: "0123456789abcdef",
+			namespace: "default",
+			name:      long,
+			want:      "ccm2-01234567-default-xxxxxxxxxxxxxxxx-",
+		},
+		{
+			desc:      "long namespace and name",
+			clusterID: "0123456789abcdef",
+			namespace: long,
+			name:      long,
+			want:      "ccm2-01234567-xxxxxxxxxxx-xxxxxxxxxxxx-",
+		},
+		{
+			desc:      "no cluster ID",
+			namespace: "default",
+			name:      "web",
+			want:      "ccm2-default-web-",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			name := makeLoadBalancerNameV2(tc.clusterID, tc.namespace, tc.name)
+			assert.True(t, strings.HasPrefix(name, tc.want), "%q should start with %q", name, tc.want)
+			assert.Len(t, name, len(tc.want)+hashLengthV2)
+			assert.LessOrEqual(t, len(name), maxLoadBalancerNameLengthV2)
+			assert.Regexp(t, gceNameRegexp, name)
+			// The names of the resources of the load balancer fit in GCE names too.
+			for _, resourceName := range []string{
+				firewallShardName(makeIPv6ResourceName(MakeFirewallName(name)), 999),
+				makeIPv6ResourceName(makeProtocolResourceName(name, v1.ProtocolSCTP, false)),
+				MakeHealthCheckFirewallName("", name, false),
+				makeIPv6ResourceName(makeHealthCheckFirewallName(name, "", false)),
+				makeNetLBHealthCheckFirewallName(name, "", false),
+			} {
+				assert.LessOrEqual(t, len(resourceName), maxResourceNameLength, resourceName)
+				assert.Regexp(t, gceNameRegexp, resourceName)
+			}
+		})
+	}
+
+	// Truncated names are kept apart by the hash.
+	assert.NotEqual(t, makeLoadBalancerNameV2("cluster", "default", long+"a"), makeLoadBalancerNameV2("cluster", "default", long+"b"))
+	assert.NotEqual(t, makeLoadBalancerNameV2("cluster-a", "default", "web"), makeLoadBalancerNameV2("cluster-b", "default", "web"))
+}
+
+func TestMakeFirewallDescription(t *testing.T) {
+	t.Parallel()
+
+	// Firewalls of legacy load balancers keep the description they had before the cluster ID was recorded.
+	legacyName := "a0123456789abcdef0123456789abcde"
+	assert.Equal(t, `{"kubernetes.io/service-name":"default/web", "kubernetes.io/service-ip":"1.2.3.4"}`,
+		makeFirewallDescription(legacyName, "default/web", "1.2.3.4", "cluster"))
+	v2Name := makeLoadBalancerNameV2("cluster", "default", "web")
+	assert.Equal(t, `{"kubernetes.io/service-name":"default/web", "kubernetes.io/service-ip":"1.2.3.4", "kubernetes.io/cluster-id":"cluster"}`,
+		makeFirewallDescription(v2Name, "default/web", "1.2.3.4", "cluster"))
+}
+
+func TestEnsureLoadBalancerNamingV2(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.loadBalancerNamingScheme = LoadBalancerNamingSchemeV2
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService.Namespace = "default"
+	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Create(context.TODO(), apiService, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+
+	v2Name := makeLoadBalancerNameV2(vals.ClusterID, apiService.Namespace, apiService.Name)
+	updated, err := gce.client.CoreV1().Services(apiService.Namespace).Get(context.TODO(), apiService.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, v2Name, updated.Annotations[LoadBalancerNameAnnotationKey])
+	assert.Empty(t, apiService.Annotations[LoadBalancerNameAnnotationKey], "the given service must not be modified")
+	assert.Equal(t, v2Name, gce.GetLoadBalancerName(context.TODO(), vals.ClusterName, updated))
+	_, err = gce.GetRegionForwardingRule(v2Name, gce.region)
+	require.NoError(t, err)
+	_, err = gce.GetTargetPool(v2Name, gce.region)
+	require.NoError(t, err)
+
+	// The recorded name is kept when the naming scheme is switched back.
+	gce.loadBalancerNamingScheme = LoadBalancerNamingSchemeV1
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, updated))
+	_, err = gce.GetRegionForwardingRule(v2Name, gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureLoadBalancerNamingV2KeepsLegacyName(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService, err = gce.client.CoreV1().Services(apiService.Namespace).Create(context.TODO(), apiService, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createExternalLoadBalancer(gce, apiService, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	legacyName := cloudprovider.DefaultLoadBalancerName(apiService)
+
+	gce.loadBalancerNamingScheme = LoadBalancerNamingSchemeV2
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+
+	updated, err := gce.client.CoreV1().Services(apiService.Namespace).Get(context.TODO(), apiService.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, legacyName, updated.Annotations[LoadBalancerNameAnnotationKey])
+	_, err = gce.GetRegionForwardingRule(legacyName, gce.region)
+	assert.NoError(t, err)
+	_, err = gce.GetRegionForwardingRule(makeLoadBalancerNameV2(vals.ClusterID, apiService.Namespace, apiService.Name), gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestGetLoadBalancerNameIgnoresUnexpectedName(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService.Annotations[LoadBalancerNameAnnotationKey] = "some-other-load-balancer"
+	assert.Equal(t, cloudprovider.DefaultLoadBalancerName(apiService), gce.GetLoadBalancerName(context.TODO(), vals.ClusterName, apiService))
+}
@@ -0,0 +1,193 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// NodeTagsStrategy is how the network tags targeted by the firewalls of the load balancers are
+// discovered on the instances of the nodes, when they are not listed in the node-tags config.
+type NodeTagsStrategy string
+
+const (
+	// NodeTagsStrategyLongestPrefix picks the longest tag of each instance that is a prefix of its name.
+	NodeTagsStrategyLongestPrefix NodeTagsStrategy = "longest-prefix"
+	// NodeTagsStrategyInstanceLabel picks the tag named by the value of a label of each instance.
+	NodeTagsStrategyInstanceLabel NodeTagsStrategy = "instance-label"
+	// NodeTagsStrategyInstanceMetadata picks the tags listed, separated by commas, in the value of a
+	// metadata key of each instance.
+	NodeTagsStrategyInstanceMetadata NodeTagsStrategy = "instance-metadata"
+	// NodeTagsStrategyRegexp picks all the tags of each instance matching a regular expression.
+	NodeTagsStrategyRegexp NodeTagsStrategy = "regexp"
+
+	eventReasonNodeTagsDiscoveryFailed = "NodeTagsDiscoveryFailed"
+)
+
+// NodeTagsConfig configures the discovery of the network tags of the nodes.
+type NodeTagsConfig struct {
+	// Strategy is the discovery strategy, NodeTagsStrategyLongestPrefix by default.
+	Strategy NodeTagsStrategy
+	// Label is the instance label of NodeTagsStrategyInstanceLabel.
+	Label string
+	// MetadataKey is the instance metadata key of NodeTagsStrategyInstanceMetadata.
+	MetadataKey string
+	// Regexp is the regular expression of NodeTagsStrategyRegexp.
+	Regexp string
+}
+
+// parseNodeTagsConfig returns the discovery of the network tags of the nodes set in the config.
+func parseNodeTagsConfig(global *ConfigGlobal) (NodeTagsConfig, error) {
+	config := NodeTagsConfig{
+		Strategy:    NodeTagsStrategy(global.NodeTagsStrategy),
+		Label:       global.NodeTagsLabel,
+		MetadataKey: global.NodeTagsMetadataKey,
+		Regexp:      global.NodeTagsRegexp,
+	}
+	switch config.Strategy {
+	case "", NodeTagsStrategyLongestPrefix:
+	case NodeTagsStrategyInstanceLabel:
+		if config.Label == "" {
+			return config, fmt.Errorf("node-tags-strategy %s requires node-tags-label", config.Strategy)
+		}
+	case NodeTagsStrategyInstanceMetadata:
+		if config.MetadataKey == "" {
+			return config, fmt.Errorf("node-tags-strategy %s requires node-tags-metadata-key", config.Strategy)
+		}
+	case NodeTagsStrategyRegexp:
+		if config.Regexp == "" {
+			return config, fmt.Errorf("node-tags-strategy %s requires node-tags-regexp", config.Strategy)
+		}
+		if _, err := regexp.Compile(config.Regexp); err != nil {
+			return config, fmt.Errorf("invalid node-tags-regexp %q: %v", config.Regexp, err)
+		}
+	default:
+		return config, fmt.Errorf("unsupported node-tags-strategy %q", config.Strategy)
+	}
+	return config, nil
+}
+
+// instanceTags returns the network tags of the instance targeted by the firewalls of the load
+// balancers, following the configured strategy.
+func (g *Cloud) instanceTags(instance *compute.Instance) ([]string, error) {
+	var instanceTags []string
+	if instance.Tags != nil {
+		instanceTags = instance.Tags.Items
+	}
+	config := g.nodeTagsConfig
+	switch config.Strategy {
+	case NodeTagsStrategyInstanceLabel:
+		tag, ok := instance.Labels[config.Label]
+		if !ok {
+			return nil, fmt.Errorf("instance %s has no label %s naming its network tag", instance.Name, config.Label)
+		}
+		return checkInstanceTags(instance.Name, instanceTags, []string{tag})
+	case NodeTagsStrategyInstanceMetadata:
+		if instance.Metadata != nil {
+			for _, item := range instance.Metadata.Items {
+				if item.Key == config.MetadataKey && item.Value != nil {
+					var tags []string
+					for _, tag := range strings.Split(*item.Value, ",") {
+						if tag = strings.TrimSpace(tag); tag != "" {
+							tags = append(tags, tag)
+						}
+					}
+					return checkInstanceTags(instance.Name, instanceTags, tags)
+				}
+			}
+		}
+		return nil, fmt.Errorf("instance %s has no metadata key %s listing its network tags", instance.Name, config.MetadataKey)
+	case NodeTagsStrategyRegexp:
+		var tags []string
+		for _, tag := range instanceTags {
+			if g.nodeTagsRegexp.MatchString(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("could not find any tag matching %q for instance %s", config.Regexp, instance.Name)
+		}
+		return tags, nil
+	default:
+		longestTag := ""
+		for _, tag := range instanceTags {
+			if strings.HasPrefix(instance.Name, tag) && len(tag) > len(longestTag) {
+				longestTag = tag
+			}
+		}
+		if len(longestTag) == 0 {
+			return nil, fmt.Errorf("could not find any tag that is a prefix of instance name for instance %s", instance.Name)
+		}
+		return []string{longestTag}, nil
+	}
+}
+
+// checkInstanceTags checks that the tags picked for the instance are network tags of the instance,
+// as firewalls targeting other tags would not apply to it.
+func checkInstanceTags(instanceName string, instanceTags, tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no network tag is set for instance %s", instanceName)
+	}
+	if missing := sets.NewString(tags...).Difference(sets.NewString(instanceTags...)); missing.Len() > 0 {
+		return nil, fmt.Errorf("tags %v are not network tags of instance %s", missing.List(), instanceName)
+	}
+	return tags, nil
+}
+
+// hostTags returns the network tags of the hosts, computed once per set of hosts, see GetNodeTags.
+func (g *Cloud) hostTags(hosts []*gceInstance) ([]string, error) {
+	g.computeNodeTagLock.Lock()
+	defer g.computeNodeTagLock.Unlock()
+
+	names := sets.NewString()
+	for _, host := range hosts {
+		names.Insert(host.Name)
+	}
+	if names.Len() > 0 && names.Equal(g.lastKnownNodeNames) {
+		return g.lastComputedNodeTags, nil
+	}
+	tags, err := g.computeHostTags(hosts)
+	if err != nil {
+		return nil, err
+	}
+	g.lastKnownNodeNames = names
+	g.lastComputedNodeTags = tags
+	return tags, nil
+}
+
+// raiseNodeTagsDiscoveryFailedEvent reports on the service that the network tags of the nodes
+// targeted by the firewalls of its load balancer could not be discovered.
+func (g *Cloud) raiseNodeTagsDiscoveryFailedEvent(svc *v1.Service, err error) {
+	strategy := g.nodeTagsConfig.Strategy
+	if strategy == "" {
+		strategy = NodeTagsStrategyLongestPrefix
+	}
+	klog.Warningf("Failed to discover the network tags of the nodes for service %s/%s with strategy %s: %v", svc.Namespace, svc.Name, strategy, err)
+	if g.eventRecorder != nil {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, eventReasonNodeTagsDiscoveryFailed, "Failed to discover the network tags of the nodes with strategy %s: %v", strategy, err)
+	}
+}
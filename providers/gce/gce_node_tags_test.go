@@ -0,0 +1,241 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseNodeTagsConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		global  ConfigGlobal
+		want    NodeTagsConfig
+		wantErr bool
+	}{
+		{
+			desc: "default strategy",
+		},
+		{
+			desc:   "instance label",
+			global: ConfigGlobal{NodeTagsStrategy: "instance-label", NodeTagsLabel: "firewall-tag"},
+			want:   NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "firewall-tag"},
+		},
+		{
+			desc:    "instance label without label",
+			global:  ConfigGlobal{NodeTagsStrategy: "instance-label"},
+			wantErr: true,
+		},
+		{
+			desc:    "instance metadata without key",
+			global:  ConfigGlobal{NodeTagsStrategy: "instance-metadata"},
+			wantErr: true,
+		},
+		{
+			desc:    "invalid regexp",
+			global:  ConfigGlobal{NodeTagsStrategy: "regexp", NodeTagsRegexp: "gke-("},
+			wantErr: true,
+		},
+		{
+			desc:    "unknown strategy",
+			global:  ConfigGlobal{NodeTagsStrategy: "shortest-prefix"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, err := parseNodeTagsConfig(&tc.global)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+		})
+	}
+}
+
+func TestInstanceTags(t *testing.T) {
+	t.Parallel()
+
+	metadataValue := "pool-a, shared"
+	instance := &compute.Instance{
+		Name:     "tf-pool-a-x1z",
+		Tags:     &compute.Tags{Items: []string{"tf-pool", "tf-pool-a", "pool-a", "shared"}},
+		Labels:   map[string]string{"firewall-tag": "pool-a", "other-tag": "unknown"},
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{{Key: "firewall-tags", Value: &metadataValue}}},
+	}
+	for _, tc := range []struct {
+		desc    string
+		config  NodeTagsConfig
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc: "longest prefix",
+			want: []string{"tf-pool-a"},
+		},
+		{
+			desc:   "instance label",
+			config: NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "firewall-tag"},
+			want:   []string{"pool-a"},
+		},
+		{
+			desc:    "missing instance label",
+			config:  NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "missing"},
+			wantErr: true,
+		},
+		{
+			desc:    "instance label naming another tag",
+			config:  NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "other-tag"},
+			wantErr: true,
+		},
+		{
+			desc:   "instance metadata",
+			config: NodeTagsConfig{Strategy: NodeTagsStrategyInstanceMetadata, MetadataKey: "firewall-tags"},
+			want:   []string{"pool-a", "shared"},
+		},
+		{
+			desc:    "missing instance metadata",
+			config:  NodeTagsConfig{Strategy: NodeTagsStrategyInstanceMetadata, MetadataKey: "missing"},
+			wantErr: true,
+		},
+		{
+			desc:   "regexp",
+			config: NodeTagsConfig{Strategy: NodeTagsStrategyRegexp, Regexp: "^tf-"},
+			want:   []string{"tf-pool", "tf-pool-a"},
+		},
+		{
+			desc:    "regexp matching no tag",
+			config:  NodeTagsConfig{Strategy: NodeTagsStrategyRegexp, Regexp: "^gke-"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := &Cloud{nodeTagsConfig: tc.config}
+			if tc.config.Regexp != "" {
+				g.nodeTagsRegexp = regexp.MustCompile(tc.config.Regexp)
+			}
+			tags, err := g.instanceTags(instance)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, tags)
+		})
+	}
+}
+
+func TestEnsureInternalLoadBalancerWithNodeTagsStrategy(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	gce.nodeTagsConfig = NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "firewall-tag"}
+
+	var nodes []*v1.Node
+	for i, pool := range []string{"pool-a", "pool-b"} {
+		name := fmt.Sprintf("tf-node-%d", i)
+		require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{
+			Name:   name,
+			Zone:   vals.ZoneName,
+			Tags:   &compute.Tags{Items: []string{pool, "default-allow-ssh"}},
+			Labels: map[string]string{"firewall-tag": pool},
+		}))
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: vals.ZoneName}},
+			Spec:       v1.NodeSpec{PodCIDR: "192.168.0.0/0"},
+		})
+	}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pool-a", "pool-b"}, fw.TargetTags)
+
+	// Nodes without the label fail the sync and raise an event.
+	require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{
+		Name: "tf-node-unlabeled",
+		Zone: vals.ZoneName,
+		Tags: &compute.Tags{Items: []string{"pool-c"}},
+	}))
+	nodes = append(nodes, &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "tf-node-unlabeled", Labels: map[string]string{v1.LabelTopologyZone: vals.ZoneName}},
+		Spec:       v1.NodeSpec{PodCIDR: "192.168.0.0/0"},
+	})
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+	found := false
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; regexp.MustCompile("^Warning " + eventReasonNodeTagsDiscoveryFailed + " .*instance-label").MatchString(event) {
+			found = true
+		}
+	}
+	assert.True(t, found, "no %s event", eventReasonNodeTagsDiscoveryFailed)
+}
+
+func TestHostTagsCache(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	_, err = createAndInsertNodes(gce, []string{"node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	hosts, err := gce.getInstancesByNames([]string{"node-1"})
+	require.NoError(t, err)
+
+	tags, err := gce.hostTags(hosts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, tags)
+
+	// The tags are only computed again when the hosts change.
+	gce.nodeTagsConfig = NodeTagsConfig{Strategy: NodeTagsStrategyInstanceLabel, Label: "firewall-tag"}
+	tags, err = gce.hostTags(hosts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, tags)
+
+	_, err = createAndInsertNodes(gce, []string{"node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	hosts, err = gce.getInstancesByNames([]string{"node-1", "node-2"})
+	require.NoError(t, err)
+	_, err = gce.hostTags(hosts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// rateLimitInitialBackoff is the first backoff of the calls of a rate limit after one of them
+	// is throttled by GCE. The backoff doubles while the calls are throttled, up to rateLimitMaxBackoff.
+	rateLimitInitialBackoff = time.Second
+	rateLimitMaxBackoff     = 32 * time.Second
+)
+
+var (
+	rateLimitWaitLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:           "cloudprovider_gce_api_rate_limit_wait_duration_seconds",
+			Help:           "Time a GCE API call waited for the rate limiter",
+			Buckets:        metrics.ExponentialBuckets(0.001, 4, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"service", "operation"},
+	)
+	throttledCalls = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name:           "cloudprovider_gce_api_request_throttled",
+			Help:           "Number of GCE API calls rejected because they exceeded a rate limit",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"service", "operation"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(rateLimitWaitLatency)
+	legacyregistry.MustRegister(throttledCalls)
+}
+
+// RateLimit is a token bucket bounding the rate of GCE API calls.
+type RateLimit struct {
+	// QPS is the sustained rate of the calls. Zero does not limit them.
+	QPS float32
+	// Burst is the number of calls above QPS allowed at once.
+	Burst int
+}
+
+// RateLimitConfig configures the rate limiting of the GCE API calls.
+type RateLimitConfig struct {
+	// Default bounds the calls without a rate limit of their own.
+	Default RateLimit
+	// Limits bound the calls of a service, keyed by the name of the service (e.g. "Instances"),
+	// or of an operation of a service, keyed by "<service>.<operation>" (e.g. "Instances.List").
+	Limits map[string]RateLimit
+}
+
+// parseRateLimitConfig returns the rate limits set in the config, whose rate-limit entries are
+// formatted as "<service>[.<operation>],<qps>,<burst>".
+func parseRateLimitConfig(global *ConfigGlobal) (RateLimitConfig, error) {
+	config := RateLimitConfig{Default: RateLimit{QPS: float32(global.RateLimitQPS), Burst: global.RateLimitBurst}}
+	if global.RateLimitQPS < 0 {
+		return config, fmt.Errorf("invalid rate-limit-qps %v: must not be negative", global.RateLimitQPS)
+	}
+	if global.RateLimitBurst < 0 {
+		return config, fmt.Errorf("invalid rate-limit-burst %d: must not be negative", global.RateLimitBurst)
+	}
+	if config.Default.QPS > 0 && config.Default.Burst == 0 {
+		config.Default.Burst = 1
+	}
+	for _, spec := range global.RateLimits {
+		fields := strings.Split(spec, ",")
+		if len(fields) != 3 {
+			return config, fmt.Errorf("invalid rate-limit %q: expected <service>[.<operation>],<qps>,<burst>", spec)
+		}
+		key := strings.TrimSpace(fields[0])
+		if parts := strings.Split(key, "."); len(parts) > 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+			return config, fmt.Errorf("invalid rate-limit %q: %q is not a service or an operation of a service", spec, key)
+		}
+		if _, ok := config.Limits[key]; ok {
+			return config, fmt.Errorf("duplicate rate-limit for %s", key)
+		}
+		qps, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 32)
+		if err != nil || qps <= 0 {
+			return config, fmt.Errorf("invalid rate-limit %q: qps must be a positive number", spec)
+		}
+		burst, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if err != nil || burst <= 0 {
+			return config, fmt.Errorf("invalid rate-limit %q: burst must be a positive integer", spec)
+		}
+		if config.Limits == nil {
+			config.Limits = map[string]RateLimit{}
+		}
+		config.Limits[key] = RateLimit{QPS: float32(qps), Burst: burst}
+	}
+	return config, nil
+}
+
+// gceRateLimiter implements cloud.RateLimiter. Every call waits for the token bucket of its
+// operation if one is configured, else of its service, else for the default one, and backs off
+// while the calls of its operation are throttled by GCE. GCE throttles the operations separately,
+// so calls sharing a bucket with a throttled operation do not back off.
+type gceRateLimiter struct {
+	// buckets are keyed like the limits of RateLimitConfig.
+	buckets       map[string]*rateLimitBucket
+	defaultBucket *rateLimitBucket
+
+	mu sync.Mutex
+	// backoffs are keyed by "<service>.<operation>".
+	backoffs map[string]*rateLimitBackoff
+}
+
+type rateLimitBucket struct {
+	// limiter is nil if the calls are not rate limited.
+	limiter flowcontrol.RateLimiter
+}
+
+type rateLimitBackoff struct {
+	mu sync.Mutex
+	// backoff is the last backoff, zero if the last call was not throttled.
+	backoff      time.Duration
+	backoffUntil time.Time
+}
+
+func newRateLimitBucket(limit RateLimit) *rateLimitBucket {
+	if limit.QPS <= 0 {
+		return &rateLimitBucket{}
+	}
+	return &rateLimitBucket{limiter: flowcontrol.NewTokenBucketRateLimiter(limit.QPS, limit.Burst)}
+}
+
+// newGCERateLimiter returns the rate limiter of the configured limits. Operations are polled at
+// the rate of operationPollRateLimiter unless a rate limit of the Operations service is configured.
+func newGCERateLimiter(config RateLimitConfig, operationPollRateLimiter flowcontrol.RateLimiter) *gceRateLimiter {
+	l := &gceRateLimiter{
+		buckets:       map[string]*rateLimitBucket{},
+		defaultBucket: newRateLimitBucket(config.Default),
+		backoffs:      map[string]*rateLimitBackoff{},
+	}
+	for key, limit := range config.Limits {
+		l.buckets[key] = newRateLimitBucket(limit)
+	}
+	if l.buckets["Operations.Get"] == nil && l.buckets["Operations"] == nil {
+		l.buckets["Operations.Get"] = &rateLimitBucket{limiter: operationPollRateLimiter}
+	}
+	return l
+}
+
+// bucket returns the token bucket of the call.
+func (l *gceRateLimiter) bucket(key *cloud.RateLimitKey) *rateLimitBucket {
+	if b, ok := l.buckets[key.Service+"."+key.Operation]; ok {
+		return b
+	}
+	if b, ok := l.buckets[key.Service]; ok {
+		return b
+	}
+	return l.defaultBucket
+}
+
+// backoff returns the backoff of the operation of the call.
+func (l *gceRateLimiter) backoff(key *cloud.RateLimitKey) *rateLimitBackoff {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name := key.Service + "." + key.Operation
+	b, ok := l.backoffs[name]
+	if !ok {
+		b = &rateLimitBackoff{}
+		l.backoffs[name] = b
+	}
+	return b
+}
+
+// Accept blocks until the operation can be performed.
+func (l *gceRateLimiter) Accept(ctx context.Context, key *cloud.RateLimitKey) error {
+	start := time.Now()
+	defer func() {
+		rateLimitWaitLatency.WithLabelValues(key.Service, key.Operation).Observe(time.Since(start).Seconds())
+	}()
+
+	if key.Operation == "Get" && key.Service == "Operations" {
+		// Wait a minimum amount of time regardless of rate limiter.
+		select {
+		case <-time.After(operationPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := l.backoff(key).wait(ctx); err != nil {
+		return err
+	}
+	b := l.bucket(key)
+	if b.limiter == nil {
+		return nil
+	}
+	return b.limiter.Wait(ctx)
+}
+
+// Observe backs off the calls of the operation of the call if it was throttled by GCE.
+func (l *gceRateLimiter) Observe(ctx context.Context, err error, key *cloud.RateLimitKey) {
+	b := l.backoff(key)
+	if !isRateLimitExceeded(err) {
+		b.reset()
+		return
+	}
+	throttledCalls.WithLabelValues(key.Service, key.Operation).Inc()
+	if backoff := b.throttled(); backoff > 0 {
+		klog.V(2).Infof("gceRateLimiter: %s.%s throttled by GCE, backing off its calls for %v: %v", key.Service, key.Operation, backoff, err)
+	}
+}
+
+// wait blocks until the backoff is over.
+func (b *rateLimitBackoff) wait(ctx context.Context) error {
+	b.mu.Lock()
+	wait := time.Until(b.backoffUntil)
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttled starts a new backoff, twice as long as the last one, and returns it. Calls throttled
+// during a backoff were made before it started, so they do not start another one and zero is
+// returned.
+func (b *rateLimitBackoff) throttled() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.backoffUntil) {
+		return 0
+	}
+	b.backoff *= 2
+	if b.backoff == 0 {
+		b.backoff = rateLimitInitialBackoff
+	}
+	if b.backoff > rateLimitMaxBackoff {
+		b.backoff = rateLimitMaxBackoff
+	}
+	b.backoffUntil = now.Add(b.backoff)
+	return b.backoff
+}
+
+// reset makes the next backoff the initial one.
+func (b *rateLimitBackoff) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backoff = 0
+}
+
+// isRateLimitExceeded returns whether the error is GCE rejecting a call because it exceeded a
+// rate limit of the project or of the user.
+func isRateLimitExceeded(err error) bool {
+	return isHTTPErrorCode(err, http.StatusTooManyRequests) || isGCEError(err, "rateLimitExceeded") || isGCEError(err, "userRateLimitExceeded")
+}
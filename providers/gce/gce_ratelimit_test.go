@@ -0,0 +1,156 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestParseRateLimitConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		global  ConfigGlobal
+		want    RateLimitConfig
+		wantErr bool
+	}{
+		{
+			desc: "no rate limit",
+		},
+		{
+			desc:   "default rate limit",
+			global: ConfigGlobal{RateLimitQPS: 10, RateLimitBurst: 5},
+			want:   RateLimitConfig{Default: RateLimit{QPS: 10, Burst: 5}},
+		},
+		{
+			desc:   "service and operation rate limits",
+			global: ConfigGlobal{RateLimits: []string{"Instances, 10, 20", "Operations.Get,2.5,5"}},
+			want: RateLimitConfig{Limits: map[string]RateLimit{
+				"Instances":      {QPS: 10, Burst: 20},
+				"Operations.Get": {QPS: 2.5, Burst: 5},
+			}},
+		},
+		{
+			desc:    "negative qps",
+			global:  ConfigGlobal{RateLimitQPS: -1},
+			wantErr: true,
+		},
+		{
+			desc:    "missing burst",
+			global:  ConfigGlobal{RateLimits: []string{"Instances,10"}},
+			wantErr: true,
+		},
+		{
+			desc:    "invalid key",
+			global:  ConfigGlobal{RateLimits: []string{"ga.Instances.Get,10,20"}},
+			wantErr: true,
+		},
+		{
+			desc:    "zero qps",
+			global:  ConfigGlobal{RateLimits: []string{"Instances,0,20"}},
+			wantErr: true,
+		},
+		{
+			desc:    "duplicate",
+			global:  ConfigGlobal{RateLimits: []string{"Instances,10,20", "Instances,5,10"}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, err := parseRateLimitConfig(&tc.global)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+		})
+	}
+}
+
+func TestGCERateLimiterBuckets(t *testing.T) {
+	t.Parallel()
+
+	pollLimiter := flowcontrol.NewTokenBucketRateLimiter(5, 5)
+	l := newGCERateLimiter(RateLimitConfig{Limits: map[string]RateLimit{
+		"Instances":      {QPS: 10, Burst: 20},
+		"Instances.List": {QPS: 1, Burst: 1},
+	}}, pollLimiter)
+
+	assert.Same(t, l.buckets["Instances.List"], l.bucket(&cloud.RateLimitKey{Service: "Instances", Operation: "List"}))
+	assert.Same(t, l.buckets["Instances"], l.bucket(&cloud.RateLimitKey{Service: "Instances", Operation: "Get"}))
+	assert.Same(t, l.defaultBucket, l.bucket(&cloud.RateLimitKey{Service: "Firewalls", Operation: "Get"}))
+	assert.Nil(t, l.defaultBucket.limiter, "calls are not rate limited by default")
+	assert.Equal(t, pollLimiter, l.bucket(&cloud.RateLimitKey{Service: "Operations", Operation: "Get"}).limiter)
+
+	// The operation limit is used once for every call, then the calls wait for a token.
+	key := &cloud.RateLimitKey{Service: "Instances", Operation: "List"}
+	require.NoError(t, l.Accept(context.TODO(), key))
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.Accept(ctx, key))
+	require.NoError(t, l.Accept(context.TODO(), &cloud.RateLimitKey{Service: "Instances", Operation: "Get"}))
+}
+
+func TestGCERateLimiterBackoff(t *testing.T) {
+	t.Parallel()
+
+	l := newGCERateLimiter(RateLimitConfig{}, nil)
+	key := &cloud.RateLimitKey{Service: "Instances", Operation: "Get"}
+	b := l.backoff(key)
+
+	l.Observe(context.TODO(), &googleapi.Error{Code: http.StatusNotFound}, key)
+	assert.Zero(t, b.backoff, "only throttled calls back off")
+
+	l.Observe(context.TODO(), &googleapi.Error{Code: http.StatusTooManyRequests}, key)
+	assert.Equal(t, rateLimitInitialBackoff, b.backoff)
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.Accept(ctx, key), "calls wait for the backoff")
+	// The other operations sharing the bucket are not throttled.
+	assert.NoError(t, l.Accept(context.TODO(), &cloud.RateLimitKey{Service: "Instances", Operation: "List"}))
+	assert.NoError(t, l.Accept(context.TODO(), &cloud.RateLimitKey{Service: "Firewalls", Operation: "Get"}))
+
+	// Calls throttled during the backoff do not start another one.
+	l.Observe(context.TODO(), &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, key)
+	assert.Equal(t, rateLimitInitialBackoff, b.backoff)
+
+	// The backoff doubles while the calls are throttled, up to the maximum.
+	for i := 0; i < 10; i++ {
+		b.backoffUntil = time.Time{}
+		l.Observe(context.TODO(), &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, key)
+	}
+	assert.Equal(t, rateLimitMaxBackoff, b.backoff)
+
+	l.Observe(context.TODO(), nil, key)
+	assert.Zero(t, b.backoff)
+	b.backoffUntil = time.Time{}
+	assert.NoError(t, l.Accept(context.TODO(), key))
+}
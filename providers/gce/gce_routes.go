@@ -90,7 +90,7 @@ func (g *Cloud) CreateRoute(ctx context.Context, clusterName string, nameHint st
 		klog.Infof("Route %q already exists.", cr.Name)
 		err = nil
 	}
-	return mc.Observe(err)
+	return g.classifyError(nodeReference(route.TargetNode), mc.Observe(err))
 }
 
 // DeleteRoute from the cloud environment.
@@ -99,7 +99,7 @@ func (g *Cloud) DeleteRoute(ctx context.Context, clusterName string, route *clou
 	defer cancel()
 
 	mc := newRoutesMetricContext("delete")
-	return mc.Observe(g.c.Routes().Delete(timeoutCtx, meta.GlobalKey(route.Name)))
+	return g.classifyError(nodeReference(route.TargetNode), mc.Observe(g.c.Routes().Delete(timeoutCtx, meta.GlobalKey(route.Name))))
 }
 
 func truncateClusterName(clusterName string) string {
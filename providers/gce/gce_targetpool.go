@@ -23,6 +23,7 @@ import (
 	compute "google.golang.org/api/compute/v1"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 )
 
@@ -49,6 +50,16 @@ func (g *Cloud) CreateTargetPool(tp *compute.TargetPool, region string) error {
 	return mc.Observe(g.c.TargetPools().Insert(ctx, meta.RegionalKey(tp.Name, region), tp))
 }
 
+// ListTargetPools lists all TargetPools in the region.
+func (g *Cloud) ListTargetPools(region string) ([]*compute.TargetPool, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newTargetPoolMetricContext("list", region)
+	v, err := g.c.TargetPools().List(ctx, region, filter.None)
+	return v, mc.Observe(err)
+}
+
 // DeleteTargetPool deletes TargetPool by name.
 func (g *Cloud) DeleteTargetPool(name, region string) error {
 	ctx, cancel := cloud.ContextWithCallTimeout()
@@ -24,9 +24,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/oauth2/google"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	cloudprovider "k8s.io/cloud-provider"
 )
 
@@ -479,6 +481,148 @@ func TestGenerateCloudConfigs(t *testing.T) {
 				return v
 			},
 		},
+		{
+			name: "Load Balancer Naming Scheme",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.LoadBalancerNamingScheme = "v2"
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.LoadBalancerNamingScheme = LoadBalancerNamingSchemeV2
+				return v
+			},
+		},
+		{
+			name: "Load Balancer Dry Run",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.LoadBalancerDryRun = true
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.LoadBalancerDryRun = true
+				return v
+			},
+		},
+		{
+			name: "Firewall Policy",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.FirewallPolicy = "lb-policy"
+				v.FirewallPolicyRegion = "us-central1"
+				v.FirewallTargetServiceAccounts = []string{"nodes@project-id.iam.gserviceaccount.com"}
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.FirewallPolicy = &FirewallPolicyConfig{Name: "lb-policy", Region: "us-central1", MinPriority: 1000, MaxPriority: 9999}
+				v.Firewall = FirewallConfig{TargetServiceAccounts: []string{"nodes@project-id.iam.gserviceaccount.com"}}
+				return v
+			},
+		},
+		{
+			name: "Firewall options",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.FirewallLogging = true
+				v.FirewallLoggingMetadata = FirewallLoggingExcludeAllMetadata
+				v.FirewallPriority = 900
+				v.FirewallTargetServiceAccounts = []string{"nodes@project-id.iam.gserviceaccount.com"}
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.Firewall = FirewallConfig{
+					Logging:               true,
+					LoggingMetadata:       FirewallLoggingExcludeAllMetadata,
+					Priority:              900,
+					TargetServiceAccounts: []string{"nodes@project-id.iam.gserviceaccount.com"},
+				}
+				return v
+			},
+		},
+		{
+			name: "Node tags strategy",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.NodeTagsStrategy = "regexp"
+				v.NodeTagsRegexp = "^tf-pool-"
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.NodeTagsConfig = NodeTagsConfig{Strategy: NodeTagsStrategyRegexp, Regexp: "^tf-pool-"}
+				return v
+			},
+		},
+		{
+			name: "Load balancer update concurrency",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.LoadBalancerUpdateConcurrency = 8
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.LoadBalancerUpdateConcurrency = 8
+				return v
+			},
+		},
+		{
+			name: "Rate limits",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.RateLimitQPS = 20
+				v.RateLimits = []string{"Instances,10,20", "Instances.List,0.5,1"}
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.RateLimit = RateLimitConfig{
+					Default: RateLimit{QPS: 20, Burst: 1},
+					Limits: map[string]RateLimit{
+						"Instances":      {QPS: 10, Burst: 20},
+						"Instances.List": {QPS: 0.5, Burst: 1},
+					},
+				}
+				return v
+			},
+		},
+		{
+			name: "Instance labels",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.InstanceLabels = []string{"machine-family", InstanceLabelPrefix + "gpu-type"}
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.InstanceLabels = sets.NewString(InstanceLabelMachineFamily, InstanceLabelGPUType)
+				return v
+			},
+		},
+		{
+			name: "Instance cache",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.InstanceCacheRefreshPeriod = "5m"
+				v.InstanceCacheMaxAge = "1m"
+				v.InstanceCacheConsumerMaxAges = []string{"InstanceShutdown,0s"}
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.InstanceCache = InstanceCacheConfig{
+					RefreshPeriod:   5 * time.Minute,
+					MaxAge:          time.Minute,
+					ConsumerMaxAges: map[InstanceCacheConsumer]time.Duration{InstanceCacheConsumerInstanceShutdown: 0},
+				}
+				return v
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -60,6 +60,7 @@ func fakeGCECloud(vals TestClusterValues) (*Cloud, error) {
 
 	gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{})
 	gce.nodeInformerSynced = func() bool { return true }
+	gce.nodeZones = map[string]sets.String{}
 	gce.client = fake.NewSimpleClientset()
 	gce.eventRecorder = &record.FakeRecorder{}
 
@@ -82,6 +83,7 @@ func fakeGCECloud(vals TestClusterValues) (*Cloud, error) {
 
 	mockGCE.MockRegionBackendServices.UpdateHook = mock.UpdateRegionBackendServiceHook
 	mockGCE.MockHealthChecks.UpdateHook = mock.UpdateHealthCheckHook
+	mockGCE.MockRegionHealthChecks.UpdateHook = mock.UpdateRegionHealthCheckHook
 	mockGCE.MockFirewalls.UpdateHook = mock.UpdateFirewallHook
 	mockGCE.MockFirewalls.PatchHook = mock.UpdateFirewallHook
 
@@ -122,11 +124,12 @@ func registerTargetPoolRemoveInstanceHook(gce *Cloud, callback func(*compute.Tar
 }
 
 type gceInstance struct {
-	Zone  string
-	Name  string
-	ID    uint64
-	Disks []*compute.AttachedDisk
-	Type  string
+	Zone   string
+	Name   string
+	ID     uint64
+	Disks  []*compute.AttachedDisk
+	Type   string
+	Status string
 }
 
 var (
@@ -192,9 +195,22 @@ func firewallToGcloudArgs(fw *compute.Firewall, projectID string) string {
 	allow := strings.Join(allPorts, ",")
 	sort.Strings(fw.SourceRanges)
 	srcRngs := strings.Join(fw.SourceRanges, ",")
-	sort.Strings(fw.TargetTags)
-	targets := strings.Join(fw.TargetTags, ",")
-	return fmt.Sprintf("--description %q --allow %v --source-ranges %v --target-tags %v --project %v", fw.Description, allow, srcRngs, targets, projectID)
+	targetsFlag, targets := "--target-tags", fw.TargetTags
+	if len(fw.TargetServiceAccounts) > 0 {
+		targetsFlag, targets = "--target-service-accounts", fw.TargetServiceAccounts
+	}
+	sort.Strings(targets)
+	args := fmt.Sprintf("--description %q --allow %v --source-ranges %v %v %v", fw.Description, allow, srcRngs, targetsFlag, strings.Join(targets, ","))
+	if fw.Priority != 0 {
+		args += fmt.Sprintf(" --priority %d", fw.Priority)
+	}
+	if fw.LogConfig != nil && fw.LogConfig.Enable {
+		args += " --enable-logging"
+		if fw.LogConfig.Metadata != "" {
+			args += fmt.Sprintf(" --logging-metadata %s", strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(fw.LogConfig.Metadata, "_METADATA"), "_", "-")))
+		}
+	}
+	return fmt.Sprintf("%v --project %v", args, projectID)
 }
 
 // Take a GCE instance 'hostname' and break it down to something that can be fed
@@ -401,15 +417,15 @@ func removeString(slice []string, s string) []string {
 }
 
 // usesL4RBS checks if service uses Regional Backend Service as a Backend.
-// Such services implemented in other controllers and
-// should not be handled by Service Controller.
+// Such services are implemented in other controllers, unless the service
+// controller manages them itself (see managesL4RBS).
 func usesL4RBS(service *v1.Service, forwardingRule *compute.ForwardingRule) bool {
 	// Detect RBS by annotation
 	if val, ok := service.Annotations[RBSAnnotationKey]; ok && val == RBSEnabled {
 		return true
 	}
 	// Detect RBS by finalizer
-	if hasFinalizer(service, NetLBFinalizerV2) || hasFinalizer(service, NetLBFinalizerV3) {
+	if hasFinalizer(service, NetLBRBSFinalizer) || hasFinalizer(service, NetLBFinalizerV2) || hasFinalizer(service, NetLBFinalizerV3) {
 		return true
 	}
 	// Detect RBS by existing forwarding rule with Backend Service attached
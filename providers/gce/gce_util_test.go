@@ -120,6 +120,15 @@ func TestFirewallToGcloudArgs(t *testing.T) {
 	if got != e {
 		t.Errorf("%q does not equal %q", got, e)
 	}
+
+	firewall.TargetTags = nil
+	firewall.Allowed = firewall.Allowed[:1]
+	FirewallConfig{Logging: true, LoggingMetadata: FirewallLoggingExcludeAllMetadata, Priority: 900, TargetServiceAccounts: []string{"nodes@my-project.iam.gserviceaccount.com"}}.apply(&firewall)
+	got = firewallToGcloudArgs(&firewall, "my-project")
+	e = `--description "Last Line of Defense" --allow udp:123,udp:123-456,udp:321 --source-ranges 1.1.1.1/20,2.2.2.2/20,3.3.3.3/20 --target-service-accounts nodes@my-project.iam.gserviceaccount.com --priority 900 --enable-logging --logging-metadata exclude-all --project my-project`
+	if got != e {
+		t.Errorf("%q does not equal %q", got, e)
+	}
 }
 
 // TestAddRemoveFinalizer tests the add/remove and hasFinalizer methods.
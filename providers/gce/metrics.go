@@ -34,8 +34,9 @@ const (
 )
 
 type apiCallMetrics struct {
-	latency *metrics.HistogramVec
-	errors  *metrics.CounterVec
+	latency          *metrics.HistogramVec
+	errors           *metrics.CounterVec
+	classifiedErrors *metrics.CounterVec
 }
 
 var (
@@ -45,6 +46,9 @@ var (
 		"zone",    // zone (optional).
 		"version", // API version.
 	}
+	classifiedErrorMetricLabels = append(append([]string{}, metricLabels...),
+		"class", // Class of the error, see gceErrorClass.
+	)
 
 	apiMetrics = registerAPIMetrics()
 )
@@ -65,6 +69,10 @@ func (mc *metricContext) Observe(err error) error {
 		time.Since(mc.start).Seconds())
 	if err != nil {
 		apiMetrics.errors.WithLabelValues(mc.attributes...).Inc()
+		if class := classifyGCEError(err); class != gceErrorOther {
+			labels := append(append([]string{}, mc.attributes...), string(class))
+			apiMetrics.classifiedErrors.WithLabelValues(labels...).Inc()
+		}
 	}
 
 	return err
@@ -102,10 +110,19 @@ func registerAPIMetrics() *apiCallMetrics {
 			},
 			metricLabels,
 		),
+		classifiedErrors: metrics.NewCounterVec(
+			&metrics.CounterOpts{
+				Name:           "cloudprovider_gce_api_request_classified_errors",
+				Help:           "Number of errors for an API call, by class of error",
+				StabilityLevel: metrics.ALPHA,
+			},
+			classifiedErrorMetricLabels,
+		),
 	}
 
 	legacyregistry.MustRegister(metrics.latency)
 	legacyregistry.MustRegister(metrics.errors)
+	legacyregistry.MustRegister(metrics.classifiedErrors)
 
 	return metrics
 }
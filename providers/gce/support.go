@@ -41,34 +41,6 @@ func (r *gceProjectRouter) ProjectID(ctx context.Context, version meta.Version,
 	}
 }
 
-// gceRateLimiter implements cloud.RateLimiter.
-type gceRateLimiter struct {
-	gce *Cloud
-}
-
-// Accept blocks until the operation can be performed.
-//
-// TODO: the current cloud provider policy doesn't seem to be correct as it
-// only rate limits the polling operations, but not the /submission/ of
-// operations.
-func (l *gceRateLimiter) Accept(ctx context.Context, key *cloud.RateLimitKey) error {
-	if key.Operation == "Get" && key.Service == "Operations" {
-		// Wait a minimum amount of time regardless of rate limiter.
-		rl := &cloud.MinimumRateLimiter{
-			// Convert flowcontrol.RateLimiter into cloud.RateLimiter
-			RateLimiter: &cloud.AcceptRateLimiter{
-				Acceptor: l.gce.operationPollRateLimiter,
-			},
-			Minimum: operationPollInterval,
-		}
-		return rl.Accept(ctx, key)
-	}
-	return nil
-}
-
-// Observe is a no-op func to satisfy cloud.RateLimiter
-func (*gceRateLimiter) Observe(context.Context, error, *cloud.RateLimitKey) {}
-
 // CreateGCECloudWithCloud is a helper function to create an instance of Cloud with the
 // given Cloud interface implementation. Typical usage is to use cloud.NewMockGCE to get a
 // handle to a mock Cloud instance and then use that for testing.